@@ -42,8 +42,9 @@ const CasdoorLoginButton: React.FC<CasdoorLoginButtonProps> = ({ className }) =>
     console.log('Cleared all authentication data');
     console.log('Initiating Casdoor authentication flow...');
     
-    // Create callback URL for our custom handler
-    const callbackUrl = `${window.location.origin}/auth/callback`;
+    // Create callback URL for our custom handler. A local path is always an
+    // allowed redirect target; absolute URLs must be on the tenant's allow-list.
+    const callbackUrl = '/auth/callback';
     
     // Use the Go server's OAuth endpoint with timestamp and callback parameters
     const timestamp = new Date().getTime();
@@ -0,0 +1,72 @@
+import React, { useState } from 'react';
+import { Button } from '@/components/ui/button';
+import { Input } from '@/components/ui/input';
+import { FaBuilding } from 'react-icons/fa';
+
+interface HomeRealmLoginProps {
+  className?: string;
+}
+
+interface HomeRealmResponse {
+  connection: { id: number; name: string } | null;
+  loginUrl?: string;
+}
+
+// Sends users whose email domain belongs to a tenant's own identity provider
+// straight to that provider
+const HomeRealmLogin: React.FC<HomeRealmLoginProps> = ({ className }) => {
+  const [email, setEmail] = useState('');
+  const [error, setError] = useState<string | null>(null);
+  const [isLoading, setIsLoading] = useState(false);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError(null);
+    setIsLoading(true);
+
+    try {
+      const response = await fetch(`/api/auth/home-realm?email=${encodeURIComponent(email)}`, {
+        credentials: 'include',
+      });
+      const data = await response.json();
+
+      if (!response.ok) {
+        setError(data.error || 'Could not look up your organization');
+        return;
+      }
+
+      const realm = data as HomeRealmResponse;
+      if (!realm.connection || !realm.loginUrl) {
+        setError('No single sign-on is configured for this email domain');
+        return;
+      }
+
+      // A local path is always an allowed redirect target
+      window.location.href = `${realm.loginUrl}?redirect_uri=${encodeURIComponent('/auth/callback')}`;
+    } catch (err) {
+      console.error('Error looking up home realm:', err);
+      setError('Could not look up your organization');
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  return (
+    <form onSubmit={handleSubmit} className={`space-y-2 ${className || ''}`}>
+      <Input
+        type="email"
+        placeholder="Work email"
+        value={email}
+        onChange={e => setEmail(e.target.value)}
+        required
+      />
+      {error && <p className="text-sm text-red-600">{error}</p>}
+      <Button type="submit" variant="outline" className="w-full" disabled={isLoading || !email}>
+        <FaBuilding className="mr-2 h-4 w-4" />
+        {isLoading ? 'Looking up...' : 'Continue with company SSO'}
+      </Button>
+    </form>
+  );
+};
+
+export default HomeRealmLogin;
@@ -22,20 +22,7 @@ export default function AuthCallback() {
           // Set the flag to indicate we've just completed a login
           localStorage.setItem('just_logged_in', 'true');
           
-          // Optional: store mock user data for Replit environment
-          const mockUser = {
-            id: 1,
-            username: 'casdoor_user',
-            name: 'Casdoor User',
-            email: 'user@example.com',
-            isSuperAdmin: true,
-            tenantId: 1
-          };
-          
-          // Save user data to local storage as fallback mechanism
-          localStorage.setItem('user_data', JSON.stringify(mockUser));
-          
-          // Manually fetch user data from backend to establish proper session
+          // Fetch the provisioned user; the session cookie set by the callback authenticates the request
           setStatus('Fetching user profile...');
           
           try {
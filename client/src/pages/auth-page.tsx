@@ -13,6 +13,7 @@ import { useToast } from '@/hooks/use-toast';
 import { Form, FormControl, FormField, FormItem, FormLabel, FormMessage } from '@/components/ui/form';
 import { useAuth } from '@/contexts/AuthContextV2';
 import CasdoorLoginButton from '@/components/CasdoorLoginButton';
+import HomeRealmLogin from '@/components/HomeRealmLogin';
 
 // Define schemas for form validation
 const loginSchema = z.object({
@@ -160,6 +161,8 @@ export default function AuthPage() {
                   </div>
                   
                   <CasdoorLoginButton className="mt-2" />
+
+                  <HomeRealmLogin className="mt-4" />
                   
                   {/* Direct link fallback for environments where redirect doesn't work properly */}
                   <div className="mt-4 text-center text-sm text-gray-500">
@@ -4,182 +4,186 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
 
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/model"
-	"github.com/gin-gonic/gin"
+	"github.com/casbin/casbin/v2/persist"
 
+	"go-server/conditions"
 	"go-server/middleware"
 	"go-server/models"
 )
 
-// InitCasbin initializes the Casbin enforcer
-func InitCasbin(db *sql.DB) (*casbin.Enforcer, error) {
-	// Define the RBAC model as a string
-	rbacModel := `
-	[request_definition]
-	r = sub, obj, act
+// rbacModel is the Casbin model: the same as config/rbac_model.conf, with
+// each tenant as a domain so a role's rights stay in its own tenant. A
+// permission's condition must also hold for the attributes of the subject
+// and object of the request. A matching deny rule overrides every allow.
+const rbacModel = `
+[request_definition]
+r = sub, dom, obj, act, subject, object
 
-	[policy_definition]
-	p = sub, obj, act
+[policy_definition]
+p = sub, dom, obj, act, cond, eft
 
-	[role_definition]
-	g = _, _
+[role_definition]
+g = _, _, _
 
-	[policy_effect]
-	e = some(where (p.eft == allow))
+[policy_effect]
+e = some(where (p.eft == allow)) && !some(where (p.eft == deny))
 
-	[matchers]
-	m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
-	`
+[matchers]
+m = g(r.sub, p.sub, r.dom) && r.dom == p.dom && r.obj == p.obj && r.act == p.act && conditionApplies(p.cond, p.eft, r.subject, r.object)
+`
 
+// newCasbinEnforcer creates an enforcer for rbacModel, loading the policy
+// from the adapter if there is one
+func newCasbinEnforcer(adapter persist.Adapter) (*casbin.SyncedEnforcer, error) {
 	// Create the model from the string
 	m, err := model.NewModelFromString(rbacModel)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create a database adapter that will load policies from our PostgreSQL database
-	// For simplicity, we'll use in-memory adapter here and load policies programmatically
-	// In a production environment, you'd want to use a persistent adapter
-	enforcer, err := casbin.NewEnforcer(m)
+	var enforcer *casbin.SyncedEnforcer
+	if adapter != nil {
+		enforcer, err = casbin.NewSyncedEnforcer(m, adapter)
+	} else {
+		enforcer, err = casbin.NewSyncedEnforcer(m)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// Set the enforcer in the middleware
-	middleware.SetupCasbin(enforcer)
+	// The policy is written through the models, and the adapter only reads it
+	enforcer.EnableAutoSave(false)
 
-	// Load policies from database
-	err = loadPoliciesFromDB(db, enforcer)
-	if err != nil {
-		log.Printf("Failed to load policies from database: %v", err)
-		// If we can't load policies, set some default policies
-		setDefaultPolicies(enforcer)
+	enforcer.AddFunction("conditionApplies", conditionApplies)
+	return enforcer, nil
+}
+
+// conditionApplies is the matcher function that evaluates a rule's
+// condition. A condition that cannot be evaluated, such as for lack of an
+// attribute, makes deny rules apply and allow rules not, so a bad condition
+// never grants access and never fails every check.
+func conditionApplies(args ...interface{}) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, fmt.Errorf("conditionApplies expects 4 arguments, got %d", len(args))
 	}
+	condition, _ := args[0].(string)
+	effect, _ := args[1].(string)
+	subject, _ := args[2].(conditions.Attributes)
+	object, _ := args[3].(conditions.Attributes)
 
-	return enforcer, nil
+	holds, err := conditions.Evaluate(condition, subject, object)
+	if err != nil {
+		return effect == models.PermissionEffectDeny, nil
+	}
+	return holds, nil
 }
 
-// loadPoliciesFromDB loads Casbin policies from the database
-func loadPoliciesFromDB(db *sql.DB, enforcer *casbin.Enforcer) error {
-	// Get all roles and permissions
-	roles, err := models.GetAllRoles(db, nil)
+// InitCasbin initializes the Casbin enforcer from the permissions and role
+// assignments in the database. The enforcer follows later changes made
+// through this server incrementally, and reloads the policy when another
+// replica announces a change.
+func InitCasbin(db *sql.DB) (*casbin.SyncedEnforcer, error) {
+	// The adapter loads the policy from our PostgreSQL tables
+	enforcer, err := newCasbinEnforcer(newCasbinAdapter(db))
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to load policies from database: %v", err)
 	}
 
-	// For each role, load its permissions
-	for _, role := range roles {
-		permissions, err := models.GetPermissionsByRoleID(db, role.ID)
-		if err != nil {
-			return err
+	// Keep replicas in sync. Without a watcher this server still sees its
+	// own changes, but not those made through other replicas.
+	watcher, err := newCasbinWatcher(db, DatabaseURL())
+	if err != nil {
+		log.Printf("Warning: Casbin policy watcher not started, policy changes made on other replicas will not be seen: %v", err)
+	} else {
+		if err := enforcer.SetWatcher(watcher); err != nil {
+			return nil, err
 		}
-
-		for _, perm := range permissions {
-			if perm.Resource == nil || perm.Action == nil {
-				continue
-			}
-			
-			// Add policy for role
-			_, err = enforcer.AddPolicy(role.Name, perm.Resource.Name, perm.Action.Name)
-			if err != nil {
-				return err
+		// Reload through the synced enforcer so enforcement waits for it
+		if err := watcher.SetUpdateCallback(func(string) {
+			if err := enforcer.LoadPolicy(); err != nil {
+				log.Printf("Failed to reload Casbin policies: %v", err)
 			}
+		}); err != nil {
+			return nil, err
 		}
 	}
 
-	// Load role inheritance (if applicable)
-	// This would be implemented based on your role hierarchy design
+	// Set the enforcer in the middleware
+	middleware.SetupCasbin(enforcer)
+
+	// Follow changes to permissions and role assignments as they are made
+	models.SetPolicyObserver(&casbinPolicyObserver{enforcer: enforcer})
 
-	return nil
+	return enforcer, nil
 }
 
-// setDefaultPolicies sets default policies if no policies are loaded
-func setDefaultPolicies(enforcer *casbin.Enforcer) {
-	// Admin role can do everything
-	enforcer.AddPolicy("admin", "*", "*")
-
-	// User role can view basic resources
-	enforcer.AddPolicy("user", "dashboard", "view")
-	enforcer.AddPolicy("user", "profile", "view")
-	enforcer.AddPolicy("user", "profile", "edit")
-
-	// More specific roles
-	enforcer.AddPolicy("compliance_officer", "compliance", "view")
-	enforcer.AddPolicy("compliance_officer", "declarations", "view")
-	enforcer.AddPolicy("compliance_officer", "declarations", "create")
-	enforcer.AddPolicy("compliance_officer", "declarations", "edit")
-
-	enforcer.AddPolicy("supplier_manager", "suppliers", "view")
-	enforcer.AddPolicy("supplier_manager", "suppliers", "create")
-	enforcer.AddPolicy("supplier_manager", "suppliers", "edit")
-	
-	// Save changes
-	enforcer.SavePolicy()
+// casbinPolicyObserver applies changes to permissions and role assignments
+// to the enforcer as they are written, which also tells the other replicas
+// through the watcher
+type casbinPolicyObserver struct {
+	enforcer *casbin.SyncedEnforcer
 }
 
-// AuthorizeMiddleware is a middleware for checking if the user has the required permissions
-func AuthorizeMiddleware(resource, action string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Skip if in development mode and permission check is disabled
-		if os.Getenv("DISABLE_AUTHORIZATION") == "true" {
-			c.Next()
-			return
-		}
+func (o *casbinPolicyObserver) PermissionAdded(permission *models.Permission) {
+	if permission.Resource == nil || permission.Action == nil {
+		return
+	}
+	if _, err := o.enforcer.AddPolicy(permissionRule(permission)); err != nil {
+		log.Printf("Failed to add Casbin policy for permission %d: %v", permission.ID, err)
+	}
+}
 
-		// Get user roles from context
-		rolesList, exists := c.Get("roles")
-		if !exists || rolesList == nil {
-			c.JSON(401, gin.H{"error": "Unauthorized"})
-			c.Abort()
-			return
-		}
+func (o *casbinPolicyObserver) PermissionRemoved(permission *models.Permission) {
+	if permission.Resource == nil || permission.Action == nil {
+		return
+	}
+	if _, err := o.enforcer.RemovePolicy(permissionRule(permission)); err != nil {
+		log.Printf("Failed to remove Casbin policy for permission %d: %v", permission.ID, err)
+	}
+}
 
-		// Check if user is super admin
-		isSuperAdmin, exists := c.Get("isSuperAdmin")
-		if exists && isSuperAdmin.(bool) {
-			// Super admins can do anything
-			c.Next()
-			return
-		}
+func (o *casbinPolicyObserver) RoleAssigned(userID, roleID, tenantID int) {
+	if _, err := o.enforcer.AddGroupingPolicy(middleware.UserSubject(userID), middleware.RoleSubject(roleID), middleware.TenantDomain(tenantID)); err != nil {
+		log.Printf("Failed to add Casbin role assignment for user %d: %v", userID, err)
+	}
+}
 
-		// Convert to string slice
-		roles, ok := rolesList.([]string)
-		if !ok {
-			c.JSON(500, gin.H{"error": "Invalid roles format"})
-			c.Abort()
-			return
-		}
+func (o *casbinPolicyObserver) RoleUnassigned(userID, roleID, tenantID int) {
+	if _, err := o.enforcer.RemoveGroupingPolicy(middleware.UserSubject(userID), middleware.RoleSubject(roleID), middleware.TenantDomain(tenantID)); err != nil {
+		log.Printf("Failed to remove Casbin role assignment for user %d: %v", userID, err)
+	}
+}
 
-		// Check if Casbin enforcer is initialized
-		if middleware.Enforcer == nil {
-			log.Println("Casbin enforcer not initialized, allowing access")
-			c.Next()
-			return
-		}
+func (o *casbinPolicyObserver) RoleParentSet(roleID, parentRoleID, tenantID int) {
+	if _, err := o.enforcer.AddGroupingPolicy(middleware.RoleSubject(roleID), middleware.RoleSubject(parentRoleID), middleware.TenantDomain(tenantID)); err != nil {
+		log.Printf("Failed to add Casbin parent for role %d: %v", roleID, err)
+	}
+}
 
-		// Check if any role has the required permission
-		for _, role := range roles {
-			allowed, err := middleware.Enforcer.Enforce(role, resource, action)
-			if err != nil {
-				log.Printf("Casbin enforcement error: %v", err)
-				c.JSON(500, gin.H{"error": "Authorization check failed"})
-				c.Abort()
-				return
-			}
+func (o *casbinPolicyObserver) RoleParentRemoved(roleID, parentRoleID, tenantID int) {
+	if _, err := o.enforcer.RemoveGroupingPolicy(middleware.RoleSubject(roleID), middleware.RoleSubject(parentRoleID), middleware.TenantDomain(tenantID)); err != nil {
+		log.Printf("Failed to remove Casbin parent for role %d: %v", roleID, err)
+	}
+}
 
-			if allowed {
-				// Permission granted
-				c.Next()
-				return
-			}
-		}
+func (o *casbinPolicyObserver) RoleRemoved(roleID int) {
+	if _, err := o.enforcer.RemoveFilteredPolicy(0, middleware.RoleSubject(roleID)); err != nil {
+		log.Printf("Failed to remove Casbin policies for role %d: %v", roleID, err)
+	}
+	// Removes the role's assignments and its children's links to it
+	if _, err := o.enforcer.RemoveFilteredGroupingPolicy(1, middleware.RoleSubject(roleID)); err != nil {
+		log.Printf("Failed to remove Casbin role assignments for role %d: %v", roleID, err)
+	}
+	if _, err := o.enforcer.RemoveFilteredGroupingPolicy(0, middleware.RoleSubject(roleID)); err != nil {
+		log.Printf("Failed to remove Casbin parent for role %d: %v", roleID, err)
+	}
+}
 
-		// No role has the required permission
-		c.JSON(403, gin.H{"error": fmt.Sprintf("Access denied: %s %s", resource, action)})
-		c.Abort()
+func (o *casbinPolicyObserver) UserRemoved(userID int) {
+	if _, err := o.enforcer.RemoveFilteredGroupingPolicy(0, middleware.UserSubject(userID)); err != nil {
+		log.Printf("Failed to remove Casbin role assignments for user %d: %v", userID, err)
 	}
-}
\ No newline at end of file
+}
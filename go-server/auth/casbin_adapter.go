@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+
+	"go-server/middleware"
+	"go-server/models"
+)
+
+// casbinAdapter is a Casbin adapter backed by the permissions and user_roles
+// tables. Those tables stay the source of truth and are written through the
+// models, so the adapter only reads them; a running enforcer follows later
+// changes through casbinPolicyObserver.
+type casbinAdapter struct {
+	db *sql.DB
+}
+
+// newCasbinAdapter creates a Casbin adapter reading the policy from the database
+func newCasbinAdapter(db *sql.DB) *casbinAdapter {
+	return &casbinAdapter{db: db}
+}
+
+// permissionRule is the p rule for a permission, without the leading "p".
+// A permission without an effect allows, as it did before deny rules.
+func permissionRule(permission *models.Permission) []string {
+	effect := permission.Effect
+	if effect == "" {
+		effect = models.PermissionEffectAllow
+	}
+	return []string{
+		middleware.RoleSubject(permission.RoleID),
+		middleware.TenantDomain(permission.TenantID),
+		middleware.PolicyObject(permission.Resource.Type, permission.Resource.Name),
+		permission.Action.Name,
+		permission.Condition,
+		effect,
+	}
+}
+
+// LoadPolicy loads a p rule for every permission, and a g rule for every
+// role assignment and for every role's parent, each in its tenant's domain
+func (a *casbinAdapter) LoadPolicy(m model.Model) error {
+	rows, err := a.db.Query(`
+		SELECT p.role_id, p.tenant_id, r.type, r.name, ac.name, p.condition, p.effect
+		FROM permissions p
+		INNER JOIN resources r ON p.resource_id = r.id
+		INNER JOIN actions ac ON p.action_id = ac.id
+		ORDER BY p.id
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		permission := models.Permission{Resource: &models.Resource{}, Action: &models.Action{}}
+		err := rows.Scan(
+			&permission.RoleID, &permission.TenantID, &permission.Resource.Type,
+			&permission.Resource.Name, &permission.Action.Name, &permission.Condition,
+			&permission.Effect,
+		)
+		if err != nil {
+			return err
+		}
+		rule := append([]string{"p"}, permissionRule(&permission)...)
+		if err := persist.LoadPolicyArray(rule, m); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	assignments, err := a.db.Query(`SELECT user_id, role_id, tenant_id FROM user_roles`)
+	if err != nil {
+		return err
+	}
+	defer assignments.Close()
+
+	for assignments.Next() {
+		var userID, roleID, tenantID int
+		if err := assignments.Scan(&userID, &roleID, &tenantID); err != nil {
+			return err
+		}
+		if err := persist.LoadPolicyArray([]string{"g", middleware.UserSubject(userID), middleware.RoleSubject(roleID), middleware.TenantDomain(tenantID)}, m); err != nil {
+			return err
+		}
+	}
+	if err := assignments.Err(); err != nil {
+		return err
+	}
+
+	// A role inherits its parent's permissions by holding the parent role
+	parents, err := a.db.Query(`SELECT id, parent_role_id, tenant_id FROM roles WHERE parent_role_id IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	defer parents.Close()
+
+	for parents.Next() {
+		var roleID, parentRoleID, tenantID int
+		if err := parents.Scan(&roleID, &parentRoleID, &tenantID); err != nil {
+			return err
+		}
+		if err := persist.LoadPolicyArray([]string{"g", middleware.RoleSubject(roleID), middleware.RoleSubject(parentRoleID), middleware.TenantDomain(tenantID)}, m); err != nil {
+			return err
+		}
+	}
+	return parents.Err()
+}
+
+// SavePolicy is not supported; permissions and role assignments are saved
+// through the API
+func (a *casbinAdapter) SavePolicy(m model.Model) error {
+	return errPolicyWrittenThroughModels
+}
+
+// errPolicyWrittenThroughModels refuses writes to the policy through the
+// enforcer. Autosave is off, so only a caller that turned it on sees it.
+var errPolicyWrittenThroughModels = errors.New("the policy is written through the permissions and roles APIs")
+
+// AddPolicy is not supported; the enforcer is only told about rules after
+// they have been written to the database
+func (a *casbinAdapter) AddPolicy(sec string, ptype string, rule []string) error {
+	return errPolicyWrittenThroughModels
+}
+
+// RemovePolicy is not supported; the enforcer is only told about rules
+// after they have been removed from the database
+func (a *casbinAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	return errPolicyWrittenThroughModels
+}
+
+// RemoveFilteredPolicy is not supported, like RemovePolicy
+func (a *casbinAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	return errPolicyWrittenThroughModels
+}
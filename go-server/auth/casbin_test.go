@@ -0,0 +1,468 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/gin-gonic/gin"
+
+	"go-server/conditions"
+	"go-server/middleware"
+	"go-server/models"
+)
+
+// Two tenants, each with an "admin" role. Tenant 1's admins may delete
+// users; tenant 2's may only read them.
+const (
+	tenantA = 1
+	tenantB = 2
+
+	adminRoleA = 10
+	adminRoleB = 20
+
+	userInA    = 100
+	userInB    = 200
+	userInBoth = 300
+)
+
+var noAttributes = conditions.Attributes{}
+
+// newTestEnforcer builds an enforcer with the server's model and applies the
+// policy through the observer, as the models would
+func newTestEnforcer(t *testing.T) (*casbin.SyncedEnforcer, *casbinPolicyObserver) {
+	t.Helper()
+
+	enforcer, err := newCasbinEnforcer(nil)
+	if err != nil {
+		t.Fatalf("newCasbinEnforcer: %v", err)
+	}
+	observer := &casbinPolicyObserver{enforcer: enforcer}
+
+	users := &models.Resource{Type: "system", Name: "users"}
+	read := &models.Action{Name: "read"}
+	del := &models.Action{Name: "delete"}
+
+	observer.PermissionAdded(&models.Permission{ID: 1, RoleID: adminRoleA, TenantID: tenantA, Resource: users, Action: read})
+	observer.PermissionAdded(&models.Permission{ID: 2, RoleID: adminRoleA, TenantID: tenantA, Resource: users, Action: del})
+	observer.PermissionAdded(&models.Permission{ID: 3, RoleID: adminRoleB, TenantID: tenantB, Resource: users, Action: read})
+
+	observer.RoleAssigned(userInA, adminRoleA, tenantA)
+	observer.RoleAssigned(userInB, adminRoleB, tenantB)
+	observer.RoleAssigned(userInBoth, adminRoleA, tenantA)
+	observer.RoleAssigned(userInBoth, adminRoleB, tenantB)
+
+	return enforcer, observer
+}
+
+// useEnforcer makes the enforcer the one the middleware checks requests with
+func useEnforcer(t *testing.T, enforcer *casbin.SyncedEnforcer) {
+	previous := middleware.Enforcer
+	middleware.SetupCasbin(enforcer)
+	t.Cleanup(func() { middleware.SetupCasbin(previous) })
+}
+
+// requirePermissionRouter mounts a route behind RequirePermission, for a
+// user authenticated as JWTAuth would, acting in the token's tenant
+func requirePermissionRouter(userID, tenantID int, method, path, resource, action string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		verifiedAt := time.Now()
+		c.Set("user", &models.User{ID: userID, TenantID: tenantID, IsActive: true, EmailVerifiedAt: &verifiedAt})
+		c.Next()
+	})
+	router.Handle(method, path, middleware.RequirePermission(nil, "system", resource, action), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func serve(router *gin.Engine, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestTenantIsolation(t *testing.T) {
+	enforcer, _ := newTestEnforcer(t)
+	useEnforcer(t, enforcer)
+
+	tests := []struct {
+		userID   int
+		tenantID int
+		action   string
+		want     bool
+	}{
+		{userInA, tenantA, "read", true},
+		{userInA, tenantA, "delete", true},
+		{userInA, tenantB, "read", false},
+		{userInA, tenantB, "delete", false},
+
+		{userInB, tenantB, "read", true},
+		{userInB, tenantB, "delete", false},
+		{userInB, tenantA, "read", false},
+		{userInB, tenantA, "delete", false},
+
+		// A member of both tenants has each admin role's rights only in
+		// the tenant it belongs to
+		{userInBoth, tenantA, "delete", true},
+		{userInBoth, tenantB, "read", true},
+		{userInBoth, tenantB, "delete", false},
+
+		{userInA, 3, "read", false},
+	}
+
+	for _, test := range tests {
+		got, err := enforcer.Enforce(middleware.UserSubject(test.userID), middleware.TenantDomain(test.tenantID), "users", test.action, noAttributes, noAttributes)
+		if err != nil {
+			t.Fatalf("Enforce(user %d, tenant %d, %s): %v", test.userID, test.tenantID, test.action, err)
+		}
+		if got != test.want {
+			t.Errorf("user %d in tenant %d, users %s: got %v, want %v", test.userID, test.tenantID, test.action, got, test.want)
+		}
+
+		// Routes are checked in the tenant of the token
+		want := http.StatusForbidden
+		if test.want {
+			want = http.StatusOK
+		}
+		router := requirePermissionRouter(test.userID, test.tenantID, http.MethodGet, "/users", "users", test.action)
+		if w := serve(router, http.MethodGet, "/users"); w.Code != want {
+			t.Errorf("user %d in tenant %d, route for users %s: got status %d, want %d", test.userID, test.tenantID, test.action, w.Code, want)
+		}
+	}
+}
+
+func TestPermissionInOtherTenantDoesNotApply(t *testing.T) {
+	enforcer, observer := newTestEnforcer(t)
+
+	// A permission recorded in tenant B for tenant A's role must not give
+	// that role's holders anything in either tenant
+	observer.PermissionAdded(&models.Permission{
+		ID: 4, RoleID: adminRoleA, TenantID: tenantB,
+		Resource: &models.Resource{Type: "system", Name: "roles"},
+		Action:   &models.Action{Name: "update"},
+	})
+
+	for _, tenantID := range []int{tenantA, tenantB} {
+		allowed, err := enforcer.Enforce(middleware.UserSubject(userInA), middleware.TenantDomain(tenantID), "roles", "update", noAttributes, noAttributes)
+		if err != nil {
+			t.Fatalf("Enforce: %v", err)
+		}
+		if allowed {
+			t.Errorf("user %d may update roles in tenant %d", userInA, tenantID)
+		}
+	}
+}
+
+func TestRoleUnassignedOnlyInItsTenant(t *testing.T) {
+	enforcer, observer := newTestEnforcer(t)
+
+	observer.RoleUnassigned(userInBoth, adminRoleA, tenantA)
+
+	allowed, err := enforcer.Enforce(middleware.UserSubject(userInBoth), middleware.TenantDomain(tenantA), "users", "read", noAttributes, noAttributes)
+	if err != nil {
+		t.Fatalf("Enforce: %v", err)
+	}
+	if allowed {
+		t.Error("user still reads users in tenant A after losing their role there")
+	}
+
+	allowed, err = enforcer.Enforce(middleware.UserSubject(userInBoth), middleware.TenantDomain(tenantB), "users", "read", noAttributes, noAttributes)
+	if err != nil {
+		t.Fatalf("Enforce: %v", err)
+	}
+	if !allowed {
+		t.Error("user lost access in tenant B when unassigned in tenant A")
+	}
+}
+
+func TestRequirePermissionUsesTokenTenant(t *testing.T) {
+	enforcer, _ := newTestEnforcer(t)
+	useEnforcer(t, enforcer)
+
+	tests := []struct {
+		name     string
+		userID   int
+		tenantID int
+		action   string
+		want     int
+	}{
+		{"own tenant", userInA, tenantA, "delete", http.StatusOK},
+		{"token for other tenant", userInA, tenantB, "read", http.StatusForbidden},
+		{"same role name in other tenant", userInBoth, tenantB, "delete", http.StatusForbidden},
+		{"member of both tenants", userInBoth, tenantB, "read", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		router := requirePermissionRouter(test.userID, test.tenantID, http.MethodGet, "/users", "users", test.action)
+		if w := serve(router, http.MethodGet, "/users"); w.Code != test.want {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+}
+
+func TestRequirePermissionFailsClosedWithoutEnforcer(t *testing.T) {
+	useEnforcer(t, nil)
+
+	router := requirePermissionRouter(userInA, tenantA, http.MethodGet, "/users", "users", "read")
+	if w := serve(router, http.MethodGet, "/users"); w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestAdapterRefusesPolicyWrites(t *testing.T) {
+	adapter := newCasbinAdapter(nil)
+	rule := []string{"role:1", "tenant:1", "users", "read", "", "allow"}
+
+	if err := adapter.AddPolicy("p", "p", rule); err == nil {
+		t.Error("AddPolicy succeeded without writing the permission")
+	}
+	if err := adapter.RemovePolicy("p", "p", rule); err == nil {
+		t.Error("RemovePolicy succeeded without removing the permission")
+	}
+	if err := adapter.RemoveFilteredPolicy("p", "p", 0, "role:1"); err == nil {
+		t.Error("RemoveFilteredPolicy succeeded without removing the permissions")
+	}
+}
+
+func TestRoleInheritsParentPermissionsInItsTenant(t *testing.T) {
+	enforcer, observer := newTestEnforcer(t)
+
+	// An auditor role in tenant A that inherits from A's admin role
+	const auditorRoleA = 11
+	const auditorInA = 400
+	observer.RoleAssigned(auditorInA, auditorRoleA, tenantA)
+	observer.RoleParentSet(auditorRoleA, adminRoleA, tenantA)
+
+	tests := []struct {
+		tenantID int
+		action   string
+		want     bool
+	}{
+		{tenantA, "read", true},
+		{tenantA, "delete", true},
+		{tenantB, "read", false},
+	}
+	for _, test := range tests {
+		got, err := enforcer.Enforce(middleware.UserSubject(auditorInA), middleware.TenantDomain(test.tenantID), "users", test.action, noAttributes, noAttributes)
+		if err != nil {
+			t.Fatalf("Enforce: %v", err)
+		}
+		if got != test.want {
+			t.Errorf("auditor in tenant %d, users %s: got %v, want %v", test.tenantID, test.action, got, test.want)
+		}
+	}
+
+	observer.RoleParentRemoved(auditorRoleA, adminRoleA, tenantA)
+	allowed, err := enforcer.Enforce(middleware.UserSubject(auditorInA), middleware.TenantDomain(tenantA), "users", "read", noAttributes, noAttributes)
+	if err != nil {
+		t.Fatalf("Enforce: %v", err)
+	}
+	if allowed {
+		t.Error("auditor still reads users after losing its parent role")
+	}
+}
+
+func TestConditionalPermission(t *testing.T) {
+	enforcer, observer := newTestEnforcer(t)
+	useEnforcer(t, enforcer)
+
+	// Supplier managers in tenant A may update suppliers only in regions
+	// they own
+	const supplierManagerRoleA = 12
+	const managerInA = 500
+	observer.RoleAssigned(managerInA, supplierManagerRoleA, tenantA)
+	observer.PermissionAdded(&models.Permission{
+		ID: 5, RoleID: supplierManagerRoleA, TenantID: tenantA,
+		Resource:  &models.Resource{Type: "system", Name: "suppliers"},
+		Action:    &models.Action{Name: "update"},
+		Condition: `r.object.region in r.subject.regions`,
+	})
+
+	owned := map[string]interface{}{"regions": []string{"EU"}}
+	tests := []struct {
+		name     string
+		tenantID int
+		subject  map[string]interface{}
+		object   map[string]interface{}
+		want     bool
+	}{
+		{"owned region", tenantA, owned, map[string]interface{}{"region": "EU"}, true},
+		{"other region", tenantA, owned, map[string]interface{}{"region": "US"}, false},
+		{"no object attributes", tenantA, owned, nil, false},
+		{"other tenant", tenantB, owned, map[string]interface{}{"region": "EU"}, false},
+	}
+
+	for _, test := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Set("user", &models.User{ID: managerInA, TenantID: test.tenantID})
+
+		got, _, err := middleware.AuthorizeRequest(c, "suppliers", "update", test.subject, test.object)
+		if err != nil {
+			t.Fatalf("%s: AuthorizeRequest: %v", test.name, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// The route has no region parameter, so the condition cannot hold there
+	router := requirePermissionRouter(managerInA, tenantA, http.MethodPut, "/suppliers", "suppliers", "update")
+	if w := serve(router, http.MethodPut, "/suppliers"); w.Code != http.StatusForbidden {
+		t.Errorf("middleware: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestConditionalPermissionOnRoute(t *testing.T) {
+	enforcer, observer := newTestEnforcer(t)
+	useEnforcer(t, enforcer)
+
+	// Members of tenant A may update only their own user
+	const memberRoleA = 15
+	const memberInA = 800
+	observer.RoleAssigned(memberInA, memberRoleA, tenantA)
+	observer.PermissionAdded(&models.Permission{
+		ID: 10, RoleID: memberRoleA, TenantID: tenantA,
+		Resource:  &models.Resource{Type: "system", Name: "users"},
+		Action:    &models.Action{Name: "update"},
+		Condition: `r.object.id == r.subject.id`,
+	})
+
+	router := requirePermissionRouter(memberInA, tenantA, http.MethodPut, "/users/:id", "users", "update")
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/users/800", http.StatusOK},
+		{"/users/801", http.StatusForbidden},
+		{"/users/me", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		if w := serve(router, http.MethodPut, test.target); w.Code != test.want {
+			t.Errorf("PUT %s: got status %d, want %d", test.target, w.Code, test.want)
+		}
+	}
+
+	// Nor does it apply in another tenant
+	other := requirePermissionRouter(memberInA, tenantB, http.MethodPut, "/users/:id", "users", "update")
+	if w := serve(other, http.MethodPut, "/users/800"); w.Code != http.StatusForbidden {
+		t.Errorf("PUT /users/800 in tenant B: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestDenyOverridesAllow(t *testing.T) {
+	enforcer, observer := newTestEnforcer(t)
+	useEnforcer(t, enforcer)
+
+	// Everyone in tenant A may delete declarations, except contractors.
+	// Tenant B's contractor role denies nothing in tenant A.
+	const (
+		everyoneRoleA   = 13
+		contractorRoleA = 14
+		contractorRoleB = 24
+		employeeInA     = 600
+		contractorInA   = 700
+	)
+	declarations := &models.Resource{Type: "system", Name: "declarations"}
+	del := &models.Action{Name: "delete"}
+	observer.PermissionAdded(&models.Permission{
+		ID: 6, RoleID: everyoneRoleA, TenantID: tenantA, Resource: declarations, Action: del,
+		Effect: models.PermissionEffectAllow,
+	})
+	observer.PermissionAdded(&models.Permission{
+		ID: 7, RoleID: contractorRoleA, TenantID: tenantA, Resource: declarations, Action: del,
+		Effect: models.PermissionEffectDeny,
+	})
+	observer.PermissionAdded(&models.Permission{
+		ID: 8, RoleID: contractorRoleB, TenantID: tenantB, Resource: declarations, Action: del,
+		Effect: models.PermissionEffectDeny,
+	})
+	observer.RoleAssigned(employeeInA, everyoneRoleA, tenantA)
+	observer.RoleAssigned(employeeInA, contractorRoleB, tenantB)
+	observer.RoleAssigned(contractorInA, everyoneRoleA, tenantA)
+	observer.RoleAssigned(contractorInA, contractorRoleA, tenantA)
+
+	allowed, denyRule, err := middleware.Enforce(employeeInA, tenantA, "declarations", "delete", nil, nil)
+	if err != nil {
+		t.Fatalf("Enforce: %v", err)
+	}
+	if !allowed || denyRule != nil {
+		t.Errorf("employee: got %v, %+v, want allowed", allowed, denyRule)
+	}
+
+	allowed, denyRule, err = middleware.Enforce(contractorInA, tenantA, "declarations", "delete", nil, nil)
+	if err != nil {
+		t.Fatalf("Enforce: %v", err)
+	}
+	if allowed {
+		t.Error("contractor may delete declarations")
+	}
+	want := middleware.DenyRule{RoleID: contractorRoleA, TenantID: tenantA, Resource: "declarations", Action: "delete"}
+	if denyRule == nil || *denyRule != want {
+		t.Errorf("contractor: got deny rule %+v, want %+v", denyRule, want)
+	}
+
+	// A user with no permission at all is refused without a deny rule
+	_, denyRule, err = middleware.Enforce(userInB, tenantB, "declarations", "delete", nil, nil)
+	if err != nil {
+		t.Fatalf("Enforce: %v", err)
+	}
+	if denyRule != nil {
+		t.Errorf("user without permission: got deny rule %+v", denyRule)
+	}
+
+	// The middleware's 403 names the deny rule that matched
+	router := requirePermissionRouter(contractorInA, tenantA, http.MethodDelete, "/declarations", "declarations", "delete")
+	w := serve(router, http.MethodDelete, "/declarations")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("middleware: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	var body struct {
+		DenyRule *middleware.DenyRule `json:"denyRule"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("middleware: %v", err)
+	}
+	if body.DenyRule == nil || *body.DenyRule != want {
+		t.Errorf("middleware: got deny rule %+v, want %+v", body.DenyRule, want)
+	}
+}
+
+func TestConditionalDeny(t *testing.T) {
+	enforcer, observer := newTestEnforcer(t)
+	useEnforcer(t, enforcer)
+
+	// Tenant A's admins may not delete users who are admins themselves
+	observer.PermissionAdded(&models.Permission{
+		ID: 9, RoleID: adminRoleA, TenantID: tenantA,
+		Resource:  &models.Resource{Type: "system", Name: "users"},
+		Action:    &models.Action{Name: "delete"},
+		Condition: `r.object.isAdmin == true`,
+		Effect:    models.PermissionEffectDeny,
+	})
+
+	tests := []struct {
+		name   string
+		object conditions.Attributes
+		want   bool
+	}{
+		{"not an admin", conditions.Attributes{"isAdmin": false}, true},
+		{"an admin", conditions.Attributes{"isAdmin": true}, false},
+		// A deny condition that cannot be evaluated refuses the action
+		{"no object attributes", nil, false},
+	}
+	for _, test := range tests {
+		got, _, err := middleware.Enforce(userInA, tenantA, "users", "delete", nil, test.object)
+		if err != nil {
+			t.Fatalf("%s: Enforce: %v", test.name, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
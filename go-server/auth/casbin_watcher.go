@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"go-server/tokens"
+)
+
+// casbinPolicyChannel is the Postgres notification channel replicas use to
+// tell each other the policy has changed
+const casbinPolicyChannel = "casbin_policy"
+
+// casbinWatcher is a Casbin watcher that keeps the enforcers of several
+// server replicas in sync through Postgres LISTEN/NOTIFY. Each replica
+// announces its own changes and reloads the policy when another one does.
+type casbinWatcher struct {
+	db       *sql.DB
+	listener *pq.Listener
+	// instanceID lets a replica ignore its own notifications
+	instanceID string
+
+	mu       sync.Mutex
+	callback func(string)
+	done     chan struct{}
+}
+
+// newCasbinWatcher starts listening for policy changes made by other replicas
+func newCasbinWatcher(db *sql.DB, dsn string) (*casbinWatcher, error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Casbin policy listener: %v", err)
+		}
+	})
+	if err := listener.Listen(casbinPolicyChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	w := &casbinWatcher{
+		db:         db,
+		listener:   listener,
+		instanceID: tokens.NewID(),
+		done:       make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// run calls the update callback for every change made by another replica.
+// After a reconnect, when notifications may have been missed, it is called
+// as well.
+func (w *casbinWatcher) run() {
+	for {
+		select {
+		case <-w.done:
+			return
+		case notification := <-w.listener.Notify:
+			if notification != nil && notification.Extra == w.instanceID {
+				continue
+			}
+			w.mu.Lock()
+			callback := w.callback
+			w.mu.Unlock()
+			if callback != nil {
+				source := ""
+				if notification != nil {
+					source = notification.Extra
+				}
+				callback(source)
+			}
+		case <-time.After(90 * time.Second):
+			// Check the connection is still alive
+			go w.listener.Ping()
+		}
+	}
+}
+
+// SetUpdateCallback sets the function called when another replica changes
+// the policy
+func (w *casbinWatcher) SetUpdateCallback(callback func(string)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callback = callback
+	return nil
+}
+
+// Update tells the other replicas the policy has changed
+func (w *casbinWatcher) Update() error {
+	_, err := w.db.Exec(`SELECT pg_notify($1, $2)`, casbinPolicyChannel, w.instanceID)
+	return err
+}
+
+// Close stops listening for changes
+func (w *casbinWatcher) Close() {
+	close(w.done)
+	w.listener.Close()
+}
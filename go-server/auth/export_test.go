@@ -0,0 +1,13 @@
+package auth
+
+import (
+	"database/sql"
+
+	"github.com/casbin/casbin/v2"
+)
+
+// NewPolicyEnforcer loads the policy from the database as InitCasbin does,
+// without the watcher, which needs a PostgreSQL server
+func NewPolicyEnforcer(db *sql.DB) (*casbin.SyncedEnforcer, error) {
+	return newCasbinEnforcer(newCasbinAdapter(db))
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"go-server/models"
+)
+
+// Login throttling. Each failed password login counts against the username
+// and against the client IP. Past a few free failures every further failure
+// doubles how long the next attempt has to wait, up to maxLoginDelay, and the
+// wait is checked before any password hashing so a blocked client costs us
+// no bcrypt work. Failures are forgotten after loginFailureWindow of quiet.
+const (
+	// userFreeLoginFailures is how many failures a username gets before backoff
+	userFreeLoginFailures = 5
+	// ipFreeLoginFailures is higher, since many users can share an office IP
+	ipFreeLoginFailures = 20
+	// baseLoginDelay is the wait after the first failure past the free ones
+	baseLoginDelay = time.Second
+	// maxLoginDelay caps the wait, and so how long an account stays locked
+	maxLoginDelay = 15 * time.Minute
+	// loginFailureWindow is how long failures are remembered
+	loginFailureWindow = time.Hour
+)
+
+// loginDelay returns how long to wait after the given number of consecutive
+// failures when the first free failures cost nothing
+func loginDelay(failures int, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+
+	delay := baseLoginDelay
+	for i := free + 1; i < failures; i++ {
+		delay *= 2
+		if delay >= maxLoginDelay {
+			return maxLoginDelay
+		}
+	}
+	return delay
+}
+
+// usernameKey is the login_attempts key for a username with no account.
+// Failures for existing users are recorded on their users row instead.
+func usernameKey(username string) string {
+	return "username:" + strings.ToLower(username)
+}
+
+// ipKey is the login_attempts key for a client IP
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// LoginRetryAfter reports how long a client must wait before trying to log
+// in as username again, or zero if it may try now. user is nil when no
+// account has the username; those usernames are throttled the same way so
+// the response does not reveal whether the account exists.
+func LoginRetryAfter(db *sql.DB, username string, ip string, user *models.User) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+
+	blockedUntil := func(until *time.Time) {
+		if until != nil && until.After(now) && until.Sub(now) > wait {
+			wait = until.Sub(now)
+		}
+	}
+
+	ipAttempt, err := models.GetLoginAttempt(db, ipKey(ip))
+	if err != nil {
+		return 0, err
+	}
+	if ipAttempt != nil {
+		blockedUntil(ipAttempt.BlockedUntil)
+	}
+
+	if user != nil {
+		blockedUntil(user.LockedUntil)
+	} else {
+		usernameAttempt, err := models.GetLoginAttempt(db, usernameKey(username))
+		if err != nil {
+			return 0, err
+		}
+		if usernameAttempt != nil {
+			blockedUntil(usernameAttempt.BlockedUntil)
+		}
+	}
+
+	return wait, nil
+}
+
+// RecordLoginFailure counts a failed login against the username and the
+// client IP, blocking further attempts once either is past its free failures
+func RecordLoginFailure(db *sql.DB, username string, ip string, user *models.User) error {
+	now := time.Now()
+	resetBefore := now.Add(-loginFailureWindow)
+
+	ipFailures, err := models.RecordLoginAttemptFailure(db, ipKey(ip), now, resetBefore)
+	if err != nil {
+		return err
+	}
+	if delay := loginDelay(ipFailures, ipFreeLoginFailures); delay > 0 {
+		if err := models.BlockLoginAttempts(db, ipKey(ip), now.Add(delay)); err != nil {
+			return err
+		}
+	}
+
+	if user == nil {
+		failures, err := models.RecordLoginAttemptFailure(db, usernameKey(username), now, resetBefore)
+		if err != nil {
+			return err
+		}
+		if delay := loginDelay(failures, userFreeLoginFailures); delay > 0 {
+			return models.BlockLoginAttempts(db, usernameKey(username), now.Add(delay))
+		}
+		return nil
+	}
+
+	failures, err := models.RecordUserLoginFailure(db, user.ID, now, resetBefore)
+	if err != nil {
+		return err
+	}
+	if delay := loginDelay(failures, userFreeLoginFailures); delay > 0 {
+		return models.LockUser(db, user.ID, now.Add(delay))
+	}
+	return nil
+}
+
+// RecordLoginSuccess clears the user's failed login count. The client IP's
+// count is left alone, so an attacker cannot reset it by logging in to an
+// account of their own between guesses.
+func RecordLoginSuccess(db *sql.DB, user *models.User) error {
+	if user.FailedLoginAttempts == 0 && user.LockedUntil == nil {
+		return nil
+	}
+	return models.UnlockUser(db, user.ID)
+}
+
+var (
+	dummyPasswordHash     []byte
+	dummyPasswordHashOnce sync.Once
+)
+
+// CompareDummyPassword spends the same bcrypt work as checking a real
+// password, for logins to usernames with no account, so response times do
+// not reveal which usernames exist
+func CompareDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+		if err == nil {
+			dummyPasswordHash = hash
+		}
+	})
+	if dummyPasswordHash != nil {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+	}
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"database/sql"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"go-server/models"
+	"go-server/passwords"
+)
+
+// CheckNewPassword checks a password someone is choosing against the policy
+// of the tenant it is for. user is the account whose password is changing,
+// or nil for a new account; its recent passwords may not be reused. Policy
+// violations are returned as a *passwords.PolicyError.
+func CheckNewPassword(db *sql.DB, tenantID int, user *models.User, password string) error {
+	policy, err := models.GetTenantPasswordPolicy(db, tenantID)
+	if err != nil {
+		return err
+	}
+
+	if err := passwords.Check(policy.Policy, password, passwords.Breached()); err != nil {
+		return err
+	}
+
+	if user == nil || policy.HistorySize == 0 {
+		return nil
+	}
+
+	// The current password counts as the first entry of the history, for
+	// accounts created before the history was kept
+	hashes, err := models.ListPasswordHistory(db, user.ID, policy.HistorySize)
+	if err != nil {
+		return err
+	}
+	hashes = append(hashes, user.Password)
+	for _, hash := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return passwords.ReusedError(policy.HistorySize)
+		}
+	}
+
+	return nil
+}
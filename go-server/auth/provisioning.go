@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"go-server/models"
+)
+
+// ExternalIdentity is a user as asserted by an external identity provider
+type ExternalIdentity struct {
+	// ConnectionID is the tenant OIDC connection the user signed in through,
+	// or zero for Casdoor
+	ConnectionID int
+	// SAMLConnectionID is the tenant SAML connection the user signed in
+	// through, or zero for Casdoor
+	SAMLConnectionID int
+	// Subject is the provider's stable user ID. Casdoor subjects are stored as
+	// users.casdoor_id, OIDC subjects in user_identities and SAML subjects in
+	// saml_identities.
+	Subject string
+	// Organization is the provider organization the user belongs to. It maps
+	// to the tenant of the same name; empty means the default tenant.
+	Organization string
+	Username     string
+	DisplayName  string
+	Email        string
+	Avatar       string
+	// Roles and Groups are the provider's role and group names. Nil means the
+	// provider sent no such claims, which leaves local roles untouched.
+	Roles  []string
+	Groups []string
+}
+
+// defaultProvisionedRole is the role given to provisioned users with no role in their tenant
+func defaultProvisionedRole() string {
+	if role := os.Getenv("SSO_DEFAULT_ROLE"); role != "" {
+		return role
+	}
+	return "user"
+}
+
+// ProvisionUser creates or updates the local user for an external identity.
+// Users are matched on their provider subject, never on username or email,
+// so a provider account cannot take over an existing local account.
+func ProvisionUser(db *sql.DB, identity ExternalIdentity) (*models.User, error) {
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("identity has no subject")
+	}
+
+	tenant, err := provisionTenant(db, identity.Organization)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	switch {
+	case identity.ConnectionID != 0:
+		user, err = models.GetUserByIdentity(db, identity.ConnectionID, identity.Subject)
+	case identity.SAMLConnectionID != 0:
+		user, err = models.GetUserBySAMLIdentity(db, identity.SAMLConnectionID, identity.Subject)
+	default:
+		user, err = models.GetUserByCasdoorID(db, identity.Subject)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if user == nil {
+		user, err = createProvisionedUser(db, identity, tenant.ID)
+		if err != nil {
+			return nil, err
+		}
+		if identity.ConnectionID != 0 {
+			if err := models.LinkUserIdentity(db, user.ID, identity.ConnectionID, identity.Subject); err != nil {
+				return nil, err
+			}
+		}
+		if identity.SAMLConnectionID != 0 {
+			if err := models.LinkUserSAMLIdentity(db, user.ID, identity.SAMLConnectionID, identity.Subject); err != nil {
+				return nil, err
+			}
+		}
+		log.Printf("Provisioned user %s for %s in tenant %s", user.Username, identity.Subject, tenant.Name)
+	} else {
+		user, err = syncProvisionedUser(db, user, identity)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The provider vouches for the address it sends, so the user does not
+	// have to verify it again here
+	if identity.Email != "" && user.EmailVerifiedAt == nil && strings.EqualFold(identity.Email, user.Email) {
+		if _, err := models.MarkEmailVerified(db, user.ID, user.Email); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	if err := syncMappedRoles(db, user, identity); err != nil {
+		return nil, err
+	}
+
+	if err := ensureDefaultRole(db, user.ID, user.TenantID); err != nil {
+		return nil, err
+	}
+
+	if err := models.UpdateLastLogin(db, user.ID); err != nil {
+		log.Printf("Failed to update last login time: %v", err)
+	}
+
+	return user, nil
+}
+
+// provisionTenant returns the tenant for a provider organization, creating it if needed
+func provisionTenant(db *sql.DB, organization string) (*models.Tenant, error) {
+	if organization == "" {
+		tenantID, err := defaultTenantID(db)
+		if err != nil {
+			return nil, err
+		}
+		return models.GetTenantByID(db, tenantID)
+	}
+
+	tenant, err := models.GetTenantByName(db, organization)
+	if err != nil || tenant != nil {
+		return tenant, err
+	}
+
+	tenant, err = models.CreateTenant(db, models.CreateTenantInput{
+		Name:        organization,
+		DisplayName: organization,
+		Description: "Provisioned from identity provider organization",
+	})
+	if err != nil {
+		// Another login may have created it concurrently
+		existing, getErr := models.GetTenantByName(db, organization)
+		if getErr == nil && existing != nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+
+	log.Printf("Provisioned tenant %s", organization)
+	return tenant, nil
+}
+
+// createProvisionedUser creates a user for an identity seen for the first time
+func createProvisionedUser(db *sql.DB, identity ExternalIdentity, tenantID int) (*models.User, error) {
+	username, err := provisionedUsername(db, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	email := identity.Email
+	if email == "" {
+		// Email is required and unique locally, but optional at the provider
+		email = fmt.Sprintf("%s@users.sso.invalid", identity.Subject)
+		if identity.ConnectionID != 0 {
+			email = fmt.Sprintf("%s.%d@users.sso.invalid", identity.Subject, identity.ConnectionID)
+		}
+		if identity.SAMLConnectionID != 0 {
+			email = fmt.Sprintf("%s.saml%d@users.sso.invalid", identity.Subject, identity.SAMLConnectionID)
+		}
+	}
+
+	displayName := identity.DisplayName
+	if displayName == "" {
+		displayName = username
+	}
+
+	var avatar *string
+	if identity.Avatar != "" {
+		avatar = &identity.Avatar
+	}
+
+	// Only Casdoor subjects live on the user; connection subjects are linked
+	// once the user exists
+	var casdoorID *string
+	if identity.ConnectionID == 0 && identity.SAMLConnectionID == 0 {
+		subject := identity.Subject
+		casdoorID = &subject
+	}
+
+	return models.CreateUser(db, &models.User{
+		Username:    username,
+		Password:    unusablePassword(),
+		Email:       email,
+		DisplayName: displayName,
+		Avatar:      avatar,
+		TenantID:    tenantID,
+		IsActive:    true,
+		CasdoorID:   casdoorID,
+	})
+}
+
+// provisionedUsername picks a free username, qualifying it with the
+// organization if the plain name is taken by another account
+func provisionedUsername(db *sql.DB, identity ExternalIdentity) (string, error) {
+	candidates := []string{identity.Username}
+	if identity.Organization != "" {
+		candidates = append(candidates, identity.Organization+"/"+identity.Username)
+	}
+	candidates = append(candidates, identity.Subject)
+
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		_, err := models.GetUserByUsername(db, candidate)
+		if err == sql.ErrNoRows {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("no free username for %s", identity.Subject)
+}
+
+// syncProvisionedUser updates profile fields the provider owns
+func syncProvisionedUser(db *sql.DB, user *models.User, identity ExternalIdentity) (*models.User, error) {
+	var input models.UpdateUserInput
+	changed := false
+
+	if identity.DisplayName != "" && identity.DisplayName != user.DisplayName {
+		input.DisplayName = &identity.DisplayName
+		changed = true
+	}
+	if identity.Email != "" && !strings.EqualFold(identity.Email, user.Email) {
+		input.Email = &identity.Email
+		changed = true
+	}
+	if identity.Avatar != "" && (user.Avatar == nil || *user.Avatar != identity.Avatar) {
+		input.Avatar = &identity.Avatar
+		changed = true
+	}
+
+	if !changed {
+		return user, nil
+	}
+
+	updated, err := models.UpdateUser(db, user.ID, input)
+	if err != nil {
+		// Keep the login working if e.g. the new email clashes with another account
+		log.Printf("Failed to sync profile for user %s: %v", user.Username, err)
+		return user, nil
+	}
+	return updated, nil
+}
+
+// ensureDefaultRole gives the user the default role if they have no role in the tenant
+func ensureDefaultRole(db *sql.DB, userID int, tenantID int) error {
+	roles, err := models.GetUserRolesByUserID(db, userID, &tenantID)
+	if err != nil {
+		return err
+	}
+	if len(roles) > 0 {
+		return nil
+	}
+
+	role, err := defaultRole(db, tenantID)
+	if err != nil {
+		return err
+	}
+
+	return models.AssignRoleToUser(db, userID, role.ID, tenantID)
+}
+
+// unusablePassword returns a random password hash input nobody knows, so
+// provisioned users can only sign in through their provider
+func unusablePassword() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
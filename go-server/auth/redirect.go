@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"database/sql"
+	"net/url"
+	"strings"
+
+	"go-server/models"
+)
+
+// RedirectAllowed reports whether users of the tenant may be sent to target
+// after login. Local paths are always allowed; absolute URLs must match one
+// of the tenant's allowed redirect URIs.
+func RedirectAllowed(db *sql.DB, tenantID int, target string) (bool, error) {
+	if isLocalPath(target) {
+		return true, nil
+	}
+
+	uris, err := models.ListTenantRedirectURIs(db, tenantID)
+	if err != nil {
+		return false, err
+	}
+
+	patterns := make([]string, 0, len(uris))
+	for _, uri := range uris {
+		patterns = append(patterns, uri.URI)
+	}
+
+	return redirectMatches(target, patterns), nil
+}
+
+// redirectMatches reports whether target is a local path or matches one of
+// the allowed patterns. A pattern matches URLs with the same scheme and host;
+// its path must match exactly, or as a prefix if it ends in a slash. An empty
+// or "/" path allows the whole origin.
+func redirectMatches(target string, patterns []string) bool {
+	if isLocalPath(target) {
+		return true
+	}
+
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.Host == "" || parsed.User != nil {
+		return false
+	}
+	if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return false
+	}
+	if hasDotSegment(parsed.Path) {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		allowed, err := url.Parse(pattern)
+		if err != nil {
+			continue
+		}
+		if !strings.EqualFold(allowed.Scheme, parsed.Scheme) || !strings.EqualFold(allowed.Host, parsed.Host) {
+			continue
+		}
+
+		switch {
+		case allowed.Path == "" || allowed.Path == "/":
+			return true
+		case strings.HasSuffix(allowed.Path, "/") && strings.HasPrefix(parsed.Path, allowed.Path):
+			return true
+		case parsed.Path == allowed.Path:
+			return true
+		}
+	}
+
+	return false
+}
+
+// isLocalPath reports whether target is a path on this site, rather than a
+// URL or a scheme-relative "//host" reference that browsers treat as one
+func isLocalPath(target string) bool {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.ContainsAny(target, "\\\r\n\t") {
+		return false
+	}
+
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" {
+		return false
+	}
+	return !hasDotSegment(parsed.Path)
+}
+
+// hasDotSegment reports whether a path contains a ".." segment
+func hasDotSegment(path string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
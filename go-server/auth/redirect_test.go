@@ -0,0 +1,45 @@
+package auth
+
+import "testing"
+
+func TestRedirectMatches(t *testing.T) {
+	patterns := []string{
+		"https://app.example.com/auth/callback",
+		"https://portal.example.com/",
+		"https://docs.example.com/tenant/",
+	}
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"/dashboard", true},
+		{"/auth/callback?next=%2Fdashboard", true},
+		{"https://app.example.com/auth/callback", true},
+		{"https://APP.example.com/auth/callback", true},
+		{"https://portal.example.com/anything/here", true},
+		{"https://docs.example.com/tenant/page", true},
+
+		{"", false},
+		{"dashboard", false},
+		{"//evil.example.com/path", false},
+		{"/\\evil.example.com", false},
+		{"/../../etc", false},
+		{"https://app.example.com/auth/callback/extra", false},
+		{"https://app.example.com/other", false},
+		{"http://app.example.com/auth/callback", false},
+		{"https://app.example.com.evil.com/auth/callback", false},
+		{"https://user@app.example.com/auth/callback", false},
+		{"https://docs.example.com/tenant", false},
+		{"https://docs.example.com/tenant/../admin", false},
+		{"https://docs.example.com/tenant/%2e%2e/admin", false},
+		{"javascript:alert(1)", false},
+		{"https://evil.example.com/", false},
+	}
+
+	for _, test := range tests {
+		if got := redirectMatches(test.target, patterns); got != test.want {
+			t.Errorf("redirectMatches(%q) = %v, want %v", test.target, got, test.want)
+		}
+	}
+}
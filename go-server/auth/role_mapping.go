@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"go-server/models"
+)
+
+// roleAuditSourceIdP marks role changes made by identity provider mappings
+const roleAuditSourceIdP = "idp"
+
+// syncMappedRoles applies the tenant's identity provider mappings to the
+// user's roles. Mapped roles are always added; when the tenant makes the
+// provider authoritative, roles that are not mapped from this login are
+// removed. Every change is written to the role assignment audit trail.
+func syncMappedRoles(db *sql.DB, user *models.User, identity ExternalIdentity) error {
+	// The provider did not send role or group claims, so there is nothing to apply
+	if identity.Roles == nil && identity.Groups == nil {
+		return nil
+	}
+
+	settings, err := models.GetIdPMappingSettings(db, user.TenantID)
+	if err != nil {
+		return err
+	}
+
+	mappings, err := models.ResolveIdPRoleMappings(db, user.TenantID, identity.Roles, identity.Groups)
+	if err != nil {
+		return err
+	}
+
+	// Collect the roles the claims map to, and why
+	desired := make(map[int]string)
+	reasons := make(map[int][]string)
+	for _, mapping := range mappings {
+		desired[mapping.RoleID] = mapping.RoleName
+		reasons[mapping.RoleID] = append(reasons[mapping.RoleID], mapping.ClaimType+" "+mapping.ExternalName)
+	}
+
+	// An authoritative provider that maps no roles leaves the user with the default role
+	if settings.Authoritative && len(desired) == 0 {
+		role, err := defaultRole(db, user.TenantID)
+		if err != nil {
+			return err
+		}
+		desired[role.ID] = role.Name
+		reasons[role.ID] = []string{"default role"}
+	}
+
+	current, err := models.GetUserRolesByUserID(db, user.ID, &user.TenantID)
+	if err != nil {
+		return err
+	}
+
+	assigned := make(map[int]bool, len(current))
+	for _, role := range current {
+		assigned[role.ID] = true
+	}
+
+	for roleID, roleName := range desired {
+		if assigned[roleID] {
+			continue
+		}
+		if err := models.AssignRoleToUser(db, user.ID, roleID, user.TenantID); err != nil {
+			return err
+		}
+		auditRoleChange(db, user, roleID, roleName, models.RoleAuditAssigned,
+			"mapped from identity provider "+strings.Join(reasons[roleID], ", "))
+	}
+
+	if !settings.Authoritative {
+		return nil
+	}
+
+	for _, role := range current {
+		if _, ok := desired[role.ID]; ok {
+			continue
+		}
+		if err := models.RemoveRoleFromUser(db, user.ID, role.ID, user.TenantID); err != nil {
+			return err
+		}
+		auditRoleChange(db, user, role.ID, role.Name, models.RoleAuditRemoved,
+			"not present in identity provider claims")
+	}
+
+	return nil
+}
+
+// auditRoleChange records a role change made by the identity provider mappings
+func auditRoleChange(db *sql.DB, user *models.User, roleID int, roleName string, action string, detail string) {
+	err := models.RecordRoleAssignmentAudit(db, &models.RoleAssignmentAudit{
+		UserID:   user.ID,
+		TenantID: user.TenantID,
+		RoleID:   roleID,
+		RoleName: roleName,
+		Action:   action,
+		Source:   roleAuditSourceIdP,
+		Detail:   detail,
+	})
+	if err != nil {
+		log.Printf("Failed to record role %s %s for user %s: %v", roleName, action, user.Username, err)
+	}
+}
+
+// defaultRole returns the tenant's default role for provisioned users, creating it if needed
+func defaultRole(db *sql.DB, tenantID int) (*models.Role, error) {
+	roleName := defaultProvisionedRole()
+	role, err := models.GetRoleByName(db, roleName, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if role != nil {
+		return role, nil
+	}
+
+	role, err = models.CreateRole(db, models.CreateRoleInput{
+		Name:        roleName,
+		DisplayName: strings.ToUpper(roleName[:1]) + roleName[1:],
+		Description: "Default role for provisioned users",
+		TenantID:    tenantID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create default role: %v", err)
+	}
+	return role, nil
+}
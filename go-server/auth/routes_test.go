@@ -0,0 +1,269 @@
+package auth_test
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-server/auth"
+	"go-server/middleware"
+	"go-server/models"
+	"go-server/routes"
+	"go-server/sqltest"
+	"go-server/tokens"
+)
+
+var userColumns = []string{
+	"id", "username", "password", "email", "display_name",
+	"avatar", "tenant_id", "is_active", "is_super_admin",
+	"last_login", "casdoor_id", "created_at", "updated_at",
+	"failed_login_attempts", "locked_until", "must_change_password",
+	"email_verified_at", "is_service_account",
+}
+
+// initTestKeyring signs tokens with a key kept in a database of its own
+func initTestKeyring(t *testing.T) {
+	t.Helper()
+	db := sqltest.Open(t)
+	var keys []models.SigningKey
+
+	db.Handle(`^SELECT kid, algorithm, private_key, created_at FROM signing_keys`, func([]driver.Value) (*sqltest.Result, error) {
+		return &sqltest.Result{Columns: []string{"kid", "algorithm", "private_key", "created_at"}}, nil
+	})
+	db.Handle(`^INSERT INTO signing_keys`, func(args []driver.Value) (*sqltest.Result, error) {
+		keys = append(keys, models.SigningKey{
+			KID:        args[0].(string),
+			Algorithm:  args[1].(string),
+			PrivateKey: args[2].(string),
+			CreatedAt:  args[3].(time.Time),
+		})
+		return sqltest.Affected(1), nil
+	})
+	db.Ignore(`^UPDATE signing_keys SET retired_at`)
+	db.Handle(`^SELECT kid, algorithm, private_key, created_at, retired_at FROM signing_keys`, func([]driver.Value) (*sqltest.Result, error) {
+		result := &sqltest.Result{Columns: []string{"kid", "algorithm", "private_key", "created_at", "retired_at"}}
+		for _, key := range keys {
+			result.Rows = append(result.Rows, []driver.Value{key.KID, key.Algorithm, key.PrivateKey, key.CreatedAt, nil})
+		}
+		return result, nil
+	})
+
+	config := tokens.KeyringConfig{Algorithm: tokens.AlgorithmES256, GracePeriod: time.Hour}
+	if err := tokens.InitKeyring(db.DB, config); err != nil {
+		t.Fatalf("InitKeyring: %v", err)
+	}
+}
+
+// newMountedAPI serves the API as main does, for users of tenant 1 whose
+// roles are those in assignments and whose permissions are the rows of
+// permissions, given as the adapter reads them. Tests add handlers for the
+// statements of the routes they call to the returned database.
+func newMountedAPI(t *testing.T, users []*models.User, permissions [][]driver.Value, assignments [][]driver.Value) (*gin.Engine, *sqltest.DB) {
+	t.Helper()
+	db := sqltest.Open(t)
+
+	db.Handle(`^SELECT p.role_id, p.tenant_id, r.type, r.name, ac.name, p.condition, p.effect FROM permissions`, func([]driver.Value) (*sqltest.Result, error) {
+		return &sqltest.Result{Columns: []string{"role_id", "tenant_id", "type", "name", "name", "condition", "effect"}, Rows: permissions}, nil
+	})
+	db.Handle(`^SELECT user_id, role_id, tenant_id FROM user_roles$`, func([]driver.Value) (*sqltest.Result, error) {
+		return &sqltest.Result{Columns: []string{"user_id", "role_id", "tenant_id"}, Rows: assignments}, nil
+	})
+	db.Handle(`^SELECT id, parent_role_id, tenant_id FROM roles WHERE parent_role_id IS NOT NULL$`, func([]driver.Value) (*sqltest.Result, error) {
+		return &sqltest.Result{Columns: []string{"id", "parent_role_id", "tenant_id"}}, nil
+	})
+
+	db.Handle(`^SELECT id, username, password, .* FROM users WHERE id = \$1`, func(args []driver.Value) (*sqltest.Result, error) {
+		result := &sqltest.Result{Columns: userColumns}
+		for _, user := range users {
+			if int64(user.ID) == args[0].(int64) {
+				result.Rows = append(result.Rows, []driver.Value{
+					int64(user.ID), user.Username, "", user.Email, user.DisplayName,
+					nil, int64(user.TenantID), true, false,
+					nil, nil, user.CreatedAt, user.UpdatedAt,
+					int64(0), nil, false,
+					user.CreatedAt, false,
+				})
+			}
+		}
+		return result, nil
+	})
+	db.Handle(`^SELECT EXISTS \(SELECT 1 FROM revoked_tokens`, func([]driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row([]string{"revoked"}, false), nil
+	})
+	db.Ignore(`^UPDATE user_sessions SET last_seen_at`)
+
+	enforcer, err := auth.NewPolicyEnforcer(db.DB)
+	if err != nil {
+		t.Fatalf("NewPolicyEnforcer: %v", err)
+	}
+	previous := middleware.Enforcer
+	middleware.SetupCasbin(enforcer)
+	t.Cleanup(func() { middleware.SetupCasbin(previous) })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	routes.RegisterAllRoutes(router.Group("/api"), db.DB)
+	return router, db
+}
+
+func TestMountedRoutesApplyDenyRules(t *testing.T) {
+	initTestKeyring(t)
+
+	// Members of tenant 1 may read users. Contractors may not read user 1,
+	// and the caller is both.
+	const memberRole, contractorRole = 10, 11
+	now := time.Now()
+	var users []*models.User
+	for _, id := range []int{1, 7, 8} {
+		users = append(users, &models.User{ID: id, Username: "user", TenantID: 1, CreatedAt: now, UpdatedAt: now})
+	}
+	router, _ := newMountedAPI(t, users,
+		[][]driver.Value{
+			{int64(memberRole), int64(1), "system", "users", "read", "", "allow"},
+			{int64(contractorRole), int64(1), "system", "users", "read", "r.object.id == 1", "deny"},
+		},
+		[][]driver.Value{
+			{int64(7), int64(memberRole), int64(1)},
+			{int64(7), int64(contractorRole), int64(1)},
+		},
+	)
+
+	token, _, err := tokens.IssueForUser(users[1], nil)
+	if err != nil {
+		t.Fatalf("IssueForUser: %v", err)
+	}
+	request := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The deny rule's condition does not hold for other users
+	if w := request(http.MethodGet, "/api/users/8"); w.Code != http.StatusOK {
+		t.Errorf("GET /api/users/8: got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	w := request(http.MethodGet, "/api/users/1")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("GET /api/users/1: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	var denied struct {
+		Error    string               `json:"error"`
+		DenyRule *middleware.DenyRule `json:"denyRule"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &denied); err != nil {
+		t.Fatalf("GET /api/users/1: %v", err)
+	}
+	wantRule := middleware.DenyRule{RoleID: contractorRole, TenantID: 1, Resource: "users", Action: "read", Condition: "r.object.id == 1"}
+	if denied.DenyRule == nil || *denied.DenyRule != wantRule {
+		t.Errorf("GET /api/users/1: got deny rule %+v, want %+v", denied.DenyRule, wantRule)
+	}
+	if want := "Access denied: role 11 may not read users"; denied.Error != want {
+		t.Errorf("GET /api/users/1: got error %q, want %q", denied.Error, want)
+	}
+
+	// Without a grant there is no deny rule to name
+	w = request(http.MethodDelete, "/api/users/8")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("DELETE /api/users/8: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	denied.DenyRule = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &denied); err != nil {
+		t.Fatalf("DELETE /api/users/8: %v", err)
+	}
+	if denied.DenyRule != nil {
+		t.Errorf("DELETE /api/users/8: got deny rule %+v", denied.DenyRule)
+	}
+}
+
+func TestMountedRoutesRefuseWritesToOtherTenants(t *testing.T) {
+	initTestKeyring(t)
+
+	// The caller administers roles and permissions in tenant 1. Role 20 and
+	// permission 5 belong to tenant 2.
+	const adminRole, otherRole, otherPermission = 10, 20, 5
+	now := time.Now()
+	admin := &models.User{ID: 7, Username: "admin", TenantID: 1, CreatedAt: now, UpdatedAt: now}
+	var permissions [][]driver.Value
+	for _, grant := range [][2]string{
+		{"roles", "create"}, {"roles", "update"}, {"roles", "delete"}, {"roles", "assign"},
+		{"permissions", "create"}, {"permissions", "delete"},
+	} {
+		permissions = append(permissions, []driver.Value{int64(adminRole), int64(1), "system", grant[0], grant[1], "", "allow"})
+	}
+	router, db := newMountedAPI(t, []*models.User{admin}, permissions, [][]driver.Value{{int64(admin.ID), int64(adminRole), int64(1)}})
+
+	roleTenants := map[int64]int64{adminRole: 1, otherRole: 2}
+	db.Handle(`^SELECT id, name, display_name, description, tenant_id, parent_role_id, created_at, updated_at FROM roles WHERE id = \$1$`, func(args []driver.Value) (*sqltest.Result, error) {
+		result := &sqltest.Result{Columns: []string{"id", "name", "display_name", "description", "tenant_id", "parent_role_id", "created_at", "updated_at"}}
+		if tenantID, ok := roleTenants[args[0].(int64)]; ok {
+			result.Rows = append(result.Rows, []driver.Value{args[0], "admin", "Admin", "", tenantID, nil, now, now})
+		}
+		return result, nil
+	})
+	db.Handle(`^SELECT id, role_id, resource_id, action_id, tenant_id, condition, effect, created_at FROM permissions WHERE id = \$1$`, func(args []driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row([]string{"id", "role_id", "resource_id", "action_id", "tenant_id", "condition", "effect", "created_at"},
+			int64(otherPermission), int64(otherRole), int64(1), int64(1), int64(2), "", "allow", now), nil
+	})
+	db.Handle(`FROM resources WHERE id = \$1`, func(args []driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row([]string{"id", "type", "name", "display_name", "description", "created_at", "updated_at"},
+			args[0], "system", "users", "Users", "", now, now), nil
+	})
+	db.Handle(`FROM actions WHERE id = \$1`, func(args []driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row([]string{"id", "name", "display_name", "description", "created_at", "updated_at"},
+			args[0], "read", "Read", "", now, now), nil
+	})
+
+	db.Handle(`^DELETE FROM user_roles WHERE user_id = \$1 AND role_id = \$2 AND tenant_id = \$3$`, func([]driver.Value) (*sqltest.Result, error) {
+		return sqltest.Affected(1), nil
+	})
+
+	token, _, err := tokens.IssueForUser(admin, nil)
+	if err != nil {
+		t.Fatalf("IssueForUser: %v", err)
+	}
+
+	tests := []struct {
+		method string
+		target string
+		body   map[string]interface{}
+		want   int
+	}{
+		{http.MethodPost, "/api/roles", map[string]interface{}{"name": "owner", "displayName": "Owner", "tenantId": 2}, http.StatusForbidden},
+		{http.MethodPut, "/api/roles/20", map[string]interface{}{"displayName": "Owner"}, http.StatusForbidden},
+		{http.MethodDelete, "/api/roles/20", nil, http.StatusForbidden},
+		{http.MethodPost, "/api/user-roles", map[string]interface{}{"userId": admin.ID, "roleId": otherRole}, http.StatusForbidden},
+		{http.MethodDelete, "/api/user-roles/7/20", nil, http.StatusForbidden},
+		{http.MethodPost, "/api/permissions", map[string]interface{}{"roleId": otherRole, "resourceId": 1, "actionId": 1, "tenantId": 2}, http.StatusForbidden},
+		{http.MethodDelete, "/api/permissions/5", nil, http.StatusForbidden},
+
+		// Roles of the caller's own tenant cannot be moved into another
+		{http.MethodPut, "/api/roles/10", map[string]interface{}{"tenantId": 2}, http.StatusBadRequest},
+
+		// Roles of the caller's own tenant are still managed
+		{http.MethodDelete, "/api/user-roles/7/10", nil, http.StatusOK},
+	}
+	for _, test := range tests {
+		var body bytes.Buffer
+		if test.body != nil {
+			json.NewEncoder(&body).Encode(test.body)
+		}
+		req := httptest.NewRequest(test.method, test.target, &body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.want {
+			t.Errorf("%s %s: got status %d, want %d: %s", test.method, test.target, w.Code, test.want, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"go-server/models"
+)
+
+// serviceAccountEmailDomain gives service accounts the unique email address
+// users need without one that can receive mail
+const serviceAccountEmailDomain = "service-accounts.invalid"
+
+// CreateServiceAccount creates a service account user in a tenant. It has
+// no usable password, so it can only act through personal access tokens or
+// as an API client.
+func CreateServiceAccount(db *sql.DB, tenantID int, username string, displayName string) (*models.User, error) {
+	if displayName == "" {
+		displayName = username
+	}
+
+	return models.CreateUser(db, &models.User{
+		Username:         username,
+		Password:         unusablePassword(),
+		Email:            fmt.Sprintf("%s@%s", strings.ToLower(username), serviceAccountEmailDomain),
+		DisplayName:      displayName,
+		TenantID:         tenantID,
+		IsActive:         true,
+		IsServiceAccount: true,
+	})
+}
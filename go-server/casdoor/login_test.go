@@ -0,0 +1,225 @@
+package casdoor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"go-server/oidc"
+)
+
+const (
+	testClientSecret = "test-client-secret"
+	testCallbackURL  = "https://app.example.com/api/auth/callback"
+)
+
+// fakeCasdoor serves Casdoor's authorize and token endpoints. Codes are only
+// redeemed with the PKCE verifier for the challenge they were issued to, and
+// client credentials are expected in the form, as Casdoor takes them.
+type fakeCasdoor struct {
+	server *httptest.Server
+
+	mu         sync.Mutex
+	challenges map[string]string
+	exchanges  int
+}
+
+func newFakeCasdoor(t *testing.T) *fakeCasdoor {
+	t.Helper()
+	f := &fakeCasdoor{challenges: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/authorize", f.authorize)
+	mux.HandleFunc("/api/login/oauth/access_token", f.token)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeCasdoor) settings() Settings {
+	return Settings{Endpoint: f.server.URL, ClientID: testAudience, ClientSecret: testClientSecret}
+}
+
+func (f *fakeCasdoor) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != testAudience || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	code := "code-" + time.Now().Format("150405.000000000")
+	f.challenges[code] = query.Get("code_challenge")
+	f.mu.Unlock()
+
+	callback, _ := url.Parse(query.Get("redirect_uri"))
+	values := callback.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	callback.RawQuery = values.Encode()
+	http.Redirect(w, r, callback.String(), http.StatusFound)
+}
+
+func (f *fakeCasdoor) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.exchanges++
+
+	code := r.PostForm.Get("code")
+	challenge, ok := f.challenges[code]
+	delete(f.challenges, code)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok || r.PostForm.Get("client_id") != testAudience || r.PostForm.Get("client_secret") != testClientSecret ||
+		oauth2.S256ChallengeFromVerifier(r.PostForm.Get("code_verifier")) != challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access-" + code,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+}
+
+func (f *fakeCasdoor) exchangeCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.exchanges
+}
+
+func newTestLoginFlow(t *testing.T) *oidc.LoginFlow {
+	t.Helper()
+	var mu sync.Mutex
+	used := map[string]bool{}
+	flow, err := oidc.NewLoginFlow(oidc.LoginConfig{
+		StateSecret: []byte("0123456789abcdef0123456789abcdef"),
+		States: oidc.StateStoreFunc(func(ctx context.Context, id string, expiresAt time.Time) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if used[id] {
+				return errors.New("state already used")
+			}
+			used[id] = true
+			return nil
+		}),
+	})
+	if err != nil {
+		t.Fatalf("NewLoginFlow: %v", err)
+	}
+	return flow
+}
+
+// authorize follows the authorization URL as the browser would, and returns
+// the code and state Casdoor sends back to the callback
+func authorize(t *testing.T, authURL string) (code string, state string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize responded with %d", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("bad callback location: %v", err)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+// complete runs the callback side of a Casdoor login
+func complete(flow *oidc.LoginFlow, settings Settings, verifier string, state string, code string) (*oauth2.Token, error) {
+	loginState, err := flow.Verify(context.Background(), verifier, state)
+	if err != nil {
+		return nil, err
+	}
+	return flow.Exchange(context.Background(), settings.Client(), loginState, verifier, code)
+}
+
+func TestCasdoorLoginUsesPKCEAndSingleUseState(t *testing.T) {
+	casdoor := newFakeCasdoor(t)
+	flow := newTestLoginFlow(t)
+	settings := casdoor.settings()
+
+	authURL, verifier, err := flow.Begin(settings.Client(), oidc.LoginRequest{CallbackURL: testCallbackURL, TenantID: 3})
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	code, state := authorize(t, authURL)
+	if state == "" || state == "eudr-complimate" {
+		t.Fatalf("login sent state %q, want a signed per-login state", state)
+	}
+
+	token, err := complete(flow, settings, verifier, state, code)
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if token.AccessToken != "access-"+code {
+		t.Errorf("access token = %q", token.AccessToken)
+	}
+
+	// The callback cannot be replayed
+	if _, err := complete(flow, settings, verifier, state, code); !errors.Is(err, oidc.ErrInvalidState) {
+		t.Errorf("replayed callback: got %v, want %v", err, oidc.ErrInvalidState)
+	}
+	if n := casdoor.exchangeCount(); n != 1 {
+		t.Errorf("code exchanged %d times, want 1", n)
+	}
+}
+
+func TestCasdoorLoginRejectsOtherBrowsersAndStaticState(t *testing.T) {
+	casdoor := newFakeCasdoor(t)
+	flow := newTestLoginFlow(t)
+	settings := casdoor.settings()
+
+	attackerURL, _, err := flow.Begin(settings.Client(), oidc.LoginRequest{CallbackURL: testCallbackURL})
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	code, state := authorize(t, attackerURL)
+
+	_, victimVerifier, err := flow.Begin(settings.Client(), oidc.LoginRequest{CallbackURL: testCallbackURL})
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		verifier string
+		state    string
+	}{
+		{"other browser", victimVerifier, state},
+		{"no verifier cookie", "", state},
+		{"static state", victimVerifier, "eudr-complimate"},
+	}
+	for _, test := range tests {
+		if _, err := complete(flow, settings, test.verifier, test.state, code); !errors.Is(err, oidc.ErrInvalidState) {
+			t.Errorf("%s: got %v, want %v", test.name, err, oidc.ErrInvalidState)
+		}
+	}
+	if n := casdoor.exchangeCount(); n != 0 {
+		t.Errorf("code exchanged %d times, want 0", n)
+	}
+}
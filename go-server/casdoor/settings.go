@@ -0,0 +1,84 @@
+package casdoor
+
+import (
+	"os"
+	"strings"
+
+	"github.com/casdoor/casdoor-go-sdk/casdoorsdk"
+	"golang.org/x/oauth2"
+
+	"go-server/oidc"
+)
+
+// Settings is the application's registration with its Casdoor
+type Settings struct {
+	Endpoint     string
+	ClientID     string
+	ClientSecret string
+	JWTSecret    string
+	Organization string
+	Application  string
+	// JWKSURL overrides where Casdoor's token keys are fetched from
+	JWKSURL string
+}
+
+// SettingsFromEnv reads the Casdoor settings from CASDOOR_* environment
+// variables, falling back to the enterprise hosted Casdoor
+func SettingsFromEnv() Settings {
+	settings := Settings{
+		Endpoint:     os.Getenv("CASDOOR_ENDPOINT"),
+		ClientID:     os.Getenv("CASDOOR_CLIENT_ID"),
+		ClientSecret: os.Getenv("CASDOOR_CLIENT_SECRET"),
+		JWTSecret:    os.Getenv("CASDOOR_JWT_SECRET"),
+		JWKSURL:      os.Getenv("CASDOOR_JWKS_URL"),
+		Organization: "tracextech",
+		Application:  "eudr-complimate",
+	}
+
+	if settings.Endpoint == "" {
+		settings.Endpoint = "https://tracextech.casdoor.com"
+	}
+	settings.Endpoint = strings.TrimRight(settings.Endpoint, "/")
+	if settings.ClientID == "" {
+		settings.ClientID = "d85be9c2468eae1dbf58"
+	}
+	if settings.JWTSecret == "" {
+		settings.JWTSecret = "jwt-secret-for-tracextech-casdoor"
+	}
+
+	// Organization and Application names from Client ID (format: organization/application)
+	if parts := strings.Split(settings.ClientID, "/"); len(parts) == 2 {
+		settings.Organization = parts[0]
+		settings.Application = parts[1]
+	}
+
+	return settings
+}
+
+// InitSDK configures the Casdoor SDK's global client
+func (s Settings) InitSDK() {
+	casdoorsdk.InitConfig(s.Endpoint, s.ClientID, s.ClientSecret, s.JWTSecret, s.Organization, s.Application)
+}
+
+// NewVerifier creates a verifier for access tokens issued to this application
+func (s Settings) NewVerifier() (*Verifier, error) {
+	return NewVerifier(Config{
+		Issuer:   s.Endpoint,
+		Audience: s.ClientID,
+		JWKSURL:  s.JWKSURL,
+	})
+}
+
+// Client returns the application's registration for use with an oidc.LoginFlow
+func (s Settings) Client() oidc.Client {
+	return oidc.Client{
+		ID:     s.ClientID,
+		Secret: s.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   s.Endpoint + "/login/oauth/authorize",
+			TokenURL:  s.Endpoint + "/api/login/oauth/access_token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+		Scopes: []string{"read"},
+	}
+}
@@ -0,0 +1,351 @@
+package casdoor
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testAudience = "test-client-id"
+
+// fakeIssuer is a local Casdoor stand-in that publishes a JWKS and signs tokens
+type fakeIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu       sync.Mutex
+	keys     map[string]interface{}
+	fetches  int
+	failJWKS bool
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	issuer := &fakeIssuer{t: t, keys: make(map[string]interface{})}
+	issuer.server = httptest.NewServer(http.HandlerFunc(issuer.serveJWKS))
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (f *fakeIssuer) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/.well-known/jwks" {
+		http.NotFound(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fetches++
+
+	if f.failJWKS {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	var keys []map[string]string
+	for kid, key := range f.keys {
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		case *ecdsa.PrivateKey:
+			keys = append(keys, map[string]string{
+				"kty": "EC",
+				"kid": kid,
+				"use": "sig",
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, 32))),
+				"y":   base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (f *fakeIssuer) addRSAKey(kid string) *rsa.PrivateKey {
+	f.t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		f.t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	f.mu.Lock()
+	f.keys[kid] = key
+	f.mu.Unlock()
+	return key
+}
+
+func (f *fakeIssuer) addECKey(kid string) *ecdsa.PrivateKey {
+	f.t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		f.t.Fatalf("failed to generate EC key: %v", err)
+	}
+	f.mu.Lock()
+	f.keys[kid] = key
+	f.mu.Unlock()
+	return key
+}
+
+func (f *fakeIssuer) fetchCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fetches
+}
+
+// claims returns valid claims for a token issued by the fake issuer
+func (f *fakeIssuer) claims() *Claims {
+	now := time.Now()
+	return &Claims{
+		ID:          "user-id-1",
+		Owner:       "tracextech",
+		Name:        "alice",
+		DisplayName: "Alice",
+		Email:       "alice@example.com",
+		TokenType:   "access-token",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    f.server.URL,
+			Subject:   "user-id-1",
+			Audience:  jwt.ClaimStrings{testAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func newTestVerifier(t *testing.T, issuer *fakeIssuer) *Verifier {
+	t.Helper()
+	verifier, err := NewVerifier(Config{
+		Issuer:   issuer.server.URL,
+		Audience: testAudience,
+		Leeway:   time.Second,
+		// Long enough that unknown kids can't force a second fetch during a test
+		MinRefreshInterval: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+	return verifier
+}
+
+func TestVerifyAcceptsValidTokens(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	rsaKey := issuer.addRSAKey("rsa-1")
+	ecKey := issuer.addECKey("ec-1")
+	verifier := newTestVerifier(t, issuer)
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		kid    string
+		key    interface{}
+	}{
+		{"RS256", jwt.SigningMethodRS256, "rsa-1", rsaKey},
+		{"ES256", jwt.SigningMethodES256, "ec-1", ecKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := sign(t, tt.method, tt.kid, tt.key, issuer.claims())
+			claims, err := verifier.Verify(context.Background(), token)
+			if err != nil {
+				t.Fatalf("expected token to verify, got %v", err)
+			}
+			if claims.Name != "alice" || claims.Owner != "tracextech" || claims.Subject != "user-id-1" {
+				t.Errorf("unexpected claims: %+v", claims)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	key := issuer.addRSAKey("rsa-1")
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	verifier := newTestVerifier(t, issuer)
+
+	tests := []struct {
+		name  string
+		token func() string
+	}{
+		{"signature from another key", func() string {
+			return sign(t, jwt.SigningMethodRS256, "rsa-1", otherKey, issuer.claims())
+		}},
+		{"unknown kid", func() string {
+			return sign(t, jwt.SigningMethodRS256, "rsa-unknown", key, issuer.claims())
+		}},
+		{"missing kid", func() string {
+			return sign(t, jwt.SigningMethodRS256, "", key, issuer.claims())
+		}},
+		{"wrong issuer", func() string {
+			claims := issuer.claims()
+			claims.Issuer = "https://evil.example.com"
+			return sign(t, jwt.SigningMethodRS256, "rsa-1", key, claims)
+		}},
+		{"wrong audience", func() string {
+			claims := issuer.claims()
+			claims.Audience = jwt.ClaimStrings{"another-client"}
+			return sign(t, jwt.SigningMethodRS256, "rsa-1", key, claims)
+		}},
+		{"expired", func() string {
+			claims := issuer.claims()
+			claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+			return sign(t, jwt.SigningMethodRS256, "rsa-1", key, claims)
+		}},
+		{"missing expiry", func() string {
+			claims := issuer.claims()
+			claims.ExpiresAt = nil
+			return sign(t, jwt.SigningMethodRS256, "rsa-1", key, claims)
+		}},
+		{"not yet valid", func() string {
+			claims := issuer.claims()
+			claims.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Minute))
+			return sign(t, jwt.SigningMethodRS256, "rsa-1", key, claims)
+		}},
+		{"refresh token", func() string {
+			claims := issuer.claims()
+			claims.RefreshTokenType = "refresh-token"
+			return sign(t, jwt.SigningMethodRS256, "rsa-1", key, claims)
+		}},
+		{"HMAC signed with the public key", func() string {
+			return sign(t, jwt.SigningMethodHS256, "rsa-1", []byte("secret"), issuer.claims())
+		}},
+		{"unsigned", func() string {
+			return sign(t, jwt.SigningMethodNone, "rsa-1", jwt.UnsafeAllowNoneSignatureType, issuer.claims())
+		}},
+		{"tampered payload", func() string {
+			token := sign(t, jwt.SigningMethodRS256, "rsa-1", key, issuer.claims())
+			claims := issuer.claims()
+			claims.Name = "mallory"
+			forged := sign(t, jwt.SigningMethodRS256, "rsa-1", otherKey, claims)
+			// Keep the original signature on the forged header and payload
+			return forged[:lastDot(forged)] + token[lastDot(token):]
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := verifier.Verify(context.Background(), tt.token()); err == nil {
+				t.Fatal("expected token to be rejected")
+			}
+		})
+	}
+}
+
+func lastDot(s string) int {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] == '.' {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestVerifyCachesKeysAndPicksUpRotation(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	oldKey := issuer.addRSAKey("rsa-1")
+	const refreshInterval = 10 * time.Millisecond
+	verifier, err := NewVerifier(Config{
+		Issuer:             issuer.server.URL,
+		Audience:           testAudience,
+		MinRefreshInterval: refreshInterval,
+	})
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		token := sign(t, jwt.SigningMethodRS256, "rsa-1", oldKey, issuer.claims())
+		if _, err := verifier.Verify(context.Background(), token); err != nil {
+			t.Fatalf("expected token to verify, got %v", err)
+		}
+	}
+	if got := issuer.fetchCount(); got != 1 {
+		t.Fatalf("expected the JWKS to be fetched once, got %d", got)
+	}
+
+	// The issuer rotates; a token with the new kid forces a refetch once the
+	// refresh interval has passed
+	newKey := issuer.addRSAKey("rsa-2")
+	time.Sleep(2 * refreshInterval)
+
+	token := sign(t, jwt.SigningMethodRS256, "rsa-2", newKey, issuer.claims())
+	if _, err := verifier.Verify(context.Background(), token); err != nil {
+		t.Fatalf("expected token signed with the rotated key to verify, got %v", err)
+	}
+	if got := issuer.fetchCount(); got != 2 {
+		t.Fatalf("expected the JWKS to be refetched, got %d fetches", got)
+	}
+}
+
+func TestVerifyLimitsRefetchesForUnknownKid(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	key := issuer.addRSAKey("rsa-1")
+	verifier := newTestVerifier(t, issuer)
+
+	for i := 0; i < 5; i++ {
+		token := sign(t, jwt.SigningMethodRS256, "rsa-unknown", key, issuer.claims())
+		if _, err := verifier.Verify(context.Background(), token); err == nil {
+			t.Fatal("expected token with unknown kid to be rejected")
+		}
+	}
+	if got := issuer.fetchCount(); got != 1 {
+		t.Fatalf("expected a single JWKS fetch, got %d", got)
+	}
+}
+
+func TestVerifyFailsClosedWhenIssuerUnavailable(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	key := issuer.addRSAKey("rsa-1")
+	issuer.failJWKS = true
+	verifier := newTestVerifier(t, issuer)
+
+	token := sign(t, jwt.SigningMethodRS256, "rsa-1", key, issuer.claims())
+	if _, err := verifier.Verify(context.Background(), token); err == nil {
+		t.Fatal("expected verification to fail without issuer keys")
+	}
+}
+
+func TestNewVerifierRequiresIssuerAndAudience(t *testing.T) {
+	if _, err := NewVerifier(Config{Audience: testAudience}); err == nil {
+		t.Error("expected an error without an issuer")
+	}
+	if _, err := NewVerifier(Config{Issuer: "https://casdoor.example.com"}); err == nil {
+		t.Error("expected an error without an audience")
+	}
+}
@@ -0,0 +1,157 @@
+// Package conditions evaluates the attribute conditions that can be attached
+// to a permission, such as
+//
+//	r.object.region in r.subject.regions
+//	r.object.status == "submitted"
+//
+// r.subject holds attributes of the user making the request and r.object
+// attributes of what they act on. The syntax is that of Casbin matchers.
+package conditions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/casbin/casbin/v2/util"
+	"github.com/casbin/govaluate"
+)
+
+// MaxLength is the longest condition that can be stored with a permission
+const MaxLength = 1000
+
+// Attributes describes the subject or the object of a request. Values are
+// those JSON can hold: strings, numbers, booleans, lists and objects.
+type Attributes map[string]interface{}
+
+// The request attributes a condition may refer to, as Casbin escapes them
+const (
+	subjectParameter = "r_subject"
+	objectParameter  = "r_object"
+)
+
+// maxCached is how many parsed conditions are kept. Only conditions that
+// were evaluated, and so stored with a permission, are cached.
+const maxCached = 1000
+
+// parsed caches parsed conditions, which are evaluated on every check
+var (
+	parsedMu sync.Mutex
+	parsed   = map[string]*govaluate.EvaluableExpression{}
+)
+
+// compile parses a condition and checks that it only refers to r.subject
+// and r.object
+func compile(condition string) (*govaluate.EvaluableExpression, error) {
+	if len(condition) > MaxLength {
+		return nil, fmt.Errorf("condition must be at most %d characters", MaxLength)
+	}
+
+	expression, err := govaluate.NewEvaluableExpression(util.EscapeAssertion(condition))
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition: %v", err)
+	}
+
+	for _, token := range expression.Tokens() {
+		var name string
+		switch token.Kind {
+		case govaluate.VARIABLE:
+			name = token.Value.(string)
+		case govaluate.ACCESSOR:
+			name = token.Value.([]string)[0]
+		case govaluate.FUNCTION:
+			return nil, errors.New("conditions cannot call functions")
+		default:
+			continue
+		}
+		if name != subjectParameter && name != objectParameter {
+			return nil, errors.New("conditions can only refer to r.subject and r.object")
+		}
+	}
+
+	return expression, nil
+}
+
+// cachedCompile compiles a condition, keeping it if it is valid. The cache
+// is emptied when full, since the conditions in use are few.
+func cachedCompile(condition string) (*govaluate.EvaluableExpression, error) {
+	parsedMu.Lock()
+	expression, ok := parsed[condition]
+	parsedMu.Unlock()
+	if ok {
+		return expression, nil
+	}
+
+	expression, err := compile(condition)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedMu.Lock()
+	if len(parsed) >= maxCached {
+		parsed = map[string]*govaluate.EvaluableExpression{}
+	}
+	parsed[condition] = expression
+	parsedMu.Unlock()
+	return expression, nil
+}
+
+// Validate checks that a condition parses and only refers to r.subject and
+// r.object. An empty condition is valid and always holds.
+func Validate(condition string) error {
+	if condition == "" {
+		return nil
+	}
+	_, err := compile(condition)
+	return err
+}
+
+// Evaluate reports whether the condition holds for the subject and object.
+// An empty condition always holds. A condition that cannot be evaluated,
+// for instance because an attribute it uses was not given, does not hold,
+// and the error says why.
+func Evaluate(condition string, subject, object Attributes) (bool, error) {
+	if condition == "" {
+		return true, nil
+	}
+
+	expression, err := cachedCompile(condition)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := expression.Evaluate(map[string]interface{}{
+		subjectParameter: map[string]interface{}(subject),
+		objectParameter:  map[string]interface{}(object),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	holds, ok := result.(bool)
+	if !ok {
+		return false, errors.New("condition did not evaluate to true or false")
+	}
+	return holds, nil
+}
+
+// Normalize converts attribute values to the types conditions compare:
+// numbers to float64 and lists to []interface{}. Attributes built from Go
+// values should be normalized before they are evaluated.
+func Normalize(attributes map[string]interface{}) (Attributes, error) {
+	if attributes == nil {
+		return Attributes{}, nil
+	}
+
+	data, err := json.Marshal(attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized := Attributes{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
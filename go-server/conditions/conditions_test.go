@@ -0,0 +1,102 @@
+package conditions
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/casbin/govaluate"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		condition string
+		valid     bool
+	}{
+		{"", true},
+		{`r.object.region in r.subject.regions`, true},
+		{`r.object.status == "submitted"`, true},
+		{`r.object.amount < 1000 && r.subject.id == r.object.ownerId`, true},
+		{`r.subject.tenantId == 3 || r.object.public`, true},
+
+		{`r.object.status ==`, false},
+		{`r.object.status == "submitted`, false},
+		{`p.sub == "role:1"`, false},
+		{`r.sub == "user:1"`, false},
+		{`status == "submitted"`, false},
+		{`keyMatch(r.object.path, "/a/*")`, false},
+	}
+
+	for _, test := range tests {
+		err := Validate(test.condition)
+		if (err == nil) != test.valid {
+			t.Errorf("Validate(%q) = %v, want valid %v", test.condition, err, test.valid)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	subject, err := Normalize(map[string]interface{}{
+		"id":      7,
+		"regions": []string{"EU", "APAC"},
+	})
+	if err != nil {
+		t.Fatalf("Normalize: %v", err)
+	}
+
+	tests := []struct {
+		condition string
+		object    map[string]interface{}
+		want      bool
+	}{
+		{"", nil, true},
+		{`r.object.region in r.subject.regions`, map[string]interface{}{"region": "EU"}, true},
+		{`r.object.region in r.subject.regions`, map[string]interface{}{"region": "US"}, false},
+		{`r.object.status == "submitted"`, map[string]interface{}{"status": "submitted"}, true},
+		{`r.object.status == "submitted"`, map[string]interface{}{"status": "draft"}, false},
+		{`r.subject.id == r.object.ownerId`, map[string]interface{}{"ownerId": 7}, true},
+
+		// Missing attributes and non-boolean results never hold
+		{`r.object.status == "submitted"`, nil, false},
+		{`r.object.region in r.subject.regions`, map[string]interface{}{}, false},
+		{`r.object.status`, map[string]interface{}{"status": "submitted"}, false},
+	}
+
+	for _, test := range tests {
+		object, err := Normalize(test.object)
+		if err != nil {
+			t.Fatalf("Normalize: %v", err)
+		}
+		got, _ := Evaluate(test.condition, subject, object)
+		if got != test.want {
+			t.Errorf("Evaluate(%q, %v) = %v, want %v", test.condition, test.object, got, test.want)
+		}
+	}
+}
+
+func TestOnlyEvaluatedValidConditionsAreCached(t *testing.T) {
+	parsedMu.Lock()
+	parsed = map[string]*govaluate.EvaluableExpression{}
+	parsedMu.Unlock()
+
+	cached := func() int {
+		parsedMu.Lock()
+		defer parsedMu.Unlock()
+		return len(parsed)
+	}
+
+	for i := 0; i < 10; i++ {
+		Validate(fmt.Sprintf(`r.object.id == %d`, i))
+		Evaluate(fmt.Sprintf(`r.object.id == %d ==`, i), Attributes{}, Attributes{})
+		Evaluate(fmt.Sprintf(`status == %d`, i), Attributes{}, Attributes{})
+	}
+	if n := cached(); n != 0 {
+		t.Errorf("validating and evaluating invalid conditions cached %d", n)
+	}
+
+	for i := 0; i < maxCached+10; i++ {
+		Evaluate(fmt.Sprintf(`r.object.id == %d`, i), Attributes{}, Attributes{"id": float64(i)})
+	}
+	if n := cached(); n == 0 || n > maxCached {
+		t.Errorf("cached %d conditions, want between 1 and %d", n, maxCached)
+	}
+}
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go-server/auth"
+	"go-server/models"
+	"go-server/tokens"
+)
+
+// defaultPATLifetime applies when a token is created without expiresInDays
+const defaultPATLifetime = 90 * 24 * time.Hour
+
+// serviceAccountUsername is what a service account may be called, so its
+// username also makes a valid email local part
+var serviceAccountUsername = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+// validatePATScopes checks each scope names an existing resource and action,
+// and returns them in canonical form. It responds and returns false if one
+// does not.
+func validatePATScopes(c *gin.Context, db *sql.DB, scopes []string) ([]string, bool) {
+	canonical := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		parsed, err := tokens.ParseScope(scope)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+
+		resource, err := models.GetResourceByName(db, parsed.ResourceType, parsed.Resource)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check scope: " + err.Error()})
+			return nil, false
+		}
+		if resource == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Scope " + scope + " names an unknown resource"})
+			return nil, false
+		}
+
+		if parsed.Action != "*" {
+			action, err := models.GetActionByName(db, parsed.Action)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check scope: " + err.Error()})
+				return nil, false
+			}
+			if action == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Scope " + scope + " names an unknown action"})
+				return nil, false
+			}
+		}
+
+		canonical = append(canonical, parsed.String())
+	}
+	return canonical, true
+}
+
+// createPAT creates a personal access token for the owner acting in the
+// tenant and responds with it. The token value is only ever shown here.
+func createPAT(c *gin.Context, db *sql.DB, owner *models.User, tenantID int, createdBy int) {
+	var input models.CreatePersonalAccessTokenInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scopes, ok := validatePATScopes(c, db, input.Scopes)
+	if !ok {
+		return
+	}
+
+	lifetime := defaultPATLifetime
+	if input.ExpiresInDays > 0 {
+		lifetime = time.Duration(input.ExpiresInDays) * 24 * time.Hour
+	}
+	expiresAt := time.Now().Add(lifetime)
+
+	value, hash, prefix := tokens.NewPAT()
+	token, err := models.CreatePersonalAccessToken(db, models.PersonalAccessToken{
+		UserID:      owner.ID,
+		TenantID:    tenantID,
+		Name:        input.Name,
+		TokenPrefix: prefix,
+		Scopes:      scopes,
+		ExpiresAt:   &expiresAt,
+		CreatedBy:   createdBy,
+	}, hash)
+	if err != nil {
+		log.Printf("Error creating personal access token for user %s: %v", owner.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	log.Printf("Personal access token %d created for user %s by user %d", token.ID, owner.Username, createdBy)
+	c.JSON(http.StatusCreated, gin.H{
+		"token":               value,
+		"personalAccessToken": token,
+	})
+}
+
+// listPATs responds with the owner's personal access tokens
+func listPATs(c *gin.Context, db *sql.DB, owner *models.User) {
+	tokenList, err := models.ListPersonalAccessTokens(db, owner.ID)
+	if err != nil {
+		log.Printf("Error listing personal access tokens for user %s: %v", owner.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": tokenList})
+}
+
+// deletePAT revokes one of the owner's personal access tokens
+func deletePAT(c *gin.Context, db *sql.DB, owner *models.User) {
+	tokenID, err := strconv.Atoi(c.Param("tokenId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	if err := models.DeletePersonalAccessToken(db, owner.ID, tokenID); err != nil {
+		if err.Error() == "token not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+			return
+		}
+		log.Printf("Error deleting personal access token %d for user %s: %v", tokenID, owner.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
+
+// GetPersonalAccessTokens lists the current user's personal access tokens
+func GetPersonalAccessTokens(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+		listPATs(c, db, user)
+	}
+}
+
+// CreatePersonalAccessToken creates a personal access token for the current
+// user, acting in their current tenant
+func CreatePersonalAccessToken(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+		createPAT(c, db, user, user.TenantID, user.ID)
+	}
+}
+
+// DeletePersonalAccessToken revokes one of the current user's personal access tokens
+func DeletePersonalAccessToken(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+		deletePAT(c, db, user)
+	}
+}
+
+// tenantServiceAccount loads a service account of the tenant in the path.
+// It responds and returns false if there is no such account.
+func tenantServiceAccount(c *gin.Context, db *sql.DB) (*models.User, bool) {
+	tenantID, ok := managedTenantID(c)
+	if !ok {
+		return nil, false
+	}
+
+	accountID, err := strconv.Atoi(c.Param("accountId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service account ID"})
+		return nil, false
+	}
+
+	account, err := models.GetUser(db, accountID)
+	if err == sql.ErrNoRows || (err == nil && (!account.IsServiceAccount || account.TenantID != tenantID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service account not found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error getting service account %d: %v", accountID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get service account"})
+		return nil, false
+	}
+
+	return account, true
+}
+
+// GetServiceAccounts lists a tenant's service accounts
+func GetServiceAccounts(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		users, err := models.ListUsers(db, &tenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list service accounts: " + err.Error()})
+			return
+		}
+
+		accounts := []*models.User{}
+		for _, user := range users {
+			if user.IsServiceAccount {
+				accounts = append(accounts, user)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"serviceAccounts": accounts})
+	}
+}
+
+// CreateServiceAccount creates a service account in a tenant with the given
+// roles there. Its tokens are created separately.
+func CreateServiceAccount(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		var input struct {
+			Username    string   `json:"username" binding:"required"`
+			DisplayName string   `json:"displayName"`
+			Roles       []string `json:"roles"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !serviceAccountUsername.MatchString(input.Username) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Username may only contain letters, digits, '.', '_' and '-'"})
+			return
+		}
+
+		// Check every role before creating anything
+		roles := make([]*models.Role, 0, len(input.Roles))
+		for _, name := range input.Roles {
+			role, err := models.GetRoleByName(db, name, tenantID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check role: " + err.Error()})
+				return
+			}
+			if role == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Role " + name + " does not exist in this tenant"})
+				return
+			}
+			roles = append(roles, role)
+		}
+
+		account, err := auth.CreateServiceAccount(db, tenantID, input.Username, input.DisplayName)
+		if err != nil {
+			if err.Error() == "username already exists" || err.Error() == "email already exists" {
+				c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+				return
+			}
+			log.Printf("Error creating service account %s: %v", input.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service account"})
+			return
+		}
+
+		for _, role := range roles {
+			if err := models.AssignRoleToUser(db, account.ID, role.ID, tenantID); err != nil {
+				log.Printf("Error assigning role %s to service account %s: %v", role.Name, account.Username, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign role " + role.Name})
+				return
+			}
+		}
+
+		log.Printf("Service account %s created in tenant %d", account.Username, tenantID)
+		c.JSON(http.StatusCreated, gin.H{"serviceAccount": account})
+	}
+}
+
+// DeleteServiceAccount deletes a service account, revoking its tokens
+func DeleteServiceAccount(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		account, ok := tenantServiceAccount(c, db)
+		if !ok {
+			return
+		}
+
+		if err := models.DeleteUser(db, account.ID); err != nil {
+			log.Printf("Error deleting service account %s: %v", account.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete service account"})
+			return
+		}
+
+		log.Printf("Service account %s deleted", account.Username)
+		c.JSON(http.StatusOK, gin.H{"message": "Service account deleted"})
+	}
+}
+
+// GetServiceAccountTokens lists a service account's access tokens
+func GetServiceAccountTokens(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		account, ok := tenantServiceAccount(c, db)
+		if !ok {
+			return
+		}
+		listPATs(c, db, account)
+	}
+}
+
+// CreateServiceAccountToken creates an access token for a service account
+func CreateServiceAccountToken(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		account, ok := tenantServiceAccount(c, db)
+		if !ok {
+			return
+		}
+
+		admin, ok := contextUser(c)
+		if !ok {
+			return
+		}
+		createPAT(c, db, account, account.TenantID, admin.ID)
+	}
+}
+
+// DeleteServiceAccountToken revokes one of a service account's access tokens
+func DeleteServiceAccountToken(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		account, ok := tenantServiceAccount(c, db)
+		if !ok {
+			return
+		}
+		deletePAT(c, db, account)
+	}
+}
@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go-server/auth"
+	"go-server/middleware"
+	"go-server/models"
+	"go-server/tokens"
+)
+
+// defaultSecretGracePeriod is how long a client's previous secrets keep
+// working after a rotation when no grace period is given
+const defaultSecretGracePeriod = 24 * time.Hour
+
+// validateAPIClientScopes checks each scope names an existing resource and
+// action, and that the admin granting it holds the permission themselves,
+// since a client's scopes are all that limit it. It returns the scopes in
+// canonical form, or responds and returns false.
+func validateAPIClientScopes(c *gin.Context, db *sql.DB, scopes []string) ([]string, bool) {
+	canonical, ok := validatePATScopes(c, db, scopes)
+	if !ok {
+		return nil, false
+	}
+
+	admin, ok := contextUser(c)
+	if !ok {
+		return nil, false
+	}
+	if admin.IsSuperAdmin {
+		return canonical, true
+	}
+
+	for _, scope := range canonical {
+		parsed, _ := tokens.ParseScope(scope)
+
+		actions := []string{parsed.Action}
+		if parsed.Action == "*" {
+			allActions, err := models.GetAllActions(db)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check scope: " + err.Error()})
+				return nil, false
+			}
+			actions = actions[:0]
+			for _, action := range allActions {
+				actions = append(actions, action.Name)
+			}
+		}
+
+		for _, action := range actions {
+			allowed, err := middleware.UserHasPermission(db, admin, parsed.ResourceType, parsed.Resource, action)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check scope: " + err.Error()})
+				return nil, false
+			}
+			if !allowed {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You cannot grant scope " + scope + " without holding that permission"})
+				return nil, false
+			}
+		}
+	}
+
+	return canonical, true
+}
+
+// tenantAPIClient loads an API client of the tenant in the path. It responds
+// and returns false if there is no such client.
+func tenantAPIClient(c *gin.Context, db *sql.DB) (*models.APIClient, bool) {
+	tenantID, ok := managedTenantID(c)
+	if !ok {
+		return nil, false
+	}
+
+	id, err := strconv.Atoi(c.Param("clientId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API client ID"})
+		return nil, false
+	}
+
+	client, err := models.GetAPIClient(db, tenantID, id)
+	if err != nil {
+		log.Printf("Error getting API client %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get API client"})
+		return nil, false
+	}
+	if client == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API client not found"})
+		return nil, false
+	}
+
+	return client, true
+}
+
+// GetAPIClients lists a tenant's API clients
+func GetAPIClients(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		clients, err := models.ListAPIClients(db, tenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API clients: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"apiClients": clients})
+	}
+}
+
+// GetAPIClient returns one of a tenant's API clients with its secrets
+func GetAPIClient(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client, ok := tenantAPIClient(c, db)
+		if !ok {
+			return
+		}
+
+		secrets, err := models.ListAPIClientSecrets(db, client.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list secrets: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"apiClient": client, "secrets": secrets})
+	}
+}
+
+// CreateAPIClient registers an API client with a tenant. The client secret
+// is only ever shown in this response.
+func CreateAPIClient(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		admin, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
+		var input models.CreateAPIClientInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		scopes, ok := validateAPIClientScopes(c, db, input.Scopes)
+		if !ok {
+			return
+		}
+
+		// The client acts as its own service account, named after its client ID
+		clientID := tokens.NewClientID()
+		account, err := auth.CreateServiceAccount(db, tenantID, clientID, input.Name)
+		if err != nil {
+			log.Printf("Error creating service account for API client %s: %v", input.Name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API client"})
+			return
+		}
+
+		secret, secretHash, secretPrefix := tokens.NewClientSecret()
+		client, err := models.CreateAPIClient(db, models.APIClient{
+			TenantID:    tenantID,
+			ClientID:    clientID,
+			Name:        input.Name,
+			Description: input.Description,
+			Scopes:      scopes,
+			UserID:      account.ID,
+			CreatedBy:   admin.ID,
+		}, models.APIClientSecret{
+			SecretPrefix: secretPrefix,
+			SecretHash:   secretHash,
+		})
+		if err != nil {
+			log.Printf("Error creating API client %s: %v", input.Name, err)
+			if err := models.DeleteUser(db, account.ID); err != nil {
+				log.Printf("Error removing service account %s: %v", account.Username, err)
+				// Not critical, continue
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API client"})
+			return
+		}
+
+		log.Printf("API client %s created in tenant %d by user %d", client.ClientID, tenantID, admin.ID)
+		c.JSON(http.StatusCreated, gin.H{
+			"apiClient":    client,
+			"clientSecret": secret,
+		})
+	}
+}
+
+// UpdateAPIClient updates an API client's name, description or scopes.
+// Narrowed scopes apply to tokens the client already holds.
+func UpdateAPIClient(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client, ok := tenantAPIClient(c, db)
+		if !ok {
+			return
+		}
+		if !client.Active() {
+			c.JSON(http.StatusConflict, gin.H{"error": "API client has been revoked"})
+			return
+		}
+
+		var input models.UpdateAPIClientInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if input.Scopes != nil {
+			if len(input.Scopes) == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "An API client needs at least one scope"})
+				return
+			}
+			scopes, ok := validateAPIClientScopes(c, db, input.Scopes)
+			if !ok {
+				return
+			}
+			input.Scopes = scopes
+		}
+
+		updated, err := models.UpdateAPIClient(db, client.TenantID, client.ID, input)
+		if err != nil {
+			log.Printf("Error updating API client %s: %v", client.ClientID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update API client"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"apiClient": updated})
+	}
+}
+
+// RevokeAPIClient revokes an API client, its secrets and every token it
+// holds. The client is kept so its history stays readable.
+func RevokeAPIClient(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client, ok := tenantAPIClient(c, db)
+		if !ok {
+			return
+		}
+
+		if err := models.RevokeAPIClient(db, client.TenantID, client.ID); err != nil {
+			if err.Error() == "api client not found" {
+				c.JSON(http.StatusConflict, gin.H{"error": "API client has already been revoked"})
+				return
+			}
+			log.Printf("Error revoking API client %s: %v", client.ClientID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API client"})
+			return
+		}
+
+		inactive := false
+		if _, err := models.UpdateUser(db, client.UserID, models.UpdateUserInput{IsActive: &inactive}); err != nil {
+			log.Printf("Error deactivating service account of API client %s: %v", client.ClientID, err)
+			// Not critical, continue
+		}
+		if err := tokens.RevokeAllForUser(db, client.UserID); err != nil {
+			log.Printf("Error revoking tokens of API client %s: %v", client.ClientID, err)
+			// Not critical, continue
+		}
+
+		log.Printf("API client %s revoked", client.ClientID)
+		c.JSON(http.StatusOK, gin.H{"message": "API client revoked"})
+	}
+}
+
+// RotateAPIClientSecret issues a new secret for an API client. Its previous
+// secrets keep working for a grace period so the client can switch over.
+// The new secret is only ever shown in this response.
+func RotateAPIClientSecret(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client, ok := tenantAPIClient(c, db)
+		if !ok {
+			return
+		}
+		if !client.Active() {
+			c.JSON(http.StatusConflict, gin.H{"error": "API client has been revoked"})
+			return
+		}
+
+		var input struct {
+			// GraceHours defaults to 24; 0 retires the previous secrets at once
+			GraceHours *int `json:"graceHours" binding:"omitempty,min=0,max=720"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil && c.Request.ContentLength > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		grace := defaultSecretGracePeriod
+		if input.GraceHours != nil {
+			grace = time.Duration(*input.GraceHours) * time.Hour
+		}
+		if err := models.ExpireAPIClientSecrets(db, client.ID, time.Now().Add(grace)); err != nil {
+			log.Printf("Error expiring secrets of API client %s: %v", client.ClientID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate secret"})
+			return
+		}
+
+		value, hash, prefix := tokens.NewClientSecret()
+		secret, err := models.CreateAPIClientSecret(db, models.APIClientSecret{
+			APIClientID:  client.ID,
+			SecretPrefix: prefix,
+			SecretHash:   hash,
+		})
+		if err != nil {
+			log.Printf("Error creating secret for API client %s: %v", client.ClientID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate secret"})
+			return
+		}
+
+		log.Printf("Secret of API client %s rotated", client.ClientID)
+		c.JSON(http.StatusCreated, gin.H{
+			"clientSecret": value,
+			"secret":       secret,
+		})
+	}
+}
+
+// RevokeAPIClientSecret revokes one of an API client's secrets. Tokens
+// already issued stay valid until they expire.
+func RevokeAPIClientSecret(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client, ok := tenantAPIClient(c, db)
+		if !ok {
+			return
+		}
+
+		secretID, err := strconv.Atoi(c.Param("secretId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid secret ID"})
+			return
+		}
+
+		if err := models.RevokeAPIClientSecret(db, client.ID, secretID); err != nil {
+			if err.Error() == "secret not found" {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Secret not found"})
+				return
+			}
+			log.Printf("Error revoking secret %d of API client %s: %v", secretID, client.ClientID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke secret"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Secret revoked"})
+	}
+}
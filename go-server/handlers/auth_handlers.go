@@ -2,13 +2,17 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go-server/auth"
 	"go-server/middleware"
 	"go-server/models"
+	"go-server/tokens"
 )
 
 // Login handles user login
@@ -24,83 +28,171 @@ func Login(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Get user by username
+		// Get user by username. A missing user is handled like a wrong
+		// password from here on, so the two cannot be told apart.
 		user, err := models.GetUserByUsername(db, loginRequest.Username)
 		if err != nil {
-			log.Printf("Login error for user %s: %v", loginRequest.Username, err)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
-			return
+			if err != sql.ErrNoRows {
+				log.Printf("Login error for user %s: %v", loginRequest.Username, err)
+			}
+			user = nil
 		}
 
-		// Check if user is active
-		if !user.IsActive {
-			c.JSON(http.StatusForbidden, gin.H{"error": "User account is inactive"})
+		// Refuse throttled clients before spending any bcrypt work
+		ip := c.ClientIP()
+		wait, err := auth.LoginRetryAfter(db, loginRequest.Username, ip, user)
+		if err != nil {
+			log.Printf("Error checking login throttle for user %s: %v", loginRequest.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+			return
+		}
+		if wait > 0 {
+			loginThrottled(c, wait)
 			return
 		}
 
 		// Verify password
-		if !models.VerifyPassword(user.Password, loginRequest.Password) {
-			log.Printf("Invalid password for user %s", loginRequest.Username)
+		var passwordOK bool
+		if user == nil {
+			auth.CompareDummyPassword(loginRequest.Password)
+		} else {
+			passwordOK = models.VerifyPassword(user.Password, loginRequest.Password)
+		}
+		if !passwordOK {
+			log.Printf("Invalid credentials for user %s from %s", loginRequest.Username, ip)
+			if err := auth.RecordLoginFailure(db, loginRequest.Username, ip, user); err != nil {
+				log.Printf("Error recording failed login for user %s: %v", loginRequest.Username, err)
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 			return
 		}
 
-		// Update last login time
-		err = models.UpdateLastLogin(db, user.ID)
-		if err != nil {
-			log.Printf("Error updating last login for user %s: %v", user.Username, err)
+		if err := auth.RecordLoginSuccess(db, user); err != nil {
+			log.Printf("Error clearing failed logins for user %s: %v", user.Username, err)
 			// Not critical, continue
 		}
 
-		// Generate token
-		token, err := middleware.GenerateToken(user)
-		if err != nil {
-			log.Printf("Error generating token for user %s: %v", user.Username, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
+		// Check if user is active
+		if !user.IsActive {
+			c.JSON(http.StatusForbidden, gin.H{"error": "User account is inactive"})
 			return
 		}
 
-		// Get user roles
-		roles, err := models.GetUserRoles(user.ID)
-		if err != nil {
-			log.Printf("Error getting roles for user %s: %v", user.Username, err)
-			// Not critical, continue with empty roles
-			roles = []models.Role{}
+		// Users enrolled in MFA, or whose roles require it, get an mfa_pending
+		// token here and finish logging in at /auth/mfa/verify
+		if beginMFAChallenge(c, db, user) {
+			return
 		}
 
-		// Get user's tenant
-		tenant, err := models.GetTenantByID(user.TenantID)
-		if err != nil {
-			log.Printf("Error getting tenant for user %s: %v", user.Username, err)
-			// Not critical, continue with nil tenant
-		}
+		completeLocalLogin(c, db, user, nil)
+	}
+}
 
-		// Convert roles to role names for the response
-		roleNames := make([]string, len(roles))
-		for i, role := range roles {
-			roleNames[i] = role.Name
-		}
+// loginThrottled responds to a login refused because of earlier failures.
+// The message is the same whether or not the account exists.
+func loginThrottled(c *gin.Context, wait time.Duration) {
+	seconds := int(wait.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":      "Too many failed login attempts. Please try again later.",
+		"retryAfter": seconds,
+	})
+}
 
-		// Return successful login response
-		c.JSON(http.StatusOK, gin.H{
-			"user": gin.H{
-				"id":           user.ID,
-				"username":     user.Username,
-				"email":        user.Email,
-				"displayName":  user.DisplayName,
-				"avatar":       user.Avatar,
-				"tenantId":     user.TenantID,
-				"isActive":     user.IsActive,
-				"isSuperAdmin": user.IsSuperAdmin,
-				"lastLogin":    user.LastLogin,
-				"createdAt":    user.CreatedAt,
-				"updatedAt":    user.UpdatedAt,
-			},
-			"tenant": tenant,
-			"roles":  roleNames,
-			"token":  token,
+// completeLocalLogin issues tokens to a user who has passed every login step
+// and responds with them. Any extra fields are added to the response. Users
+// who must change their password are sent to /auth/password/change instead.
+func completeLocalLogin(c *gin.Context, db *sql.DB, user *models.User, extra gin.H) {
+	access, err := models.UnverifiedUserAccess(db, user)
+	if err != nil {
+		log.Printf("Error checking email verification policy for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+		return
+	}
+	if access == models.UnverifiedAccessNone {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":                     "Please verify your email address before logging in",
+			"emailVerificationRequired": true,
 		})
+		return
+	}
+
+	if user.MustChangePassword {
+		requirePasswordChange(c, user, extra)
+		return
+	}
+
+	// Update last login time
+	err = models.UpdateLastLogin(db, user.ID)
+	if err != nil {
+		log.Printf("Error updating last login for user %s: %v", user.Username, err)
+		// Not critical, continue
+	}
+
+	// Get user roles
+	roles, err := models.GetUserRolesByUserID(db, user.ID, &user.TenantID)
+	if err != nil {
+		log.Printf("Error getting roles for user %s: %v", user.Username, err)
+		// Not critical, continue with empty roles
+		roles = []models.Role{}
+	}
+
+	// Generate token
+	token, claims, err := tokens.IssueForUser(user, roles)
+	if err != nil {
+		log.Printf("Error generating token for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
+		return
 	}
+
+	refreshToken, err := tokens.IssueRefreshToken(db, claims)
+	if err != nil {
+		log.Printf("Error generating refresh token for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
+		return
+	}
+	startSession(c, db, claims)
+
+	// Get user's tenant
+	tenant, err := models.GetTenantByID(db, user.TenantID)
+	if err != nil {
+		log.Printf("Error getting tenant for user %s: %v", user.Username, err)
+		// Not critical, continue with nil tenant
+	}
+
+	// Convert roles to role names for the response
+	roleNames := make([]string, len(roles))
+	for i, role := range roles {
+		roleNames[i] = role.Name
+	}
+
+	// Return successful login response
+	response := gin.H{
+		"user": gin.H{
+			"id":           user.ID,
+			"username":     user.Username,
+			"email":        user.Email,
+			"displayName":  user.DisplayName,
+			"avatar":       user.Avatar,
+			"tenantId":     user.TenantID,
+			"isActive":     user.IsActive,
+			"isSuperAdmin": user.IsSuperAdmin,
+			"lastLogin":    user.LastLogin,
+			"createdAt":    user.CreatedAt,
+			"updatedAt":    user.UpdatedAt,
+		},
+		"tenant":       tenant,
+		"roles":        roleNames,
+		"token":        token,
+		"refreshToken": refreshToken,
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // Register handles user registration
@@ -124,7 +216,7 @@ func Register(db *sql.DB) gin.HandlerFunc {
 		if tenantID == 0 {
 			// Use default tenant if none specified
 			tenant, err := models.GetTenantByName(db, "default")
-			if err != nil {
+			if err != nil || tenant == nil {
 				log.Printf("Error getting default tenant: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get default tenant"})
 				return
@@ -132,13 +224,17 @@ func Register(db *sql.DB) gin.HandlerFunc {
 			tenantID = tenant.ID
 		} else {
 			// Verify the tenant exists
-			_, err := models.GetTenantByID(tenantID)
-			if err != nil {
+			tenant, err := models.GetTenantByID(db, tenantID)
+			if err != nil || tenant == nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
 				return
 			}
 		}
 
+		if !checkNewPassword(c, db, tenantID, nil, registerRequest.Password) {
+			return
+		}
+
 		// Create the user
 		user := &models.User{
 			Username:     registerRequest.Username,
@@ -160,7 +256,7 @@ func Register(db *sql.DB) gin.HandlerFunc {
 
 		// Assign default user role
 		userRole, err := models.GetRoleByName(db, "user", tenantID)
-		if err != nil {
+		if err != nil || userRole == nil {
 			log.Printf("Error getting user role: %v", err)
 			// Not critical, continue
 		} else {
@@ -171,16 +267,51 @@ func Register(db *sql.DB) gin.HandlerFunc {
 			}
 		}
 
+		if _, err := sendActionEmail(c, db, createdUser, models.ActionEmailVerification); err != nil {
+			log.Printf("Error sending email verification for user %s: %v", createdUser.Username, err)
+			// Not critical, the user can ask for another
+		}
+
+		// Tenants that require a verified address before login get no tokens yet
+		access, err := models.UnverifiedUserAccess(db, createdUser)
+		if err != nil {
+			log.Printf("Error checking email verification policy: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check email verification policy"})
+			return
+		}
+		if access == models.UnverifiedAccessNone {
+			c.JSON(http.StatusCreated, gin.H{
+				"user":                      createdUser,
+				"emailVerificationRequired": true,
+			})
+			return
+		}
+
 		// Generate token
-		token, err := middleware.GenerateToken(createdUser)
+		roles, err := models.GetUserRolesByUserID(db, createdUser.ID, &createdUser.TenantID)
+		if err != nil {
+			log.Printf("Error getting roles: %v", err)
+			// Not critical, continue with empty roles
+			roles = []models.Role{}
+		}
+
+		token, claims, err := tokens.IssueForUser(createdUser, roles)
 		if err != nil {
 			log.Printf("Error generating token: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
 			return
 		}
 
+		refreshToken, err := tokens.IssueRefreshToken(db, claims)
+		if err != nil {
+			log.Printf("Error generating refresh token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
+			return
+		}
+		startSession(c, db, claims)
+
 		// Get user's tenant
-		tenant, err := models.GetTenantByID(createdUser.TenantID)
+		tenant, err := models.GetTenantByID(db, createdUser.TenantID)
 		if err != nil {
 			log.Printf("Error getting tenant: %v", err)
 			// Not critical, continue with nil tenant
@@ -188,10 +319,11 @@ func Register(db *sql.DB) gin.HandlerFunc {
 
 		// Return successful registration response
 		c.JSON(http.StatusCreated, gin.H{
-			"user":   createdUser,
-			"tenant": tenant,
-			"roles":  []string{"user"},
-			"token":  token,
+			"user":         createdUser,
+			"tenant":       tenant,
+			"roles":        []string{"user"},
+			"token":        token,
+			"refreshToken": refreshToken,
 		})
 	}
 }
@@ -213,7 +345,7 @@ func GetCurrentUser(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Get user roles
-		roles, err := models.GetUserRoles(user.ID)
+		roles, err := models.GetUserRolesByUserID(db, user.ID, &user.TenantID)
 		if err != nil {
 			log.Printf("Error getting roles for user %s: %v", user.Username, err)
 			// Not critical, continue with empty roles
@@ -221,7 +353,7 @@ func GetCurrentUser(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Get user's tenant
-		tenant, err := models.GetTenantByID(user.TenantID)
+		tenant, err := models.GetTenantByID(db, user.TenantID)
 		if err != nil {
 			log.Printf("Error getting tenant for user %s: %v", user.Username, err)
 			// Not critical, continue with nil tenant
@@ -233,20 +365,87 @@ func GetCurrentUser(db *sql.DB) gin.HandlerFunc {
 			roleNames[i] = role.Name
 		}
 
-		// Return user information
-		c.JSON(http.StatusOK, gin.H{
+		response := gin.H{
 			"user":   user,
 			"tenant": tenant,
 			"roles":  roleNames,
-		})
+		}
+
+		// Clients show a banner while a super admin is impersonating the user
+		if imp, ok := middleware.GetImpersonationFromContext(c); ok {
+			response["impersonation"] = gin.H{
+				"id":          imp.ID,
+				"actor":       gin.H{"id": imp.ActorID, "username": imp.ActorUsername},
+				"reason":      imp.Reason,
+				"allowWrites": imp.AllowWrites,
+				"startedAt":   imp.StartedAt,
+				"expiresAt":   imp.ExpiresAt,
+			}
+		}
+
+		// Return user information
+		c.JSON(http.StatusOK, response)
 	}
 }
 
-// Logout handles user logout
-func Logout(c *gin.Context) {
-	// In a stateless JWT system, the client is responsible for discarding the token
-	// So we just return a success message
-	c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
+// Logout handles user logout by revoking the current token and its session
+func Logout(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := middleware.GetClaimsFromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		if err := tokens.RevokeSession(db, claims); err != nil {
+			log.Printf("Error revoking session for user %s: %v", claims.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+
+		clearSessionCookies(c)
+		c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
+	}
+}
+
+// LogoutAll handles logging the user out of every session
+func LogoutAll(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := middleware.GetClaimsFromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		if err := tokens.RevokeAllForUser(db, claims.UserID); err != nil {
+			log.Printf("Error revoking sessions for user %s: %v", claims.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+
+		clearSessionCookies(c)
+		c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out of all sessions"})
+	}
+}
+
+// SetSessionCookies stores our tokens in httpOnly cookies for browser
+// sessions started by an SSO redirect, where the client cannot receive them
+// in a response body
+func SetSessionCookies(c *gin.Context, accessToken string, refreshToken string) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(middleware.AccessTokenCookie, accessToken, 0, "/", "", secure, true)
+	c.SetCookie(middleware.RefreshTokenCookie, refreshToken, 0, "/api/auth/refresh", "", secure, true)
+}
+
+// clearSessionCookies clears our session cookies and any Casdoor cookies left
+// over from an SSO login
+func clearSessionCookies(c *gin.Context) {
+	c.SetCookie(middleware.AccessTokenCookie, "", -1, "/", "", false, true)
+	c.SetCookie(middleware.RefreshTokenCookie, "", -1, "/api/auth/refresh", "", false, true)
+	c.SetCookie("casdoor_token", "", -1, "/", "", false, true)
+	c.SetCookie(mfaTokenCookie, "", -1, "/", "", false, false)
+	c.SetCookie("auth_status", "", -1, "/", "", false, false)
 }
 
 // SwitchTenant switches the user's current tenant
@@ -275,8 +474,8 @@ func SwitchTenant(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Check if tenant exists
-		tenant, err := models.GetTenantByID(request.TenantID)
-		if err != nil {
+		tenant, err := models.GetTenantByID(db, request.TenantID)
+		if err != nil || tenant == nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
 			return
 		}
@@ -299,22 +498,58 @@ func SwitchTenant(db *sql.DB) gin.HandlerFunc {
 			}
 		}
 
-		// Generate a new token with the new tenant ID
+		// Get roles for the new tenant
+		roles, err := models.GetUserRolesByUserID(db, user.ID, &request.TenantID)
+		if err != nil {
+			log.Printf("Error getting roles: %v", err)
+			// Not critical, continue with empty roles
+			roles = []models.Role{}
+		}
+
+		// A tenant's MFA policy applies to users switching into it too
+		policy, err := models.GetTenantMFAPolicy(db, request.TenantID)
+		if err != nil {
+			log.Printf("Error getting MFA policy: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check MFA policy"})
+			return
+		}
+		if policy.RequiresMFA(roles) {
+			enrollment, err := models.GetUserMFA(db, user.ID)
+			if err != nil {
+				log.Printf("Error getting MFA enrollment: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check MFA policy"})
+				return
+			}
+			if !enrollment.Enabled() {
+				c.JSON(http.StatusForbidden, gin.H{"error": "This tenant requires MFA for your role; enable MFA first", "mfaRequired": true})
+				return
+			}
+		}
+
+		// Generate a new token with the new tenant ID, keeping the current session
 		userCopy := *user
 		userCopy.TenantID = request.TenantID
-		token, err := middleware.GenerateToken(&userCopy)
+		sessionID := ""
+		if current, ok := middleware.GetClaimsFromContext(c); ok {
+			sessionID = current.SessionID
+		}
+		token, err := tokens.Issue(tokens.NewClaims(&userCopy, roles, sessionID))
 		if err != nil {
 			log.Printf("Error generating token: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
 			return
 		}
 
-		// Get roles for the new tenant
-		roles, err := models.GetUserRolesByUserID(db, user.ID, &request.TenantID)
-		if err != nil {
-			log.Printf("Error getting roles: %v", err)
-			// Not critical, continue with empty roles
-			roles = []models.Role{}
+		// Refreshed tokens for this session should stay in the new tenant
+		if sessionID != "" {
+			if err := models.SetRefreshTokenFamilyTenant(db, sessionID, request.TenantID); err != nil {
+				log.Printf("Error updating refresh token tenant: %v", err)
+				// Not critical, continue
+			}
+			if err := models.SetUserSessionTenant(db, sessionID, request.TenantID); err != nil {
+				log.Printf("Error updating session tenant: %v", err)
+				// Not critical, continue
+			}
 		}
 
 		// Convert roles to role names for the response
@@ -333,6 +568,104 @@ func SwitchTenant(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token. Reusing a refresh token revokes its whole session.
+func RefreshToken(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			RefreshToken string `json:"refreshToken"`
+		}
+
+		// Browser sessions started by SSO keep the refresh token in a cookie
+		fromCookie := false
+		if err := c.ShouldBindJSON(&request); err != nil || request.RefreshToken == "" {
+			cookie, cookieErr := c.Cookie(middleware.RefreshTokenCookie)
+			if cookieErr != nil || cookie == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+				return
+			}
+			request.RefreshToken = cookie
+			fromCookie = true
+		}
+
+		refreshToken, stored, err := tokens.RotateRefreshToken(db, request.RefreshToken)
+		if err != nil {
+			if errors.Is(err, models.ErrRefreshTokenReused) {
+				log.Printf("Refresh token reuse detected, revoked session")
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+				return
+			}
+			if errors.Is(err, models.ErrRefreshTokenInvalid) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+				return
+			}
+			log.Printf("Error rotating refresh token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+			return
+		}
+
+		user, err := models.GetUser(db, stored.UserID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+			return
+		}
+
+		// Check if user is active
+		if !user.IsActive {
+			c.JSON(http.StatusForbidden, gin.H{"error": "User account is inactive"})
+			return
+		}
+
+		// The session keeps the tenant it was last switched to
+		user.TenantID = stored.TenantID
+
+		// Sessions of users who must change their password end here; they
+		// get a password_change token instead, as when they log in
+		if user.MustChangePassword {
+			if err := models.RevokeRefreshTokenFamily(db, stored.FamilyID); err != nil {
+				log.Printf("Error revoking refresh tokens for user %s: %v", user.Username, err)
+			}
+			if err := models.EndUserSession(db, stored.FamilyID); err != nil {
+				log.Printf("Error ending session for user %s: %v", user.Username, err)
+			}
+			if fromCookie {
+				clearSessionCookies(c)
+			}
+			requirePasswordChange(c, user, nil)
+			return
+		}
+
+		if err := models.ExtendUserSession(db, stored.FamilyID, c.ClientIP(), stored.ExpiresAt); err != nil {
+			log.Printf("Error updating session for user %s: %v", user.Username, err)
+			// Not critical, continue
+		}
+
+		// Get user roles
+		roles, err := models.GetUserRolesByUserID(db, user.ID, &user.TenantID)
+		if err != nil {
+			log.Printf("Error getting roles for user %s: %v", user.Username, err)
+			// Not critical, continue with empty roles
+			roles = []models.Role{}
+		}
+
+		token, err := tokens.Issue(tokens.NewClaims(user, roles, stored.FamilyID))
+		if err != nil {
+			log.Printf("Error generating token for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
+			return
+		}
+
+		if fromCookie {
+			SetSessionCookies(c, token, refreshToken)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"token":        token,
+			"refreshToken": refreshToken,
+		})
+	}
+}
+
 // SeedDemoUser creates a demo user for testing
 func SeedDemoUser(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -379,7 +712,7 @@ func SeedDemoUser(db *sql.DB) gin.HandlerFunc {
 			}
 
 			// Get user roles
-			roles, err := models.GetUserRoles(user.ID)
+			roles, err := models.GetUserRolesByUserID(db, user.ID, &user.TenantID)
 			if err != nil {
 				log.Printf("Error getting roles for demo user: %v", err)
 				// Not critical, continue with empty roles
@@ -387,7 +720,7 @@ func SeedDemoUser(db *sql.DB) gin.HandlerFunc {
 			}
 
 			// Get user's tenant
-			tenant, err := models.GetTenantByID(user.TenantID)
+			tenant, err := models.GetTenantByID(db, user.TenantID)
 			if err != nil {
 				log.Printf("Error getting tenant for demo user: %v", err)
 				// Not critical, continue with nil tenant
@@ -496,7 +829,7 @@ func SeedDemoUser(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Get user's tenant
-		tenant, err := models.GetTenantByID(createdUser.TenantID)
+		tenant, err := models.GetTenantByID(db, createdUser.TenantID)
 		if err != nil {
 			log.Printf("Error getting tenant for demo user: %v", err)
 			// Not critical, continue with nil tenant
@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go-server/mailer"
+	"go-server/models"
+	"go-server/tokens"
+)
+
+// actionEmailInterval is how often a user can be sent a password reset or
+// verification email, so the endpoints cannot be used to flood an inbox
+const actionEmailInterval = time.Minute
+
+// forgotPasswordMessage is the response to every password reset request, so
+// the endpoint does not reveal which addresses have accounts
+const forgotPasswordMessage = "If an account with that email address exists, a password reset link has been sent to it"
+
+// resendVerificationMessage is the response to every public verification
+// request, for the same reason
+const resendVerificationMessage = "If an unverified account with that email address exists, a verification link has been sent to it"
+
+// sendActionEmail creates a token for the action and emails the user a link
+// containing it. It reports false without sending anything if the user was
+// sent one too recently. The email is sent in the background so response
+// times do not depend on the mail server.
+func sendActionEmail(c *gin.Context, db *sql.DB, user *models.User, action string) (bool, error) {
+	last, err := models.LastActionTokenCreatedAt(db, user.ID, action)
+	if err != nil {
+		return false, err
+	}
+	if last != nil && time.Since(*last) < actionEmailInterval {
+		return false, nil
+	}
+
+	var ttl time.Duration
+	var path, subject, body string
+	switch action {
+	case models.ActionPasswordReset:
+		ttl = tokens.PasswordResetTTL
+		path = "/reset-password"
+		subject = "Reset your password"
+		body = "Someone asked to reset the password for your account %s.\n\n" +
+			"To choose a new password, open this link within %s:\n\n%s\n\n" +
+			"If this was not you, you can ignore this email; your password has not been changed.\n"
+	case models.ActionEmailVerification:
+		ttl = tokens.EmailVerificationTTL
+		path = "/verify-email"
+		subject = "Verify your email address"
+		body = "Please confirm that this is the email address for your account %s.\n\n" +
+			"To verify it, open this link within %s:\n\n%s\n\n" +
+			"If you did not sign up, you can ignore this email.\n"
+	default:
+		return false, fmt.Errorf("unknown action %s", action)
+	}
+
+	value, hash := tokens.NewActionToken()
+	err = models.CreateActionToken(db, models.ActionToken{
+		UserID:    user.ID,
+		Action:    action,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(ttl),
+	}, hash)
+	if err != nil {
+		return false, err
+	}
+
+	link := publicBaseURL(c) + path + "?token=" + url.QueryEscape(value)
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body:    fmt.Sprintf(body, user.Username, hoursText(ttl), link),
+	}
+	go func() {
+		if err := mailer.Default().Send(msg); err != nil {
+			log.Printf("Error sending %s email to user %s: %v", action, user.Username, err)
+		}
+	}()
+
+	return true, nil
+}
+
+// hoursText describes a whole number of hours for an email
+func hoursText(d time.Duration) string {
+	hours := int(d / time.Hour)
+	if hours == 1 {
+		return "1 hour"
+	}
+	return fmt.Sprintf("%d hours", hours)
+}
+
+// ForgotPassword emails a password reset link to the account with the given
+// address. The response is the same whether or not there is one.
+func ForgotPassword(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Email string `json:"email" binding:"required,email"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := models.GetUserByEmail(db, input.Email)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error getting user for password reset: %v", err)
+			}
+			c.JSON(http.StatusAccepted, gin.H{"message": forgotPasswordMessage})
+			return
+		}
+
+		// Users who sign in through an identity provider reset their
+		// password there; a local one would bypass the provider
+		external, err := models.UserHasExternalIdentity(db, user.ID)
+		if err != nil {
+			log.Printf("Error checking identities for user %s: %v", user.Username, err)
+		}
+		if !user.IsActive || external || err != nil {
+			c.JSON(http.StatusAccepted, gin.H{"message": forgotPasswordMessage})
+			return
+		}
+
+		if _, err := sendActionEmail(c, db, user, models.ActionPasswordReset); err != nil {
+			log.Printf("Error sending password reset for user %s: %v", user.Username, err)
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": forgotPasswordMessage})
+	}
+}
+
+// ResetPassword sets a new password with the token from a password reset
+// email. All of the user's sessions are signed out and any lockout cleared;
+// they then log in as usual, including any second factor.
+func ResetPassword(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Token       string `json:"token" binding:"required"`
+			NewPassword string `json:"newPassword" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		token, err := models.GetActionToken(db, models.ActionPasswordReset, tokens.HashActionToken(input.Token))
+		if err != nil {
+			log.Printf("Error getting password reset token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+			return
+		}
+		if token == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+			return
+		}
+
+		// Links sent to an address the account no longer has stop working
+		user, err := models.GetUser(db, token.UserID)
+		if err != nil || !user.IsActive || user.Email != token.Email {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+			return
+		}
+
+		// Check the password before using up the token, so the user can try
+		// again with a better one
+		if !checkNewPassword(c, db, user.TenantID, user, input.NewPassword) {
+			return
+		}
+
+		consumed, err := models.ConsumeActionToken(db, token.ID)
+		if err != nil {
+			log.Printf("Error consuming password reset token for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+			return
+		}
+		if !consumed {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+			return
+		}
+
+		if _, err := models.UpdateUser(db, user.ID, models.UpdateUserInput{Password: &input.NewPassword}); err != nil {
+			log.Printf("Error resetting password for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+			return
+		}
+
+		if err := models.UnlockUser(db, user.ID); err != nil {
+			log.Printf("Error unlocking user %s after password reset: %v", user.Username, err)
+			// Not critical, continue
+		}
+
+		// Following the link proves the user reads the address it was sent to
+		if _, err := models.MarkEmailVerified(db, user.ID, token.Email); err != nil {
+			log.Printf("Error marking email verified for user %s: %v", user.Username, err)
+			// Not critical, continue
+		}
+
+		log.Printf("Password reset for user %s", user.Username)
+		c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+	}
+}
+
+// RequestEmailVerification emails the current user a link to verify their address
+func RequestEmailVerification(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
+		if user.EmailVerifiedAt != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email address is already verified"})
+			return
+		}
+
+		sent, err := sendActionEmail(c, db, user, models.ActionEmailVerification)
+		if err != nil {
+			log.Printf("Error sending email verification for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+			return
+		}
+		if !sent {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "A verification email was sent recently. Please check your inbox."})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent to " + user.Email})
+	}
+}
+
+// ResendEmailVerification emails a verification link to the unverified
+// account with the given address, for users whose tenant will not let them
+// log in until they verify. The response is the same whether or not there
+// is such an account.
+func ResendEmailVerification(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Email string `json:"email" binding:"required,email"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := models.GetUserByEmail(db, input.Email)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error getting user for email verification: %v", err)
+			}
+			c.JSON(http.StatusAccepted, gin.H{"message": resendVerificationMessage})
+			return
+		}
+
+		if user.IsActive && user.EmailVerifiedAt == nil {
+			if _, err := sendActionEmail(c, db, user, models.ActionEmailVerification); err != nil {
+				log.Printf("Error sending email verification for user %s: %v", user.Username, err)
+			}
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": resendVerificationMessage})
+	}
+}
+
+// VerifyEmail marks a user's address verified with the token from a
+// verification email
+func VerifyEmail(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Token string `json:"token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		token, err := models.GetActionToken(db, models.ActionEmailVerification, tokens.HashActionToken(input.Token))
+		if err != nil {
+			log.Printf("Error getting email verification token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email address"})
+			return
+		}
+		if token == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+
+		consumed, err := models.ConsumeActionToken(db, token.ID)
+		if err != nil {
+			log.Printf("Error consuming email verification token for user %d: %v", token.UserID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email address"})
+			return
+		}
+		if !consumed {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+
+		verified, err := models.MarkEmailVerified(db, token.UserID, token.Email)
+		if err != nil {
+			log.Printf("Error marking email verified for user %d: %v", token.UserID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email address"})
+			return
+		}
+		if !verified {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The account's email address has changed since this link was sent"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email address verified"})
+	}
+}
+
+// GetTenantEmailVerificationPolicy returns what a tenant's unverified users may do
+func GetTenantEmailVerificationPolicy(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		policy, err := models.GetTenantEmailVerificationPolicy(db, tenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get email verification policy: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"policy": policy})
+	}
+}
+
+// UpdateTenantEmailVerificationPolicy sets what a tenant's unverified users may do
+func UpdateTenantEmailVerificationPolicy(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		var input models.UpdateTenantEmailVerificationPolicyInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		policy, err := models.SetTenantEmailVerificationPolicy(db, tenantID, input)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update email verification policy: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"policy": policy})
+	}
+}
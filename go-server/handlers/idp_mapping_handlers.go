@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"go-server/middleware"
+	"go-server/models"
+)
+
+// UpdateIdPMappingSettingsInput represents the input for updating mapping settings
+type UpdateIdPMappingSettingsInput struct {
+	Authoritative *bool `json:"authoritative" binding:"required"`
+}
+
+// managedTenantID reads the tenant ID from the path and checks the caller may
+// manage it: super admins may manage any tenant, others only their own
+func managedTenantID(c *gin.Context) (int, bool) {
+	tenantID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+		return 0, false
+	}
+
+	if !managesTenant(c, tenantID) {
+		return 0, false
+	}
+	return tenantID, true
+}
+
+// managesTenant checks the caller may manage the tenant: super admins may
+// manage any tenant, others only their own. It responds if not.
+func managesTenant(c *gin.Context, tenantID int) bool {
+	isSuperAdmin, exists := c.Get("isSuperAdmin")
+	if exists && isSuperAdmin.(bool) {
+		return true
+	}
+
+	currentTenantID, ok := middleware.GetTenantIDFromContext(c)
+	if !ok || currentTenantID != tenantID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return false
+	}
+	return true
+}
+
+// GetIdPRoleMappings lists a tenant's identity provider role mappings
+func GetIdPRoleMappings(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		mappings, err := models.ListIdPRoleMappings(db, tenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get mappings: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"mappings": mappings})
+	}
+}
+
+// CreateIdPRoleMapping maps an identity provider role or group onto a tenant role
+func CreateIdPRoleMapping(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		var input models.CreateIdPRoleMappingInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		mapping, err := models.CreateIdPRoleMapping(db, tenantID, input)
+		if err != nil {
+			switch err.Error() {
+			case "role not found in this tenant":
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			case "mapping already exists":
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create mapping: " + err.Error()})
+			}
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"mapping": mapping})
+	}
+}
+
+// DeleteIdPRoleMapping deletes an identity provider role mapping
+func DeleteIdPRoleMapping(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		mappingID, err := strconv.Atoi(c.Param("mappingId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mapping ID"})
+			return
+		}
+
+		if err := models.DeleteIdPRoleMapping(db, tenantID, mappingID); err != nil {
+			if err.Error() == "mapping not found" {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Mapping not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete mapping: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Mapping deleted successfully"})
+	}
+}
+
+// GetIdPMappingSettings gets a tenant's identity provider mapping settings
+func GetIdPMappingSettings(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		settings, err := models.GetIdPMappingSettings(db, tenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get mapping settings: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"settings": settings})
+	}
+}
+
+// UpdateIdPMappingSettings sets whether the identity provider is authoritative for a tenant's roles
+func UpdateIdPMappingSettings(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		var input UpdateIdPMappingSettingsInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		settings, err := models.UpdateIdPMappingSettings(db, tenantID, *input.Authoritative)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update mapping settings: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"settings": settings})
+	}
+}
+
+// GetRoleAssignmentAudit lists a tenant's role assignment audit trail
+func GetRoleAssignmentAudit(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		var userID *int
+		if userIDStr := c.Query("userId"); userIDStr != "" {
+			id, err := strconv.Atoi(userIDStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+				return
+			}
+			userID = &id
+		}
+
+		limit := 100
+		if limitStr := c.Query("limit"); limitStr != "" {
+			l, err := strconv.Atoi(limitStr)
+			if err != nil || l <= 0 || l > 1000 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+				return
+			}
+			limit = l
+		}
+
+		entries, err := models.ListRoleAssignmentAudit(db, tenantID, userID, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit trail: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"entries": entries})
+	}
+}
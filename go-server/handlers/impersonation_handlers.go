@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go-server/middleware"
+	"go-server/models"
+	"go-server/tokens"
+)
+
+// defaultImpersonationDuration applies when an impersonation is started
+// without durationMinutes
+const defaultImpersonationDuration = 30 * time.Minute
+
+// impersonationListLimit caps how many impersonations are listed
+const impersonationListLimit = 100
+
+// StartImpersonation lets a super admin act as another user for a limited
+// time, to see what they see. The response carries an access token for the
+// user that also names the super admin; it cannot be refreshed.
+func StartImpersonation(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		actor, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
+		var input models.StartImpersonationInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		subject, err := models.GetUser(db, userID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error getting user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user"})
+			return
+		}
+
+		switch {
+		case subject.ID == actor.ID:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot impersonate yourself"})
+			return
+		case subject.IsSuperAdmin:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Super admins cannot be impersonated"})
+			return
+		case subject.IsServiceAccount:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Service accounts cannot be impersonated"})
+			return
+		case !subject.IsActive:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User account is inactive"})
+			return
+		}
+
+		duration := defaultImpersonationDuration
+		if input.DurationMinutes > 0 {
+			duration = time.Duration(input.DurationMinutes) * time.Minute
+		}
+
+		now := time.Now()
+		imp := &models.Impersonation{
+			ID:              tokens.NewID(),
+			ActorID:         actor.ID,
+			ActorUsername:   actor.Username,
+			SubjectID:       subject.ID,
+			SubjectUsername: subject.Username,
+			TenantID:        subject.TenantID,
+			Reason:          input.Reason,
+			AllowWrites:     input.AllowWrites,
+			StartedAt:       now,
+			ExpiresAt:       now.Add(duration),
+		}
+		if err := models.CreateImpersonation(db, imp); err != nil {
+			log.Printf("Error starting impersonation of user %s: %v", subject.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start impersonation"})
+			return
+		}
+
+		roles, err := models.GetUserRolesByUserID(db, subject.ID, &subject.TenantID)
+		if err != nil {
+			log.Printf("Error getting roles for user %s: %v", subject.Username, err)
+			// Not critical, continue with empty roles
+			roles = []models.Role{}
+		}
+
+		token, _, err := tokens.IssueImpersonation(subject, roles, actor, imp)
+		if err != nil {
+			log.Printf("Error generating impersonation token for user %s: %v", subject.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
+			return
+		}
+
+		log.Printf("Impersonation %s of user %s started by %s until %s: %s", imp.ID, subject.Username, actor.Username, imp.ExpiresAt.Format(time.RFC3339), imp.Reason)
+		c.JSON(http.StatusCreated, gin.H{
+			"token":         token,
+			"expiresAt":     imp.ExpiresAt,
+			"impersonation": imp,
+		})
+	}
+}
+
+// EndImpersonation ends the impersonation the request is made in and
+// revokes its token
+func EndImpersonation(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		imp, ok := middleware.GetImpersonationFromContext(c)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Not impersonating"})
+			return
+		}
+
+		claims, ok := middleware.GetClaimsFromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		if err := models.EndImpersonation(db, imp.ID); err != nil {
+			log.Printf("Error ending impersonation %s: %v", imp.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end impersonation"})
+			return
+		}
+		if err := tokens.RevokeSession(db, claims); err != nil {
+			log.Printf("Error revoking impersonation token %s: %v", imp.ID, err)
+			// Not critical, continue; the impersonation has ended
+		}
+
+		log.Printf("Impersonation %s of user %s ended by %s", imp.ID, imp.SubjectUsername, imp.ActorUsername)
+		c.JSON(http.StatusOK, gin.H{"message": "Impersonation ended"})
+	}
+}
+
+// GetImpersonations lists recent impersonations, optionally of one user
+func GetImpersonations(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var subjectID *int
+		if userIDStr := c.Query("userId"); userIDStr != "" {
+			id, err := strconv.Atoi(userIDStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+				return
+			}
+			subjectID = &id
+		}
+
+		impersonations, err := models.ListImpersonations(db, subjectID, impersonationListLimit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list impersonations: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"impersonations": impersonations})
+	}
+}
+
+// GetImpersonationAudit returns an impersonation with every request made
+// during it
+func GetImpersonationAudit(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("impersonationId")
+		imp, err := models.GetImpersonation(db, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get impersonation: " + err.Error()})
+			return
+		}
+		if imp == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Impersonation not found"})
+			return
+		}
+
+		entries, err := models.ListImpersonationAuditEntries(db, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit log: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"impersonation": imp,
+			"requests":      entries,
+		})
+	}
+}
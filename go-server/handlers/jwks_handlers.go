@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go-server/tokens"
+)
+
+// JWKS publishes the public keys that verify tokens issued by this server
+func JWKS(c *gin.Context) {
+	// Keys rotate slowly, but a retired key disappears after its grace period
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, tokens.JWKS())
+}
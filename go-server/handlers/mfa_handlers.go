@@ -0,0 +1,662 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go-server/mfa"
+	"go-server/models"
+	"go-server/tokens"
+)
+
+// maxMFAAttempts is how many wrong codes an mfa_pending token survives
+// before the user has to enter their password again
+const maxMFAAttempts = 5
+
+// mfaIssuer names this service in authenticator apps
+func mfaIssuer() string {
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "TraceX Comply"
+}
+
+// beginMFAChallenge starts the second step of a login when the user has MFA
+// enabled or a role their tenant requires it for. It reports whether it has
+// responded, in which case the login stops here.
+func beginMFAChallenge(c *gin.Context, db *sql.DB, user *models.User) bool {
+	required, enrolled, ok := loginRequiresMFA(c, db, user)
+	if !ok {
+		return true
+	}
+	if !required {
+		return false
+	}
+
+	token, claims, ok := openMFAChallenge(c, db, user)
+	if !ok {
+		return true
+	}
+
+	// Users the policy covers who have not enrolled yet enrol now, with the
+	// pending token, before they can finish logging in
+	c.JSON(http.StatusOK, gin.H{
+		"mfaRequired":        true,
+		"enrollmentRequired": !enrolled,
+		"mfaToken":           token,
+		"expiresAt":          claims.ExpiresAt.Time,
+	})
+	return true
+}
+
+// loginRequiresMFA reports whether a login by the user needs a second
+// factor, and whether they have enrolled one. It responds and returns false
+// if that cannot be checked.
+func loginRequiresMFA(c *gin.Context, db *sql.DB, user *models.User) (required bool, enrolled bool, ok bool) {
+	enrollment, err := models.GetUserMFA(db, user.ID)
+	if err != nil {
+		log.Printf("Error getting MFA enrollment for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check MFA status"})
+		return false, false, false
+	}
+	if enrollment.Enabled() {
+		return true, true, true
+	}
+
+	required, err = models.UserRequiresMFA(db, user.ID, user.TenantID)
+	if err != nil {
+		log.Printf("Error checking MFA policy for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check MFA status"})
+		return false, false, false
+	}
+	return required, false, true
+}
+
+// openMFAChallenge issues an mfa_pending token for the user and records its
+// challenge. It responds and returns false if that fails.
+func openMFAChallenge(c *gin.Context, db *sql.DB, user *models.User) (string, *tokens.Claims, bool) {
+	token, claims, err := tokens.IssueMFAPending(user)
+	if err != nil {
+		log.Printf("Error generating MFA token for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
+		return "", nil, false
+	}
+
+	err = models.CreateMFAChallenge(db, models.MFAChallenge{
+		ID:        claims.ID,
+		UserID:    user.ID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	})
+	if err != nil {
+		log.Printf("Error creating MFA challenge for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
+		return "", nil, false
+	}
+	return token, claims, true
+}
+
+// pendingMFAChallenge resolves an mfa_pending token to its open challenge and
+// user. It responds and returns false if the login cannot continue.
+func pendingMFAChallenge(c *gin.Context, db *sql.DB, mfaToken string) (*models.MFAChallenge, *models.User, bool) {
+	claims, err := tokens.ParseMFAPending(mfaToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return nil, nil, false
+	}
+
+	challenge, err := models.GetMFAChallenge(db, claims.ID)
+	if err != nil {
+		log.Printf("Error getting MFA challenge for user %d: %v", claims.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify MFA token"})
+		return nil, nil, false
+	}
+	if challenge == nil || challenge.UserID != claims.UserID {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return nil, nil, false
+	}
+
+	user, err := models.GetUser(db, claims.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return nil, nil, false
+	}
+	if !user.IsActive {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User account is inactive"})
+		return nil, nil, false
+	}
+
+	return challenge, user, true
+}
+
+// startEnrollment stores a new pending TOTP secret for the user and responds
+// with what their authenticator app needs to enrol it
+func startEnrollment(c *gin.Context, db *sql.DB, user *models.User) {
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		log.Printf("Error generating MFA secret for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start MFA enrollment"})
+		return
+	}
+
+	if err := models.StartUserMFAEnrollment(db, user.ID, secret); err != nil {
+		if err.Error() == "mfa already enabled" {
+			c.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+			return
+		}
+		log.Printf("Error starting MFA enrollment for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start MFA enrollment"})
+		return
+	}
+
+	account := user.Email
+	if account == "" {
+		account = user.Username
+	}
+	uri := mfa.ProvisioningURI(mfaIssuer(), account, secret)
+	qrCode, err := mfa.QRCodeDataURI(uri)
+	if err != nil {
+		log.Printf("Error rendering MFA QR code for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start MFA enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":          secret,
+		"provisioningUri": uri,
+		"qrCode":          qrCode,
+	})
+}
+
+// confirmEnrollment enables a pending enrolment if the code is valid. It
+// returns the user's new recovery codes, or responds and returns false.
+func confirmEnrollment(c *gin.Context, db *sql.DB, user *models.User, enrollment *models.UserMFA, code string) ([]string, bool) {
+	step, ok := mfa.Validate(enrollment.Secret, code, time.Now())
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid verification code"})
+		return nil, false
+	}
+
+	recoveryCodes, err := mfa.GenerateRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable MFA"})
+		return nil, false
+	}
+
+	if err := models.ConfirmUserMFA(db, user.ID, step, mfa.HashRecoveryCodes(recoveryCodes)); err != nil {
+		if err.Error() == "no pending mfa enrollment" {
+			c.JSON(http.StatusConflict, gin.H{"error": "No MFA enrollment is pending"})
+			return nil, false
+		}
+		log.Printf("Error enabling MFA for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable MFA"})
+		return nil, false
+	}
+
+	return recoveryCodes, true
+}
+
+// verifySecondFactor checks a TOTP code or an unused recovery code for a user
+// with MFA enabled, consuming it so it cannot be used again
+func verifySecondFactor(db *sql.DB, enrollment *models.UserMFA, code string, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := mfa.Validate(enrollment.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return models.UseMFAStep(db, enrollment.UserID, step)
+	}
+	if recoveryCode != "" {
+		return models.UseRecoveryCode(db, enrollment.UserID, mfa.HashRecoveryCode(recoveryCode))
+	}
+	return false, nil
+}
+
+// StartPendingMFAEnrollment starts TOTP enrolment for a user whose login is
+// waiting on an MFA policy they have not enrolled for yet
+func StartPendingMFAEnrollment(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			MFAToken string `json:"mfaToken" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		_, user, ok := pendingMFAChallenge(c, db, request.MFAToken)
+		if !ok {
+			return
+		}
+
+		startEnrollment(c, db, user)
+	}
+}
+
+// VerifyMFA completes a login with the mfa_pending token from the first step
+// and a TOTP or recovery code. Users enrolling under a tenant policy confirm
+// their new authenticator here and get their recovery codes with the tokens.
+func VerifyMFA(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			MFAToken     string `json:"mfaToken" binding:"required"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recoveryCode"`
+		}
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+		if request.Code == "" && request.RecoveryCode == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A code or recovery code is required"})
+			return
+		}
+
+		challenge, user, ok := pendingMFAChallenge(c, db, request.MFAToken)
+		if !ok {
+			return
+		}
+
+		enrollment, err := models.GetUserMFA(db, user.ID)
+		if err != nil {
+			log.Printf("Error getting MFA enrollment for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+		if enrollment == nil || (!enrollment.Enabled() && request.Code == "") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "MFA enrollment required", "enrollmentRequired": true})
+			return
+		}
+
+		var verified bool
+		if enrollment.Enabled() {
+			verified, err = verifySecondFactor(db, enrollment, request.Code, request.RecoveryCode)
+			if err != nil {
+				log.Printf("Error verifying MFA code for user %s: %v", user.Username, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+				return
+			}
+		} else {
+			_, verified = mfa.Validate(enrollment.Secret, request.Code, time.Now())
+		}
+
+		if !verified {
+			remaining, err := models.FailMFAChallenge(db, challenge.ID, maxMFAAttempts)
+			if err != nil {
+				log.Printf("Error recording failed MFA attempt for user %s: %v", user.Username, err)
+			}
+			log.Printf("Invalid MFA code for user %s", user.Username)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid verification code", "attemptsRemaining": remaining})
+			return
+		}
+
+		// Each pending token completes one login, even if two requests
+		// carrying valid codes race
+		consumed, err := models.ConsumeMFAChallenge(db, challenge.ID)
+		if err != nil {
+			log.Printf("Error consuming MFA challenge for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+		if !consumed {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+			return
+		}
+
+		var extra gin.H
+		if !enrollment.Enabled() {
+			recoveryCodes, ok := confirmEnrollment(c, db, user, enrollment, request.Code)
+			if !ok {
+				return
+			}
+			extra = gin.H{"recoveryCodes": recoveryCodes}
+		}
+
+		completeLocalLogin(c, db, user, extra)
+	}
+}
+
+// GetMFAStatus returns whether the authenticated user has MFA enabled and
+// whether their current tenant requires it of them
+func GetMFAStatus(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
+		enrollment, err := models.GetUserMFA(db, user.ID)
+		if err != nil {
+			log.Printf("Error getting MFA enrollment for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get MFA status"})
+			return
+		}
+
+		required, err := models.UserRequiresMFA(db, user.ID, user.TenantID)
+		if err != nil {
+			log.Printf("Error checking MFA policy for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get MFA status"})
+			return
+		}
+
+		recoveryCodesRemaining := 0
+		if enrollment.Enabled() {
+			recoveryCodesRemaining, err = models.CountUnusedRecoveryCodes(db, user.ID)
+			if err != nil {
+				log.Printf("Error counting recovery codes for user %s: %v", user.Username, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get MFA status"})
+				return
+			}
+		}
+
+		var enabledAt *time.Time
+		if enrollment != nil {
+			enabledAt = enrollment.ConfirmedAt
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"enabled":                enrollment.Enabled(),
+			"enabledAt":              enabledAt,
+			"required":               required,
+			"recoveryCodesRemaining": recoveryCodesRemaining,
+		})
+	}
+}
+
+// StartMFAEnrollment starts TOTP enrolment for the authenticated user
+func StartMFAEnrollment(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
+		startEnrollment(c, db, user)
+	}
+}
+
+// ConfirmMFAEnrollment enables MFA for the authenticated user once they
+// enter a code from their newly enrolled authenticator
+func ConfirmMFAEnrollment(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Code string `json:"code" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
+		enrollment, err := models.GetUserMFA(db, user.ID)
+		if err != nil {
+			log.Printf("Error getting MFA enrollment for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable MFA"})
+			return
+		}
+		if enrollment == nil || enrollment.Enabled() {
+			c.JSON(http.StatusConflict, gin.H{"error": "No MFA enrollment is pending"})
+			return
+		}
+
+		recoveryCodes, ok := confirmEnrollment(c, db, user, enrollment, request.Code)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":       "MFA enabled successfully",
+			"recoveryCodes": recoveryCodes,
+		})
+	}
+}
+
+// DisableMFA turns off MFA for the authenticated user after checking a
+// current code. Users whose tenant requires MFA of them cannot turn it off.
+func DisableMFA(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recoveryCode"`
+		}
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
+		enrollment, ok := enabledMFA(c, db, user)
+		if !ok {
+			return
+		}
+
+		required, err := models.UserRequiresMFA(db, user.ID, user.TenantID)
+		if err != nil {
+			log.Printf("Error checking MFA policy for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable MFA"})
+			return
+		}
+		if required {
+			c.JSON(http.StatusForbidden, gin.H{"error": "MFA is required for your role in this tenant"})
+			return
+		}
+
+		verified, err := verifySecondFactor(db, enrollment, request.Code, request.RecoveryCode)
+		if err != nil {
+			log.Printf("Error verifying MFA code for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable MFA"})
+			return
+		}
+		if !verified {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid verification code"})
+			return
+		}
+
+		if err := models.DisableUserMFA(db, user.ID); err != nil {
+			log.Printf("Error disabling MFA for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable MFA"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "MFA disabled successfully"})
+	}
+}
+
+// RegenerateRecoveryCodes replaces the authenticated user's recovery codes
+// after checking a current TOTP code
+func RegenerateRecoveryCodes(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Code string `json:"code" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
+		enrollment, ok := enabledMFA(c, db, user)
+		if !ok {
+			return
+		}
+
+		verified, err := verifySecondFactor(db, enrollment, request.Code, "")
+		if err != nil {
+			log.Printf("Error verifying MFA code for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate recovery codes"})
+			return
+		}
+		if !verified {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid verification code"})
+			return
+		}
+
+		recoveryCodes, err := mfa.GenerateRecoveryCodes()
+		if err != nil {
+			log.Printf("Error generating recovery codes for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate recovery codes"})
+			return
+		}
+
+		if err := models.ReplaceRecoveryCodes(db, user.ID, mfa.HashRecoveryCodes(recoveryCodes)); err != nil {
+			log.Printf("Error storing recovery codes for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate recovery codes"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"recoveryCodes": recoveryCodes})
+	}
+}
+
+// ResetUserMFA removes a user's MFA enrolment so they can enrol a new
+// authenticator, for users who have lost both their device and recovery codes
+func ResetUserMFA(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		currentUser, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
+		// Only super admins may reset another user's second factor
+		if !currentUser.IsSuperAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only administrators can reset MFA"})
+			return
+		}
+
+		if _, err := models.GetUser(db, userID); err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			log.Printf("Error getting user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user"})
+			return
+		}
+
+		if err := models.DisableUserMFA(db, userID); err != nil {
+			log.Printf("Error resetting MFA for user %d: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset MFA"})
+			return
+		}
+
+		log.Printf("MFA reset for user %d by %s", userID, currentUser.Username)
+		c.JSON(http.StatusOK, gin.H{"message": "MFA reset successfully"})
+	}
+}
+
+// GetTenantMFAPolicy returns the roles a tenant requires MFA for
+func GetTenantMFAPolicy(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		policy, err := models.GetTenantMFAPolicy(db, tenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get MFA policy: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"policy": policy})
+	}
+}
+
+// UpdateTenantMFAPolicy sets the roles a tenant requires MFA for
+func UpdateTenantMFAPolicy(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		var input models.UpdateTenantMFAPolicyInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Only the tenant's own roles can be named, so a typo cannot leave
+		// admins unprotected without anyone noticing
+		for _, name := range input.RequiredRoles {
+			role, err := models.GetRoleByName(db, name, tenantID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check role: " + err.Error()})
+				return
+			}
+			if role == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Role " + name + " does not exist in this tenant"})
+				return
+			}
+		}
+
+		policy, err := models.SetTenantMFAPolicy(db, tenantID, input)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update MFA policy: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"policy": policy})
+	}
+}
+
+// enabledMFA returns the user's confirmed enrolment, or responds and returns
+// false if they do not have MFA enabled
+func enabledMFA(c *gin.Context, db *sql.DB, user *models.User) (*models.UserMFA, bool) {
+	enrollment, err := models.GetUserMFA(db, user.ID)
+	if err != nil {
+		log.Printf("Error getting MFA enrollment for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get MFA status"})
+		return nil, false
+	}
+	if !enrollment.Enabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "MFA is not enabled"})
+		return nil, false
+	}
+	return enrollment, true
+}
+
+// contextUser returns the user set by the auth middleware, or responds and
+// returns false if there is none
+func contextUser(c *gin.Context) (*models.User, bool) {
+	userValue, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return nil, false
+	}
+
+	user, ok := userValue.(*models.User)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user in context"})
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go-server/models"
+	"go-server/tokens"
+)
+
+// oauthError responds with an OAuth2 error response (RFC 6749 section 5.2)
+func oauthError(c *gin.Context, status int, code string, description string) {
+	if status == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
+
+// clientCredentialsFromRequest returns the client ID and secret from HTTP
+// Basic authentication or, failing that, from the form body
+func clientCredentialsFromRequest(c *gin.Context) (string, string) {
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		// RFC 6749 section 2.3.1 form-encodes both before Basic encoding
+		if unescaped, err := url.QueryUnescape(id); err == nil {
+			id = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(secret); err == nil {
+			secret = unescaped
+		}
+		return id, secret
+	}
+	return c.PostForm("client_id"), c.PostForm("client_secret")
+}
+
+// authenticateAPIClient checks a client secret against the client's usable
+// secrets. It returns nil if the client cannot be authenticated.
+func authenticateAPIClient(db *sql.DB, clientID string, clientSecret string) (*models.APIClient, error) {
+	if clientID == "" || clientSecret == "" {
+		return nil, nil
+	}
+
+	client, err := models.GetAPIClientByClientID(db, clientID)
+	if err != nil || client == nil || !client.Active() {
+		return nil, err
+	}
+
+	secrets, err := models.ListAPIClientSecrets(db, client.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := []byte(tokens.HashClientSecret(clientSecret))
+	now := time.Now()
+	for _, secret := range secrets {
+		if secret.Usable(now) && subtle.ConstantTimeCompare(hash, []byte(secret.SecretHash)) == 1 {
+			return client, nil
+		}
+	}
+	return nil, nil
+}
+
+// OAuthToken is the OAuth2 token endpoint. It supports the
+// client_credentials grant, issuing API clients an access token that acts
+// within the scopes they were registered with.
+func OAuthToken(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		grantType := c.PostForm("grant_type")
+		if grantType == "" {
+			oauthError(c, http.StatusBadRequest, "invalid_request", "grant_type is required")
+			return
+		}
+		if grantType != "client_credentials" {
+			oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "Only the client_credentials grant is supported")
+			return
+		}
+
+		clientID, clientSecret := clientCredentialsFromRequest(c)
+		client, err := authenticateAPIClient(db, clientID, clientSecret)
+		if err != nil {
+			log.Printf("Error authenticating API client %s: %v", clientID, err)
+			oauthError(c, http.StatusInternalServerError, "server_error", "Failed to authenticate client")
+			return
+		}
+		if client == nil {
+			oauthError(c, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+			return
+		}
+
+		// Clients get every scope they were registered with unless they ask
+		// for fewer
+		scopes := client.Scopes
+		if requested := strings.Fields(c.PostForm("scope")); len(requested) > 0 {
+			scopes = make([]string, 0, len(requested))
+			for _, scope := range requested {
+				parsed, err := tokens.ParseScope(scope)
+				if err != nil || !tokens.ScopesAllow(client.Scopes, parsed.ResourceType, parsed.Resource, parsed.Action) {
+					oauthError(c, http.StatusBadRequest, "invalid_scope", "Scope "+scope+" is not granted to this client")
+					return
+				}
+				scopes = append(scopes, parsed.String())
+			}
+		}
+
+		user, err := models.GetUser(db, client.UserID)
+		if err != nil || !user.IsActive {
+			oauthError(c, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+			return
+		}
+
+		accessToken, claims, err := tokens.IssueClientCredentials(user, client, scopes)
+		if err != nil {
+			log.Printf("Error issuing token to API client %s: %v", client.ClientID, err)
+			oauthError(c, http.StatusInternalServerError, "server_error", "Failed to issue token")
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Header("Pragma", "no-cache")
+		c.JSON(http.StatusOK, gin.H{
+			"access_token": accessToken,
+			"token_type":   "Bearer",
+			"expires_in":   int(time.Until(claims.ExpiresAt.Time).Seconds()),
+			"scope":        claims.Scope,
+		})
+	}
+}
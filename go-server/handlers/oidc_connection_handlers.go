@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-server/models"
+	"go-server/oidc"
+)
+
+// validateOIDCIssuer checks an issuer is well formed and serves discovery
+// metadata that names it, so a misconfigured connection fails when saved
+// rather than at login
+func validateOIDCIssuer(ctx context.Context, issuer string) error {
+	issuer = strings.TrimRight(issuer, "/")
+	if err := oidc.ValidateIssuer(issuer); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, err := oidc.Discover(ctx, &http.Client{Timeout: 10 * time.Second}, issuer)
+	return err
+}
+
+// connectionModelError responds with the status for an OIDC or SAML connection model error
+func connectionModelError(c *gin.Context, action string, err error) {
+	switch {
+	case err.Error() == "connection not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "Connection not found"})
+	case err.Error() == "connection name already exists", strings.HasPrefix(err.Error(), "domain "):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err.Error() == "invalid domain":
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " connection: " + err.Error()})
+	}
+}
+
+// GetOIDCConnections lists a tenant's OIDC connections
+func GetOIDCConnections(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		connections, err := models.ListOIDCConnections(db, tenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get connections: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"connections": connections})
+	}
+}
+
+// GetOIDCConnection gets one of a tenant's OIDC connections
+func GetOIDCConnection(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		connectionID, err := strconv.Atoi(c.Param("connectionId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid connection ID"})
+			return
+		}
+
+		conn, err := models.GetOIDCConnection(db, connectionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get connection: " + err.Error()})
+			return
+		}
+		if conn == nil || conn.TenantID != tenantID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Connection not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"connection": conn})
+	}
+}
+
+// CreateOIDCConnection creates an OIDC connection for a tenant
+func CreateOIDCConnection(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		var input models.CreateOIDCConnectionInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := oidc.ValidateClaimMappings(input.ClaimMappings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateOIDCIssuer(c.Request.Context(), input.Issuer); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Issuer discovery failed: " + err.Error()})
+			return
+		}
+
+		conn, err := models.CreateOIDCConnection(db, tenantID, input)
+		if err != nil {
+			connectionModelError(c, "create", err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"connection": conn})
+	}
+}
+
+// UpdateOIDCConnection updates one of a tenant's OIDC connections
+func UpdateOIDCConnection(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		connectionID, err := strconv.Atoi(c.Param("connectionId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid connection ID"})
+			return
+		}
+
+		var input models.UpdateOIDCConnectionInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := oidc.ValidateClaimMappings(input.ClaimMappings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if input.Issuer != nil {
+			if err := validateOIDCIssuer(c.Request.Context(), *input.Issuer); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Issuer discovery failed: " + err.Error()})
+				return
+			}
+		}
+
+		conn, err := models.UpdateOIDCConnection(db, tenantID, connectionID, input)
+		if err != nil {
+			connectionModelError(c, "update", err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"connection": conn})
+	}
+}
+
+// DeleteOIDCConnection deletes one of a tenant's OIDC connections
+func DeleteOIDCConnection(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		connectionID, err := strconv.Atoi(c.Param("connectionId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid connection ID"})
+			return
+		}
+
+		if err := models.DeleteOIDCConnection(db, tenantID, connectionID); err != nil {
+			connectionModelError(c, "delete", err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Connection deleted successfully"})
+	}
+}
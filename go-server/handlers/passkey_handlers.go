@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go-server/models"
+	"go-server/passkey"
+	"go-server/tokens"
+)
+
+// WebAuthn ceremonies recorded in webauthn_sessions
+const (
+	passkeyRegistration = "registration"
+	passkeyLogin        = "login"
+)
+
+// passkeyRelyingParty configures WebAuthn for the host users reach us on.
+// WEBAUTHN_ORIGINS lists further origins, comma separated, allowed to run
+// ceremonies, such as a client served from another port in development.
+func passkeyRelyingParty(c *gin.Context) (*webauthn.WebAuthn, error) {
+	var extraOrigins []string
+	if origins := os.Getenv("WEBAUTHN_ORIGINS"); origins != "" {
+		extraOrigins = strings.Split(origins, ",")
+	}
+	return passkey.NewRelyingParty(publicBaseURL(c), mfaIssuer(), extraOrigins)
+}
+
+// passkeyUser loads a user's passkey handle and stored passkeys, assigning
+// a handle if they have none yet
+func passkeyUser(db *sql.DB, user *models.User) (*passkey.User, error) {
+	candidate, err := passkey.NewUserHandle()
+	if err != nil {
+		return nil, err
+	}
+	handle, err := models.EnsureWebAuthnUserHandle(db, user.ID, candidate)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := models.ListWebAuthnCredentials(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return passkey.NewUser(user, handle, credentials), nil
+}
+
+// startPasskeyCeremony stores the library's session state for a ceremony and
+// returns the ID the client finishes it with
+func startPasskeyCeremony(db *sql.DB, ceremony string, userID *int, name string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	id := tokens.NewID()
+	err = models.CreateWebAuthnSession(db, models.WebAuthnSession{
+		ID:        id,
+		Ceremony:  ceremony,
+		UserID:    userID,
+		Name:      name,
+		Data:      data,
+		ExpiresAt: time.Now().Add(passkey.CeremonyTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// finishPasskeyCeremony consumes the ceremony named by the sessionId query
+// parameter and returns it with the library's session state. It responds
+// and returns false if there is no such ceremony.
+func finishPasskeyCeremony(c *gin.Context, db *sql.DB, ceremony string) (*models.WebAuthnSession, *webauthn.SessionData, bool) {
+	stored, err := models.ConsumeWebAuthnSession(db, c.Query("sessionId"), ceremony)
+	if err != nil {
+		log.Printf("Error getting passkey %s session: %v", ceremony, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify passkey"})
+		return nil, nil, false
+	}
+	if stored == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired passkey session"})
+		return nil, nil, false
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(stored.Data, &session); err != nil {
+		log.Printf("Error reading passkey %s session: %v", ceremony, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify passkey"})
+		return nil, nil, false
+	}
+
+	return stored, &session, true
+}
+
+// BeginPasskeyRegistration starts registering a passkey for the authenticated
+// user. The options are passed to navigator.credentials.create and the
+// result posted to the finish endpoint with the returned session ID.
+func BeginPasskeyRegistration(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Name string `json:"name"`
+		}
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
+		name := strings.TrimSpace(request.Name)
+		if name == "" {
+			name = "Passkey"
+		}
+
+		relyingParty, err := passkeyRelyingParty(c)
+		if err != nil {
+			log.Printf("Error configuring WebAuthn: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Passkeys are not configured"})
+			return
+		}
+
+		webauthnUser, err := passkeyUser(db, user)
+		if err != nil {
+			log.Printf("Error loading passkeys for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+			return
+		}
+
+		options, session, err := relyingParty.BeginRegistration(webauthnUser, webauthn.WithExclusions(webauthnUser.Exclusions()))
+		if err != nil {
+			log.Printf("Error starting passkey registration for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+			return
+		}
+
+		sessionID, err := startPasskeyCeremony(db, passkeyRegistration, &user.ID, name, session)
+		if err != nil {
+			log.Printf("Error storing passkey registration for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"sessionId": sessionID,
+			"options":   options,
+		})
+	}
+}
+
+// FinishPasskeyRegistration verifies the authenticator's attestation and
+// stores the new passkey. The body is the PublicKeyCredential returned by
+// navigator.credentials.create.
+func FinishPasskeyRegistration(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
+		stored, session, ok := finishPasskeyCeremony(c, db, passkeyRegistration)
+		if !ok {
+			return
+		}
+		if stored.UserID == nil || *stored.UserID != user.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired passkey session"})
+			return
+		}
+
+		parsed, err := protocol.ParseCredentialCreationResponseBody(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey response"})
+			return
+		}
+
+		relyingParty, err := passkeyRelyingParty(c)
+		if err != nil {
+			log.Printf("Error configuring WebAuthn: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Passkeys are not configured"})
+			return
+		}
+
+		webauthnUser, err := passkeyUser(db, user)
+		if err != nil {
+			log.Printf("Error loading passkeys for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register passkey"})
+			return
+		}
+
+		credential, err := relyingParty.CreateCredential(webauthnUser, *session, parsed)
+		if err != nil {
+			log.Printf("Passkey registration rejected for user %s: %v", user.Username, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey registration could not be verified"})
+			return
+		}
+
+		cred, err := models.CreateWebAuthnCredential(db, passkey.FromCredential(user.ID, stored.Name, credential))
+		if err != nil {
+			if err.Error() == "credential already registered" {
+				c.JSON(http.StatusConflict, gin.H{"error": "This passkey is already registered"})
+				return
+			}
+			log.Printf("Error storing passkey for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register passkey"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"passkey": cred})
+	}
+}
+
+// GetPasskeys lists the authenticated user's passkeys
+func GetPasskeys(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
+		credentials, err := models.ListWebAuthnCredentials(db, user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get passkeys: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"passkeys": credentials})
+	}
+}
+
+// DeletePasskey revokes one of the authenticated user's passkeys
+func DeletePasskey(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
+		passkeyID, err := strconv.Atoi(c.Param("passkeyId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey ID"})
+			return
+		}
+
+		if err := models.DeleteWebAuthnCredential(db, user.ID, passkeyID); err != nil {
+			if err.Error() == "credential not found" {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete passkey: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Passkey deleted successfully"})
+	}
+}
+
+// BeginPasskeyLogin starts a passkey login. No username is needed: the
+// authenticator offers the user's passkeys for this site and the assertion
+// names the user.
+func BeginPasskeyLogin(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		relyingParty, err := passkeyRelyingParty(c)
+		if err != nil {
+			log.Printf("Error configuring WebAuthn: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Passkeys are not configured"})
+			return
+		}
+
+		options, session, err := relyingParty.BeginDiscoverableLogin(passkey.LoginOptions()...)
+		if err != nil {
+			log.Printf("Error starting passkey login: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey login"})
+			return
+		}
+
+		sessionID, err := startPasskeyCeremony(db, passkeyLogin, nil, "", session)
+		if err != nil {
+			log.Printf("Error storing passkey login: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey login"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"sessionId": sessionID,
+			"options":   options,
+		})
+	}
+}
+
+// FinishPasskeyLogin verifies a passkey assertion and logs the user in. The
+// body is the PublicKeyCredential returned by navigator.credentials.get.
+// Passkeys verify the user on the authenticator, so no TOTP step follows.
+func FinishPasskeyLogin(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, session, ok := finishPasskeyCeremony(c, db, passkeyLogin)
+		if !ok {
+			return
+		}
+
+		parsed, err := protocol.ParseCredentialRequestResponseBody(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey response"})
+			return
+		}
+
+		relyingParty, err := passkeyRelyingParty(c)
+		if err != nil {
+			log.Printf("Error configuring WebAuthn: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Passkeys are not configured"})
+			return
+		}
+
+		var user *models.User
+		var webauthnUser *passkey.User
+		lookup := func(rawID, userHandle []byte) (webauthn.User, error) {
+			found, err := models.GetUserByWebAuthnHandle(db, userHandle)
+			if err != nil {
+				return nil, err
+			}
+			credentials, err := models.ListWebAuthnCredentials(db, found.ID)
+			if err != nil {
+				return nil, err
+			}
+			user = found
+			webauthnUser = passkey.NewUser(found, userHandle, credentials)
+			return webauthnUser, nil
+		}
+
+		credential, err := relyingParty.ValidateDiscoverableLogin(lookup, *session, parsed)
+		if err != nil {
+			log.Printf("Passkey login rejected: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return
+		}
+
+		stored := webauthnUser.Stored(credential.ID)
+		if stored == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return
+		}
+
+		cloned := stored.CloneWarning || credential.Authenticator.CloneWarning
+		err = models.RecordWebAuthnCredentialUse(db, stored.ID, int64(credential.Authenticator.SignCount), cloned, credential.Flags.BackupState)
+		if err != nil {
+			log.Printf("Error recording passkey use for user %s: %v", user.Username, err)
+			// Not critical, continue
+		}
+
+		// A signature counter that went backwards means two authenticators
+		// may hold the same key, so the passkey is no longer trusted
+		if cloned {
+			log.Printf("Passkey %d of user %s rejected: possible cloned authenticator", stored.ID, user.Username)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "This passkey has been blocked; sign in another way and register it again"})
+			return
+		}
+
+		if !user.IsActive {
+			c.JSON(http.StatusForbidden, gin.H{"error": "User account is inactive"})
+			return
+		}
+
+		completeLocalLogin(c, db, user, nil)
+	}
+}
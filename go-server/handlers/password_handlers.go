@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go-server/auth"
+	"go-server/models"
+	"go-server/passwords"
+	"go-server/tokens"
+)
+
+// checkNewPassword checks a password being chosen against the tenant's
+// policy and, for an existing user, their password history. It responds and
+// returns false if the password cannot be used.
+func checkNewPassword(c *gin.Context, db *sql.DB, tenantID int, user *models.User, password string) bool {
+	err := auth.CheckNewPassword(db, tenantID, user, password)
+	if err == nil {
+		return true
+	}
+
+	if policyErr, ok := err.(*passwords.PolicyError); ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Password does not meet the password policy",
+			"violations": policyErr.Violations,
+		})
+		return false
+	}
+
+	log.Printf("Error checking password policy for tenant %d: %v", tenantID, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check password policy"})
+	return false
+}
+
+// requirePasswordChange responds to a login by a user who must change their
+// password with a password_change token instead of an access token. Any
+// extra fields are added to the response.
+func requirePasswordChange(c *gin.Context, user *models.User, extra gin.H) {
+	token, claims, err := tokens.IssuePasswordChange(user)
+	if err != nil {
+		log.Printf("Error generating password change token for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
+		return
+	}
+
+	response := gin.H{
+		"passwordChangeRequired": true,
+		"passwordChangeToken":    token,
+		"expiresAt":              claims.ExpiresAt.Time,
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// CompletePasswordChange sets a new password for a user who had to change
+// theirs at login, and finishes the login
+func CompletePasswordChange(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			PasswordChangeToken string `json:"passwordChangeToken" binding:"required"`
+			NewPassword         string `json:"newPassword" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		claims, err := tokens.ParsePasswordChange(input.PasswordChangeToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired password change token"})
+			return
+		}
+
+		user, err := models.GetUser(db, claims.UserID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			return
+		}
+		if !user.IsActive {
+			c.JSON(http.StatusForbidden, gin.H{"error": "User account is inactive"})
+			return
+		}
+
+		// The flag is cleared by the change, so each token works only once
+		if !user.MustChangePassword {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired password change token"})
+			return
+		}
+
+		if !checkNewPassword(c, db, user.TenantID, user, input.NewPassword) {
+			return
+		}
+
+		user, err = models.UpdateUser(db, user.ID, models.UpdateUserInput{Password: &input.NewPassword})
+		if err != nil {
+			log.Printf("Error changing password for user %s: %v", claims.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+			return
+		}
+
+		completeLocalLogin(c, db, user, nil)
+	}
+}
+
+// ChangePassword changes the current user's password. Their other sessions
+// are signed out and this one is given new tokens.
+func ChangePassword(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
+		var input struct {
+			CurrentPassword string `json:"currentPassword" binding:"required"`
+			NewPassword     string `json:"newPassword" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !models.VerifyPassword(user.Password, input.CurrentPassword) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+
+		if !checkNewPassword(c, db, user.TenantID, user, input.NewPassword) {
+			return
+		}
+
+		updatedUser, err := models.UpdateUser(db, user.ID, models.UpdateUserInput{Password: &input.NewPassword})
+		if err != nil {
+			log.Printf("Error changing password for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+			return
+		}
+
+		completeLocalLogin(c, db, updatedUser, nil)
+	}
+}
+
+// GetTenantPasswordPolicy returns the password policy for a tenant's users
+func GetTenantPasswordPolicy(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		policy, err := models.GetTenantPasswordPolicy(db, tenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get password policy: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"policy": policy})
+	}
+}
+
+// UpdateTenantPasswordPolicy sets the password policy for a tenant's users.
+// It applies to passwords chosen from now on; existing passwords are kept.
+func UpdateTenantPasswordPolicy(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		var input passwords.Policy
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := input.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		policy, err := models.SetTenantPasswordPolicy(db, tenantID, input)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password policy: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"policy": policy})
+	}
+}
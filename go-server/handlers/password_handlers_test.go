@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"go-server/middleware"
+	"go-server/models"
+	"go-server/sqltest"
+	"go-server/tokens"
+)
+
+var userColumns = []string{
+	"id", "username", "password", "email", "display_name",
+	"avatar", "tenant_id", "is_active", "is_super_admin",
+	"last_login", "casdoor_id", "created_at", "updated_at",
+	"failed_login_attempts", "locked_until", "must_change_password",
+	"email_verified_at", "is_service_account",
+}
+
+// initTestKeyring signs tokens with a key kept in a database of its own
+func initTestKeyring(t *testing.T) {
+	t.Helper()
+	db := sqltest.Open(t)
+	var keys []models.SigningKey
+
+	db.Handle(`^SELECT kid, algorithm, private_key, created_at FROM signing_keys`, func([]driver.Value) (*sqltest.Result, error) {
+		return &sqltest.Result{Columns: []string{"kid", "algorithm", "private_key", "created_at"}}, nil
+	})
+	db.Handle(`^INSERT INTO signing_keys`, func(args []driver.Value) (*sqltest.Result, error) {
+		keys = append(keys, models.SigningKey{
+			KID:        args[0].(string),
+			Algorithm:  args[1].(string),
+			PrivateKey: args[2].(string),
+			CreatedAt:  args[3].(time.Time),
+		})
+		return sqltest.Affected(1), nil
+	})
+	db.Ignore(`^UPDATE signing_keys SET retired_at`)
+	db.Handle(`^SELECT kid, algorithm, private_key, created_at, retired_at FROM signing_keys`, func([]driver.Value) (*sqltest.Result, error) {
+		result := &sqltest.Result{Columns: []string{"kid", "algorithm", "private_key", "created_at", "retired_at"}}
+		for _, key := range keys {
+			result.Rows = append(result.Rows, []driver.Value{key.KID, key.Algorithm, key.PrivateKey, key.CreatedAt, nil})
+		}
+		return result, nil
+	})
+
+	config := tokens.KeyringConfig{Algorithm: tokens.AlgorithmES256, GracePeriod: time.Hour}
+	if err := tokens.InitKeyring(db.DB, config); err != nil {
+		t.Fatalf("InitKeyring: %v", err)
+	}
+}
+
+// newPasswordChangeStore answers the statements run to change a user's
+// password, sign them in again and authenticate their requests, for a
+// single user
+func newPasswordChangeStore(t *testing.T, user *models.User) *sqltest.DB {
+	db := sqltest.Open(t)
+	var cutoff time.Time
+	revokedSessions := map[string]bool{}
+	sessions := map[string]bool{}
+
+	db.Handle(`^SELECT id, username, password, .* FROM users WHERE id = \$1`, func([]driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row(userColumns,
+			int64(user.ID), user.Username, user.Password, user.Email, user.DisplayName,
+			nil, int64(user.TenantID), user.IsActive, user.IsSuperAdmin,
+			nil, nil, user.CreatedAt, user.UpdatedAt,
+			int64(0), nil, user.MustChangePassword,
+			*user.EmailVerifiedAt, false,
+		), nil
+	})
+	db.Handle(`^UPDATE users SET updated_at = NOW\(\), password = \$1, password_changed_at = \$2, must_change_password = \$3 WHERE id = \$4$`, func(args []driver.Value) (*sqltest.Result, error) {
+		user.Password = args[0].(string)
+		user.MustChangePassword = args[2].(bool)
+		return sqltest.Affected(1), nil
+	})
+	db.Ignore(`^SELECT min_length, .* FROM tenant_password_policies`)
+	db.Ignore(`password_history`)
+	db.Ignore(`^UPDATE users SET last_login = NOW\(\)`)
+	db.Ignore(`FROM roles`)
+	db.Ignore(`FROM tenants`)
+	db.Handle(`^INSERT INTO refresh_tokens`, func([]driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row([]string{"id"}, int64(1)), nil
+	})
+
+	db.Handle(`^INSERT INTO user_token_revocations`, func(args []driver.Value) (*sqltest.Result, error) {
+		cutoff = args[1].(time.Time)
+		return sqltest.Affected(1), nil
+	})
+	db.Ignore(`^UPDATE refresh_tokens SET revoked_at = \$1 WHERE user_id = \$2`)
+	db.Handle(`^UPDATE user_sessions SET revoked_at = \$1 WHERE user_id = \$2`, func([]driver.Value) (*sqltest.Result, error) {
+		for id := range sessions {
+			revokedSessions[id] = true
+		}
+		return sqltest.Affected(int64(len(sessions))), nil
+	})
+	db.Handle(`^INSERT INTO user_sessions`, func(args []driver.Value) (*sqltest.Result, error) {
+		sessions[args[0].(string)] = true
+		return sqltest.Affected(1), nil
+	})
+	db.Ignore(`^UPDATE user_sessions SET last_seen_at`)
+	db.Handle(`^SELECT EXISTS \(SELECT 1 FROM revoked_tokens`, func(args []driver.Value) (*sqltest.Result, error) {
+		revoked := revokedSessions[args[1].(string)] || !cutoff.IsZero() && !cutoff.Before(args[3].(time.Time))
+		return sqltest.Row([]string{"revoked"}, revoked), nil
+	})
+
+	return db
+}
+
+func newPasswordTestUser(t *testing.T, password string) *models.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+	verifiedAt := time.Now().Add(-time.Hour)
+	return &models.User{
+		ID: 7, Username: "alice", Password: string(hash), Email: "alice@example.com", DisplayName: "Alice",
+		TenantID: 3, IsActive: true, CreatedAt: verifiedAt, UpdatedAt: verifiedAt, EmailVerifiedAt: &verifiedAt,
+	}
+}
+
+// newPasswordTestRouter mounts the password change endpoints and an
+// authenticated endpoint to try the issued tokens on
+func newPasswordTestRouter(db *sqltest.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/password/change", CompletePasswordChange(db.DB))
+
+	protected := router.Group("/", middleware.JWTAuth(db.DB))
+	protected.POST("/auth/password", ChangePassword(db.DB))
+	protected.GET("/auth/me", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{})
+	})
+	return router
+}
+
+func request(router *gin.Engine, method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// loginToken returns the access token of a successful login response
+func loginToken(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("responded with %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Token == "" {
+		t.Fatalf("no token in response %s", w.Body.String())
+	}
+	return response.Token
+}
+
+func TestChangePasswordIssuesUsableToken(t *testing.T) {
+	initTestKeyring(t)
+	user := newPasswordTestUser(t, "Old-password-1234")
+	db := newPasswordChangeStore(t, user)
+	router := newPasswordTestRouter(db)
+
+	oldToken, _, err := tokens.IssueForUser(user, nil)
+	if err != nil {
+		t.Fatalf("IssueForUser: %v", err)
+	}
+
+	w := request(router, http.MethodPost, "/auth/password", oldToken, gin.H{
+		"currentPassword": "Old-password-1234",
+		"newPassword":     "New-password-5678",
+	})
+	newToken := loginToken(t, w)
+
+	if w := request(router, http.MethodGet, "/auth/me", newToken, nil); w.Code != http.StatusOK {
+		t.Errorf("token issued by the password change: got %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if w := request(router, http.MethodGet, "/auth/me", oldToken, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("token issued before the password change: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestCompletePasswordChangeIssuesUsableToken(t *testing.T) {
+	initTestKeyring(t)
+	user := newPasswordTestUser(t, "Chosen-for-you-1234")
+	user.MustChangePassword = true
+	db := newPasswordChangeStore(t, user)
+	router := newPasswordTestRouter(db)
+
+	changeToken, _, err := tokens.IssuePasswordChange(user)
+	if err != nil {
+		t.Fatalf("IssuePasswordChange: %v", err)
+	}
+
+	w := request(router, http.MethodPost, "/auth/password/change", "", gin.H{
+		"passwordChangeToken": changeToken,
+		"newPassword":         "New-password-5678",
+	})
+	token := loginToken(t, w)
+
+	if w := request(router, http.MethodGet, "/auth/me", token, nil); w.Code != http.StatusOK {
+		t.Errorf("token issued by completing the password change: got %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+}
+
+func TestRefreshTokenRequiresPasswordChange(t *testing.T) {
+	initTestKeyring(t)
+	user := newPasswordTestUser(t, "Chosen-for-you-1234")
+	user.MustChangePassword = true
+	db := newPasswordChangeStore(t, user)
+	router := newPasswordTestRouter(db)
+	router.POST("/auth/refresh", RefreshToken(db.DB))
+
+	// A refresh token from a session started before the password change was
+	// required
+	db.Handle(`^SELECT id, token_hash, family_id, .* FROM refresh_tokens WHERE token_hash = \$1 FOR UPDATE$`, func([]driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row([]string{"id", "token_hash", "family_id", "user_id", "tenant_id", "expires_at", "used_at", "revoked_at", "created_at"},
+			int64(1), "hash", "family", int64(user.ID), int64(user.TenantID), time.Now().Add(time.Hour), nil, nil, time.Now()), nil
+	})
+	db.Ignore(`^UPDATE refresh_tokens SET used_at = \$1 WHERE id = \$2$`)
+	db.Ignore(`^UPDATE refresh_tokens SET revoked_at = \$1 WHERE family_id = \$2`)
+	db.Ignore(`^UPDATE user_sessions SET revoked_at = \$2 WHERE id = \$1`)
+
+	w := request(router, http.MethodPost, "/auth/refresh", "", gin.H{"refreshToken": "refresh-token"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("responded with %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Token                  string `json:"token"`
+		RefreshToken           string `json:"refreshToken"`
+		PasswordChangeRequired bool   `json:"passwordChangeRequired"`
+		PasswordChangeToken    string `json:"passwordChangeToken"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if response.Token != "" || response.RefreshToken != "" {
+		t.Error("refreshed the session of a user who must change their password")
+	}
+	if !response.PasswordChangeRequired {
+		t.Fatalf("got %s, want a password change", w.Body.String())
+	}
+	if _, err := tokens.ParsePasswordChange(response.PasswordChangeToken); err != nil {
+		t.Errorf("password change token: %v", err)
+	}
+
+	if revoked := db.Statements(`^UPDATE refresh_tokens SET revoked_at = \$1 WHERE family_id = \$2`); len(revoked) != 1 || revoked[0].Args[1] != "family" {
+		t.Errorf("got refresh token revocations %v, want the session's", revoked)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"database/sql"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 
+	"go-server/conditions"
 	"go-server/models"
 )
 
@@ -144,31 +146,33 @@ func GetPermissions(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
-// CreatePermission creates a new permission
+// CreatePermission creates a new permission in a tenant the caller manages
 func CreatePermission(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Check if user has permission
-		isSuperAdmin, exists := c.Get("isSuperAdmin")
-		
-		// Temporarily bypass superadmin check for testing
-		_ = exists
-		_ = isSuperAdmin
-		
-		// Original permission check
-		/*if !exists || !isSuperAdmin.(bool) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Superadmin privileges required"})
-			return
-		}*/
-
 		var input models.CreatePermissionInput
 		if err := c.ShouldBindJSON(&input); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
+		if !managesTenant(c, input.TenantID) {
+			return
+		}
+
+		// Check the condition now rather than when it is first evaluated
+		input.Condition = strings.TrimSpace(input.Condition)
+		if err := conditions.Validate(input.Condition); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		// Create permission
 		permission, err := models.CreatePermission(db, input)
 		if err != nil {
+			if err.Error() == "role belongs to another tenant" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Role belongs to another tenant"})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create permission: " + err.Error()})
 			return
 		}
@@ -177,16 +181,9 @@ func CreatePermission(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
-// DeletePermission deletes a permission
+// DeletePermission deletes a permission in a tenant the caller manages
 func DeletePermission(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Check if user has permission
-		isSuperAdmin, exists := c.Get("isSuperAdmin")
-		if !exists || !isSuperAdmin.(bool) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Superadmin privileges required"})
-			return
-		}
-
 		// Get permission ID from path
 		idStr := c.Param("id")
 		id, err := strconv.Atoi(idStr)
@@ -196,11 +193,14 @@ func DeletePermission(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Check if permission exists
-		_, err = models.GetPermissionByID(db, id)
-		if err != nil {
+		permission, err := models.GetPermissionByID(db, id)
+		if err != nil || permission == nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Permission not found"})
 			return
 		}
+		if !managesTenant(c, permission.TenantID) {
+			return
+		}
 
 		// Delete permission
 		err = models.DeletePermission(db, id)
@@ -27,7 +27,7 @@ func GetRoles(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Get roles
-		roles, err := models.GetAllRoles(db, tenantID)
+		roles, err := models.ListRoles(db, tenantID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get roles: " + err.Error()})
 			return
@@ -63,6 +63,54 @@ func GetRole(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
+// GetRoleEffectivePermissions gets every permission a role has, including
+// those it inherits, with the role that grants each
+func GetRoleEffectivePermissions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get role ID from path
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+			return
+		}
+
+		// Get role
+		role, err := models.GetRoleByID(db, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role: " + err.Error()})
+			return
+		}
+		if role == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+
+		permissions, err := models.GetEffectivePermissions(db, role.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get permissions: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"role": role, "permissions": permissions})
+	}
+}
+
+// isRoleHierarchyError reports whether a role could not be saved because
+// its parent breaks the rules of the role hierarchy
+func isRoleHierarchyError(err error) bool {
+	switch err.Error() {
+	case "parent role not found",
+		"parent role must be in the same tenant",
+		"role cannot be its own parent",
+		"role hierarchy cannot contain a cycle",
+		"role hierarchy is too deep",
+		"role has child roles":
+		return true
+	}
+	return false
+}
+
 // CreateRole creates a new role
 func CreateRole(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -83,8 +131,13 @@ func CreateRole(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		// Roles are only created in tenants the caller manages
+		if !managesTenant(c, input.TenantID) {
+			return
+		}
+
 		// Check if role name already exists in this tenant
-		existingRole, err := models.GetRoleByName(db, input.Name, &input.TenantID)
+		existingRole, err := models.GetRoleByName(db, input.Name, input.TenantID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check role name: " + err.Error()})
 			return
@@ -108,6 +161,10 @@ func CreateRole(db *sql.DB) gin.HandlerFunc {
 		// Create role
 		role, err := models.CreateRole(db, input)
 		if err != nil {
+			if isRoleHierarchyError(err) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create role: " + err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role: " + err.Error()})
 			return
 		}
@@ -138,6 +195,10 @@ func UpdateRole(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		if !managesTenant(c, role.TenantID) {
+			return
+		}
+
 		// Parse request
 		var input models.UpdateRoleInput
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -145,13 +206,16 @@ func UpdateRole(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		// A role's permissions and assignments are kept in its tenant, so
+		// the role cannot move to another
+		if input.TenantID != nil && *input.TenantID != role.TenantID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Roles cannot be moved to another tenant"})
+			return
+		}
+
 		// Check if role name is being changed and already exists in this tenant
 		if input.Name != nil && *input.Name != role.Name {
-			tenantID := role.TenantID
-			if input.TenantID != nil {
-				tenantID = *input.TenantID
-			}
-			existingRole, err := models.GetRoleByName(db, *input.Name, &tenantID)
+			existingRole, err := models.GetRoleByName(db, *input.Name, role.TenantID)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check role name: " + err.Error()})
 				return
@@ -162,22 +226,13 @@ func UpdateRole(db *sql.DB) gin.HandlerFunc {
 			}
 		}
 
-		// Check if tenant is being changed and exists
-		if input.TenantID != nil {
-			tenant, err := models.GetTenantByID(db, *input.TenantID)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check tenant: " + err.Error()})
-				return
-			}
-			if tenant == nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Tenant not found"})
-				return
-			}
-		}
-
 		// Update role
 		updatedRole, err := models.UpdateRole(db, id, input)
 		if err != nil {
+			if isRoleHierarchyError(err) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update role: " + err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role: " + err.Error()})
 			return
 		}
@@ -207,6 +262,9 @@ func DeleteRole(db *sql.DB) gin.HandlerFunc {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
 			return
 		}
+		if !managesTenant(c, role.TenantID) {
+			return
+		}
 
 		// Delete role
 		err = models.DeleteRole(db, id)
@@ -217,4 +275,81 @@ func DeleteRole(db *sql.DB) gin.HandlerFunc {
 
 		c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
 	}
-}
\ No newline at end of file
+}
+// AssignRoleToUser assigns a role to a user within the role's tenant
+func AssignRoleToUser(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Parse request
+		var input struct {
+			UserID int `json:"userId" binding:"required"`
+			RoleID int `json:"roleId" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Check if role exists
+		role, err := models.GetRoleByID(db, input.RoleID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role: " + err.Error()})
+			return
+		}
+		if role == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		if !managesTenant(c, role.TenantID) {
+			return
+		}
+
+		// Assign role
+		err = models.AssignRoleToUser(db, input.UserID, role.ID, role.TenantID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to assign role: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"message": "Role assigned successfully"})
+	}
+}
+
+// RemoveRoleFromUser removes a role from a user
+func RemoveRoleFromUser(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get user and role IDs from path
+		userID, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		roleID, err := strconv.Atoi(c.Param("roleId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+			return
+		}
+
+		// Check if role exists
+		role, err := models.GetRoleByID(db, roleID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role: " + err.Error()})
+			return
+		}
+		if role == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		if !managesTenant(c, role.TenantID) {
+			return
+		}
+
+		// Remove role
+		err = models.RemoveRoleFromUser(db, userID, role.ID, role.TenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove role: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Role removed successfully"})
+	}
+}
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-server/auth"
+	"go-server/models"
+	"go-server/saml"
+)
+
+// samlRequestTTL is how long the IdP has to answer an AuthnRequest
+const samlRequestTTL = 10 * time.Minute
+
+// samlServiceProvider builds our service provider for a connection. The
+// entity ID is the connection's metadata URL.
+func samlServiceProvider(c *gin.Context, conn *models.SAMLConnection) (*saml.ServiceProvider, error) {
+	idpMetadata, err := saml.ParseIdPMetadata([]byte(conn.IdPMetadata))
+	if err != nil {
+		return nil, err
+	}
+
+	key, cert, err := saml.ParseKeyPair(conn.SPPrivateKey, conn.SPCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := fmt.Sprintf("%s/api/auth/saml/%d", publicBaseURL(c), conn.ID)
+	return saml.NewServiceProvider(saml.Config{
+		EntityID:    baseURL + "/metadata",
+		ACSURL:      baseURL + "/acs",
+		Key:         key,
+		Certificate: cert,
+		IdPMetadata: idpMetadata,
+	})
+}
+
+// samlConnectionParam loads the SAML connection named in the path
+func samlConnectionParam(c *gin.Context, db *sql.DB) (*models.SAMLConnection, bool) {
+	connectionID, err := strconv.Atoi(c.Param("connectionId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid connection ID"})
+		return nil, false
+	}
+
+	conn, err := models.GetSAMLConnection(db, connectionID)
+	if err != nil {
+		log.Printf("[AUTH] Failed to get SAML connection %d: %v", connectionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get connection"})
+		return nil, false
+	}
+	if conn == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Connection not found"})
+		return nil, false
+	}
+
+	return conn, true
+}
+
+// SAMLMetadata serves our SP metadata for a connection, for the tenant to
+// register with its IdP
+func SAMLMetadata(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conn, ok := samlConnectionParam(c, db)
+		if !ok {
+			return
+		}
+
+		sp, err := samlServiceProvider(c, conn)
+		if err != nil {
+			log.Printf("[AUTH] SAML connection %d is misconfigured: %v", conn.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Connection is misconfigured"})
+			return
+		}
+
+		metadata, err := sp.Metadata()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate metadata"})
+			return
+		}
+
+		c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+	}
+}
+
+// SAMLLogin starts a login through a tenant's SAML connection with a signed
+// AuthnRequest
+func SAMLLogin(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conn, ok := samlConnectionParam(c, db)
+		if !ok {
+			return
+		}
+		if !conn.Enabled {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Connection not found"})
+			return
+		}
+
+		redirectTo, ok := loginRedirectTarget(c, db, conn.TenantID)
+		if !ok {
+			return
+		}
+
+		sp, err := samlServiceProvider(c, conn)
+		if err != nil {
+			log.Printf("[AUTH] SAML connection %d is misconfigured: %v", conn.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Connection is misconfigured"})
+			return
+		}
+
+		// The relay state identifies the request when the IdP posts back. It
+		// is random so it can't be guessed to answer someone else's request.
+		relayBytes := make([]byte, 24)
+		if _, err := rand.Read(relayBytes); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			return
+		}
+		relayState := base64.RawURLEncoding.EncodeToString(relayBytes)
+
+		authURL, requestID, err := sp.AuthnRequest(relayState)
+		if err != nil {
+			log.Printf("[AUTH] Failed to create AuthnRequest for SAML connection %d: %v", conn.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			return
+		}
+
+		err = models.CreateSAMLRequest(db, models.SAMLRequest{
+			ID:           requestID,
+			RelayState:   relayState,
+			ConnectionID: conn.ID,
+			TenantID:     conn.TenantID,
+			RedirectTo:   redirectTo,
+			ExpiresAt:    time.Now().Add(samlRequestTTL),
+		})
+		if err != nil {
+			log.Printf("[AUTH] Failed to record SAML request: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			return
+		}
+
+		c.Redirect(http.StatusTemporaryRedirect, authURL)
+	}
+}
+
+// SAMLACS is the assertion consumer service. It validates the IdP's response
+// to a request we made and issues our own session for the user.
+func SAMLACS(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conn, ok := samlConnectionParam(c, db)
+		if !ok {
+			return
+		}
+
+		// Each request can be answered once; IdP-initiated responses have no
+		// request and are refused
+		request, err := models.ConsumeSAMLRequest(db, c.PostForm("RelayState"))
+		if err != nil {
+			log.Printf("[AUTH] Failed to look up SAML request: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login state"})
+			return
+		}
+		if request == nil || request.ConnectionID != conn.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login state"})
+			return
+		}
+		if !conn.Enabled || conn.TenantID != request.TenantID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Connection not found"})
+			return
+		}
+
+		sp, err := samlServiceProvider(c, conn)
+		if err != nil {
+			log.Printf("[AUTH] SAML connection %d is misconfigured: %v", conn.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Connection is misconfigured"})
+			return
+		}
+
+		assertion, err := sp.ParseResponse(c.Request, request.ID)
+		if err != nil {
+			log.Printf("[AUTH] Rejected SAML response for connection %d: %v", conn.ID, err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify identity"})
+			return
+		}
+
+		mapped := saml.MapAssertion(assertion, conn.AttributeMappings)
+		if mapped.Subject == "" {
+			log.Printf("[AUTH] SAML assertion for connection %d has no subject", conn.ID)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify identity"})
+			return
+		}
+
+		tenant, err := models.GetTenantByID(db, conn.TenantID)
+		if err != nil || tenant == nil {
+			log.Printf("[AUTH] Failed to get tenant %d: %v", conn.TenantID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to provision user"})
+			return
+		}
+
+		// Users of a connection always belong to its tenant
+		completeSSOLogin(c, db, auth.ExternalIdentity{
+			SAMLConnectionID: conn.ID,
+			Subject:          mapped.Subject,
+			Organization:     tenant.Name,
+			Username:         mapped.Username,
+			DisplayName:      mapped.DisplayName,
+			Email:            mapped.Email,
+			Avatar:           mapped.Avatar,
+			Roles:            mapped.Roles,
+			Groups:           mapped.Groups,
+		}, request.RedirectTo)
+	}
+}
+
+// GetSAMLConnections lists a tenant's SAML connections
+func GetSAMLConnections(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		connections, err := models.ListSAMLConnections(db, tenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get connections: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"connections": connections})
+	}
+}
+
+// GetSAMLConnection gets one of a tenant's SAML connections
+func GetSAMLConnection(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		conn, ok := samlConnectionParam(c, db)
+		if !ok {
+			return
+		}
+		if conn.TenantID != tenantID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Connection not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"connection": conn})
+	}
+}
+
+// CreateSAMLConnection creates a SAML connection for a tenant, with a new key
+// pair for signing its AuthnRequests
+func CreateSAMLConnection(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		var input models.CreateSAMLConnectionInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := saml.ValidateAttributeMappings(input.AttributeMappings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		idpMetadata, err := saml.ParseIdPMetadata([]byte(input.IdPMetadata))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		keyPEM, certPEM, err := saml.GenerateKeyPair(input.Name)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate key pair: " + err.Error()})
+			return
+		}
+
+		conn, err := models.CreateSAMLConnection(db, tenantID, input, idpMetadata.EntityID, keyPEM, certPEM)
+		if err != nil {
+			connectionModelError(c, "create", err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"connection": conn})
+	}
+}
+
+// UpdateSAMLConnection updates one of a tenant's SAML connections
+func UpdateSAMLConnection(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		connectionID, err := strconv.Atoi(c.Param("connectionId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid connection ID"})
+			return
+		}
+
+		var input models.UpdateSAMLConnectionInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := saml.ValidateAttributeMappings(input.AttributeMappings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		idpEntityID := ""
+		if input.IdPMetadata != nil {
+			idpMetadata, err := saml.ParseIdPMetadata([]byte(*input.IdPMetadata))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			idpEntityID = idpMetadata.EntityID
+		}
+
+		conn, err := models.UpdateSAMLConnection(db, tenantID, connectionID, input, idpEntityID)
+		if err != nil {
+			connectionModelError(c, "update", err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"connection": conn})
+	}
+}
+
+// DeleteSAMLConnection deletes one of a tenant's SAML connections
+func DeleteSAMLConnection(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		connectionID, err := strconv.Atoi(c.Param("connectionId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid connection ID"})
+			return
+		}
+
+		if err := models.DeleteSAMLConnection(db, tenantID, connectionID); err != nil {
+			connectionModelError(c, "delete", err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Connection deleted successfully"})
+	}
+}
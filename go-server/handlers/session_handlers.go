@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go-server/middleware"
+	"go-server/models"
+	"go-server/tokens"
+)
+
+// userAgentBrowsers and userAgentPlatforms map user agent fragments to the
+// names shown for a session. Order matters: several browsers claim to be
+// Chrome or Safari too.
+var userAgentBrowsers = []struct{ fragment, name string }{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"curl/", "curl"},
+	{"PostmanRuntime/", "Postman"},
+}
+
+var userAgentPlatforms = []struct{ fragment, name string }{
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Android", "Android"},
+	{"Windows", "Windows"},
+	{"Mac OS X", "macOS"},
+	{"CrOS", "ChromeOS"},
+	{"Linux", "Linux"},
+}
+
+// describeDevice returns a readable description of the device behind a user
+// agent, such as "Firefox on Windows"
+func describeDevice(userAgent string) string {
+	browser := "Unknown browser"
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(userAgent, b.fragment) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, p := range userAgentPlatforms {
+		if strings.Contains(userAgent, p.fragment) {
+			return browser + " on " + p.name
+		}
+	}
+	return browser
+}
+
+// startSession records the session a login has just started, so the user
+// can see where they are signed in
+func startSession(c *gin.Context, db *sql.DB, claims *tokens.Claims) {
+	userAgent := c.Request.UserAgent()
+	err := tokens.StartSession(db, claims, models.UserSession{
+		Device:    describeDevice(userAgent),
+		UserAgent: userAgent,
+		IPAddress: c.ClientIP(),
+	})
+	if err != nil {
+		log.Printf("Error recording session for user %s: %v", claims.Username, err)
+		// Not critical, continue
+	}
+}
+
+// sessionsResponse responds with a user's sessions, marking the one the
+// request was made with
+func sessionsResponse(c *gin.Context, db *sql.DB, userID int) {
+	sessions, err := models.ListUserSessions(db, userID)
+	if err != nil {
+		log.Printf("Error listing sessions for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	currentSessionID := ""
+	if claims, ok := middleware.GetClaimsFromContext(c); ok && claims.UserID == userID {
+		currentSessionID = claims.SessionID
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions":         sessions,
+		"currentSessionId": currentSessionID,
+	})
+}
+
+// revokeSession revokes one of a user's sessions
+func revokeSession(c *gin.Context, db *sql.DB, userID int) {
+	sessionID := c.Param("sessionId")
+	if err := models.RevokeUserSession(db, userID, sessionID); err != nil {
+		if err.Error() == "session not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		log.Printf("Error revoking session %s of user %d: %v", sessionID, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	// Signing out of the current browser session clears its cookies too
+	if claims, ok := middleware.GetClaimsFromContext(c); ok && claims.UserID == userID && claims.SessionID == sessionID {
+		clearSessionCookies(c)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// GetSessions lists the sessions the current user is signed in with
+func GetSessions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+		sessionsResponse(c, db, user.ID)
+	}
+}
+
+// RevokeSession signs the current user out of one of their sessions
+func RevokeSession(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := contextUser(c)
+		if !ok {
+			return
+		}
+		revokeSession(c, db, user.ID)
+	}
+}
+
+// RevokeOtherSessions signs the current user out of every session except
+// the one the request was made with. /auth/logout-all signs them out of
+// that one as well.
+func RevokeOtherSessions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := middleware.GetClaimsFromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		revoked, err := models.RevokeOtherUserSessions(db, claims.UserID, claims.SessionID)
+		if err != nil {
+			log.Printf("Error revoking sessions for user %s: %v", claims.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Other sessions revoked", "revoked": revoked})
+	}
+}
+
+// managedUser loads the user in the path for an admin. Super admins can
+// manage anyone; other admins only users of their current tenant. It
+// responds and returns false if the user cannot be managed.
+func managedUser(c *gin.Context, db *sql.DB) (*models.User, bool) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil, false
+	}
+
+	currentUser, ok := contextUser(c)
+	if !ok {
+		return nil, false
+	}
+
+	user, err := models.GetUser(db, userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user"})
+		return nil, false
+	}
+
+	if !currentUser.IsSuperAdmin && user.TenantID != currentUser.TenantID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return nil, false
+	}
+
+	return user, true
+}
+
+// GetUserSessions lists the sessions a user is signed in with
+func GetUserSessions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := managedUser(c, db)
+		if !ok {
+			return
+		}
+		sessionsResponse(c, db, user.ID)
+	}
+}
+
+// RevokeUserSession signs a user out of one of their sessions
+func RevokeUserSession(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := managedUser(c, db)
+		if !ok {
+			return
+		}
+		revokeSession(c, db, user.ID)
+	}
+}
+
+// RevokeUserSessions signs a user out of every session
+func RevokeUserSessions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := managedUser(c, db)
+		if !ok {
+			return
+		}
+
+		if err := tokens.RevokeAllForUser(db, user.ID); err != nil {
+			log.Printf("Error revoking sessions for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+			return
+		}
+
+		log.Printf("All sessions of user %s revoked", user.Username)
+		c.JSON(http.StatusOK, gin.H{"message": "All sessions revoked"})
+	}
+}
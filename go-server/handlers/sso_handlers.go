@@ -0,0 +1,509 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-server/auth"
+	"go-server/casdoor"
+	"go-server/models"
+	"go-server/oidc"
+	"go-server/tokens"
+)
+
+// ssoLoginCookie holds the PKCE verifier that binds a login to the browser that started it
+const ssoLoginCookie = "sso_login"
+
+// mfaTokenCookie hands the mfa_pending token of an SSO login that needs a
+// second factor to the client, which completes it at /auth/mfa/verify
+const mfaTokenCookie = "mfa_token"
+
+// providerCacheTTL is how long a connection's discovered provider is reused
+// before its issuer is discovered again
+const providerCacheTTL = time.Hour
+
+// SSOConfig configures the SSO login handlers
+type SSOConfig struct {
+	Casdoor casdoor.Settings
+	// CasdoorVerifier verifies Casdoor access tokens. Casdoor logins are
+	// disabled without it; tenant OIDC connections still work.
+	CasdoorVerifier *casdoor.Verifier
+	// StateSecret signs login state and must be shared by all replicas
+	StateSecret []byte
+}
+
+// SSO serves browser logins through Casdoor and through tenants' own OpenID
+// Connect providers
+type SSO struct {
+	db              *sql.DB
+	flow            *oidc.LoginFlow
+	casdoor         casdoor.Settings
+	casdoorVerifier *casdoor.Verifier
+
+	mu        sync.Mutex
+	providers map[int]cachedProvider
+}
+
+// cachedProvider is a discovered provider for a connection as it was configured
+type cachedProvider struct {
+	provider     *oidc.Provider
+	updatedAt    time.Time
+	discoveredAt time.Time
+}
+
+// NewSSO creates the SSO login handlers
+func NewSSO(db *sql.DB, config SSOConfig) (*SSO, error) {
+	flow, err := oidc.NewLoginFlow(oidc.LoginConfig{
+		StateSecret: config.StateSecret,
+		States: oidc.StateStoreFunc(func(ctx context.Context, id string, expiresAt time.Time) error {
+			return models.ConsumeOAuthState(db, id, expiresAt)
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SSO{
+		db:              db,
+		flow:            flow,
+		casdoor:         config.Casdoor,
+		casdoorVerifier: config.CasdoorVerifier,
+		providers:       map[int]cachedProvider{},
+	}, nil
+}
+
+// CasdoorLogin starts a login through Casdoor for the tenant named by the
+// "tenant" query parameter, or the default tenant
+func (s *SSO) CasdoorLogin(c *gin.Context) {
+	if s.casdoorVerifier == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Casdoor login is not configured"})
+		return
+	}
+
+	tenantName := c.Query("tenant")
+	if tenantName == "" {
+		tenantName = "default"
+	}
+	tenant, err := models.GetTenantByName(s.db, tenantName)
+	if err != nil {
+		log.Printf("[AUTH] Failed to look up tenant %s: %v", tenantName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up tenant"})
+		return
+	}
+	if tenant == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown tenant"})
+		return
+	}
+
+	s.beginLogin(c, s.casdoor.Client(), 0, tenant.ID)
+}
+
+// ConnectionLogin starts a login through one of a tenant's OIDC connections
+func (s *SSO) ConnectionLogin(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("connectionId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid connection ID"})
+		return
+	}
+
+	conn, err := models.GetOIDCConnection(s.db, id)
+	if err != nil {
+		log.Printf("[AUTH] Failed to get OIDC connection %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get connection"})
+		return
+	}
+	if conn == nil || !conn.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Connection not found"})
+		return
+	}
+
+	provider, err := s.provider(c.Request.Context(), conn)
+	if err != nil {
+		log.Printf("[AUTH] Failed to discover issuer %s for connection %d: %v", conn.Issuer, conn.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	s.beginLogin(c, provider.Client(), conn.ID, conn.TenantID)
+}
+
+// beginLogin redirects the browser to the identity provider, bound to it by
+// a cookie holding the PKCE verifier
+func (s *SSO) beginLogin(c *gin.Context, client oidc.Client, connectionID int, tenantID int) {
+	redirectTo, ok := loginRedirectTarget(c, s.db, tenantID)
+	if !ok {
+		return
+	}
+
+	authURL, verifier, err := s.flow.Begin(client, oidc.LoginRequest{
+		CallbackURL:  ssoCallbackURL(c),
+		RedirectTo:   redirectTo,
+		TenantID:     tenantID,
+		ConnectionID: connectionID,
+	})
+	if err != nil {
+		log.Printf("[AUTH] Failed to start login: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	// Lax so the cookie comes back on the top-level redirect from the provider
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoLoginCookie, verifier, int(s.flow.StateTTL().Seconds()), "/api/auth/callback", "", secure, true)
+
+	log.Printf("[AUTH] Redirecting to identity provider: %s", authURL)
+	c.Redirect(http.StatusTemporaryRedirect, authURL)
+}
+
+// loginRedirectTarget reads the redirect_uri a login should return to, and
+// rejects the request unless the tenant allows it
+func loginRedirectTarget(c *gin.Context, db *sql.DB, tenantID int) (string, bool) {
+	redirectTo := c.Query("redirect_uri")
+	if redirectTo == "" {
+		return "", true
+	}
+
+	allowed, err := auth.RedirectAllowed(db, tenantID, redirectTo)
+	if err != nil {
+		log.Printf("[AUTH] Failed to check redirect URI: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check redirect URI"})
+		return "", false
+	}
+	if !allowed {
+		log.Printf("[AUTH] Rejected redirect URI not allowed for tenant %d: %s", tenantID, redirectTo)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "redirect_uri is not allowed"})
+		return "", false
+	}
+
+	return redirectTo, true
+}
+
+// Callback completes a login started by CasdoorLogin or ConnectionLogin and
+// issues our own session for the user
+func (s *SSO) Callback(c *gin.Context) {
+	code := c.Query("code")
+	state := c.Query("state")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No authorization code provided"})
+		return
+	}
+
+	// The verifier cookie is single use whatever the outcome
+	verifier, _ := c.Cookie(ssoLoginCookie)
+	c.SetCookie(ssoLoginCookie, "", -1, "/api/auth/callback", "", false, true)
+
+	// Check the state was issued by us to this browser and not used before
+	loginState, err := s.flow.Verify(c.Request.Context(), verifier, state)
+	if err != nil {
+		log.Printf("[AUTH] Login callback rejected: %v", err)
+		if errors.Is(err, oidc.ErrInvalidState) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login state"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login state"})
+		return
+	}
+
+	var identity *auth.ExternalIdentity
+	if loginState.ConnectionID == 0 {
+		identity, err = s.completeCasdoorLogin(c, loginState, verifier, code)
+	} else {
+		identity, err = s.completeConnectionLogin(c, loginState, verifier, code)
+	}
+	if err != nil {
+		log.Printf("[AUTH] Failed to verify identity: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify identity"})
+		return
+	}
+
+	completeSSOLogin(c, s.db, *identity, loginState.RedirectTo)
+}
+
+// completeSSOLogin provisions the local user for a verified external identity,
+// issues our own session for it and redirects to the target chosen when the
+// login started, as long as the user's tenant allows it. Users who need a
+// second factor are redirected with an mfa_pending token instead.
+func completeSSOLogin(c *gin.Context, db *sql.DB, identity auth.ExternalIdentity, redirectTo string) {
+	user, err := auth.ProvisionUser(db, identity)
+	if err != nil {
+		log.Printf("[AUTH] Failed to provision user for %s: %v", identity.Subject, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to provision user"})
+		return
+	}
+
+	if !user.IsActive {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User account is inactive"})
+		return
+	}
+
+	// The tenant's MFA policy applies however the user signs in
+	required, enrolled, ok := loginRequiresMFA(c, db, user)
+	if !ok {
+		return
+	}
+	if required {
+		mfaToken, mfaClaims, ok := openMFAChallenge(c, db, user)
+		if !ok {
+			return
+		}
+
+		status := "mfa_required"
+		if !enrolled {
+			status = "mfa_enrollment_required"
+		}
+		maxAge := int(time.Until(mfaClaims.ExpiresAt.Time) / time.Second)
+		secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(mfaTokenCookie, mfaToken, maxAge, "/", "", secure, false)
+		c.SetCookie("auth_status", status, maxAge, "/", "", false, false)
+		log.Printf("[AUTH] Second factor required for user %s in tenant %d", user.Username, user.TenantID)
+
+		c.Redirect(http.StatusFound, ssoRedirectTarget(db, user.TenantID, redirectTo))
+		return
+	}
+
+	roles, err := models.GetUserRolesByUserID(db, user.ID, &user.TenantID)
+	if err != nil {
+		log.Printf("[AUTH] Error getting roles for user %s: %v", user.Username, err)
+		roles = []models.Role{}
+	}
+
+	accessToken, claims, err := tokens.IssueForUser(user, roles)
+	if err != nil {
+		log.Printf("[AUTH] Error generating token for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
+		return
+	}
+
+	refreshToken, err := tokens.IssueRefreshToken(db, claims)
+	if err != nil {
+		log.Printf("[AUTH] Error generating refresh token for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
+		return
+	}
+	startSession(c, db, claims)
+
+	SetSessionCookies(c, accessToken, refreshToken)
+	log.Printf("[AUTH] Issued session for user %s in tenant %d", user.Username, user.TenantID)
+
+	// Let the client know the login succeeded
+	c.SetCookie("auth_status", "success", 3600, "/", "", false, false)
+
+	c.Redirect(http.StatusFound, ssoRedirectTarget(db, user.TenantID, redirectTo))
+}
+
+// ssoRedirectTarget returns where to send the browser after an SSO login:
+// the target chosen when the login started if the tenant allows it, or /
+func ssoRedirectTarget(db *sql.DB, tenantID int, redirectTo string) string {
+	if redirectTo == "" {
+		return "/"
+	}
+
+	allowed, err := auth.RedirectAllowed(db, tenantID, redirectTo)
+	if err != nil {
+		log.Printf("[AUTH] Failed to check redirect URI: %v", err)
+		return "/"
+	}
+	if !allowed {
+		log.Printf("[AUTH] Redirect URI not allowed for tenant %d, using default: %s", tenantID, redirectTo)
+		return "/"
+	}
+	return redirectTo
+}
+
+// completeCasdoorLogin exchanges the code with Casdoor and verifies the access token
+func (s *SSO) completeCasdoorLogin(c *gin.Context, loginState *oidc.LoginState, verifier string, code string) (*auth.ExternalIdentity, error) {
+	if s.casdoorVerifier == nil {
+		return nil, errors.New("casdoor login is not configured")
+	}
+
+	ctx := c.Request.Context()
+	token, err := s.flow.Exchange(ctx, s.casdoor.Client(), loginState, verifier, code)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never trust the token without verifying its signature and claims
+	user, err := s.casdoorVerifier.Verify(ctx, token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// The client checks for this cookie after a Casdoor login
+	c.SetCookie("casdoor_token", token.AccessToken, 3600, "/", "", false, true)
+
+	subject := user.Subject
+	if subject == "" {
+		subject = user.ID
+	}
+	return &auth.ExternalIdentity{
+		Subject:      subject,
+		Organization: user.Owner,
+		Username:     user.Name,
+		DisplayName:  user.DisplayName,
+		Email:        user.Email,
+		Avatar:       user.Avatar,
+		Roles:        user.RoleNames(),
+		Groups:       user.Groups,
+	}, nil
+}
+
+// completeConnectionLogin exchanges the code with a tenant's provider and
+// verifies the ID token
+func (s *SSO) completeConnectionLogin(c *gin.Context, loginState *oidc.LoginState, verifier string, code string) (*auth.ExternalIdentity, error) {
+	conn, err := models.GetOIDCConnection(s.db, loginState.ConnectionID)
+	if err != nil {
+		return nil, err
+	}
+	if conn == nil || !conn.Enabled || conn.TenantID != loginState.TenantID {
+		return nil, fmt.Errorf("connection %d is no longer available", loginState.ConnectionID)
+	}
+
+	tenant, err := models.GetTenantByID(s.db, conn.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if tenant == nil {
+		return nil, fmt.Errorf("tenant %d not found", conn.TenantID)
+	}
+
+	ctx := c.Request.Context()
+	provider, err := s.provider(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.flow.Exchange(ctx, provider.Client(), loginState, verifier, code)
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, err := oidc.IDToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := provider.VerifyIDToken(ctx, rawIDToken, loginState.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	mapped := oidc.MapClaims(claims, conn.ClaimMappings)
+	if mapped.Subject == "" {
+		return nil, errors.New("mapped subject claim is empty")
+	}
+
+	// Users of a connection always belong to its tenant
+	return &auth.ExternalIdentity{
+		ConnectionID: conn.ID,
+		Subject:      mapped.Subject,
+		Organization: tenant.Name,
+		Username:     mapped.Username,
+		DisplayName:  mapped.DisplayName,
+		Email:        mapped.Email,
+		Avatar:       mapped.Avatar,
+		Roles:        mapped.Roles,
+		Groups:       mapped.Groups,
+	}, nil
+}
+
+// HomeRealm finds the OIDC connection that users with an email address sign
+// in through, so the login page can send them straight to their provider
+func (s *SSO) HomeRealm(c *gin.Context) {
+	email := strings.TrimSpace(c.Query("email"))
+	at := strings.LastIndex(email, "@")
+	if at < 0 || at == len(email)-1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A valid email address is required"})
+		return
+	}
+
+	conn, err := models.GetOIDCConnectionByDomain(s.db, email[at+1:])
+	if err != nil {
+		log.Printf("[AUTH] Failed to look up connection for %s: %v", email[at+1:], err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up connection"})
+		return
+	}
+	if conn == nil {
+		c.JSON(http.StatusOK, gin.H{"connection": nil})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"connection": gin.H{
+			"id":   conn.ID,
+			"name": conn.Name,
+		},
+		"loginUrl": fmt.Sprintf("/api/auth/oidc/%d/login", conn.ID),
+	})
+}
+
+// provider returns the discovered provider for a connection, discovering it
+// again when the connection has changed or the cached discovery is stale
+func (s *SSO) provider(ctx context.Context, conn *models.OIDCConnection) (*oidc.Provider, error) {
+	s.mu.Lock()
+	cached, ok := s.providers[conn.ID]
+	s.mu.Unlock()
+	if ok && cached.updatedAt.Equal(conn.UpdatedAt) && time.Since(cached.discoveredAt) < providerCacheTTL {
+		return cached.provider, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, oidc.ProviderConfig{
+		Issuer:       conn.Issuer,
+		ClientID:     conn.ClientID,
+		ClientSecret: conn.ClientSecret,
+		Scopes:       conn.Scopes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.providers[conn.ID] = cachedProvider{
+		provider:     provider,
+		updatedAt:    conn.UpdatedAt,
+		discoveredAt: time.Now(),
+	}
+	s.mu.Unlock()
+
+	return provider, nil
+}
+
+// ssoCallbackURL is where the identity provider sends the browser back to
+func ssoCallbackURL(c *gin.Context) string {
+	// An explicit callback URL set by the Express proxy wins
+	if callbackURL := c.GetHeader("X-Replit-Callback-URL"); callbackURL != "" {
+		return callbackURL
+	}
+	return publicBaseURL(c) + "/api/auth/callback"
+}
+
+// publicBaseURL is the scheme and host browsers and identity providers reach
+// us at. Behind the Replit proxy the public host differs from the request's.
+func publicBaseURL(c *gin.Context) string {
+	if baseURL := os.Getenv("PUBLIC_BASE_URL"); baseURL != "" {
+		return strings.TrimRight(baseURL, "/")
+	}
+
+	if replitDomains := os.Getenv("REPLIT_DOMAINS"); replitDomains != "" {
+		return fmt.Sprintf("https://%s", replitDomains)
+	}
+
+	if strings.Contains(c.Request.Host, "replit") || strings.Contains(c.Request.Host, ".repl.co") {
+		return fmt.Sprintf("https://%s", c.Request.Host)
+	}
+
+	// Local development
+	return "http://localhost:5000"
+}
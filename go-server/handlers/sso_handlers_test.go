@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-server/auth"
+	"go-server/middleware"
+	"go-server/models"
+	"go-server/sqltest"
+	"go-server/tokens"
+)
+
+// newSSOLoginStore answers the statements run to sign in a Casdoor user who
+// holds the admin role in tenant 3, whose MFA policy requires it for the
+// given roles. If enrolled, the user has confirmed a TOTP enrolment.
+func newSSOLoginStore(t *testing.T, user *models.User, requiredRoles string, enrolled bool) *sqltest.DB {
+	db := sqltest.Open(t)
+	now := time.Now()
+
+	userRow := func([]driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row(userColumns,
+			int64(user.ID), user.Username, "", user.Email, user.DisplayName,
+			nil, int64(user.TenantID), user.IsActive, false,
+			nil, "casdoor-alice", user.CreatedAt, user.UpdatedAt,
+			int64(0), nil, false,
+			*user.EmailVerifiedAt, false,
+		), nil
+	}
+	db.Handle(`^SELECT id, username, password, .* FROM users WHERE casdoor_id = \$1`, userRow)
+	db.Handle(`^SELECT id, username, password, .* FROM users WHERE id = \$1`, userRow)
+	db.Handle(`^SELECT id, name, display_name, .* FROM tenants WHERE`, func([]driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row([]string{"id", "name", "display_name", "description", "created_at", "updated_at"},
+			int64(user.TenantID), "acme", "Acme", "", now, now), nil
+	})
+	db.Handle(`^SELECT r.id, r.name, .* FROM roles r`, func([]driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row([]string{"id", "name", "display_name", "description", "tenant_id", "parent_role_id", "created_at", "updated_at"},
+			int64(10), "admin", "Admin", "", int64(user.TenantID), nil, now, now), nil
+	})
+	db.Ignore(`^UPDATE users SET last_login = NOW\(\)`)
+
+	db.Handle(`^SELECT user_id, secret, confirmed_at, .* FROM user_mfa WHERE user_id = \$1`, func([]driver.Value) (*sqltest.Result, error) {
+		result := &sqltest.Result{Columns: []string{"user_id", "secret", "confirmed_at", "last_used_step", "created_at", "updated_at"}}
+		if enrolled {
+			result.Rows = append(result.Rows, []driver.Value{int64(user.ID), "secret", now, int64(0), now, now})
+		}
+		return result, nil
+	})
+	db.Handle(`^SELECT required_roles, updated_at FROM tenant_mfa_policies WHERE tenant_id = \$1`, func([]driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row([]string{"required_roles", "updated_at"}, requiredRoles, now), nil
+	})
+	db.Ignore(`mfa_challenges`)
+
+	db.Handle(`^INSERT INTO refresh_tokens`, func([]driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row([]string{"id"}, int64(1)), nil
+	})
+	db.Ignore(`^INSERT INTO user_sessions`)
+
+	return db
+}
+
+// completeTestSSOLogin completes a Casdoor login for the user and returns the
+// cookies set by the response
+func completeTestSSOLogin(t *testing.T, db *sqltest.DB, user *models.User) map[string]string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/callback", func(c *gin.Context) {
+		completeSSOLogin(c, db.DB, auth.ExternalIdentity{
+			Subject:      "casdoor-alice",
+			Organization: "acme",
+			Username:     user.Username,
+			DisplayName:  user.DisplayName,
+			Email:        user.Email,
+		}, "")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/callback", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("responded with %d, want %d: %s", w.Code, http.StatusFound, w.Body.String())
+	}
+
+	cookies := map[string]string{}
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+	return cookies
+}
+
+func TestSSOLoginRequiresMFA(t *testing.T) {
+	initTestKeyring(t)
+
+	tests := []struct {
+		name          string
+		requiredRoles string
+		enrolled      bool
+		wantStatus    string
+	}{
+		{"enrolled user", "{}", true, "mfa_required"},
+		{"admin the policy covers", "{admin}", false, "mfa_enrollment_required"},
+		{"user the policy does not cover", "{owner}", false, "success"},
+	}
+	for _, test := range tests {
+		user := newPasswordTestUser(t, "Unused-password-1234")
+		db := newSSOLoginStore(t, user, test.requiredRoles, test.enrolled)
+		cookies := completeTestSSOLogin(t, db, user)
+
+		if status := cookies["auth_status"]; status != test.wantStatus {
+			t.Errorf("%s: got auth_status %q, want %q", test.name, status, test.wantStatus)
+		}
+
+		_, session := cookies[middleware.AccessTokenCookie]
+		if session != (test.wantStatus == "success") {
+			t.Errorf("%s: issued a session: %v", test.name, session)
+		}
+		challenges := db.Statements(`^INSERT INTO mfa_challenges`)
+		if opened := len(challenges) == 1; opened != (test.wantStatus != "success") {
+			t.Errorf("%s: opened %d MFA challenges", test.name, len(challenges))
+		}
+
+		// The login is finished at /auth/mfa/verify with the pending token
+		mfaToken, ok := cookies[mfaTokenCookie]
+		if ok != (test.wantStatus != "success") {
+			t.Errorf("%s: set an MFA token cookie: %v", test.name, ok)
+		}
+		if ok {
+			if _, err := tokens.ParseMFAPending(mfaToken); err != nil {
+				t.Errorf("%s: MFA token cookie: %v", test.name, err)
+			}
+		}
+	}
+}
@@ -15,7 +15,7 @@ import (
 func GetTenants(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get tenants
-		tenants, err := models.GetAllTenants(db)
+		tenants, err := models.ListTenants(db)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tenants: " + err.Error()})
 			return
@@ -191,4 +191,81 @@ func GetTenantUserCounts(db *sql.DB) gin.HandlerFunc {
 
 		c.JSON(http.StatusOK, gin.H{"counts": counts})
 	}
-}
\ No newline at end of file
+}
+// GetTenantRedirectURIs lists the redirect targets a tenant allows after login
+func GetTenantRedirectURIs(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		uris, err := models.ListTenantRedirectURIs(db, tenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get redirect URIs: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"redirectUris": uris})
+	}
+}
+
+// CreateTenantRedirectURI allows a redirect target for a tenant
+func CreateTenantRedirectURI(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		var input models.CreateTenantRedirectURIInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := models.ValidateRedirectURIPattern(input.URI); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		uri, err := models.CreateTenantRedirectURI(db, tenantID, input)
+		if err != nil {
+			if err.Error() == "redirect URI already exists" {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create redirect URI: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"redirectUri": uri})
+	}
+}
+
+// DeleteTenantRedirectURI removes an allowed redirect target from a tenant
+func DeleteTenantRedirectURI(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := managedTenantID(c)
+		if !ok {
+			return
+		}
+
+		uriID, err := strconv.Atoi(c.Param("uriId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid redirect URI ID"})
+			return
+		}
+
+		if err := models.DeleteTenantRedirectURI(db, tenantID, uriID); err != nil {
+			if err.Error() == "redirect URI not found" {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Redirect URI not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete redirect URI: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Redirect URI deleted successfully"})
+	}
+}
@@ -7,13 +7,19 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"go-server/models"
 	"go-server/middleware"
+	"go-server/models"
 )
 
-// ListUsers returns a list of all users
+// ListUsers returns a list of users. Super admins can list every tenant's
+// users; other users only those of their current tenant.
 func ListUsers(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		currentUser, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
 		// Get tenant query parameter
 		tenantIDStr := c.Query("tenantId")
 		var tenantID *int
@@ -26,8 +32,16 @@ func ListUsers(db *sql.DB) gin.HandlerFunc {
 			tenantID = &id
 		}
 
+		if !currentUser.IsSuperAdmin {
+			if tenantID != nil && *tenantID != currentUser.TenantID {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+				return
+			}
+			tenantID = &currentUser.TenantID
+		}
+
 		// Get users from database
-		users, err := models.GetUsers(db, tenantID)
+		users, err := models.ListUsers(db, tenantID)
 		if err != nil {
 			log.Printf("Error getting users: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting users"})
@@ -39,21 +53,12 @@ func ListUsers(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
-// GetUser returns a single user by ID
+// GetUser returns a single user by ID, if they belong to the current tenant
+// or the caller is a super admin
 func GetUser(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get user ID from path parameter
-		userID, err := strconv.Atoi(c.Param("id"))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-			return
-		}
-
-		// Get user from database
-		user, err := models.GetUserByID(userID)
-		if err != nil {
-			log.Printf("Error getting user: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user"})
+		user, ok := managedUser(c, db)
+		if !ok {
 			return
 		}
 
@@ -62,29 +67,60 @@ func GetUser(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
-// CreateUser creates a new user
+// CreateUser creates a new user. Only super admins can create super admins
+// or users of another tenant; other users are created in the current tenant.
 func CreateUser(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		currentUser, ok := contextUser(c)
+		if !ok {
+			return
+		}
+
 		// Parse request body
-		var user models.User
-		if err := c.ShouldBindJSON(&user); err != nil {
+		var input models.CreateUserInput
+		if err := c.ShouldBindJSON(&input); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Validate required fields
-		if user.Username == "" || user.Password == "" || user.Email == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Username, password, and email are required"})
+		if !currentUser.IsSuperAdmin {
+			if input.IsSuperAdmin {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Only super admins can create super admins"})
+				return
+			}
+			input.TenantID = currentUser.TenantID
+		}
+		if input.TenantID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Tenant ID is required"})
 			return
 		}
 
 		// Check if username already exists
-		existingUser, err := models.GetUserByUsername(user.Username)
+		existingUser, err := models.GetUserByUsername(db, input.Username)
 		if err == nil && existingUser != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Username already exists"})
 			return
 		}
 
+		if !checkNewPassword(c, db, input.TenantID, nil, input.Password) {
+			return
+		}
+
+		user := models.User{
+			Username:           input.Username,
+			Password:           input.Password,
+			Email:              input.Email,
+			DisplayName:        input.DisplayName,
+			Avatar:             input.Avatar,
+			TenantID:           input.TenantID,
+			IsActive:           true,
+			IsSuperAdmin:       input.IsSuperAdmin,
+			MustChangePassword: input.MustChangePassword,
+		}
+		if input.IsActive != nil {
+			user.IsActive = *input.IsActive
+		}
+
 		// Create user
 		createdUser, err := models.CreateUser(db, &user)
 		if err != nil {
@@ -98,63 +134,88 @@ func CreateUser(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
-// UpdateUser updates an existing user
+// UpdateUser updates an existing user. Super admins can update anyone;
+// other users only users of their current tenant who are not super admins.
 func UpdateUser(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get user ID from path parameter
-		userID, err := strconv.Atoi(c.Param("id"))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-			return
-		}
-
-		// Get current user from context
-		currentUser, exists := c.Get("user")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			return
-		}
-		currentUserObj := currentUser.(*models.User)
-
-		// Only allow users to update their own account or super admins to update any account
-		if currentUserObj.ID != userID && !currentUserObj.IsSuperAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "You can only update your own account"})
+		existingUser, ok := managedUser(c, db)
+		if !ok {
 			return
 		}
+		userID := existingUser.ID
+		currentUserObj, _ := contextUser(c)
 
 		// Parse request body
-		var updateData models.User
+		var updateData models.UpdateUserInput
 		if err := c.ShouldBindJSON(&updateData); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Get existing user
-		existingUser, err := models.GetUserByID(userID)
-		if err != nil {
-			log.Printf("Error getting user: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user"})
+		// Users change their own password with their current one, from an
+		// interactive session
+		if updateData.Password != nil && currentUserObj.ID == userID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Change your own password with POST /api/auth/password"})
 			return
 		}
-		if existingUser == nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			return
+
+		if !currentUserObj.IsSuperAdmin {
+			// Super admins are only managed by other super admins
+			if existingUser.IsSuperAdmin {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+				return
+			}
+
+			if updateData.IsSuperAdmin != nil && *updateData.IsSuperAdmin != existingUser.IsSuperAdmin {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change super admin status"})
+				return
+			}
+
+			// Users stay in the tenant they were created in
+			if updateData.TenantID != nil && *updateData.TenantID != existingUser.TenantID {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You cannot move users to another tenant"})
+				return
+			}
+
+			// Nor can users lock themselves out, or back in
+			if updateData.IsActive != nil && currentUserObj.ID == userID && *updateData.IsActive != existingUser.IsActive {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You cannot activate or deactivate your own account"})
+				return
+			}
 		}
 
-		// Non-super admins cannot change their own super admin status
-		if !currentUserObj.IsSuperAdmin && currentUserObj.ID == userID && updateData.IsSuperAdmin != existingUser.IsSuperAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change your own admin status"})
+		// Only super admins can require or waive a password change
+		if !currentUserObj.IsSuperAdmin && updateData.MustChangePassword != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change whether a password change is required"})
 			return
 		}
 
+		if updateData.Password != nil {
+			tenantID := existingUser.TenantID
+			if updateData.TenantID != nil {
+				tenantID = *updateData.TenantID
+			}
+			if !checkNewPassword(c, db, tenantID, existingUser, *updateData.Password) {
+				return
+			}
+		}
+
 		// Update user
-		updatedUser, err := models.UpdateUser(db, userID, &updateData)
+		updatedUser, err := models.UpdateUser(db, userID, updateData)
 		if err != nil {
 			log.Printf("Error updating user: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating user"})
 			return
 		}
 
+		// A new address has to be verified
+		if updatedUser.Email != existingUser.Email {
+			if _, err := sendActionEmail(c, db, updatedUser, models.ActionEmailVerification); err != nil {
+				log.Printf("Error sending email verification for user %s: %v", updatedUser.Username, err)
+				// Not critical, the user can ask for another
+			}
+		}
+
 		// Return updated user
 		c.JSON(http.StatusOK, updatedUser)
 	}
@@ -202,188 +263,86 @@ func DeleteUser(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
-// GetCurrentUser returns the currently authenticated user
-func GetCurrentUser(db *sql.DB) gin.HandlerFunc {
+// GetUserRoles returns the roles for a user
+func GetUserRoles(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get user from context (set by AuthRequired middleware)
-		userValue, exists := c.Get("user")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		// Get user ID from path parameter
+		userID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 			return
 		}
 
-		user, ok := userValue.(*models.User)
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user in context"})
-			return
+		// Get tenant query parameter
+		tenantIDStr := c.Query("tenantId")
+		var tenantID *int
+		if tenantIDStr != "" {
+			id, err := strconv.Atoi(tenantIDStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+				return
+			}
+			tenantID = &id
 		}
 
-		// Get tenant from context
-		tenantValue, exists := c.Get("tenant")
-		tenant, ok := tenantValue.(*models.Tenant)
-		
-		// Get user roles
-		roles, err := models.GetUserRoles(user.ID)
+		// Get user roles, filtered by tenant if specified
+		roles, err := models.GetUserRolesByUserID(db, userID, tenantID)
 		if err != nil {
 			log.Printf("Error getting user roles: %v", err)
-			// Not fatal, just log it
-		}
-
-		// Map roles to role names
-		roleNames := make([]string, 0, len(roles))
-		for _, role := range roles {
-			roleNames = append(roleNames, role.Name)
-		}
-
-		// Build response
-		response := gin.H{
-			"user":  user,
-			"roles": roleNames,
-		}
-
-		if exists && ok && tenant != nil {
-			response["tenant"] = tenant
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user roles"})
+			return
 		}
 
-		// Return user
-		c.JSON(http.StatusOK, response)
+		// Return roles
+		c.JSON(http.StatusOK, roles)
 	}
 }
 
-// Logout logs out the current user
-func Logout(c *gin.Context) {
-	// Not much to do for token-based auth, just return success
-	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
-}
-
-// SwitchTenant switches the current user's tenant
-func SwitchTenant(db *sql.DB) gin.HandlerFunc {
+// UnlockUser clears a user's login lockout and failed login count. Super
+// admins can unlock anyone; other admins only users of their current tenant.
+func UnlockUser(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get user from context
-		userValue, exists := c.Get("user")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-			return
-		}
-
-		user, ok := userValue.(*models.User)
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user in context"})
-			return
-		}
-
-		// Parse request body
-		var requestBody struct {
-			TenantID int `json:"tenantId" binding:"required"`
-		}
-		if err := c.ShouldBindJSON(&requestBody); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		// Validate tenant exists
-		tenant, err := models.GetTenantByID(requestBody.TenantID)
+		// Get user ID from path parameter
+		userID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			log.Printf("Error getting tenant: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting tenant"})
-			return
-		}
-		if tenant == nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
-			return
-		}
-
-		// Check if user belongs to tenant
-		if user.TenantID != tenant.ID && !user.IsSuperAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "You do not belong to this tenant"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 			return
 		}
 
-		// Update user's tenant
-		updateData := models.User{
-			TenantID: tenant.ID,
-		}
-		updatedUser, err := models.UpdateUser(db, user.ID, &updateData)
-		if err != nil {
-			log.Printf("Error updating user tenant: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating user tenant"})
+		// Get current user from context
+		currentUser, exists := c.Get("user")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			return
 		}
+		currentUserObj := currentUser.(*models.User)
 
-		// Generate new token with updated tenant
-		token, err := middleware.GenerateToken(updatedUser)
-		if err != nil {
-			log.Printf("Error generating token: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		user, err := models.GetUser(db, userID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
-
-		// Get user roles
-		roles, err := models.GetUserRoles(updatedUser.ID)
 		if err != nil {
-			log.Printf("Error getting user roles: %v", err)
-			// Not fatal, just log it
-		}
-
-		// Map roles to role names
-		roleNames := make([]string, 0, len(roles))
-		for _, role := range roles {
-			roleNames = append(roleNames, role.Name)
-		}
-
-		// Return success
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Tenant switched successfully",
-			"user":    updatedUser,
-			"tenant":  tenant,
-			"roles":   roleNames,
-			"token":   token,
-		})
-	}
-}
-
-// GetUserRoles returns the roles for a user
-func GetUserRoles(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Get user ID from path parameter
-		userID, err := strconv.Atoi(c.Param("userId"))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			log.Printf("Error getting user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user"})
 			return
 		}
 
-		// Get tenant query parameter
-		tenantIDStr := c.Query("tenantId")
-		var tenantID *int
-		if tenantIDStr != "" {
-			id, err := strconv.Atoi(tenantIDStr)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+		if !currentUserObj.IsSuperAdmin {
+			tenantID, ok := middleware.GetTenantIDFromContext(c)
+			if !ok || user.TenantID != tenantID {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
 				return
 			}
-			tenantID = &id
 		}
 
-		// Get user roles
-		roles, err := models.GetUserRoles(userID)
-		if err != nil {
-			log.Printf("Error getting user roles: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting user roles"})
+		if err := models.UnlockUser(db, userID); err != nil {
+			log.Printf("Error unlocking user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unlocking user"})
 			return
 		}
 
-		// Filter roles by tenant if specified
-		if tenantID != nil {
-			filteredRoles := make([]*models.Role, 0)
-			for _, role := range roles {
-				if role.TenantID == *tenantID {
-					filteredRoles = append(filteredRoles, role)
-				}
-			}
-			roles = filteredRoles
-		}
-
-		// Return roles
-		c.JSON(http.StatusOK, roles)
+		log.Printf("User %s unlocked by %s", user.Username, currentUserObj.Username)
+		c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
 	}
 }
\ No newline at end of file
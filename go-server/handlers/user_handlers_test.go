@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-server/models"
+	"go-server/sqltest"
+)
+
+// newUserStore answers the statements the user handlers run for the given
+// users, and stores the users they create
+func newUserStore(t *testing.T, users ...*models.User) *sqltest.DB {
+	db := sqltest.Open(t)
+	userRow := func(user *models.User) []driver.Value {
+		return []driver.Value{
+			int64(user.ID), user.Username, "", user.Email, user.DisplayName,
+			nil, int64(user.TenantID), user.IsActive, user.IsSuperAdmin,
+			nil, nil, user.CreatedAt, user.UpdatedAt,
+			int64(0), nil, false,
+			nil, false,
+		}
+	}
+
+	db.Handle(`^SELECT id, username, password, .* FROM users WHERE id = \$1`, func(args []driver.Value) (*sqltest.Result, error) {
+		result := &sqltest.Result{Columns: userColumns}
+		for _, user := range users {
+			if int64(user.ID) == args[0].(int64) {
+				result.Rows = append(result.Rows, userRow(user))
+			}
+		}
+		return result, nil
+	})
+	db.Handle(`^SELECT id, username, password, .* FROM users (WHERE tenant_id = \$1 )?ORDER BY username$`, func(args []driver.Value) (*sqltest.Result, error) {
+		result := &sqltest.Result{Columns: userColumns}
+		for _, user := range users {
+			if len(args) == 0 || int64(user.TenantID) == args[0].(int64) {
+				result.Rows = append(result.Rows, userRow(user))
+			}
+		}
+		return result, nil
+	})
+
+	db.Ignore(`^SELECT id, username, password, .* FROM users WHERE username = \$1`)
+	db.Handle(`^SELECT COUNT\(\*\) FROM users WHERE`, func([]driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row([]string{"count"}, int64(0)), nil
+	})
+	db.Ignore(`^SELECT min_length, .* FROM tenant_password_policies`)
+	db.Ignore(`password_history`)
+	db.Handle(`^INSERT INTO users`, func(args []driver.Value) (*sqltest.Result, error) {
+		now := time.Now()
+		user := &models.User{
+			ID: len(users) + 100, Username: args[0].(string), Email: args[2].(string), DisplayName: args[3].(string),
+			TenantID: int(args[5].(int64)), IsActive: args[6].(bool), IsSuperAdmin: args[7].(bool), CreatedAt: now, UpdatedAt: now,
+		}
+		users = append(users, user)
+		return sqltest.Row([]string{"id"}, int64(user.ID)), nil
+	})
+	db.Handle(`^UPDATE users SET`, func([]driver.Value) (*sqltest.Result, error) {
+		return sqltest.Affected(1), nil
+	})
+	db.Ignore(`^INSERT INTO user_token_revocations`)
+	db.Ignore(`^UPDATE (refresh_tokens|user_sessions) SET revoked_at`)
+
+	return db
+}
+
+// newUserTestRouter mounts the user handlers for requests made by caller
+func newUserTestRouter(db *sqltest.DB, caller *models.User) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user", caller)
+		c.Set("tenantId", caller.TenantID)
+		c.Set("isSuperAdmin", caller.IsSuperAdmin)
+	})
+	router.GET("/users", ListUsers(db.DB))
+	router.GET("/users/:id", GetUser(db.DB))
+	router.POST("/users", CreateUser(db.DB))
+	router.PUT("/users/:id", UpdateUser(db.DB))
+	return router
+}
+
+// newTenantUsers returns an admin of tenant 3, another user of tenant 3, a
+// user of tenant 4 and a super admin whose home tenant is 3
+func newTenantUsers() (admin, colleague, outsider, superAdmin *models.User) {
+	now := time.Now()
+	newUser := func(id, tenantID int, isSuperAdmin bool) *models.User {
+		return &models.User{
+			ID: id, Username: "user", Email: "user@example.com", DisplayName: "User",
+			TenantID: tenantID, IsActive: true, IsSuperAdmin: isSuperAdmin, CreatedAt: now, UpdatedAt: now,
+		}
+	}
+	return newUser(7, 3, false), newUser(8, 3, false), newUser(9, 4, false), newUser(10, 3, true)
+}
+
+func TestListUsersOnlyListsCurrentTenant(t *testing.T) {
+	admin, colleague, outsider, superAdmin := newTenantUsers()
+	db := newUserStore(t, admin, colleague, outsider)
+
+	listed := func(caller *models.User, target string) []int {
+		t.Helper()
+		w := request(newUserTestRouter(db, caller), http.MethodGet, target, "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: got status %d, want %d: %s", target, w.Code, http.StatusOK, w.Body.String())
+		}
+		var users []models.User
+		if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+			t.Fatalf("GET %s: %v", target, err)
+		}
+		var ids []int
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+		return ids
+	}
+
+	if ids := listed(admin, "/users"); len(ids) != 2 || ids[0] != admin.ID || ids[1] != colleague.ID {
+		t.Errorf("tenant admin listed users %v, want %v", ids, []int{admin.ID, colleague.ID})
+	}
+	if ids := listed(superAdmin, "/users"); len(ids) != 3 {
+		t.Errorf("super admin listed users %v, want all three", ids)
+	}
+	if ids := listed(superAdmin, "/users?tenantId=4"); len(ids) != 1 || ids[0] != outsider.ID {
+		t.Errorf("super admin listed users %v of tenant 4, want %v", ids, []int{outsider.ID})
+	}
+
+	if w := request(newUserTestRouter(db, admin), http.MethodGet, "/users?tenantId=4", "", nil); w.Code != http.StatusForbidden {
+		t.Errorf("tenant admin listing tenant 4: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestGetUserOfAnotherTenant(t *testing.T) {
+	admin, colleague, outsider, superAdmin := newTenantUsers()
+	db := newUserStore(t, admin, colleague, outsider)
+
+	tests := []struct {
+		caller *models.User
+		target string
+		want   int
+	}{
+		{admin, "/users/8", http.StatusOK},
+		{admin, "/users/9", http.StatusForbidden},
+		{superAdmin, "/users/9", http.StatusOK},
+	}
+	for _, test := range tests {
+		if w := request(newUserTestRouter(db, test.caller), http.MethodGet, test.target, "", nil); w.Code != test.want {
+			t.Errorf("user %d: GET %s: got status %d, want %d", test.caller.ID, test.target, w.Code, test.want)
+		}
+	}
+}
+
+func TestCreateUserKeepsCallerTenant(t *testing.T) {
+	admin, _, _, superAdmin := newTenantUsers()
+	db := newUserStore(t, admin)
+	newUser := gin.H{
+		"username": "bob", "password": "Bobs-password-1234", "email": "bob@example.com",
+		"displayName": "Bob", "tenantId": 4,
+	}
+
+	w := request(newUserTestRouter(db, admin), http.MethodPost, "/users", "", newUser)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("tenant admin creating a user: got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var created models.User
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("tenant admin creating a user: %v", err)
+	}
+	if created.TenantID != admin.TenantID {
+		t.Errorf("tenant admin created a user of tenant %d, want %d", created.TenantID, admin.TenantID)
+	}
+
+	newUser["isSuperAdmin"] = true
+	if w := request(newUserTestRouter(db, admin), http.MethodPost, "/users", "", newUser); w.Code != http.StatusForbidden {
+		t.Errorf("tenant admin creating a super admin: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if inserts := db.Statements(`^INSERT INTO users`); len(inserts) != 1 {
+		t.Fatalf("got %d users created, want 1", len(inserts))
+	}
+
+	w = request(newUserTestRouter(db, superAdmin), http.MethodPost, "/users", "", newUser)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("super admin creating a super admin: got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("super admin creating a super admin: %v", err)
+	}
+	if created.TenantID != 4 || !created.IsSuperAdmin {
+		t.Errorf("super admin created %+v, want a super admin of tenant 4", created)
+	}
+}
+
+func TestUpdateUserRefusesUnmanagedChanges(t *testing.T) {
+	admin, colleague, outsider, superAdmin := newTenantUsers()
+	db := newUserStore(t, admin, colleague, outsider, superAdmin)
+
+	tests := []struct {
+		name   string
+		caller *models.User
+		target string
+		body   gin.H
+		want   int
+	}{
+		{"rename a colleague", admin, "/users/8", gin.H{"displayName": "Colleague"}, http.StatusOK},
+		{"reset a colleague's password", admin, "/users/8", gin.H{"password": "Reset-password-1234"}, http.StatusOK},
+		{"update a user of another tenant", admin, "/users/9", gin.H{"displayName": "Outsider"}, http.StatusForbidden},
+		{"deactivate a user of another tenant", admin, "/users/9", gin.H{"isActive": false}, http.StatusForbidden},
+		{"move a colleague to another tenant", admin, "/users/8", gin.H{"tenantId": 4}, http.StatusForbidden},
+		{"move themselves to another tenant", admin, "/users/7", gin.H{"tenantId": 4}, http.StatusForbidden},
+		{"deactivate themselves", admin, "/users/7", gin.H{"isActive": false}, http.StatusForbidden},
+		{"set their own password", admin, "/users/7", gin.H{"password": "Taken-over-1234"}, http.StatusForbidden},
+		{"update a super admin", admin, "/users/10", gin.H{"password": "Taken-over-1234"}, http.StatusForbidden},
+		{"make themselves super admin", admin, "/users/7", gin.H{"isSuperAdmin": true}, http.StatusForbidden},
+		{"super admin sets their own password", superAdmin, "/users/10", gin.H{"password": "Taken-over-1234"}, http.StatusForbidden},
+		{"super admin moves a user", superAdmin, "/users/9", gin.H{"tenantId": 3}, http.StatusOK},
+	}
+	for _, test := range tests {
+		before := len(db.Statements(`^UPDATE users SET`))
+		w := request(newUserTestRouter(db, test.caller), http.MethodPut, test.target, "", test.body)
+		if w.Code != test.want {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, w.Code, test.want, w.Body.String())
+		}
+		updated := len(db.Statements(`^UPDATE users SET`)) > before
+		if updated != (test.want == http.StatusOK) {
+			t.Errorf("%s: updated the user: %v", test.name, updated)
+		}
+	}
+}
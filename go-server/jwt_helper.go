@@ -1,78 +0,0 @@
-package main
-
-import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/json"
-	"fmt"
-	"log"
-	"strings"
-	"time"
-)
-
-// CustomJWTClaims represents the claims in the Casdoor JWT token
-type CustomJWTClaims struct {
-	Name   string `json:"name"`
-	Email  string `json:"email"`
-	Owner  string `json:"owner"`
-	UserId string `json:"userId"`
-	// Add other fields as needed
-	Subject   string `json:"sub"`
-	IssuedAt  int64  `json:"iat"`
-	ExpiresAt int64  `json:"exp"`
-}
-
-// ParseJWTWithHMAC parses a JWT token using HMAC-SHA256 verification
-// This is a simplified version that should work with the secret format from Casdoor
-func ParseJWTWithHMAC(tokenString string, secretKey string) (*CustomJWTClaims, error) {
-	// Split the token into parts
-	parts := strings.Split(tokenString, ".")
-	if len(parts) != 3 {
-		return nil, fmt.Errorf("invalid token format")
-	}
-
-	// Decode the header and payload
-	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode header: %v", err)
-	}
-
-	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode payload: %v", err)
-	}
-
-	// Parse the claims from the payload
-	var claims CustomJWTClaims
-	if err := json.Unmarshal(payloadBytes, &claims); err != nil {
-		return nil, fmt.Errorf("failed to parse claims: %v", err)
-	}
-
-	// Basic validation of the token's expiration
-	now := time.Now().Unix()
-	if claims.ExpiresAt < now {
-		return nil, fmt.Errorf("token is expired")
-	}
-
-	// Verify the signature
-	// Create the message to be signed (header.payload)
-	message := parts[0] + "." + parts[1]
-	
-	// Compute the HMAC
-	h := hmac.New(sha256.New, []byte(secretKey))
-	h.Write([]byte(message))
-	expectedSignature := base64.RawURLEncoding.EncodeToString(h.Sum(nil))
-	
-	// Compare signatures (this is simplified and might not be exact)
-	// In a real implementation, you would decode the actual signature
-	if !hmac.Equal([]byte(parts[2]), []byte(expectedSignature)) {
-		// Instead of failing on signature verification (which might be tricky with different encodings),
-		// just log the mismatch and continue for now
-		log.Printf("Warning: Signature verification failed, but proceeding anyway for development")
-		// For production, uncomment the next line to enforce signature verification
-		// return nil, fmt.Errorf("invalid signature")
-	}
-
-	return &claims, nil
-}
\ No newline at end of file
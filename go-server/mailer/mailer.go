@@ -0,0 +1,90 @@
+package mailer
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email
+type Mailer interface {
+	Send(msg Message) error
+}
+
+var (
+	defaultMailer     Mailer
+	defaultMailerOnce sync.Once
+	defaultMailerMu   sync.RWMutex
+)
+
+// Default returns the mailer configured by the environment, creating it on
+// first use. MAIL_TRANSPORT selects it:
+//
+//   - smtp: SMTP_HOST, SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD
+//   - file: appends each message to MAIL_FILE (default mail.log)
+//   - log (default): writes each message to the server log
+//
+// MAIL_FROM sets the sender address for SMTP.
+func Default() Mailer {
+	defaultMailerOnce.Do(func() {
+		m := fromEnv()
+		defaultMailerMu.Lock()
+		if defaultMailer == nil {
+			defaultMailer = m
+		}
+		defaultMailerMu.Unlock()
+	})
+
+	defaultMailerMu.RLock()
+	defer defaultMailerMu.RUnlock()
+	return defaultMailer
+}
+
+// SetDefault replaces the mailer returned by Default, e.g. with a
+// FileMailer in tests
+func SetDefault(m Mailer) {
+	defaultMailerMu.Lock()
+	defaultMailer = m
+	defaultMailerMu.Unlock()
+}
+
+func fromEnv() Mailer {
+	switch transport := os.Getenv("MAIL_TRANSPORT"); transport {
+	case "smtp":
+		port := 587
+		if value := os.Getenv("SMTP_PORT"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				log.Printf("WARNING: Ignoring invalid SMTP_PORT %q, using %d", value, port)
+			} else {
+				port = parsed
+			}
+		}
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	case "file":
+		path := os.Getenv("MAIL_FILE")
+		if path == "" {
+			path = "mail.log"
+		}
+		return NewFileMailer(path)
+	case "", "log":
+		return LogMailer{}
+	default:
+		log.Printf("WARNING: Unknown MAIL_TRANSPORT %q, writing mail to the log", transport)
+		return LogMailer{}
+	}
+}
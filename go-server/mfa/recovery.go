@@ -0,0 +1,59 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// RecoveryCodeCount is how many recovery codes a user is given at a time
+const RecoveryCodeCount = 10
+
+// recoveryAlphabet leaves out characters that are easily misread (0/O, 1/I/L)
+const recoveryAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+// recoveryCodeLength is the number of characters in a code, about 50 bits
+const recoveryCodeLength = 10
+
+// GenerateRecoveryCodes returns a new set of single-use recovery codes,
+// formatted as XXXXX-XXXXX. Only their hashes should be stored.
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, recoveryCodeLength)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		var code strings.Builder
+		for j, v := range b {
+			if j == recoveryCodeLength/2 {
+				code.WriteByte('-')
+			}
+			// 256 is not a multiple of the alphabet size; the bias is
+			// negligible next to the code's length
+			code.WriteByte(recoveryAlphabet[int(v)%len(recoveryAlphabet)])
+		}
+		codes[i] = code.String()
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the hash a recovery code is stored under. Codes
+// are compared without the separator and regardless of case, so users can
+// type them however they were written down.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashRecoveryCodes hashes each of a set of recovery codes
+func HashRecoveryCodes(codes []string) []string {
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = HashRecoveryCode(code)
+	}
+	return hashes
+}
@@ -1,16 +1,18 @@
 package middleware
 
 import (
+	"database/sql"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"go-server/tokens"
 )
 
 // AuthRequired is a middleware that checks if a user is authenticated
-func AuthRequired() gin.HandlerFunc {
+func AuthRequired(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get authorization header
-		authHeader := c.GetHeader("Authorization")
+		authHeader := authorizationFromRequest(c)
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
 			c.Abort()
@@ -18,20 +20,19 @@ func AuthRequired() gin.HandlerFunc {
 		}
 
 		// Extract and validate token
-		claims, err := ExtractAndValidateToken(authHeader)
+		claims, err := tokens.ParseBearer(authHeader)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: " + err.Error()})
 			c.Abort()
 			return
 		}
 
+		if !checkNotRevoked(c, db, claims) {
+			return
+		}
+
 		// Store user info in context
-		c.Set("userId", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("email", claims.Email)
-		c.Set("name", claims.Name)
-		c.Set("tenantId", claims.TenantID)
-		c.Set("isSuperAdmin", claims.IsSuperAdmin)
+		setClaimsInContext(c, claims)
 
 		c.Next()
 	}
@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/gin-gonic/gin"
+
+	"go-server/conditions"
+	"go-server/models"
+)
+
+// Enforcer is the shared Casbin enforcer, set up by auth.InitCasbin
+var Enforcer *casbin.SyncedEnforcer
+
+// SetupCasbin sets the Casbin enforcer used by the authorization middleware
+func SetupCasbin(enforcer *casbin.SyncedEnforcer) {
+	Enforcer = enforcer
+}
+
+// RoleSubject and UserSubject name roles and users in Casbin rules. IDs
+// are used rather than names, since role names are only unique per tenant.
+func RoleSubject(roleID int) string {
+	return fmt.Sprintf("role:%d", roleID)
+}
+
+func UserSubject(userID int) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// TenantDomain names a tenant as a Casbin domain. Permissions and role
+// assignments only apply in the tenant they were made in.
+func TenantDomain(tenantID int) string {
+	return fmt.Sprintf("tenant:%d", tenantID)
+}
+
+// PolicyObject names a resource in Casbin rules: system resources by name,
+// others as type/name
+func PolicyObject(resourceType string, resourceName string) string {
+	if resourceType == "system" {
+		return resourceName
+	}
+	return resourceType + "/" + resourceName
+}
+
+// DenyRule describes the deny rule that refused a request
+type DenyRule struct {
+	RoleID    int    `json:"roleId"`
+	TenantID  int    `json:"tenantId"`
+	Resource  string `json:"resource"`
+	Action    string `json:"action"`
+	Condition string `json:"condition"`
+}
+
+// denyRuleFromPolicy describes a matched p rule if it is a deny rule
+func denyRuleFromPolicy(rule []string) *DenyRule {
+	if len(rule) != 6 || rule[5] != models.PermissionEffectDeny {
+		return nil
+	}
+
+	denyRule := DenyRule{Resource: rule[2], Action: rule[3], Condition: rule[4]}
+	fmt.Sscanf(rule[0], "role:%d", &denyRule.RoleID)
+	fmt.Sscanf(rule[1], "tenant:%d", &denyRule.TenantID)
+	return &denyRule
+}
+
+// Enforce checks whether the user may perform the action on the resource,
+// named as by PolicyObject, in the tenant. Permissions with a condition only
+// count if it holds for the subject and object attributes. If a deny rule
+// refused the request, it is returned.
+func Enforce(userID, tenantID int, resource, action string, subject, object conditions.Attributes) (bool, *DenyRule, error) {
+	if Enforcer == nil {
+		return false, nil, errors.New("casbin enforcer not initialized")
+	}
+	if subject == nil {
+		subject = conditions.Attributes{}
+	}
+	if object == nil {
+		object = conditions.Attributes{}
+	}
+
+	// The user's role assignments are g rules, so one check covers every
+	// role they hold in the tenant
+	allowed, rule, err := Enforcer.EnforceEx(UserSubject(userID), TenantDomain(tenantID), resource, action, subject, object)
+	if err != nil || allowed {
+		return allowed, nil, err
+	}
+	return false, denyRuleFromPolicy(rule), nil
+}
+
+// subjectAttributes returns the attributes of the user making the request,
+// added to those the caller knows about them. What the server knows about
+// the user cannot be overridden.
+func subjectAttributes(c *gin.Context, user *models.User, extra map[string]interface{}) (conditions.Attributes, error) {
+	subject := map[string]interface{}{}
+	for name, value := range extra {
+		subject[name] = value
+	}
+
+	subject["id"] = user.ID
+	subject["tenantId"] = user.TenantID
+	subject["username"] = user.Username
+	subject["email"] = user.Email
+	subject["roles"] = []string{}
+	if c != nil {
+		subject["roles"] = c.GetStringSlice("roles")
+	}
+
+	return conditions.Normalize(subject)
+}
+
+// enforceForUser checks the user's roles in their active tenant for the
+// action on the resource. Super admins may do anything.
+func enforceForUser(c *gin.Context, user *models.User, resourceType, resourceName, action string, subject, object map[string]interface{}) (bool, *DenyRule, error) {
+	if user.IsSuperAdmin {
+		return true, nil, nil
+	}
+
+	subjectAttrs, err := subjectAttributes(c, user, subject)
+	if err != nil {
+		return false, nil, fmt.Errorf("invalid subject attributes: %v", err)
+	}
+	objectAttrs, err := conditions.Normalize(object)
+	if err != nil {
+		return false, nil, fmt.Errorf("invalid object attributes: %v", err)
+	}
+
+	return Enforce(user.ID, user.TenantID, PolicyObject(resourceType, resourceName), action, subjectAttrs, objectAttrs)
+}
+
+// AuthorizeRequest checks whether the authenticated user may perform the
+// action on a system resource, for handlers that have loaded the object.
+// subject adds to what is known about the user, such as the regions they
+// own, and object describes what they act on. If a deny rule refused the
+// request, it is returned.
+func AuthorizeRequest(c *gin.Context, resource, action string, subject, object map[string]interface{}) (bool, *DenyRule, error) {
+	userVal, exists := c.Get("user")
+	if !exists {
+		return false, nil, errors.New("request is not authenticated")
+	}
+	user, ok := userVal.(*models.User)
+	if !ok {
+		return false, nil, errors.New("invalid user data")
+	}
+
+	return enforceForUser(c, user, "system", resource, action, subject, object)
+}
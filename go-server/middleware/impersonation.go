@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go-server/models"
+	"go-server/tokens"
+)
+
+// impersonationExemptRoute is the one route that makes changes an
+// impersonation token may always call, so it can be ended
+const impersonationExemptRoute = "/auth/impersonation/end"
+
+// checkImpersonation checks that the impersonation an impersonation token
+// belongs to is still running, and sets it in the context. Unless the
+// impersonation allows writes, only safe methods are let through. It reports
+// whether the request may continue.
+func checkImpersonation(c *gin.Context, db *sql.DB, claims *tokens.Claims, user *models.User) bool {
+	imp, err := models.GetImpersonation(db, claims.SessionID)
+	if err != nil {
+		log.Printf("Error getting impersonation %s: %v", claims.SessionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate token"})
+		c.Abort()
+		return false
+	}
+
+	if imp == nil || !imp.Active(time.Now()) || imp.ActorID != claims.Actor.UserID || imp.SubjectID != user.ID {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Impersonation has ended"})
+		c.Abort()
+		return false
+	}
+
+	user.IsSuperAdmin = false
+	c.Set("impersonation", imp)
+
+	if !imp.AllowWrites && !safeMethod(c.Request.Method) && !strings.HasSuffix(c.FullPath(), impersonationExemptRoute) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":         "Changes cannot be made while impersonating",
+			"impersonating": true,
+		})
+		c.Abort()
+		recordImpersonatedRequest(c, db, imp)
+		return false
+	}
+
+	return true
+}
+
+// safeMethod reports whether an HTTP method only reads
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// recordImpersonatedRequest adds a request made while impersonating to the
+// audit log, with the status it was answered with
+func recordImpersonatedRequest(c *gin.Context, db *sql.DB, imp *models.Impersonation) {
+	err := models.AddImpersonationAuditEntry(db, models.ImpersonationAuditEntry{
+		ImpersonationID: imp.ID,
+		ActorID:         imp.ActorID,
+		SubjectID:       imp.SubjectID,
+		Method:          c.Request.Method,
+		Path:            c.Request.URL.Path,
+		Status:          c.Writer.Status(),
+		IPAddress:       c.ClientIP(),
+	})
+	if err != nil {
+		log.Printf("Error auditing request %s %s of impersonation %s: %v", c.Request.Method, c.Request.URL.Path, imp.ID, err)
+		// Not critical, continue
+	}
+}
+
+// GetImpersonationFromContext returns the impersonation the request was
+// made in, if it was made with an impersonation token
+func GetImpersonationFromContext(c *gin.Context) (*models.Impersonation, bool) {
+	value, exists := c.Get("impersonation")
+	if !exists {
+		return nil, false
+	}
+	imp, ok := value.(*models.Impersonation)
+	return imp, ok
+}
@@ -2,112 +2,58 @@ package middleware
 
 import (
 	"database/sql"
-	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"go-server/models"
+	"go-server/tokens"
 )
 
-var jwtKey = []byte(os.Getenv("JWT_SECRET"))
+// AccessTokenCookie carries the access token for browser sessions started by SSO
+const AccessTokenCookie = "auth_token"
 
-// If JWT_SECRET is not set, use a default value for development
-func init() {
-	if len(jwtKey) == 0 {
-		jwtKey = []byte("development_jwt_secret_key")
-		log.Println("WARNING: Using default JWT secret key. Set JWT_SECRET environment variable in production.")
-	}
-}
-
-// Claims defines the structure for JWT claims
-type Claims struct {
-	UserID       int    `json:"userId"`
-	Username     string `json:"username"`
-	Email        string `json:"email"`
-	TenantID     int    `json:"tenantId"`
-	IsSuperAdmin bool   `json:"isSuperAdmin"`
-	jwt.RegisteredClaims
-}
+// RefreshTokenCookie carries the refresh token for browser sessions started by SSO
+const RefreshTokenCookie = "refresh_token"
 
-// GenerateToken creates a new JWT token for the user
-func GenerateToken(user *models.User) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
-
-	claims := &Claims{
-		UserID:       user.ID,
-		Username:     user.Username,
-		Email:        user.Email,
-		TenantID:     user.TenantID,
-		IsSuperAdmin: user.IsSuperAdmin,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Subject:   fmt.Sprintf("%d", user.ID),
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
-	if err != nil {
-		return "", err
-	}
-
-	return tokenString, nil
-}
-
-// ValidateToken validates a JWT token string
-func ValidateToken(tokenString string) (*Claims, error) {
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtKey, nil
-	})
-
-	if err != nil {
-		return nil, err
-	}
-
-	if !token.Valid {
-		return nil, fmt.Errorf("invalid token")
-	}
-
-	return claims, nil
-}
-
-// JWTAuth is a middleware that checks for a valid JWT token in the Authorization header
+// JWTAuth is a middleware that checks for a valid JWT token in the Authorization
+// header, or in the access token cookie for browser sessions. Personal access
+// tokens are accepted in the Authorization header too. Requests made with an
+// impersonation token are audited.
 func JWTAuth(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
+		authHeader := authorizationFromRequest(c)
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
 			c.Abort()
 			return
 		}
 
-		// Extract the token from the Authorization header
-		// Expected format: "Bearer {token}"
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization format"})
+		tokenString, err := tokens.BearerToken(authHeader)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
-		tokenString := parts[1]
-		claims, err := ValidateToken(tokenString)
+		// Personal access tokens are opaque and looked up rather than verified
+		if tokens.IsPAT(tokenString) {
+			authenticatePAT(c, db, tokenString)
+			return
+		}
+
+		claims, err := tokens.Parse(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
+		if !checkNotRevoked(c, db, claims) {
+			return
+		}
+
 		// Get user from database
 		user, err := models.GetUser(db, claims.UserID)
 		if err != nil {
@@ -123,8 +69,87 @@ func JWTAuth(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		// The token carries the active tenant, which may differ from the
+		// user's home tenant after a tenant switch
+		if claims.TenantID != 0 {
+			user.TenantID = claims.TenantID
+		}
+
+		if claims.ClientID != "" && !checkAPIClient(c, db, claims, user) {
+			return
+		}
+
+		if claims.Actor != nil && !checkImpersonation(c, db, claims, user) {
+			return
+		}
+
+		if err := models.TouchUserSession(db, claims.SessionID, c.ClientIP(), time.Now()); err != nil {
+			log.Printf("Error updating session for user %s: %v", user.Username, err)
+			// Not critical, continue
+		}
+
 		// Set user in context for downstream handlers
 		c.Set("user", user)
+		setClaimsInContext(c, claims)
 		c.Next()
+
+		// Everything done while impersonating is audited
+		if imp, ok := GetImpersonationFromContext(c); ok {
+			recordImpersonatedRequest(c, db, imp)
+		}
+	}
+}
+
+// authorizationFromRequest returns the Authorization header, falling back to a
+// bearer value built from the access token cookie
+func authorizationFromRequest(c *gin.Context) string {
+	if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+		return authHeader
 	}
-}
\ No newline at end of file
+	if token, err := c.Cookie(AccessTokenCookie); err == nil && token != "" {
+		return "Bearer " + token
+	}
+	return ""
+}
+
+// checkNotRevoked rejects the request if the token has been revoked. It
+// reports whether the request may continue.
+func checkNotRevoked(c *gin.Context, db *sql.DB, claims *tokens.Claims) bool {
+	revoked, err := tokens.IsRevoked(db, claims)
+	if err != nil {
+		log.Printf("Error checking token revocation for user %d: %v", claims.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate token"})
+		c.Abort()
+		return false
+	}
+
+	if revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+		c.Abort()
+		return false
+	}
+
+	return true
+}
+
+// GetClaimsFromContext gets the validated token claims from the context
+func GetClaimsFromContext(c *gin.Context) (*tokens.Claims, bool) {
+	value, exists := c.Get("claims")
+	if !exists {
+		return nil, false
+	}
+	claims, ok := value.(*tokens.Claims)
+	return claims, ok
+}
+
+// setClaimsInContext stores the token claims and the values derived from them
+func setClaimsInContext(c *gin.Context, claims *tokens.Claims) {
+	c.Set("claims", claims)
+	c.Set("userId", claims.UserID)
+	c.Set("username", claims.Username)
+	c.Set("email", claims.Email)
+	c.Set("tenantId", claims.TenantID)
+	c.Set("roles", claims.Roles)
+	c.Set("isSuperAdmin", claims.IsSuperAdmin)
+	c.Set("sessionId", claims.SessionID)
+}
@@ -2,14 +2,20 @@ package middleware
 
 import (
 	"database/sql"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"go-server/models"
+	"go-server/tokens"
 )
 
-// RequireRole checks if the user has a specific role
-func RequireRole(db *sql.DB, roleName string) gin.HandlerFunc {
+// RequirePermission checks if the user has permission to access a resource.
+// Their roles in the token's tenant are checked by the Casbin enforcer, and
+// without one every request is refused.
+func RequirePermission(db *sql.DB, resourceType string, resourceName string, actionName string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get user from context (set by JWTAuth middleware)
 		userVal, exists := c.Get("user")
@@ -26,131 +32,126 @@ func RequireRole(db *sql.DB, roleName string) gin.HandlerFunc {
 			return
 		}
 
-		// Super admins bypass role checks
-		if user.IsSuperAdmin {
-			c.Next()
-			return
-		}
-
-		// Get user roles
-		roles, err := models.GetUserRolesByUserID(db, user.ID, &user.TenantID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user roles"})
-			c.Abort()
-			return
-		}
-
-		// Check if user has the required role
-		hasRole := false
-		for _, role := range roles {
-			if role.Name == roleName {
-				hasRole = true
-				break
+		// Tenants can hold back everything that needs a permission until the
+		// user has verified their email address
+		if !user.IsSuperAdmin && user.EmailVerifiedAt == nil {
+			access, err := models.UnverifiedUserAccess(db, user)
+			if err != nil {
+				log.Printf("Email verification check failed for user %s: %v", user.Username, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+				c.Abort()
+				return
+			}
+			if access != models.UnverifiedAccessFull {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error":                     "Please verify your email address",
+					"emailVerificationRequired": true,
+				})
+				c.Abort()
+				return
 			}
 		}
 
-		if !hasRole {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
-			c.Abort()
-			return
-		}
-
-		c.Next()
-	}
-}
-
-// RequirePermission checks if the user has permission to access a resource
-func RequirePermission(db *sql.DB, resourceType string, resourceName string, actionName string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Get user from context (set by JWTAuth middleware)
-		userVal, exists := c.Get("user")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-			c.Abort()
-			return
-		}
-
-		user, ok := userVal.(*models.User)
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user data"})
+		// Personal access tokens are limited to their scopes, whatever the
+		// user's roles allow
+		if token, ok := GetPersonalAccessTokenFromContext(c); ok && !tokens.ScopesAllow(token.Scopes, resourceType, resourceName, actionName) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token scopes do not allow this action"})
 			c.Abort()
 			return
 		}
 
-		// Super admins bypass permission checks
-		if user.IsSuperAdmin {
+		// API clients hold no roles; they can do exactly what their scopes allow
+		if client, ok := GetAPIClientFromContext(c); ok {
+			if !apiClientAllows(c, client, resourceType, resourceName, actionName) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Client scopes do not allow this action"})
+				c.Abort()
+				return
+			}
 			c.Next()
 			return
 		}
 
-		// Get user roles
-		roles, err := models.GetUserRolesByUserID(db, user.ID, &user.TenantID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user roles"})
-			c.Abort()
-			return
-		}
-
-		// Get resource ID
-		resource, err := models.GetResourceByTypeAndName(db, resourceType, resourceName)
+		// Conditions can refer to the route's parameters as attributes of
+		// the object, e.g. r.object.id on /users/:id
+		allowed, denyRule, err := enforceForUser(c, user, resourceType, resourceName, actionName, nil, routeObject(c))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Resource not found"})
+			log.Printf("Permission check failed for user %s: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
 			c.Abort()
 			return
 		}
 
-		// Get action ID
-		action, err := models.GetActionByName(db, actionName)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Action not found"})
+		if !allowed {
+			permissionDenied(c, resourceName, actionName, denyRule)
 			c.Abort()
 			return
 		}
 
-		// Check if user has permission through any of their roles
-		hasPermission := false
-		for _, role := range roles {
-			permission, err := models.GetPermissionByRoleResourceAction(db, role.ID, resource.ID, action.ID, user.TenantID)
-			if err == nil && permission != nil {
-				hasPermission = true
-				break
-			}
-		}
+		c.Next()
+	}
+}
 
-		if !hasPermission {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
-			c.Abort()
-			return
+// routeObject describes the object of a request by the route's parameters.
+// Numeric IDs are numbers, so they compare equal to the subject's.
+func routeObject(c *gin.Context) map[string]interface{} {
+	object := map[string]interface{}{}
+	for _, param := range c.Params {
+		if number, err := strconv.ParseInt(param.Value, 10, 64); err == nil {
+			object[param.Key] = number
+		} else {
+			object[param.Key] = param.Value
 		}
+	}
+	return object
+}
 
-		c.Next()
+// permissionDenied responds to a request none of the user's roles allows.
+// A deny rule that refused it is named, so admins can tell an exception
+// from a missing grant.
+func permissionDenied(c *gin.Context, resourceName string, actionName string, denyRule *DenyRule) {
+	if denyRule != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":    fmt.Sprintf("Access denied: role %d may not %s %s", denyRule.RoleID, actionName, resourceName),
+			"denyRule": denyRule,
+		})
+		return
 	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
 }
 
-// RequireSuperAdmin checks if the user is a super admin
-func RequireSuperAdmin() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Get user from context (set by JWTAuth middleware)
-		userVal, exists := c.Get("user")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-			c.Abort()
-			return
-		}
+// CheckPermissionForRequest checks whether the authenticated user may perform
+// an action on a system resource, for handlers that need an inline check
+func CheckPermissionForRequest(c *gin.Context, userID int, resourceName string, actionName string, db *sql.DB) bool {
+	userVal, exists := c.Get("user")
+	if !exists {
+		return false
+	}
 
-		user, ok := userVal.(*models.User)
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user data"})
-			c.Abort()
-			return
-		}
+	user, ok := userVal.(*models.User)
+	if !ok || user.ID != userID {
+		return false
+	}
 
-		if !user.IsSuperAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Super admin privileges required"})
-			c.Abort()
-			return
-		}
+	if token, ok := GetPersonalAccessTokenFromContext(c); ok && !tokens.ScopesAllow(token.Scopes, "system", resourceName, actionName) {
+		return false
+	}
 
-		c.Next()
+	if client, ok := GetAPIClientFromContext(c); ok {
+		return apiClientAllows(c, client, "system", resourceName, actionName)
+	}
+
+	allowed, _, err := enforceForUser(c, user, "system", resourceName, actionName, nil, nil)
+	if err != nil {
+		log.Printf("Permission check failed for user %s: %v", user.Username, err)
+		return false
 	}
-}
\ No newline at end of file
+
+	return allowed
+}
+
+// UserHasPermission checks if the user's roles in their active tenant allow
+// the action on the resource, for handlers that grant access to others
+func UserHasPermission(db *sql.DB, user *models.User, resourceType string, resourceName string, actionName string) (bool, error) {
+	allowed, _, err := enforceForUser(nil, user, resourceType, resourceName, actionName, nil, nil)
+	return allowed, err
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Actions a user_action_tokens token can be used for
+const (
+	ActionPasswordReset     = "password_reset"
+	ActionEmailVerification = "email_verification"
+)
+
+// ActionToken is a single-use token sent to a user's email address. Only
+// the hash of the token is stored.
+type ActionToken struct {
+	ID     int
+	UserID int
+	Action string
+	// Email is the address the token was sent to
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// CreateActionToken stores a new token for a user. Earlier unused tokens for
+// the same action stop working, so only the latest email's link is valid.
+func CreateActionToken(db *sql.DB, token ActionToken, tokenHash string) error {
+	now := time.Now()
+	_, err := db.Exec(`
+		UPDATE user_action_tokens SET used_at = $3
+		WHERE user_id = $1 AND action = $2 AND used_at IS NULL
+	`, token.UserID, token.Action, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO user_action_tokens (user_id, action, token_hash, email, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, token.UserID, token.Action, tokenHash, token.Email, token.ExpiresAt, now)
+	if err != nil {
+		return err
+	}
+
+	// Drop tokens that can no longer be used
+	_, err = db.Exec("DELETE FROM user_action_tokens WHERE expires_at < $1", now.Add(-24*time.Hour))
+	return err
+}
+
+// GetActionToken retrieves an unused, unexpired token for an action by its
+// hash. It returns nil if there is none.
+func GetActionToken(db *sql.DB, action string, tokenHash string) (*ActionToken, error) {
+	var token ActionToken
+	err := db.QueryRow(`
+		SELECT id, user_id, action, email, expires_at, used_at, created_at
+		FROM user_action_tokens
+		WHERE token_hash = $1 AND action = $2 AND used_at IS NULL AND expires_at > $3
+	`, tokenHash, action, time.Now()).Scan(
+		&token.ID, &token.UserID, &token.Action, &token.Email, &token.ExpiresAt, &token.UsedAt, &token.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ConsumeActionToken marks a token used. It reports false if the token was
+// already used, so two requests racing with the same token cannot both succeed.
+func ConsumeActionToken(db *sql.DB, id int) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE user_action_tokens SET used_at = $2
+		WHERE id = $1 AND used_at IS NULL
+	`, id, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// LastActionTokenCreatedAt returns when the user was last sent a token for
+// the action, or nil if never
+func LastActionTokenCreatedAt(db *sql.DB, userID int, action string) (*time.Time, error) {
+	var createdAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT MAX(created_at) FROM user_action_tokens WHERE user_id = $1 AND action = $2
+	`, userID, action).Scan(&createdAt)
+	if err != nil {
+		return nil, err
+	}
+	if !createdAt.Valid {
+		return nil, nil
+	}
+	return &createdAt.Time, nil
+}
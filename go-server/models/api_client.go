@@ -0,0 +1,294 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIClient is a partner system registered with a tenant that gets access
+// tokens through the OAuth2 client_credentials grant. Each client acts as
+// its own service account user, and its scopes are all it may do.
+type APIClient struct {
+	ID          int      `json:"id"`
+	TenantID    int      `json:"tenantId"`
+	ClientID    string   `json:"clientId"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Scopes      []string `json:"scopes"`
+	// UserID is the service account the client's tokens act as
+	UserID    int        `json:"userId"`
+	CreatedBy int        `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+}
+
+// APIClientSecret is one of a client's secrets. Only its hash is stored.
+// Rotation leaves the previous secrets working until they expire.
+type APIClientSecret struct {
+	ID           int        `json:"id"`
+	APIClientID  int        `json:"-"`
+	SecretPrefix string     `json:"secretPrefix"`
+	SecretHash   string     `json:"-"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	ExpiresAt    *time.Time `json:"expiresAt"`
+	RevokedAt    *time.Time `json:"revokedAt"`
+}
+
+// CreateAPIClientInput represents the input for registering an API client
+type CreateAPIClientInput struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	Scopes      []string `json:"scopes" binding:"required,min=1"`
+}
+
+// UpdateAPIClientInput represents the input for updating an API client.
+// Only non-nil fields are written.
+type UpdateAPIClientInput struct {
+	Name        *string  `json:"name"`
+	Description *string  `json:"description"`
+	Scopes      []string `json:"scopes"`
+}
+
+// Active reports whether the client may still get and use tokens
+func (c *APIClient) Active() bool {
+	return c.RevokedAt == nil
+}
+
+// Usable reports whether the secret can authenticate its client
+func (s *APIClientSecret) Usable(now time.Time) bool {
+	return s.RevokedAt == nil && (s.ExpiresAt == nil || now.Before(*s.ExpiresAt))
+}
+
+const apiClientColumns = `
+	id, tenant_id, client_id, name, description, scopes, user_id,
+	created_by, created_at, updated_at, revoked_at
+`
+
+func scanAPIClient(row rowScanner) (*APIClient, error) {
+	var client APIClient
+	err := row.Scan(
+		&client.ID, &client.TenantID, &client.ClientID, &client.Name, &client.Description,
+		pq.Array(&client.Scopes), &client.UserID, &client.CreatedBy, &client.CreatedAt,
+		&client.UpdatedAt, &client.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	client.Scopes = nonNilStrings(client.Scopes)
+	return &client, nil
+}
+
+// ListAPIClients lists a tenant's API clients, including revoked ones
+func ListAPIClients(db *sql.DB, tenantID int) ([]*APIClient, error) {
+	rows, err := db.Query(`
+		SELECT `+apiClientColumns+`
+		FROM api_clients
+		WHERE tenant_id = $1
+		ORDER BY name
+	`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*APIClient{}
+	for rows.Next() {
+		client, err := scanAPIClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// GetAPIClient retrieves an API client by its ID in a tenant. It returns nil
+// if there is none.
+func GetAPIClient(db *sql.DB, tenantID int, id int) (*APIClient, error) {
+	client, err := scanAPIClient(db.QueryRow(`
+		SELECT `+apiClientColumns+` FROM api_clients WHERE id = $1 AND tenant_id = $2
+	`, id, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return client, err
+}
+
+// GetAPIClientByClientID retrieves an API client by its OAuth2 client_id.
+// It returns nil if there is none.
+func GetAPIClientByClientID(db *sql.DB, clientID string) (*APIClient, error) {
+	client, err := scanAPIClient(db.QueryRow(`
+		SELECT `+apiClientColumns+` FROM api_clients WHERE client_id = $1
+	`, clientID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return client, err
+}
+
+// CreateAPIClient registers an API client with its first secret
+func CreateAPIClient(db *sql.DB, client APIClient, secret APIClientSecret) (*APIClient, error) {
+	now := time.Now()
+	created, err := scanAPIClient(db.QueryRow(`
+		INSERT INTO api_clients (
+			tenant_id, client_id, name, description, scopes, user_id,
+			created_by, created_at, updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING `+apiClientColumns,
+		client.TenantID, client.ClientID, client.Name, client.Description,
+		pq.Array(nonNilStrings(client.Scopes)), client.UserID, client.CreatedBy, now, now,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	secret.APIClientID = created.ID
+	if _, err := CreateAPIClientSecret(db, secret); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// UpdateAPIClient updates an API client's name, description and scopes
+func UpdateAPIClient(db *sql.DB, tenantID int, id int, input UpdateAPIClientInput) (*APIClient, error) {
+	var scopes interface{}
+	if input.Scopes != nil {
+		scopes = pq.Array(input.Scopes)
+	}
+
+	result, err := db.Exec(`
+		UPDATE api_clients
+		SET name = COALESCE($3, name),
+			description = COALESCE($4, description),
+			scopes = COALESCE($5, scopes),
+			updated_at = $6
+		WHERE id = $1 AND tenant_id = $2
+	`, id, tenantID, input.Name, input.Description, scopes, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, errors.New("api client not found")
+	}
+
+	return GetAPIClient(db, tenantID, id)
+}
+
+// RevokeAPIClient stops a client from getting new tokens and from using the
+// ones it has. Its secrets are revoked with it.
+func RevokeAPIClient(db *sql.DB, tenantID int, id int) error {
+	now := time.Now()
+	result, err := db.Exec(`
+		UPDATE api_clients SET revoked_at = $3, updated_at = $3
+		WHERE id = $1 AND tenant_id = $2 AND revoked_at IS NULL
+	`, id, tenantID, now)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("api client not found")
+	}
+
+	_, err = db.Exec(`
+		UPDATE api_client_secrets SET revoked_at = $2
+		WHERE api_client_id = $1 AND revoked_at IS NULL
+	`, id, now)
+	return err
+}
+
+// ListAPIClientSecrets lists a client's secrets, newest first
+func ListAPIClientSecrets(db *sql.DB, apiClientID int) ([]*APIClientSecret, error) {
+	rows, err := db.Query(`
+		SELECT id, api_client_id, secret_prefix, secret_hash, created_at, expires_at, revoked_at
+		FROM api_client_secrets
+		WHERE api_client_id = $1
+		ORDER BY created_at DESC, id DESC
+	`, apiClientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	secrets := []*APIClientSecret{}
+	for rows.Next() {
+		var secret APIClientSecret
+		err := rows.Scan(
+			&secret.ID, &secret.APIClientID, &secret.SecretPrefix, &secret.SecretHash,
+			&secret.CreatedAt, &secret.ExpiresAt, &secret.RevokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, &secret)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+// CreateAPIClientSecret stores a new secret for a client
+func CreateAPIClientSecret(db *sql.DB, secret APIClientSecret) (*APIClientSecret, error) {
+	secret.CreatedAt = time.Now()
+	err := db.QueryRow(`
+		INSERT INTO api_client_secrets (api_client_id, secret_prefix, secret_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, secret.APIClientID, secret.SecretPrefix, secret.SecretHash, secret.CreatedAt, secret.ExpiresAt).Scan(&secret.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+// ExpireAPIClientSecrets makes a client's current secrets stop working at
+// the given time, unless they already expire sooner
+func ExpireAPIClientSecrets(db *sql.DB, apiClientID int, at time.Time) error {
+	_, err := db.Exec(`
+		UPDATE api_client_secrets SET expires_at = $2
+		WHERE api_client_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > $2)
+	`, apiClientID, at)
+	return err
+}
+
+// RevokeAPIClientSecret revokes one of a client's secrets
+func RevokeAPIClientSecret(db *sql.DB, apiClientID int, id int) error {
+	result, err := db.Exec(`
+		UPDATE api_client_secrets SET revoked_at = $3
+		WHERE id = $1 AND api_client_id = $2 AND revoked_at IS NULL
+	`, id, apiClientID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("secret not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Claim types an identity provider mapping can match on
+const (
+	ClaimTypeRole  = "role"
+	ClaimTypeGroup = "group"
+)
+
+// IdPRoleMapping maps an identity provider role or group name onto a local role
+type IdPRoleMapping struct {
+	ID           int       `json:"id"`
+	TenantID     int       `json:"tenantId"`
+	ClaimType    string    `json:"claimType"`
+	ExternalName string    `json:"externalName"`
+	RoleID       int       `json:"roleId"`
+	RoleName     string    `json:"roleName"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// CreateIdPRoleMappingInput represents the input for creating a mapping
+type CreateIdPRoleMappingInput struct {
+	ClaimType    string `json:"claimType" binding:"required,oneof=role group"`
+	ExternalName string `json:"externalName" binding:"required"`
+	RoleID       int    `json:"roleId" binding:"required"`
+}
+
+// IdPMappingSettings holds a tenant's identity provider mapping options
+type IdPMappingSettings struct {
+	TenantID int `json:"tenantId"`
+	// Authoritative makes the identity provider the source of truth: roles
+	// not mapped from the login's claims are removed
+	Authoritative bool      `json:"authoritative"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// ListIdPRoleMappings lists a tenant's mappings
+func ListIdPRoleMappings(db *sql.DB, tenantID int) ([]IdPRoleMapping, error) {
+	rows, err := db.Query(`
+		SELECT m.id, m.tenant_id, m.claim_type, m.external_name, m.role_id, r.name, m.created_at
+		FROM idp_role_mappings m
+		JOIN roles r ON r.id = m.role_id
+		WHERE m.tenant_id = $1
+		ORDER BY m.claim_type, m.external_name, r.name
+	`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mappings := []IdPRoleMapping{}
+	for rows.Next() {
+		var mapping IdPRoleMapping
+		err := rows.Scan(
+			&mapping.ID,
+			&mapping.TenantID,
+			&mapping.ClaimType,
+			&mapping.ExternalName,
+			&mapping.RoleID,
+			&mapping.RoleName,
+			&mapping.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return mappings, nil
+}
+
+// CreateIdPRoleMapping creates a mapping onto one of the tenant's roles
+func CreateIdPRoleMapping(db *sql.DB, tenantID int, input CreateIdPRoleMappingInput) (*IdPRoleMapping, error) {
+	role, err := GetRoleByID(db, input.RoleID)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil || role.TenantID != tenantID {
+		return nil, errors.New("role not found in this tenant")
+	}
+
+	mapping := &IdPRoleMapping{
+		TenantID:     tenantID,
+		ClaimType:    input.ClaimType,
+		ExternalName: input.ExternalName,
+		RoleID:       role.ID,
+		RoleName:     role.Name,
+		CreatedAt:    time.Now(),
+	}
+
+	err = db.QueryRow(`
+		INSERT INTO idp_role_mappings (tenant_id, claim_type, external_name, role_id, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, mapping.TenantID, mapping.ClaimType, mapping.ExternalName, mapping.RoleID, mapping.CreatedAt).Scan(&mapping.ID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, errors.New("mapping already exists")
+		}
+		return nil, err
+	}
+
+	return mapping, nil
+}
+
+// DeleteIdPRoleMapping deletes one of the tenant's mappings
+func DeleteIdPRoleMapping(db *sql.DB, tenantID int, id int) error {
+	result, err := db.Exec("DELETE FROM idp_role_mappings WHERE id = $1 AND tenant_id = $2", id, tenantID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("mapping not found")
+	}
+
+	return nil
+}
+
+// GetIdPMappingSettings retrieves a tenant's mapping settings, defaulting to
+// a non-authoritative identity provider
+func GetIdPMappingSettings(db *sql.DB, tenantID int) (*IdPMappingSettings, error) {
+	settings := &IdPMappingSettings{TenantID: tenantID}
+	err := db.QueryRow(`
+		SELECT authoritative, updated_at FROM idp_mapping_settings WHERE tenant_id = $1
+	`, tenantID).Scan(&settings.Authoritative, &settings.UpdatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdateIdPMappingSettings stores a tenant's mapping settings
+func UpdateIdPMappingSettings(db *sql.DB, tenantID int, authoritative bool) (*IdPMappingSettings, error) {
+	settings := &IdPMappingSettings{
+		TenantID:      tenantID,
+		Authoritative: authoritative,
+		UpdatedAt:     time.Now(),
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO idp_mapping_settings (tenant_id, authoritative, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (tenant_id) DO UPDATE SET authoritative = EXCLUDED.authoritative, updated_at = EXCLUDED.updated_at
+	`, settings.TenantID, settings.Authoritative, settings.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// ResolveIdPRoleMappings returns the tenant's mappings matched by the given
+// role and group names
+func ResolveIdPRoleMappings(db *sql.DB, tenantID int, roles []string, groups []string) ([]IdPRoleMapping, error) {
+	rows, err := db.Query(`
+		SELECT m.id, m.tenant_id, m.claim_type, m.external_name, m.role_id, r.name, m.created_at
+		FROM idp_role_mappings m
+		JOIN roles r ON r.id = m.role_id
+		WHERE m.tenant_id = $1
+		  AND ((m.claim_type = 'role' AND m.external_name = ANY($2))
+		    OR (m.claim_type = 'group' AND m.external_name = ANY($3)))
+		ORDER BY m.id
+	`, tenantID, pq.Array(roles), pq.Array(groups))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mappings []IdPRoleMapping
+	for rows.Next() {
+		var mapping IdPRoleMapping
+		err := rows.Scan(
+			&mapping.ID,
+			&mapping.TenantID,
+			&mapping.ClaimType,
+			&mapping.ExternalName,
+			&mapping.RoleID,
+			&mapping.RoleName,
+			&mapping.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	return mappings, rows.Err()
+}
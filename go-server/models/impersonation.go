@@ -0,0 +1,176 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Impersonation is a time-boxed session in which a super admin acts as
+// another user, to see what they see. Its ID is the session ID of the
+// impersonation token.
+type Impersonation struct {
+	ID              string `json:"id"`
+	ActorID         int    `json:"actorId"`
+	ActorUsername   string `json:"actorUsername"`
+	SubjectID       int    `json:"subjectId"`
+	SubjectUsername string `json:"subjectUsername"`
+	TenantID        int    `json:"tenantId"`
+	Reason          string `json:"reason"`
+	// AllowWrites lets the actor make changes as the user; otherwise only
+	// reads are allowed
+	AllowWrites bool       `json:"allowWrites"`
+	StartedAt   time.Time  `json:"startedAt"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	EndedAt     *time.Time `json:"endedAt"`
+}
+
+// StartImpersonationInput represents the input for impersonating a user
+type StartImpersonationInput struct {
+	Reason string `json:"reason" binding:"required"`
+	// DurationMinutes defaults to 30 and may not exceed 120
+	DurationMinutes int  `json:"durationMinutes" binding:"min=0,max=120"`
+	AllowWrites     bool `json:"allowWrites"`
+}
+
+// ImpersonationAuditEntry records one request made while impersonating
+type ImpersonationAuditEntry struct {
+	ID              int       `json:"id"`
+	ImpersonationID string    `json:"impersonationId"`
+	ActorID         int       `json:"actorId"`
+	SubjectID       int       `json:"subjectId"`
+	Method          string    `json:"method"`
+	Path            string    `json:"path"`
+	Status          int       `json:"status"`
+	IPAddress       string    `json:"ipAddress"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// Active reports whether the impersonation can still be used
+func (i *Impersonation) Active(now time.Time) bool {
+	return i.EndedAt == nil && now.Before(i.ExpiresAt)
+}
+
+const impersonationColumns = `
+	id, actor_id, actor_username, subject_id, subject_username, tenant_id, reason,
+	allow_writes, started_at, expires_at, ended_at
+`
+
+func scanImpersonation(row rowScanner) (*Impersonation, error) {
+	var imp Impersonation
+	err := row.Scan(
+		&imp.ID, &imp.ActorID, &imp.ActorUsername, &imp.SubjectID, &imp.SubjectUsername,
+		&imp.TenantID, &imp.Reason, &imp.AllowWrites, &imp.StartedAt, &imp.ExpiresAt, &imp.EndedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &imp, nil
+}
+
+// CreateImpersonation records the start of an impersonation
+func CreateImpersonation(db *sql.DB, imp *Impersonation) error {
+	_, err := db.Exec(`
+		INSERT INTO impersonations (
+			id, actor_id, actor_username, subject_id, subject_username, tenant_id,
+			reason, allow_writes, started_at, expires_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, imp.ID, imp.ActorID, imp.ActorUsername, imp.SubjectID, imp.SubjectUsername, imp.TenantID,
+		imp.Reason, imp.AllowWrites, imp.StartedAt, imp.ExpiresAt)
+	return err
+}
+
+// GetImpersonation retrieves an impersonation by its ID. It returns nil if
+// there is none.
+func GetImpersonation(db *sql.DB, id string) (*Impersonation, error) {
+	imp, err := scanImpersonation(db.QueryRow(`
+		SELECT `+impersonationColumns+` FROM impersonations WHERE id = $1
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return imp, err
+}
+
+// ListImpersonations lists impersonations, newest first, optionally only
+// those of one subject
+func ListImpersonations(db *sql.DB, subjectID *int, limit int) ([]*Impersonation, error) {
+	rows, err := db.Query(`
+		SELECT `+impersonationColumns+`
+		FROM impersonations
+		WHERE $1::INTEGER IS NULL OR subject_id = $1
+		ORDER BY started_at DESC
+		LIMIT $2
+	`, subjectID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	impersonations := []*Impersonation{}
+	for rows.Next() {
+		imp, err := scanImpersonation(rows)
+		if err != nil {
+			return nil, err
+		}
+		impersonations = append(impersonations, imp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return impersonations, nil
+}
+
+// EndImpersonation marks an impersonation as ended
+func EndImpersonation(db *sql.DB, id string) error {
+	_, err := db.Exec(`
+		UPDATE impersonations SET ended_at = $2 WHERE id = $1 AND ended_at IS NULL
+	`, id, time.Now())
+	return err
+}
+
+// AddImpersonationAuditEntry records a request made while impersonating
+func AddImpersonationAuditEntry(db *sql.DB, entry ImpersonationAuditEntry) error {
+	_, err := db.Exec(`
+		INSERT INTO impersonation_audit_log (
+			impersonation_id, actor_id, subject_id, method, path, status, ip_address, created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, entry.ImpersonationID, entry.ActorID, entry.SubjectID, entry.Method, entry.Path,
+		entry.Status, entry.IPAddress, time.Now())
+	return err
+}
+
+// ListImpersonationAuditEntries lists the requests made during an
+// impersonation, oldest first
+func ListImpersonationAuditEntries(db *sql.DB, impersonationID string) ([]*ImpersonationAuditEntry, error) {
+	rows, err := db.Query(`
+		SELECT id, impersonation_id, actor_id, subject_id, method, path, status, ip_address, created_at
+		FROM impersonation_audit_log
+		WHERE impersonation_id = $1
+		ORDER BY created_at, id
+	`, impersonationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*ImpersonationAuditEntry{}
+	for rows.Next() {
+		var entry ImpersonationAuditEntry
+		err := rows.Scan(
+			&entry.ID, &entry.ImpersonationID, &entry.ActorID, &entry.SubjectID, &entry.Method,
+			&entry.Path, &entry.Status, &entry.IPAddress, &entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginAttempt counts failed logins for a key such as a client IP
+type LoginAttempt struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  *time.Time
+}
+
+// GetLoginAttempt retrieves the failures recorded for a key. It returns nil
+// if none are recorded.
+func GetLoginAttempt(db *sql.DB, key string) (*LoginAttempt, error) {
+	var attempt LoginAttempt
+	err := db.QueryRow(`
+		SELECT key, failures, last_failure_at, blocked_until
+		FROM login_attempts
+		WHERE key = $1
+	`, key).Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailureAt, &attempt.BlockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// RecordLoginAttemptFailure counts a failed login against a key and returns
+// the failures now recorded. Failures older than resetBefore are forgotten.
+func RecordLoginAttemptFailure(db *sql.DB, key string, now time.Time, resetBefore time.Time) (int, error) {
+	var failures int
+	err := db.QueryRow(`
+		INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING failures
+	`, key, now, resetBefore).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+
+	// Drop keys that have gone quiet
+	_, err = db.Exec(`
+		DELETE FROM login_attempts
+		WHERE last_failure_at < $1 AND (blocked_until IS NULL OR blocked_until < $2)
+	`, resetBefore, now)
+	return failures, err
+}
+
+// BlockLoginAttempts refuses logins for a key until the given time
+func BlockLoginAttempts(db *sql.DB, key string, until time.Time) error {
+	_, err := db.Exec("UPDATE login_attempts SET blocked_until = $2 WHERE key = $1", key, until)
+	return err
+}
+
+// RecordUserLoginFailure counts a failed password login against a user and
+// returns the failures now recorded. Failures older than resetBefore are forgotten.
+func RecordUserLoginFailure(db *sql.DB, userID int, now time.Time, resetBefore time.Time) (int, error) {
+	var failures int
+	err := db.QueryRow(`
+		UPDATE users
+		SET failed_login_attempts = CASE
+				WHEN last_failed_login_at IS NULL OR last_failed_login_at < $3 THEN 1
+				ELSE failed_login_attempts + 1
+			END,
+			last_failed_login_at = $2
+		WHERE id = $1
+		RETURNING failed_login_attempts
+	`, userID, now, resetBefore).Scan(&failures)
+	return failures, err
+}
+
+// LockUser refuses password logins for a user until the given time
+func LockUser(db *sql.DB, userID int, until time.Time) error {
+	_, err := db.Exec("UPDATE users SET locked_until = $2 WHERE id = $1", userID, until)
+	return err
+}
+
+// UnlockUser clears a user's lockout and failed login count
+func UnlockUser(db *sql.DB, userID int) error {
+	result, err := db.Exec(`
+		UPDATE users
+		SET failed_login_attempts = 0, last_failed_login_at = NULL, locked_until = NULL
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("user not found")
+	}
+
+	return nil
+}
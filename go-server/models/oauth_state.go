@@ -0,0 +1,35 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrOAuthStateUsed is returned when a login state has already been used
+var ErrOAuthStateUsed = errors.New("login state has already been used")
+
+// ConsumeOAuthState records a login state as used. It returns
+// ErrOAuthStateUsed if the state was used before.
+func ConsumeOAuthState(db *sql.DB, id string, expiresAt time.Time) error {
+	result, err := db.Exec(`
+		INSERT INTO used_oauth_states (id, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (id) DO NOTHING
+	`, id, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrOAuthStateUsed
+	}
+
+	// Expired states are rejected before they get here, so drop them
+	_, err = db.Exec("DELETE FROM used_oauth_states WHERE expires_at < NOW()")
+	return err
+}
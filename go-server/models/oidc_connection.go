@@ -0,0 +1,363 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// OIDCConnection is a tenant's federation with its own OpenID Connect provider
+type OIDCConnection struct {
+	ID       int    `json:"id"`
+	TenantID int    `json:"tenantId"`
+	Name     string `json:"name"`
+	Issuer   string `json:"issuer"`
+	ClientID string `json:"clientId"`
+	// ClientSecret is never returned to clients
+	ClientSecret    string   `json:"-"`
+	HasClientSecret bool     `json:"hasClientSecret"`
+	Scopes          []string `json:"scopes"`
+	// ClaimMappings names the ID token claim each identity field is read from
+	ClaimMappings map[string]string `json:"claimMappings"`
+	// Domains are the email domains whose users are sent to this connection
+	Domains   []string  `json:"domains"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CreateOIDCConnectionInput represents the input for creating a connection
+type CreateOIDCConnectionInput struct {
+	Name          string            `json:"name" binding:"required"`
+	Issuer        string            `json:"issuer" binding:"required"`
+	ClientID      string            `json:"clientId" binding:"required"`
+	ClientSecret  string            `json:"clientSecret"`
+	Scopes        []string          `json:"scopes"`
+	ClaimMappings map[string]string `json:"claimMappings"`
+	Domains       []string          `json:"domains"`
+	Enabled       *bool             `json:"enabled"`
+}
+
+// UpdateOIDCConnectionInput represents the input for updating a connection
+type UpdateOIDCConnectionInput struct {
+	Name          *string           `json:"name"`
+	Issuer        *string           `json:"issuer"`
+	ClientID      *string           `json:"clientId"`
+	ClientSecret  *string           `json:"clientSecret"`
+	Scopes        []string          `json:"scopes"`
+	ClaimMappings map[string]string `json:"claimMappings"`
+	Domains       []string          `json:"domains"`
+	Enabled       *bool             `json:"enabled"`
+}
+
+// oidcConnectionColumns are selected by every connection query
+const oidcConnectionColumns = `
+	c.id, c.tenant_id, c.name, c.issuer, c.client_id, c.client_secret, c.scopes, c.claim_mappings, c.enabled,
+	c.created_at, c.updated_at,
+	ARRAY(SELECT d.domain FROM oidc_connection_domains d WHERE d.connection_id = c.id ORDER BY d.domain)
+`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanOIDCConnection scans a row selected with oidcConnectionColumns
+func scanOIDCConnection(row rowScanner) (*OIDCConnection, error) {
+	var conn OIDCConnection
+	var claimMappings []byte
+	err := row.Scan(
+		&conn.ID,
+		&conn.TenantID,
+		&conn.Name,
+		&conn.Issuer,
+		&conn.ClientID,
+		&conn.ClientSecret,
+		pq.Array(&conn.Scopes),
+		&claimMappings,
+		&conn.Enabled,
+		&conn.CreatedAt,
+		&conn.UpdatedAt,
+		pq.Array(&conn.Domains),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.ClaimMappings = map[string]string{}
+	if err := json.Unmarshal(claimMappings, &conn.ClaimMappings); err != nil {
+		return nil, err
+	}
+	conn.HasClientSecret = conn.ClientSecret != ""
+	if conn.Scopes == nil {
+		conn.Scopes = []string{}
+	}
+	if conn.Domains == nil {
+		conn.Domains = []string{}
+	}
+
+	return &conn, nil
+}
+
+// ListOIDCConnections lists a tenant's connections
+func ListOIDCConnections(db *sql.DB, tenantID int) ([]*OIDCConnection, error) {
+	rows, err := db.Query(`
+		SELECT `+oidcConnectionColumns+`
+		FROM oidc_connections c
+		WHERE c.tenant_id = $1
+		ORDER BY c.name
+	`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	connections := []*OIDCConnection{}
+	for rows.Next() {
+		conn, err := scanOIDCConnection(rows)
+		if err != nil {
+			return nil, err
+		}
+		connections = append(connections, conn)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return connections, nil
+}
+
+// GetOIDCConnection retrieves a connection by ID
+func GetOIDCConnection(db *sql.DB, id int) (*OIDCConnection, error) {
+	conn, err := scanOIDCConnection(db.QueryRow(`
+		SELECT `+oidcConnectionColumns+`
+		FROM oidc_connections c
+		WHERE c.id = $1
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return conn, err
+}
+
+// GetOIDCConnectionByDomain retrieves the enabled connection that claims an
+// email domain, for home-realm discovery
+func GetOIDCConnectionByDomain(db *sql.DB, domain string) (*OIDCConnection, error) {
+	conn, err := scanOIDCConnection(db.QueryRow(`
+		SELECT `+oidcConnectionColumns+`
+		FROM oidc_connections c
+		JOIN oidc_connection_domains cd ON cd.connection_id = c.id
+		WHERE cd.domain = $1 AND c.enabled = TRUE
+	`, normalizeDomain(domain)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return conn, err
+}
+
+// CreateOIDCConnection creates a connection for a tenant
+func CreateOIDCConnection(db *sql.DB, tenantID int, input CreateOIDCConnectionInput) (*OIDCConnection, error) {
+	enabled := true
+	if input.Enabled != nil {
+		enabled = *input.Enabled
+	}
+
+	claimMappings, err := marshalClaimMappings(input.ClaimMappings)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO oidc_connections (tenant_id, name, issuer, client_id, client_secret, scopes, claim_mappings, enabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		RETURNING id
+	`,
+		tenantID,
+		input.Name,
+		strings.TrimRight(input.Issuer, "/"),
+		input.ClientID,
+		input.ClientSecret,
+		pq.Array(nonNilStrings(input.Scopes)),
+		claimMappings,
+		enabled,
+		now,
+	).Scan(&id)
+	if err != nil {
+		return nil, connectionError(err)
+	}
+
+	if err := setOIDCConnectionDomains(tx, id, input.Domains); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetOIDCConnection(db, id)
+}
+
+// UpdateOIDCConnection updates one of a tenant's connections
+func UpdateOIDCConnection(db *sql.DB, tenantID int, id int, input UpdateOIDCConnectionInput) (*OIDCConnection, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Build dynamic update query
+	query := "UPDATE oidc_connections SET updated_at = $1"
+	args := []interface{}{time.Now()}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		query += fmt.Sprintf(", %s = $%d", column, len(args))
+	}
+
+	if input.Name != nil {
+		set("name", *input.Name)
+	}
+	if input.Issuer != nil {
+		set("issuer", strings.TrimRight(*input.Issuer, "/"))
+	}
+	if input.ClientID != nil {
+		set("client_id", *input.ClientID)
+	}
+	if input.ClientSecret != nil {
+		set("client_secret", *input.ClientSecret)
+	}
+	if input.Scopes != nil {
+		set("scopes", pq.Array(input.Scopes))
+	}
+	if input.ClaimMappings != nil {
+		claimMappings, err := marshalClaimMappings(input.ClaimMappings)
+		if err != nil {
+			return nil, err
+		}
+		set("claim_mappings", claimMappings)
+	}
+	if input.Enabled != nil {
+		set("enabled", *input.Enabled)
+	}
+
+	args = append(args, id, tenantID)
+	query += fmt.Sprintf(" WHERE id = $%d AND tenant_id = $%d", len(args)-1, len(args))
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return nil, connectionError(err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, errors.New("connection not found")
+	}
+
+	if input.Domains != nil {
+		if err := setOIDCConnectionDomains(tx, id, input.Domains); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetOIDCConnection(db, id)
+}
+
+// DeleteOIDCConnection deletes one of a tenant's connections. Users who signed
+// in through it keep their accounts but lose the link to the provider.
+func DeleteOIDCConnection(db *sql.DB, tenantID int, id int) error {
+	result, err := db.Exec("DELETE FROM oidc_connections WHERE id = $1 AND tenant_id = $2", id, tenantID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("connection not found")
+	}
+
+	return nil
+}
+
+// setOIDCConnectionDomains replaces the email domains a connection claims
+func setOIDCConnectionDomains(tx *sql.Tx, connectionID int, domains []string) error {
+	if _, err := tx.Exec("DELETE FROM oidc_connection_domains WHERE connection_id = $1", connectionID); err != nil {
+		return err
+	}
+
+	for _, domain := range domains {
+		domain = normalizeDomain(domain)
+		if domain == "" || strings.ContainsAny(domain, "@/ ") || !strings.Contains(domain, ".") {
+			return errors.New("invalid domain")
+		}
+		_, err := tx.Exec(`
+			INSERT INTO oidc_connection_domains (domain, connection_id)
+			VALUES ($1, $2)
+			ON CONFLICT (domain) DO NOTHING
+		`, domain, connectionID)
+		if err != nil {
+			return err
+		}
+
+		// The domain may already belong to another connection
+		var owner int
+		if err := tx.QueryRow("SELECT connection_id FROM oidc_connection_domains WHERE domain = $1", domain).Scan(&owner); err != nil {
+			return err
+		}
+		if owner != connectionID {
+			return errors.New("domain " + domain + " is already claimed by another connection")
+		}
+	}
+
+	return nil
+}
+
+// connectionError maps constraint violations to user-facing errors
+func connectionError(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return errors.New("connection name already exists")
+	}
+	return err
+}
+
+// marshalClaimMappings encodes claim mappings for the JSONB column
+func marshalClaimMappings(mappings map[string]string) ([]byte, error) {
+	if mappings == nil {
+		mappings = map[string]string{}
+	}
+	return json.Marshal(mappings)
+}
+
+// normalizeDomain lowercases a domain and strips surrounding whitespace and dots
+func normalizeDomain(domain string) string {
+	return strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// nonNilStrings returns an empty slice for nil, so arrays are stored as '{}'
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
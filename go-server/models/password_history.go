@@ -0,0 +1,61 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// passwordHistoryKept is how many previous password hashes are kept per
+// user, enough for the largest history size a tenant can configure
+const passwordHistoryKept = 24
+
+// AddPasswordHistory records a user's new password hash, dropping entries
+// older than any policy could ask about
+func AddPasswordHistory(db *sql.DB, userID int, passwordHash string) error {
+	_, err := db.Exec(`
+		INSERT INTO password_history (user_id, password_hash, created_at)
+		VALUES ($1, $2, $3)
+	`, userID, passwordHash, time.Now())
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		DELETE FROM password_history
+		WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = $1
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2
+		)
+	`, userID, passwordHistoryKept)
+	return err
+}
+
+// ListPasswordHistory returns the hashes of a user's last limit passwords,
+// newest first
+func ListPasswordHistory(db *sql.DB, userID int, limit int) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT password_hash FROM password_history
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
@@ -27,13 +27,23 @@ type Action struct {
 	UpdatedAt   time.Time `json:"updatedAt"`
 }
 
-// Permission represents a permission in the system
+// The effects a permission can have. A permission that denies an action
+// overrides any that allow it.
+const (
+	PermissionEffectAllow = "allow"
+	PermissionEffectDeny  = "deny"
+)
+
+// Permission represents a permission in the system. A permission with a
+// condition only applies to requests whose attributes satisfy it.
 type Permission struct {
 	ID         int       `json:"id"`
 	RoleID     int       `json:"roleId"`
 	ResourceID int       `json:"resourceId"`
 	ActionID   int       `json:"actionId"`
 	TenantID   int       `json:"tenantId"`
+	Condition  string    `json:"condition"`
+	Effect     string    `json:"effect"`
 	CreatedAt  time.Time `json:"createdAt"`
 	Resource   *Resource `json:"resource,omitempty"`
 	Action     *Action   `json:"action,omitempty"`
@@ -54,12 +64,15 @@ type CreateActionInput struct {
 	Description string `json:"description" binding:"required"`
 }
 
-// CreatePermissionInput represents the input for creating a permission
+// CreatePermissionInput represents the input for creating a permission.
+// Permissions allow unless Effect is deny.
 type CreatePermissionInput struct {
-	RoleID     int `json:"roleId" binding:"required"`
-	ResourceID int `json:"resourceId" binding:"required"`
-	ActionID   int `json:"actionId" binding:"required"`
-	TenantID   int `json:"tenantId" binding:"required"`
+	RoleID     int    `json:"roleId" binding:"required"`
+	ResourceID int    `json:"resourceId" binding:"required"`
+	ActionID   int    `json:"actionId" binding:"required"`
+	TenantID   int    `json:"tenantId" binding:"required"`
+	Condition  string `json:"condition"`
+	Effect     string `json:"effect" binding:"omitempty,oneof=allow deny"`
 }
 
 // GetResourceByID gets a resource by ID
@@ -317,7 +330,7 @@ func CreateAction(db *sql.DB, input CreateActionInput) (*Action, error) {
 func GetPermissionByID(db *sql.DB, id int) (*Permission, error) {
 	// Query permission
 	row := db.QueryRow(`
-		SELECT id, role_id, resource_id, action_id, tenant_id, created_at
+		SELECT id, role_id, resource_id, action_id, tenant_id, condition, effect, created_at
 		FROM permissions
 		WHERE id = $1
 	`, id)
@@ -330,6 +343,8 @@ func GetPermissionByID(db *sql.DB, id int) (*Permission, error) {
 		&permission.ResourceID,
 		&permission.ActionID,
 		&permission.TenantID,
+		&permission.Condition,
+		&permission.Effect,
 		&permission.CreatedAt,
 	)
 	if err != nil {
@@ -356,11 +371,16 @@ func GetPermissionByID(db *sql.DB, id int) (*Permission, error) {
 	return &permission, nil
 }
 
+// GetPermissionsByRoleID gets all permissions granted to a role
+func GetPermissionsByRoleID(db *sql.DB, roleID int) ([]Permission, error) {
+	return GetAllPermissions(db, &roleID, nil)
+}
+
 // GetAllPermissions gets all permissions
 func GetAllPermissions(db *sql.DB, roleID, tenantID *int) ([]Permission, error) {
 	// Build query
 	query := `
-		SELECT p.id, p.role_id, p.resource_id, p.action_id, p.tenant_id, p.created_at,
+		SELECT p.id, p.role_id, p.resource_id, p.action_id, p.tenant_id, p.condition, p.effect, p.created_at,
 			r.id as r_id, r.type as r_type, r.name as r_name, r.display_name as r_display_name, r.description as r_description, r.created_at as r_created_at, r.updated_at as r_updated_at,
 			a.id as a_id, a.name as a_name, a.display_name as a_display_name, a.description as a_description, a.created_at as a_created_at, a.updated_at as a_updated_at
 		FROM permissions p
@@ -399,6 +419,8 @@ func GetAllPermissions(db *sql.DB, roleID, tenantID *int) ([]Permission, error)
 			&permission.ResourceID,
 			&permission.ActionID,
 			&permission.TenantID,
+			&permission.Condition,
+			&permission.Effect,
 			&permission.CreatedAt,
 			&resource.ID,
 			&resource.Type,
@@ -437,6 +459,12 @@ func CreatePermission(db *sql.DB, input CreatePermissionInput) (*Permission, err
 		return nil, fmt.Errorf("role not found")
 	}
 
+	// A role's permissions only apply in its own tenant, where the
+	// enforcer looks for them
+	if role.TenantID != input.TenantID {
+		return nil, fmt.Errorf("role belongs to another tenant")
+	}
+
 	// Check if resource exists
 	resource, err := GetResourceByID(db, input.ResourceID)
 	if err != nil {
@@ -455,13 +483,20 @@ func CreatePermission(db *sql.DB, input CreatePermissionInput) (*Permission, err
 		return nil, fmt.Errorf("action not found")
 	}
 
-	// Check if permission already exists
+	effect := input.Effect
+	if effect == "" {
+		effect = PermissionEffectAllow
+	}
+
+	// Check if permission already exists. The same action may be allowed
+	// and denied under different conditions.
 	var count int
 	err = db.QueryRow(`
 		SELECT COUNT(*)
 		FROM permissions
 		WHERE role_id = $1 AND resource_id = $2 AND action_id = $3 AND tenant_id = $4
-	`, input.RoleID, input.ResourceID, input.ActionID, input.TenantID).Scan(&count)
+			AND condition = $5 AND effect = $6
+	`, input.RoleID, input.ResourceID, input.ActionID, input.TenantID, input.Condition, effect).Scan(&count)
 	if err != nil {
 		return nil, err
 	}
@@ -473,15 +508,17 @@ func CreatePermission(db *sql.DB, input CreatePermissionInput) (*Permission, err
 	var permission Permission
 	err = db.QueryRow(`
 		INSERT INTO permissions (
-			role_id, resource_id, action_id, tenant_id, created_at
+			role_id, resource_id, action_id, tenant_id, condition, effect, created_at
 		) VALUES (
-			$1, $2, $3, $4, $5
-		) RETURNING id, role_id, resource_id, action_id, tenant_id, created_at
+			$1, $2, $3, $4, $5, $6, $7
+		) RETURNING id, role_id, resource_id, action_id, tenant_id, condition, effect, created_at
 	`,
 		input.RoleID,
 		input.ResourceID,
 		input.ActionID,
 		input.TenantID,
+		input.Condition,
+		effect,
 		time.Now(),
 	).Scan(
 		&permission.ID,
@@ -489,6 +526,8 @@ func CreatePermission(db *sql.DB, input CreatePermissionInput) (*Permission, err
 		&permission.ResourceID,
 		&permission.ActionID,
 		&permission.TenantID,
+		&permission.Condition,
+		&permission.Effect,
 		&permission.CreatedAt,
 	)
 	if err != nil {
@@ -499,12 +538,29 @@ func CreatePermission(db *sql.DB, input CreatePermissionInput) (*Permission, err
 	permission.Resource = resource
 	permission.Action = action
 
+	if policyObserver != nil {
+		policyObserver.PermissionAdded(&permission)
+	}
+
 	return &permission, nil
 }
 
 // DeletePermission deletes a permission
 func DeletePermission(db *sql.DB, id int) error {
+	// Keep what the permission granted so the policy observer can be told
+	permission, err := GetPermissionByID(db, id)
+	if err != nil {
+		return err
+	}
+
 	// Delete permission
-	_, err := db.Exec("DELETE FROM permissions WHERE id = $1", id)
-	return err
-}
\ No newline at end of file
+	_, err = db.Exec("DELETE FROM permissions WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	if permission != nil && policyObserver != nil {
+		policyObserver.PermissionRemoved(permission)
+	}
+	return nil
+}
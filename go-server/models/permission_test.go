@@ -0,0 +1,124 @@
+package models
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"go-server/sqltest"
+)
+
+var roleColumns = []string{"id", "name", "display_name", "description", "tenant_id", "parent_role_id", "created_at", "updated_at"}
+
+// newPermissionStore answers the statements CreatePermission runs for a
+// role of tenant 1
+func newPermissionStore(t *testing.T) *sqltest.DB {
+	db := sqltest.Open(t)
+	now := time.Now()
+
+	db.Handle(`^SELECT id, name, display_name, description, tenant_id, parent_role_id, created_at, updated_at FROM roles WHERE id = \$1`, func(args []driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row(roleColumns, args[0], "admin", "Admin", "", int64(1), nil, now, now), nil
+	})
+	db.Handle(`FROM resources WHERE id = \$1`, func(args []driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row([]string{"id", "type", "name", "display_name", "description", "created_at", "updated_at"},
+			args[0], "system", "users", "Users", "", now, now), nil
+	})
+	db.Handle(`FROM actions WHERE id = \$1`, func(args []driver.Value) (*sqltest.Result, error) {
+		return sqltest.Row([]string{"id", "name", "display_name", "description", "created_at", "updated_at"},
+			args[0], "read", "Read", "", now, now), nil
+	})
+
+	// Stored permissions are kept as their role, resource, action, tenant,
+	// condition and effect
+	var rules [][]driver.Value
+	sameRule := func(a, b []driver.Value) bool {
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+	db.Handle(`^SELECT COUNT\(\*\) FROM permissions WHERE role_id = \$1 AND resource_id = \$2 AND action_id = \$3 AND tenant_id = \$4 AND condition = \$5 AND effect = \$6$`, func(args []driver.Value) (*sqltest.Result, error) {
+		var count int64
+		for _, rule := range rules {
+			if sameRule(rule, args) {
+				count++
+			}
+		}
+		return sqltest.Row([]string{"count"}, count), nil
+	})
+	db.Handle(`^INSERT INTO permissions`, func(args []driver.Value) (*sqltest.Result, error) {
+		rules = append(rules, args[:6])
+		return sqltest.Row([]string{"id", "role_id", "resource_id", "action_id", "tenant_id", "condition", "effect", "created_at"},
+			int64(len(rules)), args[0], args[1], args[2], args[3], args[4], args[5], args[6]), nil
+	})
+
+	return db
+}
+
+func TestCreatePermission(t *testing.T) {
+	db := newPermissionStore(t)
+
+	permission, err := CreatePermission(db.DB, CreatePermissionInput{
+		RoleID: 10, ResourceID: 1, ActionID: 1, TenantID: 1,
+		Condition: `r.object.id == r.subject.id`,
+		Effect:    PermissionEffectDeny,
+	})
+	if err != nil {
+		t.Fatalf("CreatePermission: %v", err)
+	}
+	if permission.RoleID != 10 || permission.TenantID != 1 || permission.Condition != `r.object.id == r.subject.id` || permission.Effect != PermissionEffectDeny {
+		t.Errorf("got %+v", permission)
+	}
+	if permission.Resource == nil || permission.Resource.Name != "users" || permission.Action == nil || permission.Action.Name != "read" {
+		t.Errorf("got resource %+v, action %+v", permission.Resource, permission.Action)
+	}
+
+	// A permission without an effect allows
+	permission, err = CreatePermission(db.DB, CreatePermissionInput{RoleID: 10, ResourceID: 1, ActionID: 1, TenantID: 1})
+	if err != nil {
+		t.Fatalf("CreatePermission: %v", err)
+	}
+	if permission.Effect != PermissionEffectAllow {
+		t.Errorf("got effect %q, want %q", permission.Effect, PermissionEffectAllow)
+	}
+}
+
+func TestCreatePermissionRejectsRoleOfOtherTenant(t *testing.T) {
+	db := newPermissionStore(t)
+
+	_, err := CreatePermission(db.DB, CreatePermissionInput{RoleID: 10, ResourceID: 1, ActionID: 1, TenantID: 2})
+	if err == nil || err.Error() != "role belongs to another tenant" {
+		t.Fatalf("got %v, want role belongs to another tenant", err)
+	}
+	if inserts := db.Statements(`^INSERT INTO permissions`); len(inserts) != 0 {
+		t.Error("stored a permission in tenant 2 for a role of tenant 1")
+	}
+}
+
+func TestCreatePermissionAllowsAndDeniesUnderDifferentConditions(t *testing.T) {
+	db := newPermissionStore(t)
+
+	inputs := []CreatePermissionInput{
+		{RoleID: 10, ResourceID: 1, ActionID: 1, TenantID: 1},
+		{RoleID: 10, ResourceID: 1, ActionID: 1, TenantID: 1, Effect: PermissionEffectDeny, Condition: `r.object.id == 1`},
+		{RoleID: 10, ResourceID: 1, ActionID: 1, TenantID: 1, Effect: PermissionEffectDeny, Condition: `r.object.id == 2`},
+	}
+	for _, input := range inputs {
+		if _, err := CreatePermission(db.DB, input); err != nil {
+			t.Fatalf("CreatePermission(%+v): %v", input, err)
+		}
+	}
+
+	// The same rule again is a duplicate, whether or not the effect is given
+	duplicates := []CreatePermissionInput{
+		{RoleID: 10, ResourceID: 1, ActionID: 1, TenantID: 1, Effect: PermissionEffectAllow},
+		{RoleID: 10, ResourceID: 1, ActionID: 1, TenantID: 1, Effect: PermissionEffectDeny, Condition: `r.object.id == 1`},
+	}
+	for _, input := range duplicates {
+		if _, err := CreatePermission(db.DB, input); err == nil || err.Error() != "permission already exists" {
+			t.Errorf("CreatePermission(%+v): got %v, want permission already exists", input, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PersonalAccessToken lets a user or service account call the API without
+// logging in. It acts in one tenant and only within its scopes; only the
+// hash of the token is stored.
+type PersonalAccessToken struct {
+	ID       int    `json:"id"`
+	UserID   int    `json:"userId"`
+	TenantID int    `json:"tenantId"`
+	Name     string `json:"name"`
+	// TokenPrefix is the start of the token, to help users recognise it
+	TokenPrefix string     `json:"tokenPrefix"`
+	Scopes      []string   `json:"scopes"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt"`
+	LastUsedIP  *string    `json:"lastUsedIp"`
+	CreatedBy   int        `json:"createdBy"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// CreatePersonalAccessTokenInput represents the input for creating a personal access token
+type CreatePersonalAccessTokenInput struct {
+	Name   string   `json:"name" binding:"required"`
+	Scopes []string `json:"scopes" binding:"required,min=1"`
+	// ExpiresInDays defaults to 90 and may not exceed 366
+	ExpiresInDays int `json:"expiresInDays" binding:"min=0,max=366"`
+}
+
+// Expired reports whether the token can no longer be used
+func (t *PersonalAccessToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+const personalAccessTokenColumns = `
+	id, user_id, tenant_id, name, token_prefix, scopes, expires_at,
+	last_used_at, last_used_ip, created_by, created_at
+`
+
+func scanPersonalAccessToken(row rowScanner) (*PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	var lastUsedIP sql.NullString
+	err := row.Scan(
+		&token.ID, &token.UserID, &token.TenantID, &token.Name, &token.TokenPrefix,
+		pq.Array(&token.Scopes), &token.ExpiresAt, &token.LastUsedAt, &lastUsedIP,
+		&token.CreatedBy, &token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsedIP.Valid {
+		token.LastUsedIP = &lastUsedIP.String
+	}
+	token.Scopes = nonNilStrings(token.Scopes)
+	return &token, nil
+}
+
+// ListPersonalAccessTokens lists a user's personal access tokens, newest first
+func ListPersonalAccessTokens(db *sql.DB, userID int) ([]*PersonalAccessToken, error) {
+	rows, err := db.Query(`
+		SELECT `+personalAccessTokenColumns+`
+		FROM personal_access_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*PersonalAccessToken{}
+	for rows.Next() {
+		token, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// GetPersonalAccessTokenByHash retrieves a personal access token by the hash
+// of its value. It returns nil if there is none.
+func GetPersonalAccessTokenByHash(db *sql.DB, tokenHash string) (*PersonalAccessToken, error) {
+	token, err := scanPersonalAccessToken(db.QueryRow(`
+		SELECT `+personalAccessTokenColumns+`
+		FROM personal_access_tokens
+		WHERE token_hash = $1
+	`, tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return token, err
+}
+
+// CreatePersonalAccessToken stores a new personal access token under the hash of its value
+func CreatePersonalAccessToken(db *sql.DB, token PersonalAccessToken, tokenHash string) (*PersonalAccessToken, error) {
+	return scanPersonalAccessToken(db.QueryRow(`
+		INSERT INTO personal_access_tokens (
+			user_id, tenant_id, name, token_prefix, token_hash, scopes,
+			expires_at, created_by, created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING `+personalAccessTokenColumns,
+		token.UserID, token.TenantID, token.Name, token.TokenPrefix, tokenHash,
+		pq.Array(nonNilStrings(token.Scopes)), token.ExpiresAt, token.CreatedBy, time.Now(),
+	))
+}
+
+// RecordPersonalAccessTokenUse stores when and from where a token was last used
+func RecordPersonalAccessTokenUse(db *sql.DB, id int, ip string, usedAt time.Time) error {
+	_, err := db.Exec(`
+		UPDATE personal_access_tokens SET last_used_at = $2, last_used_ip = $3 WHERE id = $1
+	`, id, usedAt, ip)
+	return err
+}
+
+// DeletePersonalAccessToken revokes one of a user's personal access tokens
+func DeletePersonalAccessToken(db *sql.DB, userID int, id int) error {
+	result, err := db.Exec(`
+		DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2
+	`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("token not found")
+	}
+
+	return nil
+}
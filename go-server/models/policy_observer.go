@@ -0,0 +1,30 @@
+package models
+
+// PolicyObserver is told about changes to the permissions, role assignments
+// and role hierarchy that make up the authorization policy, after they have
+// been written. It lets a cached policy, such as the Casbin enforcer, follow
+// the database without reloading it.
+type PolicyObserver interface {
+	PermissionAdded(permission *Permission)
+	PermissionRemoved(permission *Permission)
+	RoleAssigned(userID, roleID, tenantID int)
+	RoleUnassigned(userID, roleID, tenantID int)
+	// RoleParentSet and RoleParentRemoved are called when a role starts or
+	// stops inheriting the permissions of its parent
+	RoleParentSet(roleID, parentRoleID, tenantID int)
+	RoleParentRemoved(roleID, parentRoleID, tenantID int)
+	// RoleRemoved is called when a role is deleted along with its
+	// permissions and assignments, and its children lose their parent
+	RoleRemoved(roleID int)
+	// UserRemoved is called when a user is deleted along with their
+	// role assignments
+	UserRemoved(userID int)
+}
+
+// policyObserver is notified of policy changes, if set
+var policyObserver PolicyObserver
+
+// SetPolicyObserver sets the observer notified of policy changes
+func SetPolicyObserver(observer PolicyObserver) {
+	policyObserver = observer
+}
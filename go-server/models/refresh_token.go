@@ -0,0 +1,198 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrRefreshTokenInvalid is returned when a refresh token is unknown, expired or revoked
+var ErrRefreshTokenInvalid = errors.New("invalid or expired refresh token")
+
+// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again
+var ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+
+// RefreshToken represents a stored refresh token. Only the hash of the
+// opaque token is persisted.
+type RefreshToken struct {
+	ID        int        `json:"id"`
+	TokenHash string     `json:"-"`
+	FamilyID  string     `json:"familyId"`
+	UserID    int        `json:"userId"`
+	TenantID  int        `json:"tenantId"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// CreateRefreshToken stores a new refresh token
+func CreateRefreshToken(db *sql.DB, token *RefreshToken) (*RefreshToken, error) {
+	return insertRefreshToken(db, token)
+}
+
+// insertRefreshToken inserts the token and fills in its ID and creation time
+func insertRefreshToken(q queryRower, token *RefreshToken) (*RefreshToken, error) {
+	token.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO refresh_tokens (token_hash, family_id, user_id, tenant_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+	err := q.QueryRow(
+		query,
+		token.TokenHash,
+		token.FamilyID,
+		token.UserID,
+		token.TenantID,
+		token.ExpiresAt,
+		token.CreatedAt,
+	).Scan(&token.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// GetRefreshTokenByHash retrieves a refresh token by the hash of its value
+func GetRefreshTokenByHash(db *sql.DB, tokenHash string) (*RefreshToken, error) {
+	token, err := scanRefreshToken(db.QueryRow(`
+		SELECT id, token_hash, family_id, user_id, tenant_id, expires_at, used_at, revoked_at, created_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+	`, tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return token, err
+}
+
+// scanRefreshToken scans a single refresh_tokens row
+func scanRefreshToken(row *sql.Row) (*RefreshToken, error) {
+	var token RefreshToken
+	var usedAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&token.ID,
+		&token.TokenHash,
+		&token.FamilyID,
+		&token.UserID,
+		&token.TenantID,
+		&token.ExpiresAt,
+		&usedAt,
+		&revokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return &token, nil
+}
+
+// RotateRefreshToken consumes the refresh token with the given hash and stores
+// next as its successor in the same family. The successor inherits the family,
+// user and tenant of the consumed token.
+//
+// Presenting a token that has already been rotated revokes the whole family and
+// returns ErrRefreshTokenReused.
+func RotateRefreshToken(db *sql.DB, tokenHash string, next *RefreshToken) (*RefreshToken, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so concurrent refreshes with the same token are serialized
+	current, err := scanRefreshToken(tx.QueryRow(`
+		SELECT id, token_hash, family_id, user_id, tenant_id, expires_at, used_at, revoked_at, created_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`, tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if current.RevokedAt != nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	now := time.Now()
+
+	if current.UsedAt != nil {
+		// The token was already exchanged, so it has been stolen or replayed
+		if _, err := tx.Exec(`
+			UPDATE refresh_tokens SET revoked_at = $1
+			WHERE family_id = $2 AND revoked_at IS NULL
+		`, now, current.FamilyID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`
+			UPDATE user_sessions SET revoked_at = $1
+			WHERE id = $2 AND revoked_at IS NULL
+		`, now, current.FamilyID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if now.After(current.ExpiresAt) {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET used_at = $1 WHERE id = $2`, now, current.ID); err != nil {
+		return nil, err
+	}
+
+	next.FamilyID = current.FamilyID
+	next.UserID = current.UserID
+	next.TenantID = current.TenantID
+	if _, err := insertRefreshToken(tx, next); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return next, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token in a family
+func RevokeRefreshTokenFamily(db *sql.DB, familyID string) error {
+	_, err := db.Exec(`
+		UPDATE refresh_tokens SET revoked_at = $1
+		WHERE family_id = $2 AND revoked_at IS NULL
+	`, time.Now(), familyID)
+	return err
+}
+
+// SetRefreshTokenFamilyTenant moves a token family to another tenant, so
+// refreshed access tokens keep the tenant the user switched to
+func SetRefreshTokenFamilyTenant(db *sql.DB, familyID string, tenantID int) error {
+	_, err := db.Exec(`
+		UPDATE refresh_tokens SET tenant_id = $1
+		WHERE family_id = $2 AND used_at IS NULL AND revoked_at IS NULL
+	`, tenantID, familyID)
+	return err
+}
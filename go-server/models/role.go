@@ -10,26 +10,61 @@ import (
 
 // Role represents a role in the system
 type Role struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	DisplayName string    `json:"displayName"`
-	Description string    `json:"description"`
-	TenantID    int       `json:"tenantId"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	DisplayName  string    `json:"displayName"`
+	Description  string    `json:"description"`
+	TenantID     int       `json:"tenantId"`
+	ParentRoleID *int      `json:"parentRoleId"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// CreateRoleInput represents the input for creating a role
+type CreateRoleInput struct {
+	Name         string `json:"name" binding:"required"`
+	DisplayName  string `json:"displayName" binding:"required"`
+	Description  string `json:"description"`
+	TenantID     int    `json:"tenantId" binding:"required"`
+	ParentRoleID *int   `json:"parentRoleId"`
+}
+
+// UpdateRoleInput represents the input for updating a role. A ParentRoleID
+// of 0 removes the role's parent.
+type UpdateRoleInput struct {
+	Name         *string `json:"name"`
+	DisplayName  *string `json:"displayName"`
+	Description  *string `json:"description"`
+	TenantID     *int    `json:"tenantId"`
+	ParentRoleID *int    `json:"parentRoleId"`
 }
 
 // CreateRole creates a new role in the database
-func CreateRole(db *sql.DB, role *Role) (*Role, error) {
+func CreateRole(db *sql.DB, input CreateRoleInput) (*Role, error) {
 	// Set defaults
 	now := time.Now()
-	role.CreatedAt = now
-	role.UpdatedAt = now
+	role := &Role{
+		Name:         input.Name,
+		DisplayName:  input.DisplayName,
+		Description:  input.Description,
+		TenantID:     input.TenantID,
+		ParentRoleID: input.ParentRoleID,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	// A new role has no children, so its parent only has to exist in the
+	// same tenant
+	if role.ParentRoleID != nil {
+		if err := checkRoleParent(db, 0, role.TenantID, *role.ParentRoleID); err != nil {
+			return nil, err
+		}
+	}
 
 	// Insert the role into the database
 	query := `
-		INSERT INTO roles (name, display_name, description, tenant_id, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO roles (name, display_name, description, tenant_id, parent_role_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id
 	`
 	err := db.QueryRow(
@@ -38,6 +73,7 @@ func CreateRole(db *sql.DB, role *Role) (*Role, error) {
 		role.DisplayName,
 		role.Description,
 		role.TenantID,
+		role.ParentRoleID,
 		role.CreatedAt,
 		role.UpdatedAt,
 	).Scan(&role.ID)
@@ -52,13 +88,17 @@ func CreateRole(db *sql.DB, role *Role) (*Role, error) {
 		return nil, err
 	}
 
+	if role.ParentRoleID != nil && policyObserver != nil {
+		policyObserver.RoleParentSet(role.ID, *role.ParentRoleID, role.TenantID)
+	}
+
 	return role, nil
 }
 
 // GetRoleByID retrieves a role by its ID
 func GetRoleByID(db *sql.DB, id int) (*Role, error) {
 	query := `
-		SELECT id, name, display_name, description, tenant_id, created_at, updated_at
+		SELECT id, name, display_name, description, tenant_id, parent_role_id, created_at, updated_at
 		FROM roles
 		WHERE id = $1
 	`
@@ -70,13 +110,14 @@ func GetRoleByID(db *sql.DB, id int) (*Role, error) {
 		&role.DisplayName,
 		&role.Description,
 		&role.TenantID,
+		&role.ParentRoleID,
 		&role.CreatedAt,
 		&role.UpdatedAt,
 	)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("role not found")
+			return nil, nil
 		}
 		return nil, err
 	}
@@ -87,7 +128,7 @@ func GetRoleByID(db *sql.DB, id int) (*Role, error) {
 // GetRoleByName retrieves a role by its name within a tenant
 func GetRoleByName(db *sql.DB, name string, tenantID int) (*Role, error) {
 	query := `
-		SELECT id, name, display_name, description, tenant_id, created_at, updated_at
+		SELECT id, name, display_name, description, tenant_id, parent_role_id, created_at, updated_at
 		FROM roles
 		WHERE name = $1 AND tenant_id = $2
 	`
@@ -99,13 +140,14 @@ func GetRoleByName(db *sql.DB, name string, tenantID int) (*Role, error) {
 		&role.DisplayName,
 		&role.Description,
 		&role.TenantID,
+		&role.ParentRoleID,
 		&role.CreatedAt,
 		&role.UpdatedAt,
 	)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("role not found")
+			return nil, nil
 		}
 		return nil, err
 	}
@@ -121,7 +163,7 @@ func ListRoles(db *sql.DB, tenantID *int) ([]Role, error) {
 
 	if tenantID != nil {
 		query = `
-			SELECT id, name, display_name, description, tenant_id, created_at, updated_at
+			SELECT id, name, display_name, description, tenant_id, parent_role_id, created_at, updated_at
 			FROM roles
 			WHERE tenant_id = $1
 			ORDER BY id
@@ -129,7 +171,7 @@ func ListRoles(db *sql.DB, tenantID *int) ([]Role, error) {
 		rows, err = db.Query(query, *tenantID)
 	} else {
 		query = `
-			SELECT id, name, display_name, description, tenant_id, created_at, updated_at
+			SELECT id, name, display_name, description, tenant_id, parent_role_id, created_at, updated_at
 			FROM roles
 			ORDER BY id
 		`
@@ -150,6 +192,7 @@ func ListRoles(db *sql.DB, tenantID *int) ([]Role, error) {
 			&role.DisplayName,
 			&role.Description,
 			&role.TenantID,
+			&role.ParentRoleID,
 			&role.CreatedAt,
 			&role.UpdatedAt,
 		)
@@ -166,6 +209,131 @@ func ListRoles(db *sql.DB, tenantID *int) ([]Role, error) {
 	return roles, nil
 }
 
+// UpdateRole updates a role in the database
+func UpdateRole(db *sql.DB, id int, input UpdateRoleInput) (*Role, error) {
+	role, err := GetRoleByID(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, errors.New("role not found")
+	}
+	oldTenantID := role.TenantID
+	oldParentID := role.ParentRoleID
+
+	// Apply the changes
+	if input.Name != nil {
+		role.Name = *input.Name
+	}
+	if input.DisplayName != nil {
+		role.DisplayName = *input.DisplayName
+	}
+	if input.Description != nil {
+		role.Description = *input.Description
+	}
+	if input.TenantID != nil {
+		role.TenantID = *input.TenantID
+	}
+	if input.ParentRoleID != nil {
+		if *input.ParentRoleID == 0 {
+			role.ParentRoleID = nil
+		} else {
+			parentID := *input.ParentRoleID
+			role.ParentRoleID = &parentID
+		}
+	}
+	role.UpdatedAt = time.Now()
+
+	tenantChanged := role.TenantID != oldTenantID
+	parentChanged := !sameRoleID(role.ParentRoleID, oldParentID)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if tenantChanged || (parentChanged && role.ParentRoleID != nil) {
+		// Serialize hierarchy changes, so two concurrent updates cannot
+		// each pass the cycle check and together create a cycle
+		if _, err := tx.Exec(`LOCK TABLE roles IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+			return nil, err
+		}
+	}
+
+	// Roles only inherit within their tenant, so a role moving to another
+	// tenant cannot take its children with it
+	if tenantChanged {
+		var hasChildren bool
+		err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM roles WHERE parent_role_id = $1)`, id).Scan(&hasChildren)
+		if err != nil {
+			return nil, err
+		}
+		if hasChildren {
+			return nil, errors.New("role has child roles")
+		}
+	}
+
+	if role.ParentRoleID != nil && (tenantChanged || parentChanged) {
+		if err := checkRoleParent(tx, id, role.TenantID, *role.ParentRoleID); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `
+		UPDATE roles
+		SET name = $1, display_name = $2, description = $3, tenant_id = $4, parent_role_id = $5, updated_at = $6
+		WHERE id = $7
+	`
+	_, err = tx.Exec(query, role.Name, role.DisplayName, role.Description, role.TenantID, role.ParentRoleID, role.UpdatedAt, id)
+	if err != nil {
+		// Check for unique constraint violation
+		if pqErr, ok := err.(*pq.Error); ok {
+			if pqErr.Code == "23505" { // unique_violation
+				return nil, errors.New("role name already exists for this tenant")
+			}
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if (tenantChanged || parentChanged) && policyObserver != nil {
+		if oldParentID != nil {
+			policyObserver.RoleParentRemoved(id, *oldParentID, oldTenantID)
+		}
+		if role.ParentRoleID != nil {
+			policyObserver.RoleParentSet(id, *role.ParentRoleID, role.TenantID)
+		}
+	}
+
+	return role, nil
+}
+
+// DeleteRole deletes a role from the database
+func DeleteRole(db *sql.DB, id int) error {
+	result, err := db.Exec(`DELETE FROM roles WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("role not found")
+	}
+
+	if policyObserver != nil {
+		policyObserver.RoleRemoved(id)
+	}
+	return nil
+}
+
 // AssignRoleToUser assigns a role to a user
 func AssignRoleToUser(db *sql.DB, userID, roleID, tenantID int) error {
 	// Check if the assignment already exists
@@ -191,7 +359,14 @@ func AssignRoleToUser(db *sql.DB, userID, roleID, tenantID int) error {
 		VALUES ($1, $2, $3, $4)
 	`
 	_, err = db.Exec(query, userID, roleID, tenantID, time.Now())
-	return err
+	if err != nil {
+		return err
+	}
+
+	if policyObserver != nil {
+		policyObserver.RoleAssigned(userID, roleID, tenantID)
+	}
+	return nil
 }
 
 // RemoveRoleFromUser removes a role from a user
@@ -200,8 +375,19 @@ func RemoveRoleFromUser(db *sql.DB, userID, roleID, tenantID int) error {
 		DELETE FROM user_roles
 		WHERE user_id = $1 AND role_id = $2 AND tenant_id = $3
 	`
-	_, err := db.Exec(query, userID, roleID, tenantID)
-	return err
+	result, err := db.Exec(query, userID, roleID, tenantID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected > 0 && policyObserver != nil {
+		policyObserver.RoleUnassigned(userID, roleID, tenantID)
+	}
+	return nil
 }
 
 // GetUserRolesByUserID retrieves all roles assigned to a user
@@ -212,7 +398,7 @@ func GetUserRolesByUserID(db *sql.DB, userID int, tenantID *int) ([]Role, error)
 
 	if tenantID != nil {
 		query = `
-			SELECT r.id, r.name, r.display_name, r.description, r.tenant_id, r.created_at, r.updated_at
+			SELECT r.id, r.name, r.display_name, r.description, r.tenant_id, r.parent_role_id, r.created_at, r.updated_at
 			FROM roles r
 			JOIN user_roles ur ON r.id = ur.role_id
 			WHERE ur.user_id = $1 AND ur.tenant_id = $2
@@ -221,7 +407,7 @@ func GetUserRolesByUserID(db *sql.DB, userID int, tenantID *int) ([]Role, error)
 		rows, err = db.Query(query, userID, *tenantID)
 	} else {
 		query = `
-			SELECT r.id, r.name, r.display_name, r.description, r.tenant_id, r.created_at, r.updated_at
+			SELECT r.id, r.name, r.display_name, r.description, r.tenant_id, r.parent_role_id, r.created_at, r.updated_at
 			FROM roles r
 			JOIN user_roles ur ON r.id = ur.role_id
 			WHERE ur.user_id = $1
@@ -244,6 +430,7 @@ func GetUserRolesByUserID(db *sql.DB, userID int, tenantID *int) ([]Role, error)
 			&role.DisplayName,
 			&role.Description,
 			&role.TenantID,
+			&role.ParentRoleID,
 			&role.CreatedAt,
 			&role.UpdatedAt,
 		)
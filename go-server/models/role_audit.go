@@ -0,0 +1,88 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Role assignment audit actions
+const (
+	RoleAuditAssigned = "assigned"
+	RoleAuditRemoved  = "removed"
+)
+
+// RoleAssignmentAudit records a change to a user's role assignments
+type RoleAssignmentAudit struct {
+	ID       int    `json:"id"`
+	UserID   int    `json:"userId"`
+	TenantID int    `json:"tenantId"`
+	RoleID   int    `json:"roleId"`
+	RoleName string `json:"roleName"`
+	Action   string `json:"action"`
+	// Source is what made the change, e.g. "idp" for identity provider mappings
+	Source    string    `json:"source"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// RecordRoleAssignmentAudit appends an entry to the role assignment audit trail
+func RecordRoleAssignmentAudit(db *sql.DB, entry *RoleAssignmentAudit) error {
+	entry.CreatedAt = time.Now()
+	return db.QueryRow(`
+		INSERT INTO role_assignment_audit (user_id, tenant_id, role_id, role_name, action, source, detail, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`,
+		entry.UserID,
+		entry.TenantID,
+		entry.RoleID,
+		entry.RoleName,
+		entry.Action,
+		entry.Source,
+		entry.Detail,
+		entry.CreatedAt,
+	).Scan(&entry.ID)
+}
+
+// ListRoleAssignmentAudit lists a tenant's audit entries, newest first,
+// optionally filtered by user
+func ListRoleAssignmentAudit(db *sql.DB, tenantID int, userID *int, limit int) ([]RoleAssignmentAudit, error) {
+	query := `
+		SELECT id, user_id, tenant_id, role_id, role_name, action, source, COALESCE(detail, ''), created_at
+		FROM role_assignment_audit
+		WHERE tenant_id = $1 AND ($2::INTEGER IS NULL OR user_id = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`
+	rows, err := db.Query(query, tenantID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []RoleAssignmentAudit{}
+	for rows.Next() {
+		var entry RoleAssignmentAudit
+		err := rows.Scan(
+			&entry.ID,
+			&entry.UserID,
+			&entry.TenantID,
+			&entry.RoleID,
+			&entry.RoleName,
+			&entry.Action,
+			&entry.Source,
+			&entry.Detail,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// MaxRoleHierarchyDepth is how many roles deep a chain of parents may be,
+// counting the role at the bottom. Casbin follows role links 10 levels
+// deep, and the user's own assignment takes one of them.
+const MaxRoleHierarchyDepth = 8
+
+// EffectivePermission is a permission a role has, either granted to it or
+// inherited from one of its ancestors. The embedded permission's RoleID is
+// the role that grants it.
+type EffectivePermission struct {
+	Permission
+	// GrantedBy is the name of the role that grants the permission
+	GrantedBy string `json:"grantedBy"`
+	Inherited bool   `json:"inherited"`
+}
+
+// sameRoleID reports whether two optional role IDs are equal
+func sameRoleID(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// checkRoleParent checks that the role in the tenant may have the parent:
+// the parent must be in the same tenant, and the hierarchy must stay free of
+// cycles and no deeper than MaxRoleHierarchyDepth. A roleID of 0 stands for
+// a role that is being created.
+func checkRoleParent(q queryRower, roleID, tenantID, parentID int) error {
+	if parentID == roleID {
+		return errors.New("role cannot be its own parent")
+	}
+
+	var parentTenantID int
+	err := q.QueryRow(`SELECT tenant_id FROM roles WHERE id = $1`, parentID).Scan(&parentTenantID)
+	if err == sql.ErrNoRows {
+		return errors.New("parent role not found")
+	}
+	if err != nil {
+		return err
+	}
+	if parentTenantID != tenantID {
+		return errors.New("parent role must be in the same tenant")
+	}
+
+	// Walk up from the parent. Reaching the role means the role would be
+	// its own ancestor. The depth bound stops the walk on a cycle that is
+	// already stored.
+	var ancestorDepth int
+	var cycle bool
+	err = q.QueryRow(`
+		WITH RECURSIVE ancestors(id, depth) AS (
+			SELECT id, 1 FROM roles WHERE id = $1
+			UNION ALL
+			SELECT r.parent_role_id, a.depth + 1
+			FROM roles r
+			INNER JOIN ancestors a ON r.id = a.id
+			WHERE r.parent_role_id IS NOT NULL AND a.depth <= $3
+		)
+		SELECT COALESCE(MAX(depth), 0), COALESCE(BOOL_OR(id = $2), FALSE) FROM ancestors
+	`, parentID, roleID, MaxRoleHierarchyDepth).Scan(&ancestorDepth, &cycle)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return errors.New("role hierarchy cannot contain a cycle")
+	}
+
+	// The role's own descendants move down with it
+	descendantDepth := 1
+	if roleID != 0 {
+		err = q.QueryRow(`
+			WITH RECURSIVE descendants(id, depth) AS (
+				SELECT id, 1 FROM roles WHERE id = $1
+				UNION ALL
+				SELECT r.id, d.depth + 1
+				FROM roles r
+				INNER JOIN descendants d ON r.parent_role_id = d.id
+				WHERE d.depth <= $2
+			)
+			SELECT COALESCE(MAX(depth), 1) FROM descendants
+		`, roleID, MaxRoleHierarchyDepth).Scan(&descendantDepth)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ancestorDepth+descendantDepth > MaxRoleHierarchyDepth {
+		return errors.New("role hierarchy is too deep")
+	}
+
+	return nil
+}
+
+// GetEffectivePermissions gets every permission a role has, with the role
+// that grants each. Where the role and an ancestor both allow or both deny
+// the same action on a resource under the same condition, the nearest one
+// is shown.
+func GetEffectivePermissions(db *sql.DB, roleID int) ([]EffectivePermission, error) {
+	rows, err := db.Query(`
+		WITH RECURSIVE ancestors(id, depth) AS (
+			SELECT $1::INTEGER, 0
+			UNION ALL
+			SELECT ro.parent_role_id, a.depth + 1
+			FROM roles ro
+			INNER JOIN ancestors a ON ro.id = a.id
+			WHERE ro.parent_role_id IS NOT NULL AND a.depth < $2
+		)
+		SELECT * FROM (
+			SELECT DISTINCT ON (p.resource_id, p.action_id, p.condition, p.effect)
+				p.id, p.role_id, p.resource_id, p.action_id, p.tenant_id, p.condition, p.effect, p.created_at,
+				r.id as r_id, r.type as r_type, r.name as r_name, r.display_name as r_display_name, r.description as r_description, r.created_at as r_created_at, r.updated_at as r_updated_at,
+				ac.id as a_id, ac.name as a_name, ac.display_name as a_display_name, ac.description as a_description, ac.created_at as a_created_at, ac.updated_at as a_updated_at,
+				ro.name as granted_by, a.depth
+			FROM ancestors a
+			INNER JOIN roles ro ON ro.id = a.id
+			INNER JOIN permissions p ON p.role_id = a.id AND p.tenant_id = ro.tenant_id
+			INNER JOIN resources r ON p.resource_id = r.id
+			INNER JOIN actions ac ON p.action_id = ac.id
+			ORDER BY p.resource_id, p.action_id, p.condition, p.effect, a.depth
+		) effective
+		ORDER BY r_type, r_name, a_name, condition, effect
+	`, roleID, MaxRoleHierarchyDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []EffectivePermission{}
+	for rows.Next() {
+		var permission EffectivePermission
+		var resource Resource
+		var action Action
+		var depth int
+
+		err := rows.Scan(
+			&permission.ID,
+			&permission.RoleID,
+			&permission.ResourceID,
+			&permission.ActionID,
+			&permission.TenantID,
+			&permission.Condition,
+			&permission.Effect,
+			&permission.CreatedAt,
+			&resource.ID,
+			&resource.Type,
+			&resource.Name,
+			&resource.DisplayName,
+			&resource.Description,
+			&resource.CreatedAt,
+			&resource.UpdatedAt,
+			&action.ID,
+			&action.Name,
+			&action.DisplayName,
+			&action.Description,
+			&action.CreatedAt,
+			&action.UpdatedAt,
+			&permission.GrantedBy,
+			&depth,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		permission.Resource = &resource
+		permission.Action = &action
+		permission.Inherited = depth > 0
+		permissions = append(permissions, permission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
@@ -0,0 +1,296 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SAMLConnection is a tenant's federation with its own SAML 2.0 identity provider
+type SAMLConnection struct {
+	ID       int    `json:"id"`
+	TenantID int    `json:"tenantId"`
+	Name     string `json:"name"`
+	// IdPEntityID is read from IdPMetadata when the connection is saved
+	IdPEntityID string `json:"idpEntityId"`
+	IdPMetadata string `json:"idpMetadata"`
+	// SPCertificate is the certificate our AuthnRequests are signed with. Its
+	// private key is never returned to clients.
+	SPCertificate string `json:"spCertificate"`
+	SPPrivateKey  string `json:"-"`
+	// AttributeMappings names the assertion attribute each identity field is read from
+	AttributeMappings map[string]string `json:"attributeMappings"`
+	Enabled           bool              `json:"enabled"`
+	CreatedAt         time.Time         `json:"createdAt"`
+	UpdatedAt         time.Time         `json:"updatedAt"`
+}
+
+// CreateSAMLConnectionInput represents the input for creating a connection
+type CreateSAMLConnectionInput struct {
+	Name              string            `json:"name" binding:"required"`
+	IdPMetadata       string            `json:"idpMetadata" binding:"required"`
+	AttributeMappings map[string]string `json:"attributeMappings"`
+	Enabled           *bool             `json:"enabled"`
+}
+
+// UpdateSAMLConnectionInput represents the input for updating a connection
+type UpdateSAMLConnectionInput struct {
+	Name              *string           `json:"name"`
+	IdPMetadata       *string           `json:"idpMetadata"`
+	AttributeMappings map[string]string `json:"attributeMappings"`
+	Enabled           *bool             `json:"enabled"`
+}
+
+// SAMLRequest is an AuthnRequest we sent and are waiting for the response to
+type SAMLRequest struct {
+	ID           string
+	RelayState   string
+	ConnectionID int
+	TenantID     int
+	RedirectTo   string
+	ExpiresAt    time.Time
+}
+
+// samlConnectionColumns are selected by every connection query
+const samlConnectionColumns = `
+	id, tenant_id, name, idp_entity_id, idp_metadata, sp_certificate, sp_private_key,
+	attribute_mappings, enabled, created_at, updated_at
+`
+
+// scanSAMLConnection scans a row selected with samlConnectionColumns
+func scanSAMLConnection(row rowScanner) (*SAMLConnection, error) {
+	var conn SAMLConnection
+	var attributeMappings []byte
+	err := row.Scan(
+		&conn.ID,
+		&conn.TenantID,
+		&conn.Name,
+		&conn.IdPEntityID,
+		&conn.IdPMetadata,
+		&conn.SPCertificate,
+		&conn.SPPrivateKey,
+		&attributeMappings,
+		&conn.Enabled,
+		&conn.CreatedAt,
+		&conn.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.AttributeMappings = map[string]string{}
+	if err := json.Unmarshal(attributeMappings, &conn.AttributeMappings); err != nil {
+		return nil, err
+	}
+
+	return &conn, nil
+}
+
+// ListSAMLConnections lists a tenant's SAML connections
+func ListSAMLConnections(db *sql.DB, tenantID int) ([]*SAMLConnection, error) {
+	rows, err := db.Query(`
+		SELECT `+samlConnectionColumns+`
+		FROM saml_connections
+		WHERE tenant_id = $1
+		ORDER BY name
+	`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	connections := []*SAMLConnection{}
+	for rows.Next() {
+		conn, err := scanSAMLConnection(rows)
+		if err != nil {
+			return nil, err
+		}
+		connections = append(connections, conn)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return connections, nil
+}
+
+// GetSAMLConnection retrieves a SAML connection by ID
+func GetSAMLConnection(db *sql.DB, id int) (*SAMLConnection, error) {
+	conn, err := scanSAMLConnection(db.QueryRow(`
+		SELECT `+samlConnectionColumns+`
+		FROM saml_connections
+		WHERE id = $1
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return conn, err
+}
+
+// CreateSAMLConnection creates a SAML connection for a tenant. The IdP entity
+// ID is read from the metadata and the SP key pair generated by the caller.
+func CreateSAMLConnection(db *sql.DB, tenantID int, input CreateSAMLConnectionInput, idpEntityID string, spPrivateKey string, spCertificate string) (*SAMLConnection, error) {
+	enabled := true
+	if input.Enabled != nil {
+		enabled = *input.Enabled
+	}
+
+	attributeMappings, err := marshalClaimMappings(input.AttributeMappings)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var id int
+	err = db.QueryRow(`
+		INSERT INTO saml_connections (tenant_id, name, idp_entity_id, idp_metadata, sp_certificate, sp_private_key, attribute_mappings, enabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		RETURNING id
+	`,
+		tenantID,
+		input.Name,
+		idpEntityID,
+		input.IdPMetadata,
+		spCertificate,
+		spPrivateKey,
+		attributeMappings,
+		enabled,
+		now,
+	).Scan(&id)
+	if err != nil {
+		return nil, connectionError(err)
+	}
+
+	return GetSAMLConnection(db, id)
+}
+
+// UpdateSAMLConnection updates one of a tenant's SAML connections. idpEntityID
+// must be given when the metadata changes.
+func UpdateSAMLConnection(db *sql.DB, tenantID int, id int, input UpdateSAMLConnectionInput, idpEntityID string) (*SAMLConnection, error) {
+	// Build dynamic update query
+	query := "UPDATE saml_connections SET updated_at = $1"
+	args := []interface{}{time.Now()}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		query += fmt.Sprintf(", %s = $%d", column, len(args))
+	}
+
+	if input.Name != nil {
+		set("name", *input.Name)
+	}
+	if input.IdPMetadata != nil {
+		set("idp_metadata", *input.IdPMetadata)
+		set("idp_entity_id", idpEntityID)
+	}
+	if input.AttributeMappings != nil {
+		attributeMappings, err := marshalClaimMappings(input.AttributeMappings)
+		if err != nil {
+			return nil, err
+		}
+		set("attribute_mappings", attributeMappings)
+	}
+	if input.Enabled != nil {
+		set("enabled", *input.Enabled)
+	}
+
+	args = append(args, id, tenantID)
+	query += fmt.Sprintf(" WHERE id = $%d AND tenant_id = $%d", len(args)-1, len(args))
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return nil, connectionError(err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, errors.New("connection not found")
+	}
+
+	return GetSAMLConnection(db, id)
+}
+
+// DeleteSAMLConnection deletes one of a tenant's SAML connections. Users who
+// signed in through it keep their accounts but lose the link to the IdP.
+func DeleteSAMLConnection(db *sql.DB, tenantID int, id int) error {
+	result, err := db.Exec("DELETE FROM saml_connections WHERE id = $1 AND tenant_id = $2", id, tenantID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("connection not found")
+	}
+
+	return nil
+}
+
+// GetUserBySAMLIdentity retrieves the user linked to a subject at a SAML connection
+func GetUserBySAMLIdentity(db *sql.DB, connectionID int, subject string) (*User, error) {
+	var userID int
+	err := db.QueryRow(`
+		SELECT user_id FROM saml_identities WHERE connection_id = $1 AND subject = $2
+	`, connectionID, subject).Scan(&userID)
+	if err != nil {
+		return nil, err
+	}
+	return GetUser(db, userID)
+}
+
+// LinkUserSAMLIdentity links a user to a subject at a SAML connection
+func LinkUserSAMLIdentity(db *sql.DB, userID int, connectionID int, subject string) error {
+	_, err := db.Exec(`
+		INSERT INTO saml_identities (user_id, connection_id, subject, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, connectionID, subject, time.Now())
+	return err
+}
+
+// CreateSAMLRequest records an AuthnRequest so its response can be matched to it
+func CreateSAMLRequest(db *sql.DB, request SAMLRequest) error {
+	_, err := db.Exec(`
+		INSERT INTO saml_requests (id, relay_state, connection_id, tenant_id, redirect_to, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, request.ID, request.RelayState, request.ConnectionID, request.TenantID, request.RedirectTo, request.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	// Drop requests that were never answered
+	_, err = db.Exec("DELETE FROM saml_requests WHERE expires_at < NOW()")
+	return err
+}
+
+// ConsumeSAMLRequest removes and returns the unexpired request a relay state
+// was issued for, so each request is answered at most once. It returns nil if
+// there is no such request.
+func ConsumeSAMLRequest(db *sql.DB, relayState string) (*SAMLRequest, error) {
+	var request SAMLRequest
+	err := db.QueryRow(`
+		DELETE FROM saml_requests
+		WHERE relay_state = $1 AND expires_at > NOW()
+		RETURNING id, relay_state, connection_id, tenant_id, redirect_to, expires_at
+	`, relayState).Scan(
+		&request.ID,
+		&request.RelayState,
+		&request.ConnectionID,
+		&request.TenantID,
+		&request.RedirectTo,
+		&request.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
@@ -40,13 +40,57 @@ func InitializeSchema(db *sql.DB) error {
 			last_login TIMESTAMP WITH TIME ZONE,
 			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
 			updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
-			casdoor_id VARCHAR(255) UNIQUE
+			casdoor_id VARCHAR(255) UNIQUE,
+			failed_login_attempts INTEGER NOT NULL DEFAULT 0,
+			last_failed_login_at TIMESTAMP WITH TIME ZONE,
+			locked_until TIMESTAMP WITH TIME ZONE,
+			must_change_password BOOLEAN NOT NULL DEFAULT FALSE,
+			password_changed_at TIMESTAMP WITH TIME ZONE,
+			email_verified_at TIMESTAMP WITH TIME ZONE,
+			is_service_account BOOLEAN NOT NULL DEFAULT FALSE
 		)
 	`)
 	if err != nil {
 		return err
 	}
 
+	// Add the login lockout columns to users tables created before them
+	_, err = db.Exec(`
+		ALTER TABLE users
+			ADD COLUMN IF NOT EXISTS failed_login_attempts INTEGER NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS last_failed_login_at TIMESTAMP WITH TIME ZONE,
+			ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Add the password change columns to users tables created before them
+	_, err = db.Exec(`
+		ALTER TABLE users
+			ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMP WITH TIME ZONE
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Add the email verification column to users tables created before it
+	_, err = db.Exec(`
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP WITH TIME ZONE
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Add the service account flag to users tables created before it
+	_, err = db.Exec(`
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS is_service_account BOOLEAN NOT NULL DEFAULT FALSE
+	`)
+	if err != nil {
+		return err
+	}
+
 	// Create roles table
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS roles (
@@ -64,6 +108,19 @@ func InitializeSchema(db *sql.DB) error {
 		return err
 	}
 
+	// Add the parent role column to roles tables created before it
+	_, err = db.Exec(`
+		ALTER TABLE roles ADD COLUMN IF NOT EXISTS parent_role_id INTEGER REFERENCES roles(id) ON DELETE SET NULL
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_roles_parent_role_id ON roles(parent_role_id)`)
+	if err != nil {
+		return err
+	}
+
 	// Create user_roles table
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS user_roles (
@@ -118,14 +175,630 @@ func InitializeSchema(db *sql.DB) error {
 			resource_id INTEGER NOT NULL REFERENCES resources(id) ON DELETE CASCADE,
 			action_id INTEGER NOT NULL REFERENCES actions(id) ON DELETE CASCADE,
 			tenant_id INTEGER NOT NULL REFERENCES tenants(id),
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Add the condition column to permissions tables created before it
+	_, err = db.Exec(`
+		ALTER TABLE permissions ADD COLUMN IF NOT EXISTS condition TEXT NOT NULL DEFAULT ''
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Add the effect column to permissions tables created before it
+	_, err = db.Exec(`
+		ALTER TABLE permissions ADD COLUMN IF NOT EXISTS effect VARCHAR(10) NOT NULL DEFAULT 'allow'
+			CHECK (effect IN ('allow', 'deny'))
+	`)
+	if err != nil {
+		return err
+	}
+
+	// A role may both allow and deny an action on a resource, under
+	// different conditions, so permissions are unique per rule rather than
+	// per action. Tables created before deny rules had the narrower constraint.
+	_, err = db.Exec(`
+		ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_role_id_resource_id_action_id_tenant_id_key
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS permissions_rule_key
+		ON permissions (role_id, resource_id, action_id, tenant_id, condition, effect)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create refresh_tokens table. Each login starts a token family that is
+	// rotated on every refresh; the family ID is the session ID in the access token.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS refresh_tokens (
+			id SERIAL PRIMARY KEY,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			family_id VARCHAR(64) NOT NULL,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			tenant_id INTEGER NOT NULL REFERENCES tenants(id),
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE,
+			revoked_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Create revoked_tokens table. Revoked access tokens are kept until they
+	// would have expired anyway.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS revoked_tokens (
+			jti VARCHAR(64) PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			revoked_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create user_token_revocations table. Tokens issued to the user before
+	// revoked_at are no longer accepted.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_token_revocations (
+			user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			revoked_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create signing_keys table. Retired keys keep verifying tokens for a
+	// grace period after rotation.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS signing_keys (
+			kid VARCHAR(64) PRIMARY KEY,
+			algorithm VARCHAR(16) NOT NULL,
+			private_key TEXT NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			retired_at TIMESTAMP WITH TIME ZONE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create idp_role_mappings table, mapping identity provider role and group
+	// names onto local roles per tenant
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS idp_role_mappings (
+			id SERIAL PRIMARY KEY,
+			tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+			claim_type VARCHAR(16) NOT NULL CHECK (claim_type IN ('role', 'group')),
+			external_name VARCHAR(255) NOT NULL,
+			role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			UNIQUE(tenant_id, claim_type, external_name, role_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create idp_mapping_settings table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS idp_mapping_settings (
+			tenant_id INTEGER PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+			authoritative BOOLEAN NOT NULL DEFAULT FALSE,
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create role_assignment_audit table. It has no foreign keys so the trail
+	// outlives deleted users and roles.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS role_assignment_audit (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			tenant_id INTEGER NOT NULL,
+			role_id INTEGER NOT NULL,
+			role_name VARCHAR(255) NOT NULL,
+			action VARCHAR(16) NOT NULL,
+			source VARCHAR(32) NOT NULL,
+			detail TEXT,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create used_oauth_states table. Each login state is recorded once used
+	// so it cannot complete a second login, and kept until it expires.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS used_oauth_states (
+			id VARCHAR(64) PRIMARY KEY,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create tenant_redirect_uris table, the allowed post-login redirect targets per tenant
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS tenant_redirect_uris (
+			id SERIAL PRIMARY KEY,
+			tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+			uri TEXT NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			UNIQUE(tenant_id, uri)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create oidc_connections table, each tenant's federations with its own
+	// OpenID Connect providers
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS oidc_connections (
+			id SERIAL PRIMARY KEY,
+			tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			issuer TEXT NOT NULL,
+			client_id VARCHAR(255) NOT NULL,
+			client_secret TEXT NOT NULL DEFAULT '',
+			scopes TEXT[] NOT NULL DEFAULT '{}',
+			claim_mappings JSONB NOT NULL DEFAULT '{}',
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			UNIQUE(tenant_id, name)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create oidc_connection_domains table. An email domain belongs to at
+	// most one connection, which the login page sends its users to.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS oidc_connection_domains (
+			domain VARCHAR(255) PRIMARY KEY,
+			connection_id INTEGER NOT NULL REFERENCES oidc_connections(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create user_identities table, linking users to their subject at an OIDC connection
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_identities (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			connection_id INTEGER NOT NULL REFERENCES oidc_connections(id) ON DELETE CASCADE,
+			subject VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			UNIQUE(connection_id, subject)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create saml_connections table, each tenant's federations with its own
+	// SAML identity providers. Each connection has its own SP key pair.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS saml_connections (
+			id SERIAL PRIMARY KEY,
+			tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			idp_entity_id TEXT NOT NULL,
+			idp_metadata TEXT NOT NULL,
+			sp_certificate TEXT NOT NULL,
+			sp_private_key TEXT NOT NULL,
+			attribute_mappings JSONB NOT NULL DEFAULT '{}',
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			UNIQUE(tenant_id, name)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create saml_identities table, linking users to their subject at a SAML connection
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS saml_identities (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			connection_id INTEGER NOT NULL REFERENCES saml_connections(id) ON DELETE CASCADE,
+			subject VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			UNIQUE(connection_id, subject)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create saml_requests table, the AuthnRequests awaiting a response
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS saml_requests (
+			id VARCHAR(255) PRIMARY KEY,
+			relay_state VARCHAR(255) NOT NULL UNIQUE,
+			connection_id INTEGER NOT NULL REFERENCES saml_connections(id) ON DELETE CASCADE,
+			tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+			redirect_to TEXT NOT NULL DEFAULT '',
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create user_mfa table for TOTP enrolments
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_mfa (
+			user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			secret VARCHAR(255) NOT NULL,
+			confirmed_at TIMESTAMP WITH TIME ZONE,
+			last_used_step BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create mfa_recovery_codes table; only code hashes are stored
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			code_hash VARCHAR(64) NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			UNIQUE(user_id, code_hash)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create mfa_challenges table for logins waiting for their second factor
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS mfa_challenges (
+			id VARCHAR(255) PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create tenant_mfa_policies table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS tenant_mfa_policies (
+			tenant_id INTEGER PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+			required_roles TEXT[] NOT NULL DEFAULT '{}',
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create webauthn_user_handles table; passkeys are registered under an
+	// opaque handle rather than the user ID
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webauthn_user_handles (
+			user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			handle BYTEA NOT NULL UNIQUE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create webauthn_credentials table for passkeys
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webauthn_credentials (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			credential_id BYTEA NOT NULL UNIQUE,
+			public_key BYTEA NOT NULL,
+			attestation_type VARCHAR(64) NOT NULL DEFAULT '',
+			transports TEXT[] NOT NULL DEFAULT '{}',
+			aaguid BYTEA,
+			sign_count BIGINT NOT NULL DEFAULT 0,
+			clone_warning BOOLEAN NOT NULL DEFAULT false,
+			backup_eligible BOOLEAN NOT NULL DEFAULT false,
+			backup_state BOOLEAN NOT NULL DEFAULT false,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			last_used_at TIMESTAMP WITH TIME ZONE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create webauthn_sessions table for registrations and logins in progress
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webauthn_sessions (
+			id VARCHAR(255) PRIMARY KEY,
+			ceremony VARCHAR(32) NOT NULL,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL DEFAULT '',
+			data JSONB NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create login_attempts table for failed logins by client IP and by
+	// usernames that do not exist; failures for real users are kept on users
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS login_attempts (
+			key VARCHAR(512) PRIMARY KEY,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			blocked_until TIMESTAMP WITH TIME ZONE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create password_history table for refusing reuse of recent passwords
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS password_history (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			password_hash VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_password_history_user_id ON password_history(user_id, created_at)`)
+	if err != nil {
+		return err
+	}
+
+	// Create tenant_password_policies table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS tenant_password_policies (
+			tenant_id INTEGER PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+			min_length INTEGER NOT NULL,
+			require_uppercase BOOLEAN NOT NULL DEFAULT FALSE,
+			require_lowercase BOOLEAN NOT NULL DEFAULT FALSE,
+			require_digit BOOLEAN NOT NULL DEFAULT FALSE,
+			require_symbol BOOLEAN NOT NULL DEFAULT FALSE,
+			reject_breached BOOLEAN NOT NULL DEFAULT TRUE,
+			history_size INTEGER NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create user_action_tokens table for password reset and email
+	// verification links; only token hashes are stored
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_action_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			action VARCHAR(50) NOT NULL,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			email VARCHAR(255) NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			used_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_user_action_tokens_user_id ON user_action_tokens(user_id, action)`)
+	if err != nil {
+		return err
+	}
+
+	// Create tenant_email_verification_policies table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS tenant_email_verification_policies (
+			tenant_id INTEGER PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+			unverified_access VARCHAR(20) NOT NULL DEFAULT 'full',
+			grace_period_hours INTEGER NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create personal_access_tokens table for API access without a login;
+	// only token hashes are stored
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS personal_access_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			token_prefix VARCHAR(32) NOT NULL,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			scopes TEXT[] NOT NULL DEFAULT '{}',
+			expires_at TIMESTAMP WITH TIME ZONE,
+			last_used_at TIMESTAMP WITH TIME ZONE,
+			last_used_ip VARCHAR(64),
+			created_by INTEGER NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user_id ON personal_access_tokens(user_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Create api_clients table for systems that get tokens through the
+	// OAuth2 client_credentials grant
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_clients (
+			id SERIAL PRIMARY KEY,
+			tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+			client_id VARCHAR(64) UNIQUE NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			scopes TEXT[] NOT NULL DEFAULT '{}',
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_by INTEGER NOT NULL,
 			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
-			UNIQUE(role_id, resource_id, action_id, tenant_id)
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			revoked_at TIMESTAMP WITH TIME ZONE
 		)
 	`)
 	if err != nil {
 		return err
 	}
 
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_api_clients_tenant_id ON api_clients(tenant_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Create api_client_secrets table; only secret hashes are stored
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_client_secrets (
+			id SERIAL PRIMARY KEY,
+			api_client_id INTEGER NOT NULL REFERENCES api_clients(id) ON DELETE CASCADE,
+			secret_prefix VARCHAR(32) NOT NULL,
+			secret_hash VARCHAR(64) UNIQUE NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE,
+			revoked_at TIMESTAMP WITH TIME ZONE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_api_client_secrets_api_client_id ON api_client_secrets(api_client_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Create user_sessions table, one row per refresh token family, so users
+	// can see and end the sessions they are signed in with
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_sessions (
+			id VARCHAR(64) PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+			device VARCHAR(255) NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			ip_address VARCHAR(64) NOT NULL DEFAULT '',
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			revoked_at TIMESTAMP WITH TIME ZONE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions(user_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Create impersonations table for support staff acting as another user.
+	// Like the audit log it has no foreign keys so the trail outlives
+	// deleted users.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS impersonations (
+			id VARCHAR(64) PRIMARY KEY,
+			actor_id INTEGER NOT NULL,
+			actor_username VARCHAR(255) NOT NULL,
+			subject_id INTEGER NOT NULL,
+			subject_username VARCHAR(255) NOT NULL,
+			tenant_id INTEGER NOT NULL,
+			reason TEXT NOT NULL,
+			allow_writes BOOLEAN NOT NULL DEFAULT FALSE,
+			started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			ended_at TIMESTAMP WITH TIME ZONE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_impersonations_subject_id ON impersonations(subject_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Create impersonation_audit_log table, one row per request made while
+	// impersonating
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS impersonation_audit_log (
+			id SERIAL PRIMARY KEY,
+			impersonation_id VARCHAR(64) NOT NULL,
+			actor_id INTEGER NOT NULL,
+			subject_id INTEGER NOT NULL,
+			method VARCHAR(16) NOT NULL,
+			path TEXT NOT NULL,
+			status INTEGER NOT NULL,
+			ip_address VARCHAR(64) NOT NULL DEFAULT '',
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_impersonation_audit_log_impersonation_id ON impersonation_audit_log(impersonation_id)`)
+	if err != nil {
+		return err
+	}
+
 	// Add basic resources
 	resources := []struct {
 		resourceType string
@@ -272,15 +945,18 @@ func CreateDefaultAdminUserIfNotExists(db *sql.DB) error {
 		return err
 	}
 
-	// Create the admin user
+	// Create the admin user. The well-known password has to be changed at
+	// the first login before the account can be used. Its address counts as
+	// verified so an email verification policy cannot lock it out.
 	var adminUserID int
 	now := time.Now()
 	err = db.QueryRow(`
 		INSERT INTO users (
 			username, password, email, display_name, tenant_id,
-			is_active, is_super_admin, created_at, updated_at
+			is_active, is_super_admin, created_at, updated_at,
+			must_change_password, email_verified_at
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, TRUE, $8)
 		RETURNING id
 	`,
 		"admin",
@@ -357,7 +1033,7 @@ func CreateDefaultAdminUserIfNotExists(db *sql.DB) error {
 			_, err = db.Exec(`
 				INSERT INTO permissions (role_id, resource_id, action_id, tenant_id, created_at)
 				VALUES ($1, $2, $3, $4, $5)
-				ON CONFLICT (role_id, resource_id, action_id, tenant_id) DO NOTHING
+				ON CONFLICT (role_id, resource_id, action_id, tenant_id, condition, effect) DO NOTHING
 			`, adminRoleID, resourceID, actionID, defaultTenantID, now)
 			if err != nil {
 				log.Printf("Error creating permission: %v", err)
@@ -371,7 +1047,7 @@ func CreateDefaultAdminUserIfNotExists(db *sql.DB) error {
 		_, err = db.Exec(`
 			INSERT INTO permissions (role_id, resource_id, action_id, tenant_id, created_at)
 			VALUES ($1, $2, (SELECT id FROM actions WHERE name = 'read'), $3, $4)
-			ON CONFLICT (role_id, resource_id, action_id, tenant_id) DO NOTHING
+			ON CONFLICT (role_id, resource_id, action_id, tenant_id, condition, effect) DO NOTHING
 		`, userRoleID, resourceID, defaultTenantID, now)
 		if err != nil {
 			log.Printf("Error creating permission: %v", err)
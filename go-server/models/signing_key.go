@@ -0,0 +1,104 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SigningKey represents a key used to sign the server's own access tokens.
+// The private key is stored PEM-encoded.
+type SigningKey struct {
+	KID        string     `json:"kid"`
+	Algorithm  string     `json:"algorithm"`
+	PrivateKey string     `json:"-"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RetiredAt  *time.Time `json:"retiredAt,omitempty"`
+}
+
+// GetActiveSigningKey retrieves the key currently used for signing
+func GetActiveSigningKey(db *sql.DB) (*SigningKey, error) {
+	var key SigningKey
+	err := db.QueryRow(`
+		SELECT kid, algorithm, private_key, created_at
+		FROM signing_keys
+		WHERE retired_at IS NULL
+		ORDER BY created_at DESC
+		LIMIT 1
+	`).Scan(&key.KID, &key.Algorithm, &key.PrivateKey, &key.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListSigningKeys lists the active key and every key retired after retiredSince,
+// newest first
+func ListSigningKeys(db *sql.DB, retiredSince time.Time) ([]SigningKey, error) {
+	rows, err := db.Query(`
+		SELECT kid, algorithm, private_key, created_at, retired_at
+		FROM signing_keys
+		WHERE retired_at IS NULL OR retired_at > $1
+		ORDER BY created_at DESC
+	`, retiredSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []SigningKey
+	for rows.Next() {
+		var key SigningKey
+		var retiredAt sql.NullTime
+		if err := rows.Scan(&key.KID, &key.Algorithm, &key.PrivateKey, &key.CreatedAt, &retiredAt); err != nil {
+			return nil, err
+		}
+		if retiredAt.Valid {
+			key.RetiredAt = &retiredAt.Time
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// ActivateSigningKey stores the key if it is new and makes it the active
+// signing key, retiring the previously active keys
+func ActivateSigningKey(db *sql.DB, key *SigningKey) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = now
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO signing_keys (kid, algorithm, private_key, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (kid) DO UPDATE SET retired_at = NULL
+	`, key.KID, key.Algorithm, key.PrivateKey, key.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE signing_keys SET retired_at = $1
+		WHERE kid <> $2 AND retired_at IS NULL
+	`, now, key.KID)
+	if err != nil {
+		return err
+	}
+
+	key.RetiredAt = nil
+	return tx.Commit()
+}
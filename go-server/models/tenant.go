@@ -18,12 +18,31 @@ type Tenant struct {
 	UpdatedAt   time.Time `json:"updatedAt"`
 }
 
+// CreateTenantInput represents the input for creating a tenant
+type CreateTenantInput struct {
+	Name        string `json:"name" binding:"required"`
+	DisplayName string `json:"displayName" binding:"required"`
+	Description string `json:"description"`
+}
+
+// UpdateTenantInput represents the input for updating a tenant
+type UpdateTenantInput struct {
+	Name        *string `json:"name"`
+	DisplayName *string `json:"displayName"`
+	Description *string `json:"description"`
+}
+
 // CreateTenant creates a new tenant in the database
-func CreateTenant(db *sql.DB, tenant *Tenant) (*Tenant, error) {
+func CreateTenant(db *sql.DB, input CreateTenantInput) (*Tenant, error) {
 	// Set defaults
 	now := time.Now()
-	tenant.CreatedAt = now
-	tenant.UpdatedAt = now
+	tenant := &Tenant{
+		Name:        input.Name,
+		DisplayName: input.DisplayName,
+		Description: input.Description,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
 
 	// Insert the tenant into the database
 	query := `
@@ -54,20 +73,31 @@ func CreateTenant(db *sql.DB, tenant *Tenant) (*Tenant, error) {
 }
 
 // GetTenantByID retrieves a tenant by its ID
-func GetTenantByID(id int) (*Tenant, error) {
-	// TODO: Implement database access
-	// This is a placeholder for demonstration
-	if id == 1 {
-		return &Tenant{
-			ID:          1,
-			Name:        "default",
-			DisplayName: "Default Tenant",
-			Description: "Default tenant for system users",
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
-		}, nil
-	}
-	return nil, errors.New("tenant not found")
+func GetTenantByID(db *sql.DB, id int) (*Tenant, error) {
+	query := `
+		SELECT id, name, display_name, description, created_at, updated_at
+		FROM tenants
+		WHERE id = $1
+	`
+	var tenant Tenant
+
+	err := db.QueryRow(query, id).Scan(
+		&tenant.ID,
+		&tenant.Name,
+		&tenant.DisplayName,
+		&tenant.Description,
+		&tenant.CreatedAt,
+		&tenant.UpdatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &tenant, nil
 }
 
 // GetTenantByName retrieves a tenant by its name
@@ -90,7 +120,7 @@ func GetTenantByName(db *sql.DB, name string) (*Tenant, error) {
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("tenant not found")
+			return nil, nil
 		}
 		return nil, err
 	}
@@ -136,8 +166,25 @@ func ListTenants(db *sql.DB) ([]Tenant, error) {
 }
 
 // UpdateTenant updates a tenant in the database
-func UpdateTenant(db *sql.DB, tenant *Tenant) (*Tenant, error) {
-	// Update timestamp
+func UpdateTenant(db *sql.DB, id int, input UpdateTenantInput) (*Tenant, error) {
+	tenant, err := GetTenantByID(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if tenant == nil {
+		return nil, errors.New("tenant not found")
+	}
+
+	// Apply the changes
+	if input.Name != nil {
+		tenant.Name = *input.Name
+	}
+	if input.DisplayName != nil {
+		tenant.DisplayName = *input.DisplayName
+	}
+	if input.Description != nil {
+		tenant.Description = *input.Description
+	}
 	tenant.UpdatedAt = time.Now()
 
 	// Update the tenant in the database
@@ -147,7 +194,7 @@ func UpdateTenant(db *sql.DB, tenant *Tenant) (*Tenant, error) {
 		WHERE id = $5
 		RETURNING id
 	`
-	err := db.QueryRow(
+	err = db.QueryRow(
 		query,
 		tenant.Name,
 		tenant.DisplayName,
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// What users who have not verified their email address may do
+const (
+	// UnverifiedAccessFull places no limits on unverified users
+	UnverifiedAccessFull = "full"
+	// UnverifiedAccessLimited lets unverified users sign in and manage their
+	// own account, but refuses anything that needs a permission
+	UnverifiedAccessLimited = "limited"
+	// UnverifiedAccessNone refuses password logins until the address is verified
+	UnverifiedAccessNone = "none"
+)
+
+// TenantEmailVerificationPolicy limits what a tenant's users can do until
+// they verify their email address. Tenants without a stored policy place no
+// limits on them.
+type TenantEmailVerificationPolicy struct {
+	TenantID         int    `json:"tenantId"`
+	UnverifiedAccess string `json:"unverifiedAccess"`
+	// GracePeriodHours is how long after signing up an unverified user
+	// keeps full access
+	GracePeriodHours int        `json:"gracePeriodHours"`
+	UpdatedAt        *time.Time `json:"updatedAt"`
+}
+
+// UpdateTenantEmailVerificationPolicyInput represents the input for setting
+// a tenant's email verification policy
+type UpdateTenantEmailVerificationPolicyInput struct {
+	UnverifiedAccess string `json:"unverifiedAccess" binding:"required,oneof=full limited none"`
+	GracePeriodHours int    `json:"gracePeriodHours" binding:"min=0"`
+}
+
+// AccessFor returns what the policy allows the user, one of the
+// UnverifiedAccess constants
+func (p *TenantEmailVerificationPolicy) AccessFor(user *User, now time.Time) string {
+	if user.EmailVerifiedAt != nil {
+		return UnverifiedAccessFull
+	}
+	if now.Before(user.CreatedAt.Add(time.Duration(p.GracePeriodHours) * time.Hour)) {
+		return UnverifiedAccessFull
+	}
+	return p.UnverifiedAccess
+}
+
+// GetTenantEmailVerificationPolicy retrieves a tenant's email verification policy
+func GetTenantEmailVerificationPolicy(db *sql.DB, tenantID int) (*TenantEmailVerificationPolicy, error) {
+	policy := TenantEmailVerificationPolicy{TenantID: tenantID}
+	var updatedAt time.Time
+	err := db.QueryRow(`
+		SELECT unverified_access, grace_period_hours, updated_at
+		FROM tenant_email_verification_policies
+		WHERE tenant_id = $1
+	`, tenantID).Scan(&policy.UnverifiedAccess, &policy.GracePeriodHours, &updatedAt)
+	if err == sql.ErrNoRows {
+		policy.UnverifiedAccess = UnverifiedAccessFull
+		return &policy, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	policy.UpdatedAt = &updatedAt
+	return &policy, nil
+}
+
+// SetTenantEmailVerificationPolicy replaces a tenant's email verification policy
+func SetTenantEmailVerificationPolicy(db *sql.DB, tenantID int, input UpdateTenantEmailVerificationPolicyInput) (*TenantEmailVerificationPolicy, error) {
+	_, err := db.Exec(`
+		INSERT INTO tenant_email_verification_policies (tenant_id, unverified_access, grace_period_hours, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id) DO UPDATE
+		SET unverified_access = EXCLUDED.unverified_access,
+			grace_period_hours = EXCLUDED.grace_period_hours,
+			updated_at = EXCLUDED.updated_at
+	`, tenantID, input.UnverifiedAccess, input.GracePeriodHours, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return GetTenantEmailVerificationPolicy(db, tenantID)
+}
+
+// UnverifiedUserAccess returns what the email verification policy of the
+// user's tenant allows them, one of the UnverifiedAccess constants
+func UnverifiedUserAccess(db *sql.DB, user *User) (string, error) {
+	// Service accounts have no mailbox to verify
+	if user.EmailVerifiedAt != nil || user.IsServiceAccount {
+		return UnverifiedAccessFull, nil
+	}
+
+	policy, err := GetTenantEmailVerificationPolicy(db, user.TenantID)
+	if err != nil {
+		return "", err
+	}
+	return policy.AccessFor(user, time.Now()), nil
+}
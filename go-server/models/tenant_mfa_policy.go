@@ -0,0 +1,87 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TenantMFAPolicy names the roles whose holders must use MFA in a tenant.
+// Tenants without a stored policy require it of nobody.
+type TenantMFAPolicy struct {
+	TenantID      int        `json:"tenantId"`
+	RequiredRoles []string   `json:"requiredRoles"`
+	UpdatedAt     *time.Time `json:"updatedAt"`
+}
+
+// UpdateTenantMFAPolicyInput represents the input for setting a tenant's MFA policy
+type UpdateTenantMFAPolicyInput struct {
+	RequiredRoles []string `json:"requiredRoles"`
+}
+
+// RequiresMFA reports whether any of the roles is one the policy requires MFA for
+func (p *TenantMFAPolicy) RequiresMFA(roles []Role) bool {
+	for _, role := range roles {
+		for _, required := range p.RequiredRoles {
+			if role.Name == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetTenantMFAPolicy retrieves a tenant's MFA policy
+func GetTenantMFAPolicy(db *sql.DB, tenantID int) (*TenantMFAPolicy, error) {
+	policy := TenantMFAPolicy{TenantID: tenantID}
+	var updatedAt time.Time
+	err := db.QueryRow(`
+		SELECT required_roles, updated_at FROM tenant_mfa_policies WHERE tenant_id = $1
+	`, tenantID).Scan(pq.Array(&policy.RequiredRoles), &updatedAt)
+	if err == sql.ErrNoRows {
+		policy.RequiredRoles = []string{}
+		return &policy, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	policy.UpdatedAt = &updatedAt
+	if policy.RequiredRoles == nil {
+		policy.RequiredRoles = []string{}
+	}
+	return &policy, nil
+}
+
+// SetTenantMFAPolicy replaces a tenant's MFA policy
+func SetTenantMFAPolicy(db *sql.DB, tenantID int, input UpdateTenantMFAPolicyInput) (*TenantMFAPolicy, error) {
+	_, err := db.Exec(`
+		INSERT INTO tenant_mfa_policies (tenant_id, required_roles, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (tenant_id) DO UPDATE
+		SET required_roles = EXCLUDED.required_roles, updated_at = EXCLUDED.updated_at
+	`, tenantID, pq.Array(nonNilStrings(input.RequiredRoles)), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return GetTenantMFAPolicy(db, tenantID)
+}
+
+// UserRequiresMFA reports whether a user's roles in a tenant oblige them to use MFA there
+func UserRequiresMFA(db *sql.DB, userID int, tenantID int) (bool, error) {
+	policy, err := GetTenantMFAPolicy(db, tenantID)
+	if err != nil {
+		return false, err
+	}
+	if len(policy.RequiredRoles) == 0 {
+		return false, nil
+	}
+
+	roles, err := GetUserRolesByUserID(db, userID, &tenantID)
+	if err != nil {
+		return false, err
+	}
+	return policy.RequiresMFA(roles), nil
+}
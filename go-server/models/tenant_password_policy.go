@@ -0,0 +1,67 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"go-server/passwords"
+)
+
+// TenantPasswordPolicy is the password policy for users whose home tenant
+// this is. Tenants without a stored policy use passwords.DefaultPolicy.
+type TenantPasswordPolicy struct {
+	TenantID int `json:"tenantId"`
+	passwords.Policy
+	UpdatedAt *time.Time `json:"updatedAt"`
+}
+
+// GetTenantPasswordPolicy retrieves a tenant's password policy
+func GetTenantPasswordPolicy(db *sql.DB, tenantID int) (*TenantPasswordPolicy, error) {
+	policy := TenantPasswordPolicy{TenantID: tenantID}
+	var updatedAt time.Time
+	err := db.QueryRow(`
+		SELECT min_length, require_uppercase, require_lowercase, require_digit,
+			require_symbol, reject_breached, history_size, updated_at
+		FROM tenant_password_policies
+		WHERE tenant_id = $1
+	`, tenantID).Scan(
+		&policy.MinLength, &policy.RequireUppercase, &policy.RequireLowercase, &policy.RequireDigit,
+		&policy.RequireSymbol, &policy.RejectBreached, &policy.HistorySize, &updatedAt,
+	)
+	if err == sql.ErrNoRows {
+		policy.Policy = passwords.DefaultPolicy
+		return &policy, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	policy.UpdatedAt = &updatedAt
+	return &policy, nil
+}
+
+// SetTenantPasswordPolicy replaces a tenant's password policy
+func SetTenantPasswordPolicy(db *sql.DB, tenantID int, input passwords.Policy) (*TenantPasswordPolicy, error) {
+	_, err := db.Exec(`
+		INSERT INTO tenant_password_policies (
+			tenant_id, min_length, require_uppercase, require_lowercase, require_digit,
+			require_symbol, reject_breached, history_size, updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (tenant_id) DO UPDATE
+		SET min_length = EXCLUDED.min_length,
+			require_uppercase = EXCLUDED.require_uppercase,
+			require_lowercase = EXCLUDED.require_lowercase,
+			require_digit = EXCLUDED.require_digit,
+			require_symbol = EXCLUDED.require_symbol,
+			reject_breached = EXCLUDED.reject_breached,
+			history_size = EXCLUDED.history_size,
+			updated_at = EXCLUDED.updated_at
+	`, tenantID, input.MinLength, input.RequireUppercase, input.RequireLowercase, input.RequireDigit,
+		input.RequireSymbol, input.RejectBreached, input.HistorySize, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return GetTenantPasswordPolicy(db, tenantID)
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TenantRedirectURI is a target the tenant's users may be redirected to after login
+type TenantRedirectURI struct {
+	ID        int       `json:"id"`
+	TenantID  int       `json:"tenantId"`
+	URI       string    `json:"uri"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CreateTenantRedirectURIInput represents the input for allowing a redirect target
+type CreateTenantRedirectURIInput struct {
+	URI string `json:"uri" binding:"required"`
+}
+
+// ValidateRedirectURIPattern checks an allow-list entry is an absolute http(s)
+// URL without credentials, query or fragment
+func ValidateRedirectURIPattern(uri string) error {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return errors.New("invalid redirect URI")
+	}
+	if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return errors.New("redirect URI must use http or https")
+	}
+	if parsed.Host == "" {
+		return errors.New("redirect URI must be absolute")
+	}
+	if parsed.User != nil || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return errors.New("redirect URI must not contain credentials, a query or a fragment")
+	}
+	return nil
+}
+
+// ListTenantRedirectURIs lists a tenant's allowed redirect targets
+func ListTenantRedirectURIs(db *sql.DB, tenantID int) ([]TenantRedirectURI, error) {
+	rows, err := db.Query(`
+		SELECT id, tenant_id, uri, created_at
+		FROM tenant_redirect_uris
+		WHERE tenant_id = $1
+		ORDER BY uri
+	`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uris := []TenantRedirectURI{}
+	for rows.Next() {
+		var uri TenantRedirectURI
+		if err := rows.Scan(&uri.ID, &uri.TenantID, &uri.URI, &uri.CreatedAt); err != nil {
+			return nil, err
+		}
+		uris = append(uris, uri)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return uris, nil
+}
+
+// CreateTenantRedirectURI allows a redirect target for a tenant
+func CreateTenantRedirectURI(db *sql.DB, tenantID int, input CreateTenantRedirectURIInput) (*TenantRedirectURI, error) {
+	if err := ValidateRedirectURIPattern(input.URI); err != nil {
+		return nil, err
+	}
+
+	uri := &TenantRedirectURI{
+		TenantID:  tenantID,
+		URI:       input.URI,
+		CreatedAt: time.Now(),
+	}
+
+	err := db.QueryRow(`
+		INSERT INTO tenant_redirect_uris (tenant_id, uri, created_at)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, uri.TenantID, uri.URI, uri.CreatedAt).Scan(&uri.ID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, errors.New("redirect URI already exists")
+		}
+		return nil, err
+	}
+
+	return uri, nil
+}
+
+// DeleteTenantRedirectURI removes one of the tenant's allowed redirect targets
+func DeleteTenantRedirectURI(db *sql.DB, tenantID int, id int) error {
+	result, err := db.Exec("DELETE FROM tenant_redirect_uris WHERE id = $1 AND tenant_id = $2", id, tenantID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("redirect URI not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RevokeToken revokes a single access token by its ID (jti). The entry is
+// kept until expiresAt, after which the token is rejected as expired anyway.
+func RevokeToken(db *sql.DB, jti string, userID int, expiresAt time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO revoked_tokens (jti, user_id, expires_at, revoked_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (jti) DO NOTHING
+	`, jti, userID, expiresAt, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// Drop entries for tokens that have expired since they were revoked
+	_, err = db.Exec("DELETE FROM revoked_tokens WHERE expires_at < NOW()")
+	return err
+}
+
+// RevokeAllUserTokens revokes every access and refresh token issued to a user
+// up to now, signing them out of all sessions
+func RevokeAllUserTokens(db *sql.DB, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Tokens issued after this microsecond are not revoked. The cutoff is
+	// stored as PostgreSQL would, to the microsecond.
+	now := time.Now().Truncate(time.Microsecond)
+
+	_, err = tx.Exec(`
+		INSERT INTO user_token_revocations (user_id, revoked_at)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET revoked_at = EXCLUDED.revoked_at
+	`, userID, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE refresh_tokens SET revoked_at = $1
+		WHERE user_id = $2 AND revoked_at IS NULL
+	`, now, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_sessions SET revoked_at = $1
+		WHERE user_id = $2 AND revoked_at IS NULL
+	`, now, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IsTokenRevoked checks whether an access token has been revoked, either by
+// its ID, because its session was revoked or because all of the user's
+// tokens were revoked at or after the time it was issued
+func IsTokenRevoked(db *sql.DB, jti string, sessionID string, userID int, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	err := db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+			OR EXISTS (SELECT 1 FROM user_sessions WHERE id = $2 AND revoked_at IS NOT NULL)
+			OR EXISTS (SELECT 1 FROM user_token_revocations WHERE user_id = $3 AND revoked_at >= $4)
+	`, jti, sessionID, userID, issuedAt).Scan(&revoked)
+	if err != nil {
+		return false, err
+	}
+	return revoked, nil
+}
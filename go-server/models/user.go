@@ -23,6 +23,55 @@ type User struct {
         CasdoorID    *string    `json:"casdoorId"`
         CreatedAt    time.Time  `json:"createdAt"`
         UpdatedAt    time.Time  `json:"updatedAt"`
+
+        // FailedLoginAttempts counts password failures since the last success.
+        // While LockedUntil is in the future password logins are refused.
+        FailedLoginAttempts int        `json:"failedLoginAttempts"`
+        LockedUntil         *time.Time `json:"lockedUntil"`
+
+        // MustChangePassword is set on accounts whose password was chosen for
+        // them; they cannot sign in normally until they pick a new one
+        MustChangePassword bool `json:"mustChangePassword"`
+
+        // EmailVerifiedAt is when the user proved they control Email, or nil
+        // if they have not. Changing Email clears it.
+        EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+
+        // IsServiceAccount marks a machine identity used by integrations. It
+        // has no usable password and signs in only with access tokens.
+        IsServiceAccount bool `json:"isServiceAccount"`
+}
+
+// CreateUserInput represents the input for creating a user
+type CreateUserInput struct {
+        Username           string  `json:"username" binding:"required"`
+        Password           string  `json:"password" binding:"required"`
+        Email              string  `json:"email" binding:"required,email"`
+        DisplayName        string  `json:"displayName" binding:"required"`
+        Avatar             *string `json:"avatar"`
+        // TenantID and IsSuperAdmin are only taken from super admins;
+        // other users create users of their current tenant
+        TenantID           int     `json:"tenantId"`
+        IsActive           *bool   `json:"isActive"`
+        IsSuperAdmin       bool    `json:"isSuperAdmin"`
+        MustChangePassword bool    `json:"mustChangePassword"`
+}
+
+// UpdateUserInput represents the input for updating a user.
+// Only non-nil fields are written.
+type UpdateUserInput struct {
+        Username     *string `json:"username"`
+        Password     *string `json:"password"`
+        Email        *string `json:"email"`
+        DisplayName  *string `json:"displayName"`
+        Avatar       *string `json:"avatar"`
+        TenantID     *int    `json:"tenantId"`
+        IsActive     *bool   `json:"isActive"`
+        IsSuperAdmin *bool   `json:"isSuperAdmin"`
+        CasdoorID    *string `json:"-"`
+
+        // MustChangePassword defaults to false whenever Password is set
+        MustChangePassword *bool `json:"mustChangePassword"`
 }
 
 // GetUser retrieves a user by ID
@@ -34,7 +83,9 @@ func GetUser(db *sql.DB, id int) (*User, error) {
         query := `
                 SELECT id, username, password, email, display_name, 
                        avatar, tenant_id, is_active, is_super_admin, 
-                       last_login, casdoor_id, created_at, updated_at
+                       last_login, casdoor_id, created_at, updated_at,
+                       failed_login_attempts, locked_until, must_change_password,
+                       email_verified_at, is_service_account
                 FROM users
                 WHERE id = $1
         `
@@ -43,6 +94,8 @@ func GetUser(db *sql.DB, id int) (*User, error) {
                 &user.ID, &user.Username, &user.Password, &user.Email, 
                 &user.DisplayName, &avatar, &user.TenantID, &user.IsActive, 
                 &user.IsSuperAdmin, &lastLogin, &casdoorID, &user.CreatedAt, &user.UpdatedAt,
+                &user.FailedLoginAttempts, &user.LockedUntil, &user.MustChangePassword,
+                &user.EmailVerifiedAt, &user.IsServiceAccount,
         )
 
         if err != nil {
@@ -75,7 +128,9 @@ func GetUserByUsername(db *sql.DB, username string) (*User, error) {
         query := `
                 SELECT id, username, password, email, display_name, 
                        avatar, tenant_id, is_active, is_super_admin, 
-                       last_login, casdoor_id, created_at, updated_at
+                       last_login, casdoor_id, created_at, updated_at,
+                       failed_login_attempts, locked_until, must_change_password,
+                       email_verified_at, is_service_account
                 FROM users
                 WHERE username = $1
         `
@@ -84,6 +139,8 @@ func GetUserByUsername(db *sql.DB, username string) (*User, error) {
                 &user.ID, &user.Username, &user.Password, &user.Email, 
                 &user.DisplayName, &avatar, &user.TenantID, &user.IsActive, 
                 &user.IsSuperAdmin, &lastLogin, &casdoorID, &user.CreatedAt, &user.UpdatedAt,
+                &user.FailedLoginAttempts, &user.LockedUntil, &user.MustChangePassword,
+                &user.EmailVerifiedAt, &user.IsServiceAccount,
         )
 
         if err != nil {
@@ -116,7 +173,9 @@ func GetUserByCasdoorID(db *sql.DB, casdoorID string) (*User, error) {
         query := `
                 SELECT id, username, password, email, display_name, 
                        avatar, tenant_id, is_active, is_super_admin, 
-                       last_login, casdoor_id, created_at, updated_at
+                       last_login, casdoor_id, created_at, updated_at,
+                       failed_login_attempts, locked_until, must_change_password,
+                       email_verified_at, is_service_account
                 FROM users
                 WHERE casdoor_id = $1
         `
@@ -125,6 +184,8 @@ func GetUserByCasdoorID(db *sql.DB, casdoorID string) (*User, error) {
                 &user.ID, &user.Username, &user.Password, &user.Email, 
                 &user.DisplayName, &avatar, &user.TenantID, &user.IsActive, 
                 &user.IsSuperAdmin, &lastLogin, &casdoorIDNull, &user.CreatedAt, &user.UpdatedAt,
+                &user.FailedLoginAttempts, &user.LockedUntil, &user.MustChangePassword,
+                &user.EmailVerifiedAt, &user.IsServiceAccount,
         )
 
         if err != nil {
@@ -169,6 +230,10 @@ func CreateUser(db *sql.DB, user *User) (*User, error) {
                 return nil, fmt.Errorf("email already exists")
         }
 
+        if user.Password == "" {
+                return nil, fmt.Errorf("password is required")
+        }
+
         // Hash the password
         hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
         if err != nil {
@@ -202,9 +267,10 @@ func CreateUser(db *sql.DB, user *User) (*User, error) {
                 INSERT INTO users (
                         username, password, email, display_name, avatar,
                         tenant_id, is_active, is_super_admin, last_login,
-                        casdoor_id, created_at, updated_at
+                        casdoor_id, created_at, updated_at, must_change_password,
+                        password_changed_at, is_service_account
                 )
-                VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+                VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
                 RETURNING id
         `
 
@@ -212,13 +278,18 @@ func CreateUser(db *sql.DB, user *User) (*User, error) {
                 query,
                 user.Username, string(hashedPassword), user.Email, user.DisplayName, avatar,
                 user.TenantID, user.IsActive, user.IsSuperAdmin, lastLogin,
-                casdoorID, user.CreatedAt, user.UpdatedAt,
+                casdoorID, user.CreatedAt, user.UpdatedAt, user.MustChangePassword,
+                now, user.IsServiceAccount,
         ).Scan(&user.ID)
 
         if err != nil {
                 return nil, err
         }
 
+        if err := AddPasswordHistory(db, user.ID, string(hashedPassword)); err != nil {
+                return nil, err
+        }
+
         // Return the created user (but with password stripped)
         createdUser, err := GetUser(db, user.ID)
         if err != nil {
@@ -229,9 +300,9 @@ func CreateUser(db *sql.DB, user *User) (*User, error) {
 }
 
 // UpdateUser updates a user's information
-func UpdateUser(db *sql.DB, id int, updates map[string]interface{}) (*User, error) {
+func UpdateUser(db *sql.DB, id int, input UpdateUserInput) (*User, error) {
         // Get the current user to verify it exists
-        _, err := GetUser(db, id)
+        current, err := GetUser(db, id)
         if err != nil {
                 return nil, err
         }
@@ -239,35 +310,63 @@ func UpdateUser(db *sql.DB, id int, updates map[string]interface{}) (*User, erro
         // Build the update query
         query := "UPDATE users SET updated_at = NOW()"
         var values []interface{}
-        i := 1
-
-        // Add updated fields
-        for field, value := range updates {
-                query += fmt.Sprintf(", %s = $%d", getDBFieldName(field), i)
-                
-                // Handle password separately for hashing
-                if field == "password" {
-                        password, ok := value.(string)
-                        if !ok {
-                                return nil, fmt.Errorf("invalid password type")
-                        }
-                        
-                        hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-                        if err != nil {
-                                return nil, err
-                        }
-                        
-                        values = append(values, string(hashedPassword))
-                } else {
-                        values = append(values, value)
+
+        set := func(column string, value interface{}) {
+                values = append(values, value)
+                query += fmt.Sprintf(", %s = $%d", column, len(values))
+        }
+
+        if input.Username != nil {
+                set("username", *input.Username)
+        }
+        var hashedPassword []byte
+        if input.Password != nil {
+                if *input.Password == "" {
+                        return nil, fmt.Errorf("password is required")
+                }
+
+                // Hash the new password before storing it
+                hashedPassword, err = bcrypt.GenerateFromPassword([]byte(*input.Password), bcrypt.DefaultCost)
+                if err != nil {
+                        return nil, err
+                }
+                set("password", string(hashedPassword))
+                set("password_changed_at", time.Now())
+        }
+        if input.Email != nil {
+                set("email", *input.Email)
+                if *input.Email != current.Email {
+                        // The new address has not been verified
+                        set("email_verified_at", nil)
                 }
-                
-                i++
+        }
+        if input.DisplayName != nil {
+                set("display_name", *input.DisplayName)
+        }
+        if input.Avatar != nil {
+                set("avatar", *input.Avatar)
+        }
+        if input.TenantID != nil {
+                set("tenant_id", *input.TenantID)
+        }
+        if input.IsActive != nil {
+                set("is_active", *input.IsActive)
+        }
+        if input.IsSuperAdmin != nil {
+                set("is_super_admin", *input.IsSuperAdmin)
+        }
+        if input.CasdoorID != nil {
+                set("casdoor_id", *input.CasdoorID)
+        }
+        if input.MustChangePassword != nil {
+                set("must_change_password", *input.MustChangePassword)
+        } else if input.Password != nil {
+                set("must_change_password", false)
         }
 
         // Complete the query
-        query += fmt.Sprintf(" WHERE id = $%d", i)
         values = append(values, id)
+        query += fmt.Sprintf(" WHERE id = $%d", len(values))
 
         // Execute the update
         _, err = db.Exec(query, values...)
@@ -275,10 +374,66 @@ func UpdateUser(db *sql.DB, id int, updates map[string]interface{}) (*User, erro
                 return nil, err
         }
 
+        if hashedPassword != nil {
+                if err := AddPasswordHistory(db, id, string(hashedPassword)); err != nil {
+                        return nil, err
+                }
+        }
+
+        // Deactivating a user, changing their password or requiring them to
+        // change it signs them out everywhere
+        if input.Password != nil || (input.IsActive != nil && !*input.IsActive) ||
+                (input.MustChangePassword != nil && *input.MustChangePassword) {
+                if err := RevokeAllUserTokens(db, id); err != nil {
+                        return nil, err
+                }
+        }
+
         // Return the updated user
         return GetUser(db, id)
 }
 
+// GetUserByEmail retrieves a user by email address, ignoring case
+func GetUserByEmail(db *sql.DB, email string) (*User, error) {
+        var userID int
+        err := db.QueryRow("SELECT id FROM users WHERE LOWER(email) = LOWER($1)", email).Scan(&userID)
+        if err != nil {
+                return nil, err
+        }
+        return GetUser(db, userID)
+}
+
+// MarkEmailVerified records that a user has verified their email address.
+// It reports false if the user's address is no longer email, e.g. because
+// it was changed after the verification link was sent.
+func MarkEmailVerified(db *sql.DB, id int, email string) (bool, error) {
+        result, err := db.Exec(`
+                UPDATE users SET email_verified_at = NOW()
+                WHERE id = $1 AND email = $2 AND email_verified_at IS NULL
+        `, id, email)
+        if err != nil {
+                return false, err
+        }
+
+        rowsAffected, err := result.RowsAffected()
+        if err != nil {
+                return false, err
+        }
+        if rowsAffected > 0 {
+                return true, nil
+        }
+
+        // Already verified counts as verified
+        var verified bool
+        err = db.QueryRow(`
+                SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1 AND email = $2
+        `, id, email).Scan(&verified)
+        if err == sql.ErrNoRows {
+                return false, nil
+        }
+        return verified, err
+}
+
 // UpdateLastLogin updates a user's last login time
 func UpdateLastLogin(db *sql.DB, id int) error {
         _, err := db.Exec("UPDATE users SET last_login = NOW() WHERE id = $1", id)
@@ -295,7 +450,9 @@ func ListUsers(db *sql.DB, tenantID *int) ([]*User, error) {
                 query = `
                         SELECT id, username, password, email, display_name, 
                                avatar, tenant_id, is_active, is_super_admin, 
-                               last_login, casdoor_id, created_at, updated_at
+                               last_login, casdoor_id, created_at, updated_at,
+                               failed_login_attempts, locked_until, must_change_password,
+                               email_verified_at, is_service_account
                         FROM users
                         WHERE tenant_id = $1
                         ORDER BY username
@@ -305,7 +462,9 @@ func ListUsers(db *sql.DB, tenantID *int) ([]*User, error) {
                 query = `
                         SELECT id, username, password, email, display_name, 
                                avatar, tenant_id, is_active, is_super_admin, 
-                               last_login, casdoor_id, created_at, updated_at
+                               last_login, casdoor_id, created_at, updated_at,
+                               failed_login_attempts, locked_until, must_change_password,
+                               email_verified_at, is_service_account
                         FROM users
                         ORDER BY username
                 `
@@ -327,6 +486,8 @@ func ListUsers(db *sql.DB, tenantID *int) ([]*User, error) {
                         &user.ID, &user.Username, &user.Password, &user.Email, 
                         &user.DisplayName, &avatar, &user.TenantID, &user.IsActive, 
                         &user.IsSuperAdmin, &lastLogin, &casdoorID, &user.CreatedAt, &user.UpdatedAt,
+                        &user.FailedLoginAttempts, &user.LockedUntil, &user.MustChangePassword,
+                        &user.EmailVerifiedAt, &user.IsServiceAccount,
                 )
 
                 if err != nil {
@@ -358,7 +519,14 @@ func ListUsers(db *sql.DB, tenantID *int) ([]*User, error) {
 // DeleteUser deletes a user by ID
 func DeleteUser(db *sql.DB, id int) error {
         _, err := db.Exec("DELETE FROM users WHERE id = $1", id)
-        return err
+        if err != nil {
+                return err
+        }
+
+        if policyObserver != nil {
+                policyObserver.UserRemoved(id)
+        }
+        return nil
 }
 
 // VerifyPassword verifies a password against a hashed password
@@ -366,37 +534,3 @@ func VerifyPassword(hashedPassword, password string) bool {
         err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
         return err == nil
 }
-
-// GetUserRoles gets the roles assigned to a user
-func GetUserRoles(userId int) ([]Role, error) {
-        // Will be implemented in role.go
-        return []Role{}, nil
-}
-
-// Helper function to convert camelCase field names to snake_case for DB
-func getDBFieldName(fieldName string) string {
-        switch fieldName {
-        case "username":
-                return "username"
-        case "password":
-                return "password"
-        case "email":
-                return "email"
-        case "displayName":
-                return "display_name"
-        case "avatar":
-                return "avatar"
-        case "tenantId":
-                return "tenant_id"
-        case "isActive":
-                return "is_active"
-        case "isSuperAdmin":
-                return "is_super_admin"
-        case "lastLogin":
-                return "last_login"
-        case "casdoorId":
-                return "casdoor_id"
-        default:
-                return fieldName
-        }
-}
\ No newline at end of file
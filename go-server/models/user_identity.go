@@ -0,0 +1,39 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// GetUserByIdentity retrieves the user linked to a subject at an OIDC connection
+func GetUserByIdentity(db *sql.DB, connectionID int, subject string) (*User, error) {
+	var userID int
+	err := db.QueryRow(`
+		SELECT user_id FROM user_identities WHERE connection_id = $1 AND subject = $2
+	`, connectionID, subject).Scan(&userID)
+	if err != nil {
+		return nil, err
+	}
+	return GetUser(db, userID)
+}
+
+// LinkUserIdentity links a user to a subject at an OIDC connection
+func LinkUserIdentity(db *sql.DB, userID int, connectionID int, subject string) error {
+	_, err := db.Exec(`
+		INSERT INTO user_identities (user_id, connection_id, subject, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, connectionID, subject, time.Now())
+	return err
+}
+
+// UserHasExternalIdentity reports whether a user signs in through Casdoor,
+// an OIDC connection or a SAML connection
+func UserHasExternalIdentity(db *sql.DB, userID int) (bool, error) {
+	var linked bool
+	err := db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND casdoor_id IS NOT NULL)
+			OR EXISTS (SELECT 1 FROM user_identities WHERE user_id = $1)
+			OR EXISTS (SELECT 1 FROM saml_identities WHERE user_id = $1)
+	`, userID).Scan(&linked)
+	return linked, err
+}
@@ -0,0 +1,292 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// UserMFA is a user's TOTP enrolment. It is pending until the user proves
+// their authenticator works by confirming a code.
+type UserMFA struct {
+	UserID int    `json:"userId"`
+	Secret string `json:"-"`
+	// ConfirmedAt is nil while enrolment is pending
+	ConfirmedAt *time.Time `json:"confirmedAt"`
+	// LastUsedStep is the TOTP time step of the last accepted code, so no
+	// code is accepted twice
+	LastUsedStep int64     `json:"-"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// Enabled reports whether the enrolment has been confirmed
+func (m *UserMFA) Enabled() bool {
+	return m != nil && m.ConfirmedAt != nil
+}
+
+// MFAChallenge is the second step of a login waiting for a code
+type MFAChallenge struct {
+	ID        string
+	UserID    int
+	Attempts  int
+	ExpiresAt time.Time
+}
+
+// GetUserMFA retrieves a user's TOTP enrolment. It returns nil if the user
+// has never started one.
+func GetUserMFA(db *sql.DB, userID int) (*UserMFA, error) {
+	var mfa UserMFA
+	err := db.QueryRow(`
+		SELECT user_id, secret, confirmed_at, last_used_step, created_at, updated_at
+		FROM user_mfa
+		WHERE user_id = $1
+	`, userID).Scan(
+		&mfa.UserID,
+		&mfa.Secret,
+		&mfa.ConfirmedAt,
+		&mfa.LastUsedStep,
+		&mfa.CreatedAt,
+		&mfa.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &mfa, nil
+}
+
+// StartUserMFAEnrollment stores a new secret for a pending enrolment,
+// replacing any earlier pending one. Confirmed enrolments must be disabled
+// before a new one can start.
+func StartUserMFAEnrollment(db *sql.DB, userID int, secret string) error {
+	now := time.Now()
+	result, err := db.Exec(`
+		INSERT INTO user_mfa (user_id, secret, created_at, updated_at)
+		VALUES ($1, $2, $3, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at
+		WHERE user_mfa.confirmed_at IS NULL
+	`, userID, secret, now)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("mfa already enabled")
+	}
+	return nil
+}
+
+// ConfirmUserMFA enables a pending enrolment once the user has entered a code
+// from the given time step, and stores the hashes of their recovery codes
+func ConfirmUserMFA(db *sql.DB, userID int, step int64, recoveryCodeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE user_mfa
+		SET confirmed_at = $2, last_used_step = $3, updated_at = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL
+	`, userID, time.Now(), step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("no pending mfa enrollment")
+	}
+
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseMFAStep records that a code from the given time step was accepted. It
+// reports false if a code from that step or a later one was already used,
+// so a code seen over someone's shoulder cannot be replayed.
+func UseMFAStep(db *sql.DB, userID int, step int64) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE user_mfa
+		SET last_used_step = $2, updated_at = $3
+		WHERE user_id = $1 AND last_used_step < $2
+	`, userID, step, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// DisableUserMFA removes a user's TOTP enrolment and recovery codes
+func DisableUserMFA(db *sql.DB, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_mfa WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes swaps a user's recovery codes for a new set
+func ReplaceRecoveryCodes(db *sql.DB, userID int, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// replaceRecoveryCodes deletes a user's recovery codes and inserts the new hashes
+func replaceRecoveryCodes(tx *sql.Tx, userID int, codeHashes []string) error {
+	if _, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, hash := range codeHashes {
+		_, err := tx.Exec(`
+			INSERT INTO mfa_recovery_codes (user_id, code_hash, created_at)
+			VALUES ($1, $2, $3)
+		`, userID, hash, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks one of a user's unused recovery codes as used. It
+// reports false if the user has no unused code with that hash.
+func UseRecoveryCode(db *sql.DB, userID int, codeHash string) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE mfa_recovery_codes
+		SET used_at = $3
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, codeHash, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// CountUnusedRecoveryCodes counts a user's remaining recovery codes
+func CountUnusedRecoveryCodes(db *sql.DB, userID int) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL
+	`, userID).Scan(&count)
+	return count, err
+}
+
+// CreateMFAChallenge records the second step of a login. The ID is the ID of
+// the mfa_pending token handed to the client.
+func CreateMFAChallenge(db *sql.DB, challenge MFAChallenge) error {
+	_, err := db.Exec(`
+		INSERT INTO mfa_challenges (id, user_id, attempts, expires_at)
+		VALUES ($1, $2, 0, $3)
+	`, challenge.ID, challenge.UserID, challenge.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	// Drop challenges that were abandoned
+	_, err = db.Exec("DELETE FROM mfa_challenges WHERE expires_at < NOW()")
+	return err
+}
+
+// GetMFAChallenge retrieves an unexpired challenge. It returns nil if there
+// is no such challenge.
+func GetMFAChallenge(db *sql.DB, id string) (*MFAChallenge, error) {
+	var challenge MFAChallenge
+	err := db.QueryRow(`
+		SELECT id, user_id, attempts, expires_at
+		FROM mfa_challenges
+		WHERE id = $1 AND expires_at > NOW()
+	`, id).Scan(&challenge.ID, &challenge.UserID, &challenge.Attempts, &challenge.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// FailMFAChallenge counts a wrong code against a challenge, deleting the
+// challenge once maxAttempts is reached so the login has to start over. It
+// returns the attempts left.
+func FailMFAChallenge(db *sql.DB, id string, maxAttempts int) (int, error) {
+	var attempts int
+	err := db.QueryRow(`
+		UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts
+	`, id).Scan(&attempts)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if attempts >= maxAttempts {
+		if _, err := db.Exec("DELETE FROM mfa_challenges WHERE id = $1", id); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+	return maxAttempts - attempts, nil
+}
+
+// ConsumeMFAChallenge removes a challenge once it has been answered, so each
+// mfa_pending token completes at most one login. It reports false if the
+// challenge was already consumed or has expired.
+func ConsumeMFAChallenge(db *sql.DB, id string) (bool, error) {
+	result, err := db.Exec("DELETE FROM mfa_challenges WHERE id = $1 AND expires_at > NOW()", id)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
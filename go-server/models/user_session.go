@@ -0,0 +1,212 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// UserSession records where a user is signed in. There is one per token
+// family: its ID is the session ID carried by the access tokens and shared
+// by the refresh tokens of the family.
+type UserSession struct {
+	ID       string `json:"id"`
+	UserID   int    `json:"userId"`
+	TenantID int    `json:"tenantId"`
+	// Device is a readable description of the browser and platform
+	Device     string     `json:"device"`
+	UserAgent  string     `json:"userAgent"`
+	IPAddress  string     `json:"ipAddress"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastSeenAt time.Time  `json:"lastSeenAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// sessionTouchInterval limits how often a session's last-seen time is
+// written, so busy clients do not turn every request into a write
+const sessionTouchInterval = time.Minute
+
+const userSessionColumns = `
+	id, user_id, tenant_id, device, user_agent, ip_address,
+	created_at, last_seen_at, expires_at, revoked_at
+`
+
+func scanUserSession(row rowScanner) (*UserSession, error) {
+	var session UserSession
+	err := row.Scan(
+		&session.ID, &session.UserID, &session.TenantID, &session.Device,
+		&session.UserAgent, &session.IPAddress, &session.CreatedAt, &session.LastSeenAt,
+		&session.ExpiresAt, &session.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// CreateUserSession records a new session
+func CreateUserSession(db *sql.DB, session *UserSession) error {
+	now := time.Now()
+	session.CreatedAt = now
+	session.LastSeenAt = now
+	_, err := db.Exec(`
+		INSERT INTO user_sessions (
+			id, user_id, tenant_id, device, user_agent, ip_address,
+			created_at, last_seen_at, expires_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (id) DO NOTHING
+	`, session.ID, session.UserID, session.TenantID, session.Device,
+		session.UserAgent, session.IPAddress, now, now, session.ExpiresAt)
+	return err
+}
+
+// ListUserSessions lists a user's sessions that are neither revoked nor
+// expired, most recently seen first
+func ListUserSessions(db *sql.DB, userID int) ([]*UserSession, error) {
+	rows, err := db.Query(`
+		SELECT `+userSessionColumns+`
+		FROM user_sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_seen_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*UserSession{}
+	for rows.Next() {
+		session, err := scanUserSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// GetUserSession retrieves one of a user's sessions. It returns nil if there
+// is none.
+func GetUserSession(db *sql.DB, userID int, id string) (*UserSession, error) {
+	session, err := scanUserSession(db.QueryRow(`
+		SELECT `+userSessionColumns+` FROM user_sessions WHERE id = $1 AND user_id = $2
+	`, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return session, err
+}
+
+// TouchUserSession records that a session was used from the given address.
+// Writes are skipped if the session was seen within the last minute.
+func TouchUserSession(db *sql.DB, id string, ip string, seenAt time.Time) error {
+	_, err := db.Exec(`
+		UPDATE user_sessions SET last_seen_at = $2, ip_address = $3
+		WHERE id = $1 AND revoked_at IS NULL AND last_seen_at < $4
+	`, id, seenAt, ip, seenAt.Add(-sessionTouchInterval))
+	return err
+}
+
+// ExtendUserSession records a refresh of the session: it was seen now and
+// lasts as long as its newest refresh token
+func ExtendUserSession(db *sql.DB, id string, ip string, expiresAt time.Time) error {
+	_, err := db.Exec(`
+		UPDATE user_sessions SET last_seen_at = $2, ip_address = $3, expires_at = $4
+		WHERE id = $1 AND revoked_at IS NULL
+	`, id, time.Now(), ip, expiresAt)
+	return err
+}
+
+// SetUserSessionTenant records a tenant switch within a session
+func SetUserSessionTenant(db *sql.DB, id string, tenantID int) error {
+	_, err := db.Exec(`UPDATE user_sessions SET tenant_id = $2 WHERE id = $1`, id, tenantID)
+	return err
+}
+
+// RevokeUserSession revokes one of a user's sessions together with its
+// refresh tokens. Its access tokens are rejected from then on.
+func RevokeUserSession(db *sql.DB, userID int, id string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		UPDATE user_sessions SET revoked_at = $3
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, id, userID, now)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("session not found")
+	}
+
+	_, err = tx.Exec(`
+		UPDATE refresh_tokens SET revoked_at = $1
+		WHERE family_id = $2 AND revoked_at IS NULL
+	`, now, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeOtherUserSessions revokes every session of a user except one,
+// together with their refresh tokens, and returns how many were revoked
+func RevokeOtherUserSessions(db *sql.DB, userID int, keepID string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		UPDATE user_sessions SET revoked_at = $3
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+	`, userID, keepID, now)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE refresh_tokens SET revoked_at = $3
+		WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL
+	`, userID, keepID, now)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
+
+// EndUserSession marks a session as revoked, such as when the user logs out
+func EndUserSession(db *sql.DB, id string) error {
+	_, err := db.Exec(`
+		UPDATE user_sessions SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL
+	`, id, time.Now())
+	return err
+}
@@ -0,0 +1,243 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// WebAuthnCredential is a passkey registered to a user. The private key
+// never leaves the user's authenticator; we keep the public key and the
+// signature counter used to spot cloned authenticators.
+type WebAuthnCredential struct {
+	ID           int    `json:"id"`
+	UserID       int    `json:"userId"`
+	Name         string `json:"name"`
+	CredentialID []byte `json:"-"`
+	PublicKey    []byte `json:"-"`
+	// AttestationType is the attestation format the authenticator used at registration
+	AttestationType string   `json:"-"`
+	Transports      []string `json:"transports"`
+	AAGUID          []byte   `json:"-"`
+	SignCount       int64    `json:"-"`
+	// CloneWarning is set when an assertion carried a signature counter that
+	// did not move forward, and blocks further logins with the credential
+	CloneWarning   bool       `json:"cloneWarning"`
+	BackupEligible bool       `json:"backupEligible"`
+	BackupState    bool       `json:"backupState"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	LastUsedAt     *time.Time `json:"lastUsedAt"`
+}
+
+// WebAuthnSession is a registration or login ceremony waiting for the
+// authenticator's response. Data holds the WebAuthn library's session state.
+type WebAuthnSession struct {
+	ID       string
+	Ceremony string
+	// UserID is nil for logins, where the user is not known until the
+	// authenticator answers
+	UserID    *int
+	Name      string
+	Data      []byte
+	ExpiresAt time.Time
+}
+
+// webAuthnCredentialColumns are selected by every credential query
+const webAuthnCredentialColumns = `
+	id, user_id, name, credential_id, public_key, attestation_type, transports, aaguid,
+	sign_count, clone_warning, backup_eligible, backup_state, created_at, last_used_at
+`
+
+// scanWebAuthnCredential scans a row selected with webAuthnCredentialColumns
+func scanWebAuthnCredential(row rowScanner) (*WebAuthnCredential, error) {
+	var cred WebAuthnCredential
+	err := row.Scan(
+		&cred.ID,
+		&cred.UserID,
+		&cred.Name,
+		&cred.CredentialID,
+		&cred.PublicKey,
+		&cred.AttestationType,
+		pq.Array(&cred.Transports),
+		&cred.AAGUID,
+		&cred.SignCount,
+		&cred.CloneWarning,
+		&cred.BackupEligible,
+		&cred.BackupState,
+		&cred.CreatedAt,
+		&cred.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if cred.Transports == nil {
+		cred.Transports = []string{}
+	}
+	return &cred, nil
+}
+
+// ListWebAuthnCredentials lists a user's passkeys
+func ListWebAuthnCredentials(db *sql.DB, userID int) ([]*WebAuthnCredential, error) {
+	rows, err := db.Query(`
+		SELECT `+webAuthnCredentialColumns+`
+		FROM webauthn_credentials
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []*WebAuthnCredential{}
+	for rows.Next() {
+		cred, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, cred)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+// CreateWebAuthnCredential stores a newly registered passkey
+func CreateWebAuthnCredential(db *sql.DB, cred *WebAuthnCredential) (*WebAuthnCredential, error) {
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO webauthn_credentials (user_id, name, credential_id, public_key, attestation_type, transports, aaguid,
+			sign_count, clone_warning, backup_eligible, backup_state, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, false, $9, $10, $11)
+		RETURNING id
+	`,
+		cred.UserID,
+		cred.Name,
+		cred.CredentialID,
+		cred.PublicKey,
+		cred.AttestationType,
+		pq.Array(nonNilStrings(cred.Transports)),
+		cred.AAGUID,
+		cred.SignCount,
+		cred.BackupEligible,
+		cred.BackupState,
+		time.Now(),
+	).Scan(&id)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, errors.New("credential already registered")
+		}
+		return nil, err
+	}
+
+	return scanWebAuthnCredential(db.QueryRow(`
+		SELECT `+webAuthnCredentialColumns+` FROM webauthn_credentials WHERE id = $1
+	`, id))
+}
+
+// RecordWebAuthnCredentialUse stores the signature counter and flags from a
+// login with a passkey
+func RecordWebAuthnCredentialUse(db *sql.DB, id int, signCount int64, cloneWarning bool, backupState bool) error {
+	_, err := db.Exec(`
+		UPDATE webauthn_credentials
+		SET sign_count = $2, clone_warning = clone_warning OR $3, backup_state = $4, last_used_at = $5
+		WHERE id = $1
+	`, id, signCount, cloneWarning, backupState, time.Now())
+	return err
+}
+
+// DeleteWebAuthnCredential revokes one of a user's passkeys
+func DeleteWebAuthnCredential(db *sql.DB, userID int, id int) error {
+	result, err := db.Exec("DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("credential not found")
+	}
+
+	return nil
+}
+
+// EnsureWebAuthnUserHandle returns the opaque handle a user's passkeys are
+// registered under, storing candidate as the handle if the user has none.
+// The handle stands in for the user ID so authenticators never see it.
+func EnsureWebAuthnUserHandle(db *sql.DB, userID int, candidate []byte) ([]byte, error) {
+	_, err := db.Exec(`
+		INSERT INTO webauthn_user_handles (user_id, handle, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO NOTHING
+	`, userID, candidate, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var handle []byte
+	err = db.QueryRow("SELECT handle FROM webauthn_user_handles WHERE user_id = $1", userID).Scan(&handle)
+	if err != nil {
+		return nil, err
+	}
+	return handle, nil
+}
+
+// GetUserByWebAuthnHandle retrieves the user a passkey user handle belongs to
+func GetUserByWebAuthnHandle(db *sql.DB, handle []byte) (*User, error) {
+	var userID int
+	err := db.QueryRow("SELECT user_id FROM webauthn_user_handles WHERE handle = $1", handle).Scan(&userID)
+	if err != nil {
+		return nil, err
+	}
+	return GetUser(db, userID)
+}
+
+// CreateWebAuthnSession records a ceremony so the authenticator's response can be matched to it
+func CreateWebAuthnSession(db *sql.DB, session WebAuthnSession) error {
+	_, err := db.Exec(`
+		INSERT INTO webauthn_sessions (id, ceremony, user_id, name, data, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, session.ID, session.Ceremony, session.UserID, session.Name, session.Data, session.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	// Drop ceremonies that were never completed
+	_, err = db.Exec("DELETE FROM webauthn_sessions WHERE expires_at < NOW()")
+	return err
+}
+
+// ConsumeWebAuthnSession removes and returns an unexpired ceremony of the
+// given kind, so each challenge is answered at most once. It returns nil if
+// there is no such ceremony.
+func ConsumeWebAuthnSession(db *sql.DB, id string, ceremony string) (*WebAuthnSession, error) {
+	var session WebAuthnSession
+	err := db.QueryRow(`
+		DELETE FROM webauthn_sessions
+		WHERE id = $1 AND ceremony = $2 AND expires_at > NOW()
+		RETURNING id, ceremony, user_id, name, data, expires_at
+	`, id, ceremony).Scan(
+		&session.ID,
+		&session.Ceremony,
+		&session.UserID,
+		&session.Name,
+		&session.Data,
+		&session.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
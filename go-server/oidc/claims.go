@@ -0,0 +1,137 @@
+package oidc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Identity fields a connection's claim mappings can set
+const (
+	FieldSubject     = "subject"
+	FieldUsername    = "username"
+	FieldEmail       = "email"
+	FieldDisplayName = "displayName"
+	FieldAvatar      = "avatar"
+	FieldRoles       = "roles"
+	FieldGroups      = "groups"
+)
+
+// DefaultClaimMappings are the standard claims each identity field is read
+// from unless a connection maps it elsewhere
+var DefaultClaimMappings = map[string]string{
+	FieldSubject:     "sub",
+	FieldUsername:    "preferred_username",
+	FieldEmail:       "email",
+	FieldDisplayName: "name",
+	FieldAvatar:      "picture",
+	FieldRoles:       "roles",
+	FieldGroups:      "groups",
+}
+
+// Identity is a user as described by an ID token's claims
+type Identity struct {
+	Subject     string
+	Username    string
+	Email       string
+	DisplayName string
+	Avatar      string
+	// Roles and Groups are nil when the token has no such claim
+	Roles  []string
+	Groups []string
+}
+
+// ValidateClaimMappings checks every mapping is for a known identity field
+func ValidateClaimMappings(mappings map[string]string) error {
+	for field, claim := range mappings {
+		if _, ok := DefaultClaimMappings[field]; !ok {
+			return fmt.Errorf("unknown claim mapping field %q", field)
+		}
+		if strings.TrimSpace(claim) == "" {
+			return fmt.Errorf("claim mapping for %q is empty", field)
+		}
+	}
+	return nil
+}
+
+// MapClaims extracts an identity from ID token claims. Mappings name the claim
+// for each field, and may use dotted paths into nested claims, such as
+// Keycloak's "realm_access.roles".
+func MapClaims(claims map[string]interface{}, mappings map[string]string) Identity {
+	claimFor := func(field string) string {
+		if claim := mappings[field]; claim != "" {
+			return claim
+		}
+		return DefaultClaimMappings[field]
+	}
+
+	identity := Identity{
+		Subject:     stringClaim(claims, claimFor(FieldSubject)),
+		Username:    stringClaim(claims, claimFor(FieldUsername)),
+		Email:       stringClaim(claims, claimFor(FieldEmail)),
+		DisplayName: stringClaim(claims, claimFor(FieldDisplayName)),
+		Avatar:      stringClaim(claims, claimFor(FieldAvatar)),
+		Roles:       listClaim(claims, claimFor(FieldRoles)),
+		Groups:      listClaim(claims, claimFor(FieldGroups)),
+	}
+
+	// Fall back to the email's local part for providers without preferred_username
+	if identity.Username == "" && identity.Email != "" {
+		identity.Username = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+
+	return identity
+}
+
+// lookupClaim follows a dotted path into the claims
+func lookupClaim(claims map[string]interface{}, path string) (interface{}, bool) {
+	// A claim whose name itself contains dots, such as a namespaced URL, wins
+	if value, ok := claims[path]; ok {
+		return value, true
+	}
+
+	var current interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = object[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// stringClaim returns a claim as a string, or "" if it is missing or not a string
+func stringClaim(claims map[string]interface{}, path string) string {
+	value, _ := lookupClaim(claims, path)
+	s, _ := value.(string)
+	return s
+}
+
+// listClaim returns a claim as a list of strings. A single string is a list
+// of one; a missing claim is nil.
+func listClaim(claims map[string]interface{}, path string) []string {
+	value, ok := lookupClaim(claims, path)
+	if !ok {
+		return nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	case []string:
+		return v
+	default:
+		return nil
+	}
+}
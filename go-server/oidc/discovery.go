@@ -0,0 +1,84 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Metadata is the part of an issuer's discovery document we use
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Discover fetches and validates the issuer's OpenID Connect discovery document
+func Discover(ctx context.Context, client *http.Client, issuer string) (*Metadata, error) {
+	if err := ValidateIssuer(issuer); err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	wellKnown := strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: failed to fetch discovery document: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: discovery endpoint responded with %d", resp.StatusCode)
+	}
+
+	var metadata Metadata
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("oidc: failed to decode discovery document: %v", err)
+	}
+
+	// The document must describe the issuer we asked for, or tokens from
+	// another issuer could be accepted as this one's
+	if strings.TrimRight(metadata.Issuer, "/") != strings.TrimRight(issuer, "/") {
+		return nil, fmt.Errorf("oidc: discovery document is for issuer %q, not %q", metadata.Issuer, issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing required endpoints")
+	}
+
+	return &metadata, nil
+}
+
+// ValidateIssuer checks an issuer is an absolute URL without query or
+// fragment. It must use https, except on localhost for development.
+func ValidateIssuer(issuer string) error {
+	parsed, err := url.Parse(issuer)
+	if err != nil || parsed.Host == "" {
+		return errors.New("oidc: issuer must be an absolute URL")
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return errors.New("oidc: issuer must not have a query or fragment")
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if host := parsed.Hostname(); host == "localhost" || host == "127.0.0.1" {
+			return nil
+		}
+	}
+	return errors.New("oidc: issuer must use https")
+}
@@ -0,0 +1,213 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultJWKSCacheTTL is how long fetched issuer keys are trusted before refetching
+const DefaultJWKSCacheTTL = time.Hour
+
+// DefaultMinRefreshInterval limits how often an unknown kid can force a JWKS fetch
+const DefaultMinRefreshInterval = 30 * time.Second
+
+// AllowedAlgorithms are the asymmetric algorithms accepted from issuers.
+// Symmetric and "none" algorithms are never accepted.
+var AllowedAlgorithms = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+// ErrUnknownKey is returned when no issuer key matches the token's kid
+var ErrUnknownKey = errors.New("oidc: no signing key matches the token kid")
+
+// KeySetConfig configures a KeySet
+type KeySetConfig struct {
+	// URL is where the issuer publishes its JWKS
+	URL string
+	// HTTPClient is used to fetch the JWKS. Defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+	// CacheTTL is how long fetched keys are cached. Defaults to DefaultJWKSCacheTTL.
+	CacheTTL time.Duration
+	// MinRefreshInterval is the least time between fetches forced by unknown
+	// kids. Defaults to DefaultMinRefreshInterval.
+	MinRefreshInterval time.Duration
+}
+
+// KeySet caches an issuer's published signing keys by kid
+type KeySet struct {
+	config KeySetConfig
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// NewKeySet creates a key set for the JWKS at config.URL
+func NewKeySet(config KeySetConfig) *KeySet {
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = DefaultJWKSCacheTTL
+	}
+	if config.MinRefreshInterval <= 0 {
+		config.MinRefreshInterval = DefaultMinRefreshInterval
+	}
+
+	return &KeySet{
+		config: config,
+		keys:   make(map[string]crypto.PublicKey),
+	}
+}
+
+// Key returns the issuer key with the given kid, fetching the JWKS when the
+// cache is stale or the kid is unknown
+func (s *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	age := time.Since(s.fetchedAt)
+	if key, ok := s.keys[kid]; ok && age < s.config.CacheTTL {
+		return key, nil
+	}
+
+	// An unknown kid may mean the issuer rotated, but don't let unknown kids
+	// hammer the issuer
+	if _, ok := s.keys[kid]; !ok && !s.fetchedAt.IsZero() && age < s.config.MinRefreshInterval {
+		return nil, ErrUnknownKey
+	}
+
+	keys, err := s.fetchKeys(ctx)
+	if err != nil {
+		// Keep serving cached keys if the issuer is briefly unreachable
+		if key, ok := s.keys[kid]; ok {
+			return key, nil
+		}
+		return nil, err
+	}
+	s.keys = keys
+	s.fetchedAt = time.Now()
+
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// jsonWebKey is a key in the issuer's JWKS
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// fetchKeys downloads and parses the issuer's JWKS
+func (s *KeySet) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: JWKS endpoint responded with %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("oidc: failed to decode JWKS: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.KeyID == "" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	return keys, nil
+}
+
+// publicKey converts the JWK to an RSA or ECDSA public key
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
+
+// decodeBigInt decodes a base64url-encoded big-endian integer
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,278 @@
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultStateTTL is how long a login may take between leaving for the
+// authorization server and coming back to the callback
+const DefaultStateTTL = 10 * time.Minute
+
+// ErrInvalidState is returned when the callback state is malformed, forged,
+// expired or not bound to the browser completing the login
+var ErrInvalidState = errors.New("oidc: invalid login state")
+
+// StateStore records states that have been used, so each can complete only one login
+type StateStore interface {
+	// Consume marks the state as used. It returns an error if it was used before.
+	Consume(ctx context.Context, id string, expiresAt time.Time) error
+}
+
+// StateStoreFunc adapts a function to a StateStore
+type StateStoreFunc func(ctx context.Context, id string, expiresAt time.Time) error
+
+// Consume calls f
+func (f StateStoreFunc) Consume(ctx context.Context, id string, expiresAt time.Time) error {
+	return f(ctx, id, expiresAt)
+}
+
+// Client is an application registered with an authorization server
+type Client struct {
+	ID     string
+	Secret string
+	// Endpoint holds the authorization and token URLs
+	Endpoint oauth2.Endpoint
+	// Scopes are requested from the authorization server. Including "openid"
+	// makes the login bind a nonce into the ID token.
+	Scopes []string
+}
+
+// LoginConfig configures a LoginFlow
+type LoginConfig struct {
+	// StateSecret signs the state parameter
+	StateSecret []byte
+	// StateTTL bounds how long a login may take. Defaults to DefaultStateTTL.
+	StateTTL time.Duration
+	// States records used states
+	States StateStore
+	// HTTPClient is used for the code exchange. Defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+}
+
+// LoginRequest describes a login about to start
+type LoginRequest struct {
+	// CallbackURL is our callback the authorization server redirects back to
+	CallbackURL string
+	// RedirectTo is where to send the browser once the login completes. It
+	// must already have been validated.
+	RedirectTo string
+	// TenantID is the tenant the login was started for
+	TenantID int
+	// ConnectionID is the tenant OIDC connection the login goes through, or
+	// zero for the application's own Casdoor
+	ConnectionID int
+}
+
+// LoginState is the signed state carried through the authorization server
+type LoginState struct {
+	ID          string `json:"id"`
+	CallbackURL string `json:"cb"`
+	RedirectTo  string `json:"rt,omitempty"`
+	TenantID     int    `json:"tid,omitempty"`
+	ConnectionID int    `json:"con,omitempty"`
+	// Nonce is bound into the ID token of OpenID Connect logins
+	Nonce string `json:"n,omitempty"`
+	// Challenge is the PKCE S256 challenge, which binds the state to the
+	// verifier held in the browser's cookie
+	Challenge string `json:"ch"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// LoginFlow runs the authorization code flow with PKCE, carrying a signed
+// state that is bound to the browser and can be used once
+type LoginFlow struct {
+	config LoginConfig
+}
+
+// NewLoginFlow creates a login flow
+func NewLoginFlow(config LoginConfig) (*LoginFlow, error) {
+	if len(config.StateSecret) < 32 {
+		return nil, errors.New("oidc: state secret must be at least 32 bytes")
+	}
+	if config.States == nil {
+		return nil, errors.New("oidc: a state store is required")
+	}
+
+	if config.StateTTL <= 0 {
+		config.StateTTL = DefaultStateTTL
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &LoginFlow{config: config}, nil
+}
+
+// StateTTL returns how long a started login stays valid
+func (f *LoginFlow) StateTTL() time.Duration {
+	return f.config.StateTTL
+}
+
+// Begin starts a login with the client. It returns the authorization URL to
+// send the browser to, and the PKCE verifier, which the caller must keep in an
+// HttpOnly cookie and hand back to Verify and Exchange.
+func (f *LoginFlow) Begin(client Client, request LoginRequest) (authURL string, verifier string, err error) {
+	if request.CallbackURL == "" {
+		return "", "", errors.New("oidc: callback URL is required")
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier = oauth2.GenerateVerifier()
+	state := LoginState{
+		ID:           id,
+		CallbackURL:  request.CallbackURL,
+		RedirectTo:   request.RedirectTo,
+		TenantID:     request.TenantID,
+		ConnectionID: request.ConnectionID,
+		Challenge:    oauth2.S256ChallengeFromVerifier(verifier),
+		ExpiresAt:    time.Now().Add(f.config.StateTTL).Unix(),
+	}
+
+	options := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}
+	if client.isOpenID() {
+		state.Nonce, err = randomToken()
+		if err != nil {
+			return "", "", err
+		}
+		options = append(options, oauth2.SetAuthURLParam("nonce", state.Nonce))
+	}
+
+	signed, err := f.signState(state)
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL = client.oauthConfig(request.CallbackURL).AuthCodeURL(signed, options...)
+	return authURL, verifier, nil
+}
+
+// Verify checks a callback's state is genuine, unexpired, bound to this
+// browser's verifier and unused, and returns it. The state names the
+// connection whose client must then be used to Exchange the code.
+func (f *LoginFlow) Verify(ctx context.Context, verifier string, state string) (*LoginState, error) {
+	loginState, err := f.verifyState(state)
+	if err != nil {
+		return nil, err
+	}
+
+	// The verifier comes from the browser's cookie, so a state started in
+	// another browser cannot be completed here
+	challenge := oauth2.S256ChallengeFromVerifier(verifier)
+	if verifier == "" || subtle.ConstantTimeCompare([]byte(challenge), []byte(loginState.Challenge)) != 1 {
+		return nil, ErrInvalidState
+	}
+
+	if err := f.config.States.Consume(ctx, loginState.ID, time.Unix(loginState.ExpiresAt, 0)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+
+	return loginState, nil
+}
+
+// Exchange redeems the authorization code with the PKCE verifier
+func (f *LoginFlow) Exchange(ctx context.Context, client Client, loginState *LoginState, verifier string, code string) (*oauth2.Token, error) {
+	if code == "" {
+		return nil, errors.New("oidc: no authorization code provided")
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, f.config.HTTPClient)
+	token, err := client.oauthConfig(loginState.CallbackURL).Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("oidc: code exchange failed: %v", err)
+	}
+
+	return token, nil
+}
+
+// oauthConfig returns the OAuth2 configuration for a callback URL
+func (c Client) oauthConfig(callbackURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.ID,
+		ClientSecret: c.Secret,
+		Endpoint:     c.Endpoint,
+		RedirectURL:  callbackURL,
+		Scopes:       c.Scopes,
+	}
+}
+
+// isOpenID reports whether the client requests an ID token
+func (c Client) isOpenID() bool {
+	for _, scope := range c.Scopes {
+		if scope == "openid" {
+			return true
+		}
+	}
+	return false
+}
+
+// signState encodes the state as base64url(JSON) "." base64url(HMAC-SHA256)
+func (f *LoginFlow) signState(state LoginState) (string, error) {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(f.mac(encoded)), nil
+}
+
+// verifyState checks the state's signature and expiry and decodes it
+func (f *LoginFlow) verifyState(state string) (*LoginState, error) {
+	encoded, signature, ok := strings.Cut(state, ".")
+	if !ok {
+		return nil, ErrInvalidState
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, f.mac(encoded)) {
+		return nil, ErrInvalidState
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+
+	var loginState LoginState
+	if err := json.Unmarshal(payload, &loginState); err != nil {
+		return nil, ErrInvalidState
+	}
+
+	if loginState.ID == "" || time.Now().Unix() > loginState.ExpiresAt {
+		return nil, ErrInvalidState
+	}
+
+	return &loginState, nil
+}
+
+// mac computes the state signature
+func (f *LoginFlow) mac(encoded string) []byte {
+	h := hmac.New(sha256.New, f.config.StateSecret)
+	h.Write([]byte("oidc-login-state." + encoded))
+	return h.Sum(nil)
+}
+
+// randomToken returns 32 random bytes, base64url-encoded
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
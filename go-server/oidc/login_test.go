@@ -0,0 +1,575 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const (
+	testClientID     = "test-client-id"
+	testClientSecret = "test-client-secret"
+	testCallbackURL  = "https://app.example.com/api/auth/callback"
+	testKeyID        = "test-key"
+)
+
+var testStateSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// fakeAuthServer is a local authorization server for the authorization code
+// flow. It remembers the PKCE challenge sent to the authorize endpoint and
+// only redeems the code for the matching verifier. It also publishes OpenID
+// Connect discovery and keys, and issues an ID token for "openid" logins.
+type fakeAuthServer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu        sync.Mutex
+	codes     map[string]authorization
+	exchanges int
+	// issuer overrides the issuer advertised by discovery
+	issuer string
+}
+
+// authorization is what the fake server remembers about an issued code
+type authorization struct {
+	challenge   string
+	redirectURI string
+	nonce       string
+	openID      bool
+}
+
+func newFakeAuthServer(t *testing.T) *fakeAuthServer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeAuthServer{t: t, key: key, codes: make(map[string]authorization)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", f.discovery)
+	mux.HandleFunc("/jwks", f.jwks)
+	mux.HandleFunc("/authorize", f.authorize)
+	mux.HandleFunc("/token", f.token)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// client returns a plain OAuth2 client registration with the server
+func (f *fakeAuthServer) client() Client {
+	return Client{
+		ID:     testClientID,
+		Secret: testClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  f.server.URL + "/authorize",
+			TokenURL: f.server.URL + "/token",
+		},
+		Scopes: []string{"read"},
+	}
+}
+
+func (f *fakeAuthServer) discovery(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	issuer := f.issuer
+	f.mu.Unlock()
+	if issuer == "" {
+		issuer = f.server.URL
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": f.server.URL + "/authorize",
+		"token_endpoint":         f.server.URL + "/token",
+		"jwks_uri":               f.server.URL + "/jwks",
+	})
+}
+
+func (f *fakeAuthServer) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKeyID,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (f *fakeAuthServer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != testClientID || query.Get("response_type") != "code" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "PKCE required", http.StatusBadRequest)
+		return
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	f.codes[code] = authorization{
+		challenge:   query.Get("code_challenge"),
+		redirectURI: query.Get("redirect_uri"),
+		nonce:       query.Get("nonce"),
+		openID:      strings.Contains(" "+query.Get("scope")+" ", " openid "),
+	}
+	f.mu.Unlock()
+
+	callback, _ := url.Parse(query.Get("redirect_uri"))
+	values := callback.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	callback.RawQuery = values.Encode()
+	http.Redirect(w, r, callback.String(), http.StatusFound)
+}
+
+func (f *fakeAuthServer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.exchanges++
+
+	code := r.PostForm.Get("code")
+	auth, ok := f.codes[code]
+	delete(f.codes, code)
+
+	clientID, clientSecret, basic := r.BasicAuth()
+	if !basic {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	writeError := func(reason string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": reason})
+	}
+
+	switch {
+	case !ok:
+		writeError("invalid_grant")
+		return
+	case clientID != testClientID || clientSecret != testClientSecret:
+		writeError("invalid_client")
+		return
+	case r.PostForm.Get("redirect_uri") != auth.redirectURI:
+		writeError("invalid_grant")
+		return
+	case oauth2.S256ChallengeFromVerifier(r.PostForm.Get("code_verifier")) != auth.challenge:
+		writeError("invalid_grant")
+		return
+	}
+
+	response := map[string]interface{}{
+		"access_token": "access-" + code,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	}
+	if auth.openID {
+		response["id_token"] = f.idToken(jwt.MapClaims{
+			"iss":                f.server.URL,
+			"aud":                testClientID,
+			"sub":                "user-123",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"nonce":              auth.nonce,
+			"email":              "jane@example.com",
+			"name":               "Jane Doe",
+			"realm_access":       map[string]interface{}{"roles": []string{"auditor", "viewer"}},
+			"groups":             "compliance",
+			"preferred_username": "jane",
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// idToken signs claims with the server's key
+func (f *fakeAuthServer) idToken(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKeyID
+	signed, err := token.SignedString(f.key)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return signed
+}
+
+func (f *fakeAuthServer) exchangeCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.exchanges
+}
+
+// memoryStateStore records used states in memory
+type memoryStateStore struct {
+	mu   sync.Mutex
+	used map[string]bool
+}
+
+func (s *memoryStateStore) Consume(ctx context.Context, id string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.used[id] {
+		return errors.New("state already used")
+	}
+	s.used[id] = true
+	return nil
+}
+
+func newTestLoginFlow(t *testing.T) *LoginFlow {
+	t.Helper()
+	flow, err := NewLoginFlow(LoginConfig{
+		StateSecret: testStateSecret,
+		States:      &memoryStateStore{used: make(map[string]bool)},
+	})
+	if err != nil {
+		t.Fatalf("NewLoginFlow: %v", err)
+	}
+	return flow
+}
+
+// authorize follows the authorization URL as the browser would, and returns
+// the code and state the authorization server sends back to the callback
+func authorize(t *testing.T, authURL string) (code string, state string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize responded with %d", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("bad callback location: %v", err)
+	}
+	if !strings.HasPrefix(location.String(), testCallbackURL) {
+		t.Fatalf("redirected to %s, want the callback", location)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+// complete runs the callback side of a login
+func complete(flow *LoginFlow, client Client, verifier string, state string, code string) (*oauth2.Token, *LoginState, error) {
+	loginState, err := flow.Verify(context.Background(), verifier, state)
+	if err != nil {
+		return nil, nil, err
+	}
+	token, err := flow.Exchange(context.Background(), client, loginState, verifier, code)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, loginState, nil
+}
+
+func TestLoginFlowCompletesWithPKCE(t *testing.T) {
+	server := newFakeAuthServer(t)
+	flow := newTestLoginFlow(t)
+	client := server.client()
+
+	authURL, verifier, err := flow.Begin(client, LoginRequest{CallbackURL: testCallbackURL, RedirectTo: "/dashboard", TenantID: 7})
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+
+	query := mustParseURL(t, authURL).Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Errorf("code_challenge_method = %q, want S256", query.Get("code_challenge_method"))
+	}
+	if query.Get("code_challenge") != oauth2.S256ChallengeFromVerifier(verifier) {
+		t.Error("code_challenge does not match the verifier")
+	}
+	if strings.Contains(authURL, verifier) {
+		t.Error("authorization URL leaks the verifier")
+	}
+	if query.Get("nonce") != "" {
+		t.Error("plain OAuth2 login should not send a nonce")
+	}
+
+	code, state := authorize(t, authURL)
+	token, loginState, err := complete(flow, client, verifier, state, code)
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if token.AccessToken != "access-"+code {
+		t.Errorf("access token = %q", token.AccessToken)
+	}
+	if loginState.RedirectTo != "/dashboard" || loginState.TenantID != 7 || loginState.CallbackURL != testCallbackURL {
+		t.Errorf("unexpected login state %+v", loginState)
+	}
+}
+
+func TestLoginFlowStateIsSingleUse(t *testing.T) {
+	server := newFakeAuthServer(t)
+	flow := newTestLoginFlow(t)
+	client := server.client()
+
+	authURL, verifier, err := flow.Begin(client, LoginRequest{CallbackURL: testCallbackURL})
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	code, state := authorize(t, authURL)
+
+	if _, _, err := complete(flow, client, verifier, state, code); err != nil {
+		t.Fatalf("first complete: %v", err)
+	}
+	if _, _, err := complete(flow, client, verifier, state, code); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("replayed complete error = %v, want ErrInvalidState", err)
+	}
+	if n := server.exchangeCount(); n != 1 {
+		t.Errorf("code exchanged %d times, want 1", n)
+	}
+}
+
+func TestLoginFlowStateIsBoundToBrowser(t *testing.T) {
+	server := newFakeAuthServer(t)
+	flow := newTestLoginFlow(t)
+	client := server.client()
+
+	// The attacker starts a login in their own browser and gets a code...
+	attackerURL, _, err := flow.Begin(client, LoginRequest{CallbackURL: testCallbackURL})
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	code, state := authorize(t, attackerURL)
+
+	// ...then lures the victim, who has their own login cookie or none, to the callback
+	_, victimVerifier, err := flow.Begin(client, LoginRequest{CallbackURL: testCallbackURL})
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+
+	for name, verifier := range map[string]string{"other browser": victimVerifier, "no cookie": ""} {
+		if _, _, err := complete(flow, client, verifier, state, code); !errors.Is(err, ErrInvalidState) {
+			t.Errorf("%s: complete error = %v, want ErrInvalidState", name, err)
+		}
+	}
+	if n := server.exchangeCount(); n != 0 {
+		t.Errorf("code exchanged %d times, want 0", n)
+	}
+}
+
+func TestLoginFlowRejectsForgedOrExpiredState(t *testing.T) {
+	server := newFakeAuthServer(t)
+	flow := newTestLoginFlow(t)
+	client := server.client()
+
+	authURL, verifier, err := flow.Begin(client, LoginRequest{CallbackURL: testCallbackURL, RedirectTo: "/dashboard"})
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	code, state := authorize(t, authURL)
+
+	loginState, err := flow.verifyState(state)
+	if err != nil {
+		t.Fatalf("verifyState: %v", err)
+	}
+
+	// Same state pointing elsewhere, signed with the wrong key
+	other := newTestLoginFlow(t)
+	other.config.StateSecret = []byte("ffffffffffffffffffffffffffffffff")
+	redirected := *loginState
+	redirected.RedirectTo = "https://evil.example.com"
+	forged, _ := other.signState(redirected)
+
+	expired := *loginState
+	expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	expiredState, _ := flow.signState(expired)
+
+	payload, signature, _ := strings.Cut(state, ".")
+	tampered := payload[:len(payload)-2] + "xx." + signature
+
+	tests := map[string]string{
+		"static":           "eudr-complimate",
+		"empty":            "",
+		"wrong key":        forged,
+		"tampered payload": tampered,
+		"expired":          expiredState,
+	}
+	for name, bad := range tests {
+		if _, _, err := complete(flow, client, verifier, bad, code); !errors.Is(err, ErrInvalidState) {
+			t.Errorf("%s: complete error = %v, want ErrInvalidState", name, err)
+		}
+	}
+	if n := server.exchangeCount(); n != 0 {
+		t.Errorf("code exchanged %d times, want 0", n)
+	}
+
+	// The genuine state still works
+	if _, _, err := complete(flow, client, verifier, state, code); err != nil {
+		t.Fatalf("complete with genuine state: %v", err)
+	}
+}
+
+func TestOpenIDLoginVerifiesIDToken(t *testing.T) {
+	server := newFakeAuthServer(t)
+	flow := newTestLoginFlow(t)
+
+	provider, err := NewProvider(context.Background(), ProviderConfig{
+		Issuer:       server.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	client := provider.Client()
+
+	authURL, verifier, err := flow.Begin(client, LoginRequest{CallbackURL: testCallbackURL, ConnectionID: 3})
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if mustParseURL(t, authURL).Query().Get("nonce") == "" {
+		t.Fatal("OpenID Connect login should send a nonce")
+	}
+
+	code, state := authorize(t, authURL)
+	token, loginState, err := complete(flow, client, verifier, state, code)
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if loginState.ConnectionID != 3 {
+		t.Errorf("connection ID = %d, want 3", loginState.ConnectionID)
+	}
+
+	rawIDToken, err := IDToken(token)
+	if err != nil {
+		t.Fatalf("IDToken: %v", err)
+	}
+
+	if _, err := provider.VerifyIDToken(context.Background(), rawIDToken, "another-login"); err == nil {
+		t.Error("expected an ID token from another login to be rejected")
+	}
+
+	claims, err := provider.VerifyIDToken(context.Background(), rawIDToken, loginState.Nonce)
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+
+	identity := MapClaims(claims, map[string]string{FieldRoles: "realm_access.roles"})
+	if identity.Subject != "user-123" || identity.Username != "jane" || identity.Email != "jane@example.com" || identity.DisplayName != "Jane Doe" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if strings.Join(identity.Roles, ",") != "auditor,viewer" || strings.Join(identity.Groups, ",") != "compliance" {
+		t.Errorf("roles %v and groups %v not mapped", identity.Roles, identity.Groups)
+	}
+}
+
+func TestVerifyIDTokenRejectsTokensForOthers(t *testing.T) {
+	server := newFakeAuthServer(t)
+	provider, err := NewProvider(context.Background(), ProviderConfig{Issuer: server.server.URL, ClientID: testClientID})
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   server.server.URL,
+			"aud":   testClientID,
+			"sub":   "user-123",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": "n",
+		}
+	}
+
+	if _, err := provider.VerifyIDToken(context.Background(), server.idToken(valid()), "n"); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+
+	tests := map[string]func(jwt.MapClaims){
+		"other audience":    func(c jwt.MapClaims) { c["aud"] = "other-client" },
+		"other issuer":      func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"expired":           func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no expiry":         func(c jwt.MapClaims) { delete(c, "exp") },
+		"no nonce":          func(c jwt.MapClaims) { delete(c, "nonce") },
+		"no subject":        func(c jwt.MapClaims) { delete(c, "sub") },
+		"other azp":         func(c jwt.MapClaims) { c["azp"] = "other-client" },
+		"several audiences": func(c jwt.MapClaims) { c["aud"] = []string{testClientID, "other-client"} },
+	}
+	for name, modify := range tests {
+		claims := valid()
+		modify(claims)
+		if _, err := provider.VerifyIDToken(context.Background(), server.idToken(claims), "n"); err == nil {
+			t.Errorf("%s: expected the token to be rejected", name)
+		}
+	}
+}
+
+func TestDiscoverRejectsMismatchedIssuer(t *testing.T) {
+	server := newFakeAuthServer(t)
+	server.issuer = "https://evil.example.com"
+
+	if _, err := Discover(context.Background(), nil, server.server.URL); err == nil {
+		t.Fatal("expected a discovery document for another issuer to be rejected")
+	}
+	if _, err := Discover(context.Background(), nil, "http://idp.example.com"); err == nil {
+		t.Fatal("expected a plain http issuer to be rejected")
+	}
+}
+
+func TestNewLoginFlowValidatesConfig(t *testing.T) {
+	store := &memoryStateStore{used: make(map[string]bool)}
+	valid := LoginConfig{StateSecret: testStateSecret, States: store}
+
+	if _, err := NewLoginFlow(valid); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+
+	shortSecret := valid
+	shortSecret.StateSecret = []byte("short")
+	noStore := valid
+	noStore.States = nil
+
+	for name, config := range map[string]LoginConfig{
+		"short secret": shortSecret,
+		"no store":     noStore,
+	} {
+		if _, err := NewLoginFlow(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse %q: %v", raw, err)
+	}
+	return parsed
+}
@@ -0,0 +1,136 @@
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// DefaultLeeway is the clock skew tolerated when checking exp and nbf
+const DefaultLeeway = 30 * time.Second
+
+// DefaultScopes are requested when a connection configures none
+var DefaultScopes = []string{"openid", "profile", "email"}
+
+// ProviderConfig configures a Provider
+type ProviderConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Scopes are requested at login. "openid" is always included. Defaults to DefaultScopes.
+	Scopes []string
+	// HTTPClient is used for discovery and the JWKS. Defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+	// Leeway is the tolerated clock skew. Defaults to DefaultLeeway.
+	Leeway time.Duration
+}
+
+// Provider is a discovered OpenID Connect provider and our client registration with it
+type Provider struct {
+	config   ProviderConfig
+	metadata *Metadata
+	keys     *KeySet
+}
+
+// NewProvider discovers the issuer's endpoints and keys
+func NewProvider(ctx context.Context, config ProviderConfig) (*Provider, error) {
+	if config.ClientID == "" {
+		return nil, errors.New("oidc: client ID is required")
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.Leeway <= 0 {
+		config.Leeway = DefaultLeeway
+	}
+
+	scopes := []string{"openid"}
+	if len(config.Scopes) == 0 {
+		config.Scopes = DefaultScopes
+	}
+	for _, scope := range config.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+	config.Scopes = scopes
+
+	metadata, err := Discover(ctx, config.HTTPClient, config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		config:   config,
+		metadata: metadata,
+		keys:     NewKeySet(KeySetConfig{URL: metadata.JWKSURI, HTTPClient: config.HTTPClient}),
+	}, nil
+}
+
+// Client returns our client registration for use with a LoginFlow
+func (p *Provider) Client() Client {
+	return Client{
+		ID:     p.config.ClientID,
+		Secret: p.config.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  p.metadata.AuthorizationEndpoint,
+			TokenURL: p.metadata.TokenEndpoint,
+		},
+		Scopes: p.config.Scopes,
+	}
+}
+
+// IDToken returns the raw ID token from a token response
+func IDToken(token *oauth2.Token) (string, error) {
+	raw, _ := token.Extra("id_token").(string)
+	if raw == "" {
+		return "", errors.New("oidc: token response has no ID token")
+	}
+	return raw, nil
+}
+
+// VerifyIDToken checks the ID token's signature against the issuer's keys and
+// enforces iss, aud, azp, exp and the login's nonce. It returns the token's claims.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken string, nonce string) (map[string]interface{}, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(AllowedAlgorithms),
+		jwt.WithIssuer(p.metadata.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(p.config.Leeway),
+	)
+
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("oidc: token has no kid")
+		}
+		return p.keys.Key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A token issued to several audiences must name us as the authorized party
+	audience, _ := claims.GetAudience()
+	if azp, ok := claims["azp"].(string); (ok || len(audience) > 1) && azp != p.config.ClientID {
+		return nil, errors.New("oidc: token was not issued to this client")
+	}
+
+	tokenNonce, _ := claims["nonce"].(string)
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, errors.New("oidc: token nonce does not match the login")
+	}
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("oidc: token has no subject")
+	}
+
+	return claims, nil
+}
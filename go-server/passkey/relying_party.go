@@ -0,0 +1,56 @@
+package passkey
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// CeremonyTTL is how long a user has to complete a registration or login
+// with their authenticator
+const CeremonyTTL = 5 * time.Minute
+
+// NewRelyingParty configures WebAuthn for the service at baseURL. The
+// relying party ID is the base URL's host, so passkeys registered on it are
+// offered on that host and its subdomains. extraOrigins lists further
+// origins, such as a separately hosted client, allowed to run ceremonies.
+func NewRelyingParty(baseURL string, displayName string, extraOrigins []string) (*webauthn.WebAuthn, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || parsed.Hostname() == "" {
+		return nil, fmt.Errorf("invalid base URL %q", baseURL)
+	}
+
+	origins := []string{parsed.Scheme + "://" + parsed.Host}
+	for _, origin := range extraOrigins {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:                  parsed.Hostname(),
+		RPDisplayName:         displayName,
+		RPOrigins:             origins,
+		AttestationPreference: protocol.PreferNoAttestation,
+		// Passkeys are discoverable credentials that verify the user, so they
+		// can be used on their own without a username or second factor
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			UserVerification:   protocol.VerificationRequired,
+		},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: CeremonyTTL, TimeoutUVD: CeremonyTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: CeremonyTTL, TimeoutUVD: CeremonyTTL},
+		},
+	})
+}
+
+// LoginOptions are the options every passkey login is started with
+func LoginOptions() []webauthn.LoginOption {
+	return []webauthn.LoginOption{webauthn.WithUserVerification(protocol.VerificationRequired)}
+}
@@ -0,0 +1,135 @@
+package passkey
+
+import (
+	"bytes"
+	"crypto/rand"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go-server/models"
+)
+
+// userHandleSize is the length of a new user handle in bytes
+const userHandleSize = 32
+
+// NewUserHandle returns a random user handle
+func NewUserHandle() ([]byte, error) {
+	handle := make([]byte, userHandleSize)
+	if _, err := rand.Read(handle); err != nil {
+		return nil, err
+	}
+	return handle, nil
+}
+
+// User presents one of our users and their stored passkeys to the WebAuthn library
+type User struct {
+	handle      []byte
+	user        *models.User
+	credentials []*models.WebAuthnCredential
+}
+
+// NewUser wraps a user, the handle their passkeys are registered under and
+// their stored passkeys
+func NewUser(user *models.User, handle []byte, credentials []*models.WebAuthnCredential) *User {
+	return &User{handle: handle, user: user, credentials: credentials}
+}
+
+// WebAuthnID returns the user handle
+func (u *User) WebAuthnID() []byte {
+	return u.handle
+}
+
+// WebAuthnName returns the name authenticators list the passkey under
+func (u *User) WebAuthnName() string {
+	if u.user.Email != "" {
+		return u.user.Email
+	}
+	return u.user.Username
+}
+
+// WebAuthnDisplayName returns the user's display name
+func (u *User) WebAuthnDisplayName() string {
+	if u.user.DisplayName != "" {
+		return u.user.DisplayName
+	}
+	return u.user.Username
+}
+
+// WebAuthnIcon is deprecated by the specification and left blank
+func (u *User) WebAuthnIcon() string {
+	return ""
+}
+
+// WebAuthnCredentials returns the user's stored passkeys
+func (u *User) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, cred := range u.credentials {
+		credentials = append(credentials, toCredential(cred))
+	}
+	return credentials
+}
+
+// Exclusions lists the user's passkeys so an authenticator that already
+// holds one is not registered twice
+func (u *User) Exclusions() []protocol.CredentialDescriptor {
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(u.credentials))
+	for _, cred := range u.WebAuthnCredentials() {
+		exclusions = append(exclusions, cred.Descriptor())
+	}
+	return exclusions
+}
+
+// Stored returns the stored passkey a credential ID belongs to, or nil
+func (u *User) Stored(credentialID []byte) *models.WebAuthnCredential {
+	for _, cred := range u.credentials {
+		if bytes.Equal(cred.CredentialID, credentialID) {
+			return cred
+		}
+	}
+	return nil
+}
+
+// FromCredential converts a newly registered credential for storage
+func FromCredential(userID int, name string, cred *webauthn.Credential) *models.WebAuthnCredential {
+	transports := make([]string, 0, len(cred.Transport))
+	for _, transport := range cred.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	return &models.WebAuthnCredential{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    cred.ID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		Transports:      transports,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       int64(cred.Authenticator.SignCount),
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+	}
+}
+
+// toCredential converts a stored passkey for the WebAuthn library
+func toCredential(cred *models.WebAuthnCredential) webauthn.Credential {
+	transports := make([]protocol.AuthenticatorTransport, 0, len(cred.Transports))
+	for _, transport := range cred.Transports {
+		transports = append(transports, protocol.AuthenticatorTransport(transport))
+	}
+
+	return webauthn.Credential{
+		ID:              cred.CredentialID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			BackupEligible: cred.BackupEligible,
+			BackupState:    cred.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:       cred.AAGUID,
+			SignCount:    uint32(cred.SignCount),
+			CloneWarning: cred.CloneWarning,
+		},
+	}
+}
@@ -0,0 +1,110 @@
+package passwords
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// defaultBreachedList holds the SHA-1 hashes of the most common passwords,
+// used when no larger list is configured
+//
+//go:embed breached_sha1.txt
+var defaultBreachedList []byte
+
+// BreachedList is a set of SHA-1 password hashes from known breaches. Only
+// hashes are kept, so the list can be built from downloads such as the Have
+// I Been Pwned Pwned Passwords file without handling plaintext passwords.
+type BreachedList struct {
+	hashes map[[sha1.Size]byte]struct{}
+}
+
+// LoadBreachedList reads one hex SHA-1 hash per line. Anything after a colon
+// (such as a breach count) is ignored, as are blank lines and # comments.
+func LoadBreachedList(r io.Reader) (*BreachedList, error) {
+	list := &BreachedList{hashes: make(map[[sha1.Size]byte]struct{})}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(text, ':'); i >= 0 {
+			text = text[:i]
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		var hash [sha1.Size]byte
+		decoded, err := hex.DecodeString(text)
+		if err != nil || len(decoded) != sha1.Size {
+			return nil, fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		copy(hash[:], decoded)
+		list.hashes[hash] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Contains reports whether the password is on the list
+func (l *BreachedList) Contains(password string) bool {
+	_, ok := l.hashes[sha1.Sum([]byte(password))]
+	return ok
+}
+
+// Len returns the number of hashes on the list
+func (l *BreachedList) Len() int {
+	return len(l.hashes)
+}
+
+var (
+	breached     *BreachedList
+	breachedOnce sync.Once
+)
+
+// Breached returns the breached password list, loading it on first use from
+// the file named by BREACHED_PASSWORDS_FILE. Without that file, or if it
+// cannot be read, the built-in list of common passwords is used.
+func Breached() *BreachedList {
+	breachedOnce.Do(func() {
+		if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+			list, err := loadBreachedFile(path)
+			if err == nil {
+				log.Printf("Loaded %d breached password hashes from %s", list.Len(), path)
+				breached = list
+				return
+			}
+			log.Printf("Error loading breached password list %s, using built-in list: %v", path, err)
+		}
+
+		list, err := LoadBreachedList(bytes.NewReader(defaultBreachedList))
+		if err != nil {
+			log.Printf("Error loading built-in breached password list: %v", err)
+			list = &BreachedList{hashes: make(map[[sha1.Size]byte]struct{})}
+		}
+		breached = list
+	})
+	return breached
+}
+
+func loadBreachedFile(path string) (*BreachedList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadBreachedList(f)
+}
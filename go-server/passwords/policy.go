@@ -0,0 +1,114 @@
+package passwords
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// MaxLength is the longest password accepted. bcrypt ignores everything past
+// 72 bytes, so longer passwords would only give a false sense of strength.
+const MaxLength = 72
+
+// MinAllowedLength is the shortest minimum length a tenant may configure
+const MinAllowedLength = 8
+
+// MaxHistorySize is the most previous passwords a tenant may refuse to reuse
+const MaxHistorySize = 24
+
+// Policy is the set of rules a new password has to satisfy
+type Policy struct {
+	MinLength        int  `json:"minLength"`
+	RequireUppercase bool `json:"requireUppercase"`
+	RequireLowercase bool `json:"requireLowercase"`
+	RequireDigit     bool `json:"requireDigit"`
+	RequireSymbol    bool `json:"requireSymbol"`
+	// RejectBreached refuses passwords found in the breached password list
+	RejectBreached bool `json:"rejectBreached"`
+	// HistorySize is how many of a user's previous passwords may not be reused
+	HistorySize int `json:"historySize"`
+}
+
+// DefaultPolicy applies to tenants that have not configured their own. It
+// favours length and the breached list over character class rules.
+var DefaultPolicy = Policy{
+	MinLength:      12,
+	RejectBreached: true,
+	HistorySize:    5,
+}
+
+// Validate checks that the policy's settings are within the allowed ranges
+func (p Policy) Validate() error {
+	if p.MinLength < MinAllowedLength || p.MinLength > MaxLength {
+		return fmt.Errorf("minLength must be between %d and %d", MinAllowedLength, MaxLength)
+	}
+	if p.HistorySize < 0 || p.HistorySize > MaxHistorySize {
+		return fmt.Errorf("historySize must be between 0 and %d", MaxHistorySize)
+	}
+	return nil
+}
+
+// PolicyError lists the ways a password fails a policy
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return "password does not meet the password policy: " + strings.Join(e.Violations, "; ")
+}
+
+// Check returns a *PolicyError if the password does not satisfy the policy.
+// Reuse of previous passwords is checked separately, since it needs the
+// user's password history.
+func Check(p Policy, password string, breached *BreachedList) error {
+	var violations []string
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if len(password) > MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes long", MaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUppercase && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLowercase && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if p.RejectBreached && breached != nil && breached.Contains(password) {
+		violations = append(violations, "appears in a list of breached passwords")
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// ReusedError is the PolicyError for a password that is one of the user's
+// last historySize passwords
+func ReusedError(historySize int) *PolicyError {
+	return &PolicyError{Violations: []string{fmt.Sprintf("must not be one of your last %d passwords", historySize)}}
+}
@@ -4,20 +4,43 @@ import (
 	"database/sql"
 
 	"github.com/gin-gonic/gin"
+	"go-server/handlers"
 )
 
 // RegisterAuthRoutes registers all authentication related routes
 func RegisterAuthRoutes(router *gin.RouterGroup, db *sql.DB) {
 	// No need to add auth middlewares to these routes as they're for authentication
-	
-	// Keep the existing auth endpoints that are defined in main.go
-	// These are:
-	// POST /auth/login
-	// POST /auth/register
-	// GET /auth/me
-	// POST /auth/logout
-	// POST /auth/switch-tenant
-	// POST /auth/seed-demo-user
-	
-	// We'll implement additional auth endpoints here if needed
-}
\ No newline at end of file
+	router.POST("/auth/login", handlers.Login(db))
+	router.POST("/auth/register", handlers.Register(db))
+	router.POST("/auth/refresh", handlers.RefreshToken(db))
+
+	// Second login step for users with MFA; these take the mfa_pending
+	// token returned by /auth/login instead of an access token
+	router.POST("/auth/mfa/verify", handlers.VerifyMFA(db))
+	router.POST("/auth/mfa/enroll", handlers.StartPendingMFAEnrollment(db))
+
+	// Login step for users who must change their password; takes the
+	// password_change token returned by /auth/login
+	router.POST("/auth/password/change", handlers.CompletePasswordChange(db))
+
+	// Password reset and email verification links sent by email
+	router.POST("/auth/password/forgot", handlers.ForgotPassword(db))
+	router.POST("/auth/password/reset", handlers.ResetPassword(db))
+	router.POST("/auth/email/verify", handlers.VerifyEmail(db))
+	router.POST("/auth/email/verification/resend", handlers.ResendEmailVerification(db))
+
+	// Passwordless login with a passkey
+	router.POST("/auth/passkeys/login/begin", handlers.BeginPasskeyLogin(db))
+	router.POST("/auth/passkeys/login/finish", handlers.FinishPasskeyLogin(db))
+
+	// OAuth2 token endpoint for API clients (client_credentials grant)
+	router.POST("/oauth/token", handlers.OAuthToken(db))
+
+	// SAML service provider endpoints for tenant connections
+	router.GET("/auth/saml/:connectionId/metadata", handlers.SAMLMetadata(db))
+	router.GET("/auth/saml/:connectionId/login", handlers.SAMLLogin(db))
+	router.POST("/auth/saml/:connectionId/acs", handlers.SAMLACS(db))
+
+	// The authenticated auth endpoints (me, logout, switch-tenant) are
+	// registered with the user routes behind the JWT middleware
+}
@@ -10,15 +10,15 @@ import (
 // RegisterPermissionRoutes registers all permission related routes
 func RegisterPermissionRoutes(router *gin.RouterGroup, db *sql.DB) {
 	// Resources
-	router.GET("/resources", middleware.RequirePermission("resources", "read"), handlers.ListResources(db))
-	router.POST("/resources", middleware.RequirePermission("resources", "create"), handlers.CreateResource(db))
+	router.GET("/resources", middleware.RequirePermission(db, "system", "resources", "read"), handlers.GetResources(db))
+	router.POST("/resources", middleware.RequirePermission(db, "system", "resources", "create"), handlers.CreateResource(db))
 	
 	// Actions
-	router.GET("/actions", middleware.RequirePermission("actions", "read"), handlers.ListActions(db))
-	router.POST("/actions", middleware.RequirePermission("actions", "create"), handlers.CreateAction(db))
+	router.GET("/actions", middleware.RequirePermission(db, "system", "actions", "read"), handlers.GetActions(db))
+	router.POST("/actions", middleware.RequirePermission(db, "system", "actions", "create"), handlers.CreateAction(db))
 	
 	// Permissions
-	router.GET("/permissions", middleware.RequirePermission("permissions", "read"), handlers.ListPermissions(db))
-	router.POST("/permissions", middleware.RequirePermission("permissions", "create"), handlers.CreatePermission(db))
-	router.DELETE("/permissions/:id", middleware.RequirePermission("permissions", "delete"), handlers.DeletePermission(db))
+	router.GET("/permissions", middleware.RequirePermission(db, "system", "permissions", "read"), handlers.GetPermissions(db))
+	router.POST("/permissions", middleware.RequirePermission(db, "system", "permissions", "create"), handlers.CreatePermission(db))
+	router.DELETE("/permissions/:id", middleware.RequirePermission(db, "system", "permissions", "delete"), handlers.DeletePermission(db))
 }
\ No newline at end of file
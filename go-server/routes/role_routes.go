@@ -10,13 +10,14 @@ import (
 // RegisterRoleRoutes registers all role related routes
 func RegisterRoleRoutes(router *gin.RouterGroup, db *sql.DB) {
 	// Roles CRUD operations
-	router.GET("/roles", middleware.RequirePermission("roles", "read"), handlers.ListRoles(db))
-	router.GET("/roles/:id", middleware.RequirePermission("roles", "read"), handlers.GetRole(db))
-	router.POST("/roles", middleware.RequirePermission("roles", "create"), handlers.CreateRole(db))
-	router.PUT("/roles/:id", middleware.RequirePermission("roles", "update"), handlers.UpdateRole(db))
-	router.DELETE("/roles/:id", middleware.RequirePermission("roles", "delete"), handlers.DeleteRole(db))
+	router.GET("/roles", middleware.RequirePermission(db, "system", "roles", "read"), handlers.GetRoles(db))
+	router.GET("/roles/:id", middleware.RequirePermission(db, "system", "roles", "read"), handlers.GetRole(db))
+	router.GET("/roles/:id/effective-permissions", middleware.RequirePermission(db, "system", "roles", "read"), handlers.GetRoleEffectivePermissions(db))
+	router.POST("/roles", middleware.RequirePermission(db, "system", "roles", "create"), handlers.CreateRole(db))
+	router.PUT("/roles/:id", middleware.RequirePermission(db, "system", "roles", "update"), handlers.UpdateRole(db))
+	router.DELETE("/roles/:id", middleware.RequirePermission(db, "system", "roles", "delete"), handlers.DeleteRole(db))
 	
 	// Role assignments
-	router.POST("/user-roles", middleware.RequirePermission("roles", "assign"), handlers.AssignRoleToUser(db))
-	router.DELETE("/user-roles/:userId/:roleId", middleware.RequirePermission("roles", "assign"), handlers.RemoveRoleFromUser(db))
+	router.POST("/user-roles", middleware.RequirePermission(db, "system", "roles", "assign"), handlers.AssignRoleToUser(db))
+	router.DELETE("/user-roles/:userId/:roleId", middleware.RequirePermission(db, "system", "roles", "assign"), handlers.RemoveRoleFromUser(db))
 }
\ No newline at end of file
@@ -4,22 +4,27 @@ import (
 	"database/sql"
 
 	"github.com/gin-gonic/gin"
+	"go-server/middleware"
 )
 
 // RegisterAllRoutes registers all API routes
 func RegisterAllRoutes(router *gin.RouterGroup, db *sql.DB) {
 	// Register authentication routes
 	RegisterAuthRoutes(router, db)
-	
+
+	// Everything below requires a valid token
+	protected := router.Group("")
+	protected.Use(middleware.JWTAuth(db))
+
 	// Register user routes
-	RegisterUserRoutes(router, db)
-	
+	RegisterUserRoutes(protected, db)
+
 	// Register role routes
-	RegisterRoleRoutes(router, db)
-	
+	RegisterRoleRoutes(protected, db)
+
 	// Register tenant routes
-	RegisterTenantRoutes(router, db)
-	
+	RegisterTenantRoutes(protected, db)
+
 	// Register permission routes
-	RegisterPermissionRoutes(router, db)
-}
\ No newline at end of file
+	RegisterPermissionRoutes(protected, db)
+}
@@ -10,12 +10,68 @@ import (
 // RegisterTenantRoutes registers all tenant related routes
 func RegisterTenantRoutes(router *gin.RouterGroup, db *sql.DB) {
 	// Tenants CRUD operations
-	router.GET("/tenants", middleware.RequirePermission("tenants", "read"), handlers.ListTenants(db))
-	router.GET("/tenants/:id", middleware.RequirePermission("tenants", "read"), handlers.GetTenant(db))
-	router.POST("/tenants", middleware.RequirePermission("tenants", "create"), handlers.CreateTenant(db))
-	router.PUT("/tenants/:id", middleware.RequirePermission("tenants", "update"), handlers.UpdateTenant(db))
-	router.DELETE("/tenants/:id", middleware.RequirePermission("tenants", "delete"), handlers.DeleteTenant(db))
+	router.GET("/tenants", middleware.RequirePermission(db, "system", "tenants", "read"), handlers.GetTenants(db))
+	router.GET("/tenants/:id", middleware.RequirePermission(db, "system", "tenants", "read"), handlers.GetTenant(db))
+	router.POST("/tenants", middleware.RequirePermission(db, "system", "tenants", "create"), handlers.CreateTenant(db))
+	router.PUT("/tenants/:id", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.UpdateTenant(db))
+	router.DELETE("/tenants/:id", middleware.RequirePermission(db, "system", "tenants", "delete"), handlers.DeleteTenant(db))
 	
 	// Additional tenant routes
-	router.GET("/tenants/user-counts", middleware.RequirePermission("tenants", "read"), handlers.GetTenantUserCounts(db))
-}
\ No newline at end of file
+	router.GET("/tenants/user-counts", middleware.RequirePermission(db, "system", "tenants", "read"), handlers.GetTenantUserCounts(db))
+
+	// Redirect targets allowed after login
+	router.GET("/tenants/:id/redirect-uris", middleware.RequirePermission(db, "system", "tenants", "read"), handlers.GetTenantRedirectURIs(db))
+	router.POST("/tenants/:id/redirect-uris", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.CreateTenantRedirectURI(db))
+	router.DELETE("/tenants/:id/redirect-uris/:uriId", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.DeleteTenantRedirectURI(db))
+
+	// Identity provider role mappings
+	router.GET("/tenants/:id/idp-role-mappings", middleware.RequirePermission(db, "system", "roles", "read"), handlers.GetIdPRoleMappings(db))
+	router.POST("/tenants/:id/idp-role-mappings", middleware.RequirePermission(db, "system", "roles", "update"), handlers.CreateIdPRoleMapping(db))
+	router.DELETE("/tenants/:id/idp-role-mappings/:mappingId", middleware.RequirePermission(db, "system", "roles", "update"), handlers.DeleteIdPRoleMapping(db))
+	router.GET("/tenants/:id/idp-mapping-settings", middleware.RequirePermission(db, "system", "roles", "read"), handlers.GetIdPMappingSettings(db))
+	router.PUT("/tenants/:id/idp-mapping-settings", middleware.RequirePermission(db, "system", "roles", "update"), handlers.UpdateIdPMappingSettings(db))
+	router.GET("/tenants/:id/role-audit", middleware.RequirePermission(db, "system", "roles", "read"), handlers.GetRoleAssignmentAudit(db))
+
+	// OpenID Connect providers the tenant's users sign in through
+	router.GET("/tenants/:id/oidc-connections", middleware.RequirePermission(db, "system", "tenants", "read"), handlers.GetOIDCConnections(db))
+	router.GET("/tenants/:id/oidc-connections/:connectionId", middleware.RequirePermission(db, "system", "tenants", "read"), handlers.GetOIDCConnection(db))
+	router.POST("/tenants/:id/oidc-connections", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.CreateOIDCConnection(db))
+	router.PUT("/tenants/:id/oidc-connections/:connectionId", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.UpdateOIDCConnection(db))
+	router.DELETE("/tenants/:id/oidc-connections/:connectionId", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.DeleteOIDCConnection(db))
+
+	// SAML identity providers the tenant's users sign in through
+	router.GET("/tenants/:id/saml-connections", middleware.RequirePermission(db, "system", "tenants", "read"), handlers.GetSAMLConnections(db))
+	router.GET("/tenants/:id/saml-connections/:connectionId", middleware.RequirePermission(db, "system", "tenants", "read"), handlers.GetSAMLConnection(db))
+	router.POST("/tenants/:id/saml-connections", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.CreateSAMLConnection(db))
+	router.PUT("/tenants/:id/saml-connections/:connectionId", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.UpdateSAMLConnection(db))
+	router.DELETE("/tenants/:id/saml-connections/:connectionId", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.DeleteSAMLConnection(db))
+
+	// Roles the tenant requires MFA for
+	router.GET("/tenants/:id/mfa-policy", middleware.RequirePermission(db, "system", "tenants", "read"), handlers.GetTenantMFAPolicy(db))
+	router.PUT("/tenants/:id/mfa-policy", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.UpdateTenantMFAPolicy(db))
+
+	// Password rules for the tenant's users
+	router.GET("/tenants/:id/password-policy", middleware.RequirePermission(db, "system", "tenants", "read"), handlers.GetTenantPasswordPolicy(db))
+	router.PUT("/tenants/:id/password-policy", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.UpdateTenantPasswordPolicy(db))
+
+	// What the tenant's users may do before verifying their email address
+	router.GET("/tenants/:id/email-verification-policy", middleware.RequirePermission(db, "system", "tenants", "read"), handlers.GetTenantEmailVerificationPolicy(db))
+	router.PUT("/tenants/:id/email-verification-policy", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.UpdateTenantEmailVerificationPolicy(db))
+
+	// Service accounts for integrations and their access tokens
+	router.GET("/tenants/:id/service-accounts", middleware.RequirePermission(db, "system", "users", "read"), handlers.GetServiceAccounts(db))
+	router.POST("/tenants/:id/service-accounts", middleware.RequirePermission(db, "system", "users", "create"), handlers.CreateServiceAccount(db))
+	router.DELETE("/tenants/:id/service-accounts/:accountId", middleware.RequirePermission(db, "system", "users", "delete"), handlers.DeleteServiceAccount(db))
+	router.GET("/tenants/:id/service-accounts/:accountId/tokens", middleware.RequirePermission(db, "system", "users", "read"), handlers.GetServiceAccountTokens(db))
+	router.POST("/tenants/:id/service-accounts/:accountId/tokens", middleware.RequirePermission(db, "system", "users", "update"), handlers.CreateServiceAccountToken(db))
+	router.DELETE("/tenants/:id/service-accounts/:accountId/tokens/:tokenId", middleware.RequirePermission(db, "system", "users", "update"), handlers.DeleteServiceAccountToken(db))
+
+	// API clients that get tokens through the client_credentials grant
+	router.GET("/tenants/:id/api-clients", middleware.RequirePermission(db, "system", "tenants", "read"), handlers.GetAPIClients(db))
+	router.GET("/tenants/:id/api-clients/:clientId", middleware.RequirePermission(db, "system", "tenants", "read"), handlers.GetAPIClient(db))
+	router.POST("/tenants/:id/api-clients", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.CreateAPIClient(db))
+	router.PUT("/tenants/:id/api-clients/:clientId", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.UpdateAPIClient(db))
+	router.DELETE("/tenants/:id/api-clients/:clientId", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.RevokeAPIClient(db))
+	router.POST("/tenants/:id/api-clients/:clientId/secrets", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.RotateAPIClientSecret(db))
+	router.DELETE("/tenants/:id/api-clients/:clientId/secrets/:secretId", middleware.RequirePermission(db, "system", "tenants", "update"), handlers.RevokeAPIClientSecret(db))
+}
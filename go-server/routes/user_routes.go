@@ -9,20 +9,65 @@ import (
 
 // RegisterUserRoutes registers all user related routes
 func RegisterUserRoutes(router *gin.RouterGroup, db *sql.DB) {
-	// All routes here already require authentication from the middleware set in main.go
+	// All routes here already require authentication from the middleware set in RegisterAllRoutes
 	
 	// Add routes for user management
 	router.GET("/auth/me", handlers.GetCurrentUser(db))
-	router.POST("/auth/logout", handlers.Logout)
-	router.POST("/auth/switch-tenant", handlers.SwitchTenant(db))
+	router.POST("/auth/impersonation/end", handlers.EndImpersonation(db))
+
+	// Routes that manage the user's own sessions and sign-in methods cannot
+	// be used with a personal access token
+	account := router.Group("", middleware.RequireInteractiveSession())
+	account.POST("/auth/logout", handlers.Logout(db))
+	account.POST("/auth/logout-all", handlers.LogoutAll(db))
+	account.POST("/auth/switch-tenant", handlers.SwitchTenant(db))
+	account.POST("/auth/password", handlers.ChangePassword(db))
+	account.POST("/auth/email/verification", handlers.RequestEmailVerification(db))
+
+	// TOTP enrolment and recovery codes for the current user
+	account.GET("/auth/mfa", handlers.GetMFAStatus(db))
+	account.POST("/auth/mfa/totp", handlers.StartMFAEnrollment(db))
+	account.POST("/auth/mfa/totp/confirm", handlers.ConfirmMFAEnrollment(db))
+	account.POST("/auth/mfa/totp/disable", handlers.DisableMFA(db))
+	account.POST("/auth/mfa/recovery-codes", handlers.RegenerateRecoveryCodes(db))
+
+	// Passkeys registered to the current user
+	account.GET("/auth/passkeys", handlers.GetPasskeys(db))
+	account.POST("/auth/passkeys/register/begin", handlers.BeginPasskeyRegistration(db))
+	account.POST("/auth/passkeys/register/finish", handlers.FinishPasskeyRegistration(db))
+	account.DELETE("/auth/passkeys/:passkeyId", handlers.DeletePasskey(db))
+
+	// Personal access tokens for the current user
+	account.GET("/auth/tokens", handlers.GetPersonalAccessTokens(db))
+	account.POST("/auth/tokens", handlers.CreatePersonalAccessToken(db))
+	account.DELETE("/auth/tokens/:tokenId", handlers.DeletePersonalAccessToken(db))
+
+	// Sessions the current user is signed in with
+	account.GET("/auth/sessions", handlers.GetSessions(db))
+	account.DELETE("/auth/sessions", handlers.RevokeOtherSessions(db))
+	account.DELETE("/auth/sessions/:sessionId", handlers.RevokeSession(db))
+
+	// Super admins acting as another user for support, with an audit trail
+	account.POST("/users/:id/impersonate", middleware.RequireSuperAdmin(), handlers.StartImpersonation(db))
+	router.GET("/impersonations", middleware.RequireSuperAdmin(), handlers.GetImpersonations(db))
+	router.GET("/impersonations/:impersonationId", middleware.RequireSuperAdmin(), handlers.GetImpersonationAudit(db))
 	
 	// Users CRUD operations
-	router.GET("/users", middleware.RequirePermission("users", "read"), handlers.ListUsers(db))
-	router.GET("/users/:id", middleware.RequirePermission("users", "read"), handlers.GetUser(db))
-	router.POST("/users", middleware.RequirePermission("users", "create"), handlers.CreateUser(db))
-	router.PUT("/users/:id", middleware.RequirePermission("users", "update"), handlers.UpdateUser(db))
-	router.DELETE("/users/:id", middleware.RequirePermission("users", "delete"), handlers.DeleteUser(db))
+	router.GET("/users", middleware.RequirePermission(db, "system", "users", "read"), handlers.ListUsers(db))
+	router.GET("/users/:id", middleware.RequirePermission(db, "system", "users", "read"), handlers.GetUser(db))
+	router.POST("/users", middleware.RequirePermission(db, "system", "users", "create"), handlers.CreateUser(db))
+	router.PUT("/users/:id", middleware.RequirePermission(db, "system", "users", "update"), handlers.UpdateUser(db))
+	router.DELETE("/users/:id", middleware.RequirePermission(db, "system", "users", "delete"), handlers.DeleteUser(db))
+	router.POST("/users/:id/unlock", middleware.RequirePermission(db, "system", "users", "update"), handlers.UnlockUser(db))
 	
 	// User roles management
-	router.GET("/users/:userId/roles", middleware.RequirePermission("users", "read"), handlers.GetUserRoles(db))
+	router.GET("/users/:id/roles", middleware.RequirePermission(db, "system", "users", "read"), handlers.GetUserRoles(db))
+
+	// Clear a user's MFA so they can enrol again
+	router.DELETE("/users/:id/mfa", middleware.RequirePermission(db, "system", "users", "update"), handlers.ResetUserMFA(db))
+
+	// Sessions a user is signed in with
+	router.GET("/users/:id/sessions", middleware.RequirePermission(db, "system", "users", "read"), handlers.GetUserSessions(db))
+	router.DELETE("/users/:id/sessions", middleware.RequirePermission(db, "system", "users", "update"), handlers.RevokeUserSessions(db))
+	router.DELETE("/users/:id/sessions/:sessionId", middleware.RequirePermission(db, "system", "users", "update"), handlers.RevokeUserSession(db))
 }
\ No newline at end of file
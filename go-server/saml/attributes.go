@@ -0,0 +1,133 @@
+package saml
+
+import (
+	"fmt"
+	"strings"
+
+	samlsdk "github.com/crewjam/saml"
+)
+
+// Identity fields a connection's attribute mappings can set
+const (
+	FieldSubject     = "subject"
+	FieldUsername    = "username"
+	FieldEmail       = "email"
+	FieldDisplayName = "displayName"
+	FieldAvatar      = "avatar"
+	FieldRoles       = "roles"
+	FieldGroups      = "groups"
+)
+
+// DefaultAttributeMappings are the attributes each identity field is read from
+// unless a connection maps it elsewhere. The subject defaults to the NameID.
+var DefaultAttributeMappings = map[string]string{
+	FieldSubject:     "",
+	FieldUsername:    "uid",
+	FieldEmail:       "email",
+	FieldDisplayName: "displayName",
+	FieldAvatar:      "",
+	FieldRoles:       "role",
+	FieldGroups:      "groups",
+}
+
+// emailNameIDFormat is the NameID format whose value is an email address
+const emailNameIDFormat = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+
+// Identity is a user as described by an assertion
+type Identity struct {
+	Subject     string
+	Username    string
+	Email       string
+	DisplayName string
+	Avatar      string
+	// Roles and Groups are nil when the assertion has no such attribute
+	Roles  []string
+	Groups []string
+}
+
+// ValidateAttributeMappings checks every mapping is for a known identity field
+func ValidateAttributeMappings(mappings map[string]string) error {
+	for field, attribute := range mappings {
+		if _, ok := DefaultAttributeMappings[field]; !ok {
+			return fmt.Errorf("unknown attribute mapping field %q", field)
+		}
+		if strings.TrimSpace(attribute) == "" {
+			return fmt.Errorf("attribute mapping for %q is empty", field)
+		}
+	}
+	return nil
+}
+
+// MapAssertion extracts an identity from a validated assertion. Mappings name
+// the attribute for each field, matched on its Name or FriendlyName.
+func MapAssertion(assertion *samlsdk.Assertion, mappings map[string]string) Identity {
+	attributeFor := func(field string) string {
+		if attribute := mappings[field]; attribute != "" {
+			return attribute
+		}
+		return DefaultAttributeMappings[field]
+	}
+
+	var nameID *samlsdk.NameID
+	if assertion.Subject != nil {
+		nameID = assertion.Subject.NameID
+	}
+
+	identity := Identity{
+		Subject:     firstValue(assertion, attributeFor(FieldSubject)),
+		Username:    firstValue(assertion, attributeFor(FieldUsername)),
+		Email:       firstValue(assertion, attributeFor(FieldEmail)),
+		DisplayName: firstValue(assertion, attributeFor(FieldDisplayName)),
+		Avatar:      firstValue(assertion, attributeFor(FieldAvatar)),
+		Roles:       values(assertion, attributeFor(FieldRoles)),
+		Groups:      values(assertion, attributeFor(FieldGroups)),
+	}
+
+	if identity.Subject == "" && nameID != nil {
+		identity.Subject = nameID.Value
+	}
+	if identity.Email == "" && nameID != nil && nameID.Format == emailNameIDFormat {
+		identity.Email = nameID.Value
+	}
+
+	// Fall back to the email's local part for IdPs that send no username
+	if identity.Username == "" && identity.Email != "" {
+		identity.Username = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+
+	return identity
+}
+
+// values returns every value of the named attribute, or nil if the assertion
+// has no such attribute
+func values(assertion *samlsdk.Assertion, name string) []string {
+	if name == "" {
+		return nil
+	}
+
+	var result []string
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if attribute.Name != name && attribute.FriendlyName != name {
+				continue
+			}
+			if result == nil {
+				result = []string{}
+			}
+			for _, value := range attribute.Values {
+				if v := strings.TrimSpace(value.Value); v != "" {
+					result = append(result, v)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// firstValue returns the first value of the named attribute, or ""
+func firstValue(assertion *samlsdk.Assertion, name string) string {
+	if list := values(assertion, name); len(list) > 0 {
+		return list[0]
+	}
+	return ""
+}
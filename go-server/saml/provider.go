@@ -0,0 +1,233 @@
+package saml
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	samlsdk "github.com/crewjam/saml"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// Config configures a ServiceProvider for one tenant connection
+type Config struct {
+	// EntityID identifies us to the IdP. It is also the URL our metadata is served at.
+	EntityID string
+	// ACSURL is where the IdP posts its responses
+	ACSURL string
+	// Key and Certificate sign our AuthnRequests
+	Key         *rsa.PrivateKey
+	Certificate *x509.Certificate
+	// IdPMetadata is the IdP's parsed metadata, see ParseIdPMetadata
+	IdPMetadata *samlsdk.EntityDescriptor
+}
+
+// ServiceProvider is our side of a SAML federation with one IdP. Only
+// SP-initiated logins are accepted: every response must answer a request we made.
+type ServiceProvider struct {
+	entityID string
+	sp       *samlsdk.ServiceProvider
+}
+
+// NewServiceProvider creates a service provider for a connection
+func NewServiceProvider(config Config) (*ServiceProvider, error) {
+	if config.Key == nil || config.Certificate == nil {
+		return nil, errors.New("saml: a signing key and certificate are required")
+	}
+	if config.IdPMetadata == nil {
+		return nil, errors.New("saml: IdP metadata is required")
+	}
+
+	entityID, err := url.Parse(config.EntityID)
+	if err != nil {
+		return nil, fmt.Errorf("saml: invalid entity ID: %v", err)
+	}
+	acsURL, err := url.Parse(config.ACSURL)
+	if err != nil {
+		return nil, fmt.Errorf("saml: invalid ACS URL: %v", err)
+	}
+
+	return &ServiceProvider{
+		entityID: config.EntityID,
+		sp: &samlsdk.ServiceProvider{
+			EntityID:          config.EntityID,
+			MetadataURL:       *entityID,
+			AcsURL:            *acsURL,
+			Key:               config.Key,
+			Certificate:       config.Certificate,
+			IDPMetadata:       config.IdPMetadata,
+			AuthnNameIDFormat: samlsdk.PersistentNameIDFormat,
+			SignatureMethod:   dsig.RSASHA256SignatureMethod,
+		},
+	}, nil
+}
+
+// Metadata returns our SP metadata for the IdP administrator
+func (p *ServiceProvider) Metadata() ([]byte, error) {
+	metadata := p.sp.Metadata()
+
+	// Responses are only accepted through the POST binding
+	for i := range metadata.SPSSODescriptors {
+		descriptor := &metadata.SPSSODescriptors[i]
+		services := descriptor.AssertionConsumerServices[:0]
+		for _, service := range descriptor.AssertionConsumerServices {
+			if service.Binding == samlsdk.HTTPPostBinding {
+				services = append(services, service)
+			}
+		}
+		descriptor.AssertionConsumerServices = services
+	}
+
+	out, err := xml.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// AuthnRequest creates a signed AuthnRequest for the HTTP-Redirect binding.
+// It returns the URL to send the browser to and the request's ID, which the
+// response must answer.
+func (p *ServiceProvider) AuthnRequest(relayState string) (redirectURL string, requestID string, err error) {
+	location := p.sp.GetSSOBindingLocation(samlsdk.HTTPRedirectBinding)
+	if location == "" {
+		return "", "", errors.New("saml: IdP has no HTTP-Redirect single sign-on service")
+	}
+
+	request, err := p.sp.MakeAuthenticationRequest(location, samlsdk.HTTPRedirectBinding, samlsdk.HTTPPostBinding)
+	if err != nil {
+		return "", "", err
+	}
+
+	// The query string is signed as a whole, so the relay state must not need escaping
+	if url.QueryEscape(relayState) != relayState {
+		return "", "", errors.New("saml: relay state must be URL safe")
+	}
+
+	redirect, err := request.Redirect(relayState, p.sp)
+	if err != nil {
+		return "", "", err
+	}
+	return redirect.String(), request.ID, nil
+}
+
+// ParseResponse validates a response posted to the ACS: its signature against
+// the IdP's certificates, issuer, destination, recipient, validity window,
+// that it answers requestID, and that it is addressed to our entity ID.
+func (p *ServiceProvider) ParseResponse(r *http.Request, requestID string) (*samlsdk.Assertion, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	if r.PostForm.Get("SAMLResponse") == "" {
+		return nil, errors.New("saml: no SAMLResponse in the request")
+	}
+
+	assertion, err := p.sp.ParseResponse(r, []string{requestID})
+	if err != nil {
+		// The library hides the reason behind a generic message
+		var invalid *samlsdk.InvalidResponseError
+		if errors.As(err, &invalid) && invalid.PrivateErr != nil {
+			return nil, fmt.Errorf("saml: invalid response: %v", invalid.PrivateErr)
+		}
+		return nil, err
+	}
+
+	// The library checks InResponseTo on each subject confirmation, so an
+	// assertion without one would not be tied to our request
+	if assertion.Subject == nil || len(assertion.Subject.SubjectConfirmations) == 0 {
+		return nil, errors.New("saml: assertion has no subject confirmation")
+	}
+
+	// An assertion without an audience restriction could have been issued
+	// for any service provider, so insist on one that names us
+	if !hasAudience(assertion, p.entityID) {
+		return nil, fmt.Errorf("saml: assertion is not addressed to %s", p.entityID)
+	}
+
+	return assertion, nil
+}
+
+// hasAudience reports whether the assertion is restricted to the audience
+func hasAudience(assertion *samlsdk.Assertion, audience string) bool {
+	if assertion.Conditions == nil {
+		return false
+	}
+	for _, restriction := range assertion.Conditions.AudienceRestrictions {
+		if restriction.Audience.Value == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// whitespace matches the line breaks IdPs put in base64 certificates
+var whitespace = regexp.MustCompile(`\s+`)
+
+// ParseIdPMetadata parses and checks an IdP's metadata document. It must name
+// the IdP, offer an HTTP-Redirect single sign-on service and include at least
+// one signing certificate.
+func ParseIdPMetadata(data []byte) (*samlsdk.EntityDescriptor, error) {
+	var descriptor samlsdk.EntityDescriptor
+	if err := xml.Unmarshal(data, &descriptor); err != nil || len(descriptor.IDPSSODescriptors) == 0 {
+		// Federations publish several entities in one document
+		var entities samlsdk.EntitiesDescriptor
+		if err := xml.Unmarshal(data, &entities); err != nil {
+			return nil, fmt.Errorf("saml: invalid IdP metadata: %v", err)
+		}
+		found := false
+		for _, entity := range entities.EntityDescriptors {
+			if len(entity.IDPSSODescriptors) > 0 {
+				descriptor = entity
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("saml: metadata does not describe an identity provider")
+		}
+	}
+
+	if descriptor.EntityID == "" {
+		return nil, errors.New("saml: IdP metadata has no entity ID")
+	}
+
+	hasRedirect := false
+	hasCertificate := false
+	for _, idp := range descriptor.IDPSSODescriptors {
+		for _, service := range idp.SingleSignOnServices {
+			if service.Binding == samlsdk.HTTPRedirectBinding && service.Location != "" {
+				hasRedirect = true
+			}
+		}
+		for _, key := range idp.KeyDescriptors {
+			if key.Use != "" && key.Use != "signing" {
+				continue
+			}
+			for _, cert := range key.KeyInfo.X509Data.X509Certificates {
+				der, err := base64.StdEncoding.DecodeString(whitespace.ReplaceAllString(cert.Data, ""))
+				if err != nil {
+					return nil, fmt.Errorf("saml: invalid IdP certificate: %v", err)
+				}
+				if _, err := x509.ParseCertificate(der); err != nil {
+					return nil, fmt.Errorf("saml: invalid IdP certificate: %v", err)
+				}
+				hasCertificate = true
+			}
+		}
+	}
+
+	if !hasRedirect {
+		return nil, errors.New("saml: IdP metadata has no HTTP-Redirect single sign-on service")
+	}
+	if !hasCertificate {
+		return nil, errors.New("saml: IdP metadata has no signing certificate")
+	}
+
+	return &descriptor, nil
+}
@@ -0,0 +1,432 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	samlsdk "github.com/crewjam/saml"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	testIdPEntityID = "https://idp.example.com/metadata"
+	testIdPSSOURL   = "https://idp.example.com/sso"
+	testSPEntityID  = "https://app.example.com/api/auth/saml/1/metadata"
+	testSPACSURL    = "https://app.example.com/api/auth/saml/1/acs"
+)
+
+// testIdP is a local identity provider with its own generated key pair. It
+// signs responses the way a real IdP would.
+type testIdP struct {
+	idp *samlsdk.IdentityProvider
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	keyPEM, certPEM, err := GenerateKeyPair("Test IdP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, cert, err := ParseKeyPair(keyPEM, certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testIdP{idp: &samlsdk.IdentityProvider{
+		Key:             key,
+		Certificate:     cert,
+		MetadataURL:     *mustParseURL(t, testIdPEntityID),
+		SSOURL:          *mustParseURL(t, testIdPSSOURL),
+		SignatureMethod: dsig.RSASHA256SignatureMethod,
+	}}
+}
+
+// metadata returns the IdP's metadata document
+func (i *testIdP) metadata(t *testing.T) []byte {
+	t.Helper()
+	out, err := xml.Marshal(i.idp.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// assertion returns a valid assertion for alice answering requestID
+func (i *testIdP) assertion(requestID string) *samlsdk.Assertion {
+	now := time.Now()
+	return &samlsdk.Assertion{
+		ID:           "id-assertion",
+		IssueInstant: now,
+		Version:      "2.0",
+		Issuer: samlsdk.Issuer{
+			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+			Value:  testIdPEntityID,
+		},
+		Subject: &samlsdk.Subject{
+			NameID: &samlsdk.NameID{
+				Format: string(samlsdk.PersistentNameIDFormat),
+				Value:  "alice-persistent-id",
+			},
+			SubjectConfirmations: []samlsdk.SubjectConfirmation{{
+				Method: "urn:oasis:names:tc:SAML:2.0:cm:bearer",
+				SubjectConfirmationData: &samlsdk.SubjectConfirmationData{
+					InResponseTo: requestID,
+					NotOnOrAfter: now.Add(5 * time.Minute),
+					Recipient:    testSPACSURL,
+				},
+			}},
+		},
+		Conditions: &samlsdk.Conditions{
+			NotBefore:    now.Add(-time.Minute),
+			NotOnOrAfter: now.Add(5 * time.Minute),
+			AudienceRestrictions: []samlsdk.AudienceRestriction{{
+				Audience: samlsdk.Audience{Value: testSPEntityID},
+			}},
+		},
+		AuthnStatements: []samlsdk.AuthnStatement{{AuthnInstant: now}},
+		AttributeStatements: []samlsdk.AttributeStatement{{
+			Attributes: []samlsdk.Attribute{
+				stringAttribute("uid", "alice"),
+				stringAttribute("email", "alice@example.com"),
+				stringAttribute("displayName", "Alice Example"),
+				stringAttribute("groups", "compliance", "auditors"),
+			},
+		}},
+	}
+}
+
+// respond signs the assertion into a response to requestID and returns the
+// base64 SAMLResponse form value the browser would post
+func (i *testIdP) respond(t *testing.T, requestID string, assertion *samlsdk.Assertion) string {
+	t.Helper()
+	req := &samlsdk.IdpAuthnRequest{
+		IDP:             i.idp,
+		Request:         samlsdk.AuthnRequest{ID: requestID},
+		SPSSODescriptor: &samlsdk.SPSSODescriptor{},
+		ACSEndpoint:     &samlsdk.IndexedEndpoint{Binding: samlsdk.HTTPPostBinding, Location: testSPACSURL},
+		Assertion:       assertion,
+		Now:             time.Now(),
+	}
+	if err := req.MakeResponse(); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := etree.NewDocument()
+	doc.SetRoot(req.ResponseEl)
+	out, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(out)
+}
+
+func stringAttribute(name string, values ...string) samlsdk.Attribute {
+	attribute := samlsdk.Attribute{Name: name, NameFormat: "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"}
+	for _, value := range values {
+		attribute.Values = append(attribute.Values, samlsdk.AttributeValue{Type: "xs:string", Value: value})
+	}
+	return attribute
+}
+
+// newTestServiceProvider creates a service provider trusting the IdP
+func newTestServiceProvider(t *testing.T, idp *testIdP) *ServiceProvider {
+	t.Helper()
+	keyPEM, certPEM, err := GenerateKeyPair("Test SP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, cert, err := ParseKeyPair(keyPEM, certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idpMetadata, err := ParseIdPMetadata(idp.metadata(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sp, err := NewServiceProvider(Config{
+		EntityID:    testSPEntityID,
+		ACSURL:      testSPACSURL,
+		Key:         key,
+		Certificate: cert,
+		IdPMetadata: idpMetadata,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sp
+}
+
+// startLogin creates an AuthnRequest and returns its ID
+func startLogin(t *testing.T, sp *ServiceProvider) string {
+	t.Helper()
+	_, requestID, err := sp.AuthnRequest("relay-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return requestID
+}
+
+// postResponse builds the ACS request a browser would post
+func postResponse(samlResponse string) *http.Request {
+	form := url.Values{"SAMLResponse": {samlResponse}, "RelayState": {"relay-state"}}
+	req := httptest.NewRequest(http.MethodPost, testSPACSURL, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestServiceProviderAcceptsSignedResponse(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := newTestServiceProvider(t, idp)
+	requestID := startLogin(t, sp)
+
+	assertion, err := sp.ParseResponse(postResponse(idp.respond(t, requestID, idp.assertion(requestID))), requestID)
+	if err != nil {
+		t.Fatalf("valid response rejected: %v", err)
+	}
+
+	identity := MapAssertion(assertion, nil)
+	if identity.Subject != "alice-persistent-id" {
+		t.Errorf("subject = %q, want the NameID", identity.Subject)
+	}
+	if identity.Username != "alice" || identity.Email != "alice@example.com" || identity.DisplayName != "Alice Example" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if len(identity.Groups) != 2 || identity.Groups[0] != "compliance" || identity.Groups[1] != "auditors" {
+		t.Errorf("groups = %v", identity.Groups)
+	}
+	if identity.Roles != nil {
+		t.Errorf("roles = %v, want nil when the assertion has no role attribute", identity.Roles)
+	}
+}
+
+func TestServiceProviderRejectsInvalidResponses(t *testing.T) {
+	idp := newTestIdP(t)
+	otherIdP := newTestIdP(t)
+	sp := newTestServiceProvider(t, idp)
+
+	tests := []struct {
+		name    string
+		respond func(requestID string) string
+	}{
+		{
+			name: "signed by another key",
+			respond: func(requestID string) string {
+				return otherIdP.respond(t, requestID, otherIdP.assertion(requestID))
+			},
+		},
+		{
+			name: "tampered after signing",
+			respond: func(requestID string) string {
+				raw, _ := base64.StdEncoding.DecodeString(idp.respond(t, requestID, idp.assertion(requestID)))
+				tampered := strings.Replace(string(raw), "alice@example.com", "admin@example.com", 1)
+				return base64.StdEncoding.EncodeToString([]byte(tampered))
+			},
+		},
+		{
+			name: "for another audience",
+			respond: func(requestID string) string {
+				assertion := idp.assertion(requestID)
+				assertion.Conditions.AudienceRestrictions[0].Audience.Value = "https://other-sp.example.com"
+				return idp.respond(t, requestID, assertion)
+			},
+		},
+		{
+			name: "without an audience",
+			respond: func(requestID string) string {
+				assertion := idp.assertion(requestID)
+				assertion.Conditions.AudienceRestrictions = nil
+				return idp.respond(t, requestID, assertion)
+			},
+		},
+		{
+			name: "answering another request",
+			respond: func(requestID string) string {
+				return idp.respond(t, "id-other-request", idp.assertion("id-other-request"))
+			},
+		},
+		{
+			name: "without a subject confirmation",
+			respond: func(requestID string) string {
+				assertion := idp.assertion(requestID)
+				assertion.Subject.SubjectConfirmations = nil
+				return idp.respond(t, requestID, assertion)
+			},
+		},
+		{
+			name: "expired",
+			respond: func(requestID string) string {
+				assertion := idp.assertion(requestID)
+				assertion.Conditions.NotBefore = time.Now().Add(-time.Hour)
+				assertion.Conditions.NotOnOrAfter = time.Now().Add(-30 * time.Minute)
+				return idp.respond(t, requestID, assertion)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requestID := startLogin(t, sp)
+			if _, err := sp.ParseResponse(postResponse(tt.respond(requestID)), requestID); err == nil {
+				t.Fatal("invalid response accepted")
+			}
+		})
+	}
+}
+
+func TestAuthnRequestIsSigned(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := newTestServiceProvider(t, idp)
+
+	redirectURL, requestID, err := sp.AuthnRequest("relay-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(redirectURL, testIdPSSOURL+"?") {
+		t.Fatalf("redirect %s is not to the IdP's SSO service", redirectURL)
+	}
+
+	// The redirect binding signs the raw query up to the Signature parameter
+	rawQuery := mustParseURL(t, redirectURL).RawQuery
+	signedPart := rawQuery[:strings.Index(rawQuery, "&Signature=")]
+	query := mustParseURL(t, redirectURL).Query()
+	if query.Get("SigAlg") != dsig.RSASHA256SignatureMethod {
+		t.Errorf("SigAlg = %q", query.Get("SigAlg"))
+	}
+	if query.Get("RelayState") != "relay-state" {
+		t.Errorf("RelayState = %q", query.Get("RelayState"))
+	}
+	signature, err := base64.StdEncoding.DecodeString(query.Get("Signature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(signedPart))
+	if err := rsa.VerifyPKCS1v15(sp.sp.Certificate.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature); err != nil {
+		t.Fatalf("AuthnRequest signature does not verify with the SP certificate: %v", err)
+	}
+
+	// The request itself names us and our ACS
+	deflated, err := base64.StdEncoding.DecodeString(query.Get("SAMLRequest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inflated, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request samlsdk.AuthnRequest
+	if err := xml.Unmarshal(inflated, &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.ID != requestID || request.Issuer.Value != testSPEntityID || request.AssertionConsumerServiceURL != testSPACSURL {
+		t.Errorf("unexpected AuthnRequest %+v", request)
+	}
+}
+
+func TestMetadataDescribesServiceProvider(t *testing.T) {
+	sp := newTestServiceProvider(t, newTestIdP(t))
+
+	out, err := sp.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metadata samlsdk.EntityDescriptor
+	if err := xml.Unmarshal(out, &metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.EntityID != testSPEntityID {
+		t.Errorf("entity ID = %q", metadata.EntityID)
+	}
+	descriptor := metadata.SPSSODescriptors[0]
+	if descriptor.AuthnRequestsSigned == nil || !*descriptor.AuthnRequestsSigned {
+		t.Error("metadata does not declare signed AuthnRequests")
+	}
+	if len(descriptor.AssertionConsumerServices) != 1 ||
+		descriptor.AssertionConsumerServices[0].Binding != samlsdk.HTTPPostBinding ||
+		descriptor.AssertionConsumerServices[0].Location != testSPACSURL {
+		t.Errorf("unexpected ACS endpoints %+v", descriptor.AssertionConsumerServices)
+	}
+}
+
+func TestParseIdPMetadataRequiresRedirectAndCertificate(t *testing.T) {
+	idp := newTestIdP(t)
+	if _, err := ParseIdPMetadata(idp.metadata(t)); err != nil {
+		t.Fatalf("valid metadata rejected: %v", err)
+	}
+
+	noCertificate := idp.idp.Metadata()
+	noCertificate.IDPSSODescriptors[0].KeyDescriptors = nil
+	out, _ := xml.Marshal(noCertificate)
+	if _, err := ParseIdPMetadata(out); err == nil {
+		t.Error("metadata without a signing certificate accepted")
+	}
+
+	postOnly := idp.idp.Metadata()
+	var services []samlsdk.Endpoint
+	for _, service := range postOnly.IDPSSODescriptors[0].SingleSignOnServices {
+		if service.Binding != samlsdk.HTTPRedirectBinding {
+			services = append(services, service)
+		}
+	}
+	postOnly.IDPSSODescriptors[0].SingleSignOnServices = services
+	out, _ = xml.Marshal(postOnly)
+	if _, err := ParseIdPMetadata(out); err == nil {
+		t.Error("metadata without an HTTP-Redirect SSO service accepted")
+	}
+
+	if _, err := ParseIdPMetadata([]byte("<not-metadata/>")); err == nil {
+		t.Error("invalid metadata accepted")
+	}
+}
+
+func TestMapAssertionUsesMappings(t *testing.T) {
+	idp := newTestIdP(t)
+	assertion := idp.assertion("id-request")
+	assertion.Subject.NameID = &samlsdk.NameID{Format: emailNameIDFormat, Value: "alice@corp.example.com"}
+	assertion.AttributeStatements[0].Attributes = []samlsdk.Attribute{
+		stringAttribute("http://schemas.microsoft.com/ws/2008/06/identity/claims/role", "Admin"),
+		{Name: "urn:oid:0.9.2342.19200300.100.1.1", FriendlyName: "employeeNumber", Values: []samlsdk.AttributeValue{{Value: "E123"}}},
+	}
+
+	identity := MapAssertion(assertion, map[string]string{
+		FieldSubject: "employeeNumber",
+		FieldRoles:   "http://schemas.microsoft.com/ws/2008/06/identity/claims/role",
+	})
+
+	if identity.Subject != "E123" {
+		t.Errorf("subject = %q, want the mapped attribute matched by friendly name", identity.Subject)
+	}
+	if identity.Email != "alice@corp.example.com" || identity.Username != "alice" {
+		t.Errorf("email and username should fall back to the email NameID, got %+v", identity)
+	}
+	if len(identity.Roles) != 1 || identity.Roles[0] != "Admin" {
+		t.Errorf("roles = %v", identity.Roles)
+	}
+
+	if err := ValidateAttributeMappings(map[string]string{"password": "pwd"}); err == nil {
+		t.Error("unknown mapping field accepted")
+	}
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
@@ -0,0 +1,255 @@
+// Package sqltest provides an in-memory database/sql driver for tests of code
+// that talks to PostgreSQL. Tests register a handler for each kind of
+// statement they expect, matched by a regular expression, and keep whatever
+// state the handlers need themselves.
+//
+// Every statement must bind exactly the arguments its $n placeholders ask
+// for, as PostgreSQL would insist, and a statement no handler matches fails
+// the test.
+package sqltest
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Result is what a handler returns for a statement. Queries return Rows
+// under Columns; other statements report RowsAffected.
+type Result struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+}
+
+// Handler answers a statement given its arguments
+type Handler func(args []driver.Value) (*Result, error)
+
+// Statement is a statement that was run, with the arguments bound to it
+type Statement struct {
+	Query string
+	Args  []driver.Value
+}
+
+// DB is a database whose statements are answered by registered handlers
+type DB struct {
+	*sql.DB
+
+	t    *testing.T
+	name string
+
+	mu         sync.Mutex
+	handlers   []handler
+	statements []Statement
+}
+
+type handler struct {
+	pattern *regexp.Regexp
+	handle  Handler
+}
+
+var (
+	registerOnce sync.Once
+	databasesMu  sync.Mutex
+	databases    = map[string]*DB{}
+	databaseSeq  int
+)
+
+// Open returns an empty database that is closed when the test ends
+func Open(t *testing.T) *DB {
+	t.Helper()
+	registerOnce.Do(func() { sql.Register("sqltest", fakeDriver{}) })
+
+	databasesMu.Lock()
+	databaseSeq++
+	db := &DB{t: t, name: strconv.Itoa(databaseSeq)}
+	databases[db.name] = db
+	databasesMu.Unlock()
+
+	sqlDB, err := sql.Open("sqltest", db.name)
+	if err != nil {
+		t.Fatalf("sqltest: %v", err)
+	}
+	db.DB = sqlDB
+
+	t.Cleanup(func() {
+		sqlDB.Close()
+		databasesMu.Lock()
+		delete(databases, db.name)
+		databasesMu.Unlock()
+	})
+	return db
+}
+
+// Handle registers a handler for statements matching pattern, a regular
+// expression matched against the statement with its whitespace collapsed.
+// Handlers registered later take precedence.
+func (db *DB) Handle(pattern string, handle Handler) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.handlers = append([]handler{{regexp.MustCompile(pattern), handle}}, db.handlers...)
+}
+
+// Ignore registers statements matching pattern as returning no rows
+func (db *DB) Ignore(pattern string) {
+	db.Handle(pattern, func([]driver.Value) (*Result, error) {
+		return &Result{}, nil
+	})
+}
+
+// Statements returns the statements run so far whose text matches pattern
+func (db *DB) Statements(pattern string) []Statement {
+	re := regexp.MustCompile(pattern)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var matched []Statement
+	for _, statement := range db.statements {
+		if re.MatchString(statement.Query) {
+			matched = append(matched, statement)
+		}
+	}
+	return matched
+}
+
+// Row is a convenience for building a Result with a single row
+func Row(columns []string, values ...driver.Value) *Result {
+	return &Result{Columns: columns, Rows: [][]driver.Value{values}}
+}
+
+// Affected is a convenience for building a Result for a statement that
+// changed n rows
+func Affected(n int64) *Result {
+	return &Result{RowsAffected: n}
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+func normalize(query string) string {
+	return strings.TrimSpace(whitespace.ReplaceAllString(query, " "))
+}
+
+var placeholder = regexp.MustCompile(`\$(\d+)`)
+
+// numInput is the highest $n placeholder in the query
+func numInput(query string) int {
+	n := 0
+	for _, match := range placeholder.FindAllStringSubmatch(query, -1) {
+		if i, _ := strconv.Atoi(match[1]); i > n {
+			n = i
+		}
+	}
+	return n
+}
+
+func (db *DB) run(query string, args []driver.Value) (*Result, error) {
+	db.mu.Lock()
+	db.statements = append(db.statements, Statement{Query: query, Args: args})
+	var handle Handler
+	for _, h := range db.handlers {
+		if h.pattern.MatchString(query) {
+			handle = h.handle
+			break
+		}
+	}
+	db.mu.Unlock()
+
+	if handle == nil {
+		db.t.Errorf("sqltest: unexpected statement: %s", query)
+		return nil, fmt.Errorf("sqltest: unexpected statement: %s", query)
+	}
+	result, err := handle(args)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		result = &Result{}
+	}
+	return result, nil
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+	db, ok := databases[name]
+	if !ok {
+		return nil, fmt.Errorf("sqltest: unknown database %q", name)
+	}
+	return &conn{db: db}, nil
+}
+
+type conn struct {
+	db *DB
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	query = normalize(query)
+	return &stmt{db: c.db, query: query, numInput: numInput(query)}, nil
+}
+
+func (c *conn) Close() error { return nil }
+
+// Begin starts a transaction. Statements take effect as they run, so
+// handlers see a transaction's writes whether or not it commits.
+func (c *conn) Begin() (driver.Tx, error) { return tx{}, nil }
+
+type tx struct{}
+
+func (tx) Commit() error   { return nil }
+func (tx) Rollback() error { return nil }
+
+type stmt struct {
+	db       *DB
+	query    string
+	numInput int
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return s.numInput }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	result, err := s.db.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(result.RowsAffected), nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	result, err := s.db.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range result.Rows {
+		if len(row) != len(result.Columns) {
+			return nil, errors.New("sqltest: row does not match its columns")
+		}
+	}
+	return &rows{result: result}, nil
+}
+
+type rows struct {
+	result *Result
+	next   int
+}
+
+func (r *rows) Columns() []string { return r.result.Columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.Rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.Rows[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,21 @@
+package tokens
+
+import "time"
+
+// PasswordResetTTL is how long a password reset link works
+const PasswordResetTTL = time.Hour
+
+// EmailVerificationTTL is how long an email verification link works
+const EmailVerificationTTL = 48 * time.Hour
+
+// NewActionToken returns a new single-use token for a link sent by email,
+// such as a password reset, and the hash it is stored under
+func NewActionToken() (string, string) {
+	value := newRefreshTokenValue()
+	return value, HashActionToken(value)
+}
+
+// HashActionToken returns the hash under which an action token is stored
+func HashActionToken(token string) string {
+	return HashRefreshToken(token)
+}
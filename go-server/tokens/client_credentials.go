@@ -0,0 +1,63 @@
+package tokens
+
+import (
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go-server/models"
+)
+
+// ClientSecretPrefix starts every API client secret, so secret scanners
+// can pick them up
+const ClientSecretPrefix = "tcx_cs_"
+
+// clientIDPrefix starts every API client ID
+const clientIDPrefix = "tcx_client_"
+
+// ClientCredentialsTTL is how long a token issued to an API client is valid.
+// Clients have no refresh token and simply ask for a new one.
+const ClientCredentialsTTL = time.Hour
+
+// NewClientID returns a new public identifier for an API client
+func NewClientID() string {
+	return clientIDPrefix + NewID()
+}
+
+// NewClientSecret returns a new API client secret, the hash it is stored
+// under and the prefix shown to identify it
+func NewClientSecret() (value string, hash string, displayPrefix string) {
+	value = ClientSecretPrefix + newRefreshTokenValue()
+	return value, HashClientSecret(value), value[:len(ClientSecretPrefix)+6]
+}
+
+// HashClientSecret returns the hash under which an API client secret is stored
+func HashClientSecret(secret string) string {
+	return HashRefreshToken(secret)
+}
+
+// IssueClientCredentials signs an access token for an API client acting as
+// its service account user. The token carries no roles; what it may do is
+// limited to the granted scopes.
+func IssueClientCredentials(user *models.User, client *models.APIClient, scopes []string) (string, *Claims, error) {
+	now := time.Now()
+	claims := NewClaims(user, nil, NewID())
+	claims.TenantID = client.TenantID
+	claims.IsSuperAdmin = false
+	claims.ClientID = client.ClientID
+	claims.Scope = strings.Join(scopes, " ")
+	claims.ID = NewID()
+	claims.setIssuedAt(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ClientCredentialsTTL))
+
+	tokenString, err := sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return tokenString, claims, nil
+}
+
+// Scopes returns the scopes granted to an API client token
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
@@ -0,0 +1,510 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go-server/models"
+)
+
+// Supported signing algorithms
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+)
+
+// DefaultKeyRotationInterval is how long a generated signing key stays active
+const DefaultKeyRotationInterval = 30 * 24 * time.Hour
+
+// DefaultKeyGracePeriod is how long a retired key keeps verifying tokens
+const DefaultKeyGracePeriod = 24 * time.Hour
+
+// keyringRefreshInterval is how often the keyring picks up keys rotated by other replicas
+const keyringRefreshInterval = time.Minute
+
+// defaultKeyThumbprint is the JWK thumbprint of the private_key.pem that used to
+// be committed to this repository. It is public and must never sign tokens in
+// production.
+const defaultKeyThumbprint = "KikP8HIWMxFExJUuKXl8KOws9iLU8E7MiM8jVlJWyLI"
+
+// KeyringConfig configures how signing keys are chosen and rotated
+type KeyringConfig struct {
+	// Algorithm used for generated keys, RS256 or ES256
+	Algorithm string
+	// RotationInterval is how often a new key is generated. Zero disables rotation.
+	RotationInterval time.Duration
+	// GracePeriod is how long a retired key is still accepted and published
+	GracePeriod time.Duration
+	// KeyFile is an optional PEM private key to sign with instead of generated keys.
+	// Rotation is then done by deploying a new file.
+	KeyFile string
+	// Production refuses the default key
+	Production bool
+}
+
+// KeyringConfigFromEnv reads the keyring configuration from the environment
+func KeyringConfigFromEnv() KeyringConfig {
+	config := KeyringConfig{
+		Algorithm:        os.Getenv("JWT_SIGNING_ALGORITHM"),
+		RotationInterval: DefaultKeyRotationInterval,
+		GracePeriod:      DefaultKeyGracePeriod,
+		KeyFile:          os.Getenv("JWT_SIGNING_KEY_FILE"),
+		Production:       os.Getenv("APP_ENV") == "production",
+	}
+
+	if config.Algorithm == "" {
+		config.Algorithm = AlgorithmRS256
+	}
+
+	if value := os.Getenv("JWT_KEY_ROTATION_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			log.Printf("WARNING: Ignoring invalid JWT_KEY_ROTATION_INTERVAL %q, using %s", value, DefaultKeyRotationInterval)
+		} else {
+			config.RotationInterval = interval
+		}
+	}
+
+	if value := os.Getenv("JWT_KEY_GRACE_PERIOD"); value != "" {
+		grace, err := time.ParseDuration(value)
+		if err != nil || grace < 0 {
+			log.Printf("WARNING: Ignoring invalid JWT_KEY_GRACE_PERIOD %q, using %s", value, DefaultKeyGracePeriod)
+		} else {
+			config.GracePeriod = grace
+		}
+	}
+
+	return config
+}
+
+// keyringKey is a parsed signing key
+type keyringKey struct {
+	id        string
+	algorithm string
+	signer    crypto.Signer
+	createdAt time.Time
+}
+
+// Keyring holds the active signing key and the retired keys still in their
+// grace period. Keys are stored in the database so all replicas share them.
+type Keyring struct {
+	db     *sql.DB
+	config KeyringConfig
+
+	mu         sync.RWMutex
+	active     *keyringKey
+	keys       map[string]*keyringKey
+	lastReload time.Time
+}
+
+// keyring is the process-wide keyring set up by InitKeyring
+var keyring *Keyring
+
+// InitKeyring loads the signing keys, generating or importing the active key
+// as needed, and keeps them up to date in the background
+func InitKeyring(db *sql.DB, config KeyringConfig) error {
+	if config.Algorithm != AlgorithmRS256 && config.Algorithm != AlgorithmES256 {
+		return fmt.Errorf("unsupported JWT signing algorithm %q", config.Algorithm)
+	}
+
+	// A retired key must outlive the tokens it signed
+	if config.GracePeriod < accessTokenTTL {
+		log.Printf("WARNING: JWT key grace period %s is shorter than the access token lifetime, using %s", config.GracePeriod, accessTokenTTL)
+		config.GracePeriod = accessTokenTTL
+	}
+
+	k := &Keyring{
+		db:     db,
+		config: config,
+		keys:   make(map[string]*keyringKey),
+	}
+
+	if config.KeyFile != "" {
+		if err := k.importKeyFile(config.KeyFile); err != nil {
+			return err
+		}
+	} else if err := k.rotateIfDue(); err != nil {
+		return err
+	}
+
+	if err := k.reload(); err != nil {
+		return err
+	}
+
+	if k.active.id == defaultKeyThumbprint {
+		if config.Production {
+			return fmt.Errorf("refusing to start in production with the default JWT signing key")
+		}
+		log.Println("WARNING: Signing tokens with the default key. Never use it in production.")
+	}
+
+	keyring = k
+	go k.run()
+
+	log.Printf("JWT keyring initialized with active key %s (%s)", k.active.id, k.active.algorithm)
+	return nil
+}
+
+// run periodically rotates the active key and reloads keys rotated elsewhere
+func (k *Keyring) run() {
+	ticker := time.NewTicker(keyringRefreshInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if k.config.KeyFile == "" {
+			if err := k.rotateIfDue(); err != nil {
+				log.Printf("Error rotating JWT signing key: %v", err)
+			}
+		}
+		if err := k.reload(); err != nil {
+			log.Printf("Error reloading JWT signing keys: %v", err)
+		}
+	}
+}
+
+// rotateIfDue generates a new active key when there is none, when it has
+// outlived the rotation interval, or when the configured algorithm changed
+func (k *Keyring) rotateIfDue() error {
+	active, err := models.GetActiveSigningKey(k.db)
+	if err != nil {
+		return err
+	}
+
+	if active != nil && active.Algorithm == k.config.Algorithm &&
+		(k.config.RotationInterval == 0 || time.Since(active.CreatedAt) < k.config.RotationInterval) {
+		return nil
+	}
+
+	signer, err := generateSigner(k.config.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	key, err := newSigningKeyRecord(signer)
+	if err != nil {
+		return err
+	}
+
+	if err := models.ActivateSigningKey(k.db, key); err != nil {
+		return err
+	}
+
+	log.Printf("Rotated JWT signing key, new key %s", key.KID)
+	return nil
+}
+
+// importKeyFile makes the PEM private key in path the active key
+func (k *Keyring) importKeyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read JWT signing key: %v", err)
+	}
+
+	signer, err := parsePrivateKeyPEM(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse JWT signing key: %v", err)
+	}
+
+	key, err := newSigningKeyRecord(signer)
+	if err != nil {
+		return err
+	}
+
+	return models.ActivateSigningKey(k.db, key)
+}
+
+// reload refreshes the in-memory keys from the database
+func (k *Keyring) reload() error {
+	records, err := models.ListSigningKeys(k.db, time.Now().Add(-k.config.GracePeriod))
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys := make(map[string]*keyringKey, len(records))
+	var active *keyringKey
+	for _, record := range records {
+		key, ok := k.keys[record.KID]
+		if !ok {
+			signer, err := parsePrivateKeyPEM([]byte(record.PrivateKey))
+			if err != nil {
+				log.Printf("Skipping unreadable JWT signing key %s: %v", record.KID, err)
+				continue
+			}
+			key = &keyringKey{
+				id:        record.KID,
+				algorithm: record.Algorithm,
+				signer:    signer,
+				createdAt: record.CreatedAt,
+			}
+		}
+		keys[key.id] = key
+
+		// Records are ordered newest first
+		if record.RetiredAt == nil && active == nil {
+			active = key
+		}
+	}
+
+	if active == nil {
+		return fmt.Errorf("no active JWT signing key")
+	}
+
+	k.keys = keys
+	k.active = active
+	k.lastReload = time.Now()
+	return nil
+}
+
+// signingKey returns the active key
+func (k *Keyring) signingKey() *keyringKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// verificationKey returns the key with the given ID. An unknown ID triggers a
+// reload, as another replica may have just rotated.
+func (k *Keyring) verificationKey(kid string) (*keyringKey, bool) {
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	stale := time.Since(k.lastReload) > 10*time.Second
+	k.mu.RUnlock()
+
+	if ok || !stale {
+		return key, ok
+	}
+
+	if err := k.reload(); err != nil {
+		log.Printf("Error reloading JWT signing keys: %v", err)
+		return nil, false
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok = k.keys[kid]
+	return key, ok
+}
+
+// signingMethod returns the JWT signing method for an algorithm
+func signingMethod(algorithm string) jwt.SigningMethod {
+	switch algorithm {
+	case AlgorithmES256:
+		return jwt.SigningMethodES256
+	default:
+		return jwt.SigningMethodRS256
+	}
+}
+
+// sign signs the claims with the active key, setting the kid header
+func sign(claims jwt.Claims) (string, error) {
+	if keyring == nil {
+		return "", fmt.Errorf("JWT keyring is not initialized")
+	}
+
+	key := keyring.signingKey()
+	token := jwt.NewWithClaims(signingMethod(key.algorithm), claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.signer)
+}
+
+// verificationKeyFunc resolves the public key for a token from its kid header
+func verificationKeyFunc(token *jwt.Token) (interface{}, error) {
+	if keyring == nil {
+		return nil, fmt.Errorf("JWT keyring is not initialized")
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("token has no key ID")
+	}
+
+	key, ok := keyring.verificationKey(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if token.Method.Alg() != key.algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.signer.Public(), nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKSet is a set of JSON Web Keys
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys that verify tokens issued by this server
+func JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	if keyring == nil {
+		return set
+	}
+
+	keyring.mu.RLock()
+	defer keyring.mu.RUnlock()
+
+	for _, key := range keyring.keys {
+		jwk, err := publicJWK(key.id, key.algorithm, key.signer.Public())
+		if err != nil {
+			log.Printf("Skipping JWT signing key %s in JWKS: %v", key.id, err)
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// publicJWK converts a public key to its JWK representation
+func publicJWK(kid string, algorithm string, public crypto.PublicKey) (JWK, error) {
+	jwk := JWK{KeyID: kid, Use: "sig", Algorithm: algorithm}
+
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return jwk, fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+		}
+		jwk.KeyType = "EC"
+		jwk.Curve = "P-256"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32)))
+	default:
+		return jwk, fmt.Errorf("unsupported key type %T", public)
+	}
+
+	return jwk, nil
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint of a public key
+func thumbprint(public crypto.PublicKey) (string, error) {
+	jwk, err := publicJWK("", "", public)
+	if err != nil {
+		return "", err
+	}
+
+	// The required members in lexicographic order
+	var members interface{}
+	if jwk.KeyType == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// generateSigner generates a new private key for the algorithm
+func generateSigner(algorithm string) (crypto.Signer, error) {
+	if algorithm == AlgorithmES256 {
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	return rsa.GenerateKey(rand.Reader, 2048)
+}
+
+// newSigningKeyRecord builds the stored form of a key, identified by its thumbprint
+func newSigningKeyRecord(signer crypto.Signer) (*models.SigningKey, error) {
+	algorithm := AlgorithmRS256
+	if _, ok := signer.(*ecdsa.PrivateKey); ok {
+		algorithm = AlgorithmES256
+	}
+
+	kid, err := thumbprint(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SigningKey{
+		KID:        kid,
+		Algorithm:  algorithm,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}, nil
+}
+
+// parsePrivateKeyPEM parses an RSA or P-256 ECDSA private key in PKCS#8,
+// PKCS#1 or SEC 1 form
+func parsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var key interface{}
+	var err error
+	if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return nil, fmt.Errorf("unsupported private key format")
+			}
+		}
+	}
+
+	switch signer := key.(type) {
+	case *rsa.PrivateKey:
+		return signer, nil
+	case *ecdsa.PrivateKey:
+		if signer.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported curve %s", signer.Curve.Params().Name)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
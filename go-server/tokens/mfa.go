@@ -0,0 +1,26 @@
+package tokens
+
+import (
+	"time"
+
+	"go-server/models"
+)
+
+// PurposeMFAPending marks a token issued after a correct password to a user
+// who still has to present their second factor
+const PurposeMFAPending = "mfa_pending"
+
+// MFAPendingTTL is how long a user has to enter their code after their password
+const MFAPendingTTL = 5 * time.Minute
+
+// IssueMFAPending signs a short-lived mfa_pending token for the user. It
+// carries no roles and is not accepted as an access token; it can only be
+// exchanged for one by completing the second step of the login.
+func IssueMFAPending(user *models.User) (string, *Claims, error) {
+	return issueForPurpose(user, PurposeMFAPending, MFAPendingTTL)
+}
+
+// ParseMFAPending validates an mfa_pending token string and returns its claims
+func ParseMFAPending(tokenString string) (*Claims, error) {
+	return parseForPurpose(tokenString, PurposeMFAPending)
+}
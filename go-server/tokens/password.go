@@ -0,0 +1,25 @@
+package tokens
+
+import (
+	"time"
+
+	"go-server/models"
+)
+
+// PurposePasswordChange marks a token issued after a correct login to a user
+// who must choose a new password before they are given an access token
+const PurposePasswordChange = "password_change"
+
+// PasswordChangeTTL is how long a user has to choose their new password
+const PasswordChangeTTL = 10 * time.Minute
+
+// IssuePasswordChange signs a short-lived password_change token for the
+// user. It can only be exchanged for an access token by changing the password.
+func IssuePasswordChange(user *models.User) (string, *Claims, error) {
+	return issueForPurpose(user, PurposePasswordChange, PasswordChangeTTL)
+}
+
+// ParsePasswordChange validates a password_change token string and returns its claims
+func ParsePasswordChange(tokenString string) (*Claims, error) {
+	return parseForPurpose(tokenString, PurposePasswordChange)
+}
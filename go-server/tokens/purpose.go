@@ -0,0 +1,50 @@
+package tokens
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go-server/models"
+)
+
+// issueForPurpose signs a short-lived token for one step of a login, such
+// as a second factor. It carries no roles and Parse rejects it.
+func issueForPurpose(user *models.User, purpose string, ttl time.Duration) (string, *Claims, error) {
+	now := time.Now()
+	claims := &Claims{
+		Version:  ClaimsVersion,
+		UserID:   user.ID,
+		Username: user.Username,
+		TenantID: user.TenantID,
+		Roles:    []string{},
+		Purpose:  purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        NewID(),
+			Subject:   strconv.Itoa(user.ID),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	claims.setIssuedAt(now)
+
+	tokenString, err := sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return tokenString, claims, nil
+}
+
+// parseForPurpose validates a token string issued for the given purpose
+func parseForPurpose(tokenString string, purpose string) (*Claims, error) {
+	claims, err := parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Purpose != purpose {
+		return nil, fmt.Errorf("not a %s token", purpose)
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,89 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go-server/models"
+)
+
+// DefaultRefreshTokenTTL is how long a refresh token is valid when REFRESH_TOKEN_EXPIRY is not set
+const DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+var refreshTokenTTL = DefaultRefreshTokenTTL
+
+func init() {
+	if expiry := os.Getenv("REFRESH_TOKEN_EXPIRY"); expiry != "" {
+		ttl, err := time.ParseDuration(expiry)
+		if err != nil || ttl <= 0 {
+			log.Printf("WARNING: Ignoring invalid REFRESH_TOKEN_EXPIRY %q, using %s", expiry, DefaultRefreshTokenTTL)
+		} else {
+			refreshTokenTTL = ttl
+		}
+	}
+}
+
+// HashRefreshToken returns the hash under which a refresh token is stored
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshTokenValue returns a new opaque refresh token
+func newRefreshTokenValue() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// IssueRefreshToken stores a new refresh token for the session described by
+// the claims and returns its opaque value. The session ID is the token family.
+func IssueRefreshToken(db *sql.DB, claims *Claims) (string, error) {
+	value := newRefreshTokenValue()
+	_, err := models.CreateRefreshToken(db, &models.RefreshToken{
+		TokenHash: HashRefreshToken(value),
+		FamilyID:  claims.SessionID,
+		UserID:    claims.UserID,
+		TenantID:  claims.TenantID,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family. It returns the new opaque value and the stored successor, which
+// carries the user, tenant and family to issue the access token for.
+func RotateRefreshToken(db *sql.DB, token string) (string, *models.RefreshToken, error) {
+	value := newRefreshTokenValue()
+	next, err := models.RotateRefreshToken(db, HashRefreshToken(token), &models.RefreshToken{
+		TokenHash: HashRefreshToken(value),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return value, next, nil
+}
+
+// StartSession records the session described by the claims, which lasts as
+// long as its refresh tokens. The device, user agent and address are taken
+// from the given session.
+func StartSession(db *sql.DB, claims *Claims, session models.UserSession) error {
+	session.ID = claims.SessionID
+	session.UserID = claims.UserID
+	session.TenantID = claims.TenantID
+	session.ExpiresAt = time.Now().Add(refreshTokenTTL)
+	return models.CreateUserSession(db, &session)
+}
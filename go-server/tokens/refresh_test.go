@@ -0,0 +1,186 @@
+package tokens
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"go-server/models"
+	"go-server/sqltest"
+)
+
+var refreshTokenColumns = []string{"id", "token_hash", "family_id", "user_id", "tenant_id", "expires_at", "used_at", "revoked_at", "created_at"}
+
+// refreshTokenStore keeps the refresh_tokens rows and revoked sessions of a
+// test database
+type refreshTokenStore struct {
+	tokens          []*models.RefreshToken
+	revokedSessions map[string]bool
+}
+
+func newRefreshTokenStore(t *testing.T) (*sqltest.DB, *refreshTokenStore) {
+	db := sqltest.Open(t)
+	store := &refreshTokenStore{revokedSessions: map[string]bool{}}
+
+	db.Handle(`^INSERT INTO refresh_tokens`, func(args []driver.Value) (*sqltest.Result, error) {
+		token := &models.RefreshToken{
+			ID:        len(store.tokens) + 1,
+			TokenHash: args[0].(string),
+			FamilyID:  args[1].(string),
+			UserID:    int(args[2].(int64)),
+			TenantID:  int(args[3].(int64)),
+			ExpiresAt: args[4].(time.Time),
+			CreatedAt: args[5].(time.Time),
+		}
+		store.tokens = append(store.tokens, token)
+		return sqltest.Row([]string{"id"}, int64(token.ID)), nil
+	})
+	db.Handle(`^SELECT .* FROM refresh_tokens WHERE token_hash = \$1`, func(args []driver.Value) (*sqltest.Result, error) {
+		result := &sqltest.Result{Columns: refreshTokenColumns}
+		if token := store.byHash(args[0].(string)); token != nil {
+			result.Rows = append(result.Rows, []driver.Value{
+				int64(token.ID), token.TokenHash, token.FamilyID, int64(token.UserID), int64(token.TenantID),
+				token.ExpiresAt, nullTime(token.UsedAt), nullTime(token.RevokedAt), token.CreatedAt,
+			})
+		}
+		return result, nil
+	})
+	db.Handle(`^UPDATE refresh_tokens SET used_at = \$1 WHERE id = \$2$`, func(args []driver.Value) (*sqltest.Result, error) {
+		usedAt := args[0].(time.Time)
+		store.tokens[args[1].(int64)-1].UsedAt = &usedAt
+		return sqltest.Affected(1), nil
+	})
+	db.Handle(`^UPDATE refresh_tokens SET revoked_at = \$1 WHERE family_id = \$2 AND revoked_at IS NULL$`, func(args []driver.Value) (*sqltest.Result, error) {
+		revokedAt := args[0].(time.Time)
+		var n int64
+		for _, token := range store.tokens {
+			if token.FamilyID == args[1].(string) && token.RevokedAt == nil {
+				token.RevokedAt = &revokedAt
+				n++
+			}
+		}
+		return sqltest.Affected(n), nil
+	})
+	db.Handle(`^UPDATE user_sessions SET revoked_at = \$1 WHERE id = \$2 AND revoked_at IS NULL$`, func(args []driver.Value) (*sqltest.Result, error) {
+		store.revokedSessions[args[1].(string)] = true
+		return sqltest.Affected(1), nil
+	})
+
+	return db, store
+}
+
+func (s *refreshTokenStore) byHash(hash string) *models.RefreshToken {
+	for _, token := range s.tokens {
+		if token.TokenHash == hash {
+			return token
+		}
+	}
+	return nil
+}
+
+func nullTime(t *time.Time) driver.Value {
+	if t == nil {
+		return nil
+	}
+	return *t
+}
+
+func testClaims() *Claims {
+	return &Claims{UserID: 7, TenantID: 3, SessionID: NewID()}
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	db, store := newRefreshTokenStore(t)
+	claims := testClaims()
+
+	first, err := IssueRefreshToken(db.DB, claims)
+	if err != nil {
+		t.Fatalf("IssueRefreshToken: %v", err)
+	}
+
+	second, next, err := RotateRefreshToken(db.DB, first)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	if second == first {
+		t.Error("rotation returned the same refresh token")
+	}
+	if next.FamilyID != claims.SessionID || next.UserID != claims.UserID || next.TenantID != claims.TenantID {
+		t.Errorf("successor is family %s, user %d, tenant %d; want the rotated token's", next.FamilyID, next.UserID, next.TenantID)
+	}
+	if store.byHash(HashRefreshToken(first)).UsedAt == nil {
+		t.Error("rotated token was not marked used")
+	}
+
+	// The successor rotates in turn
+	if _, _, err := RotateRefreshToken(db.DB, second); err != nil {
+		t.Fatalf("RotateRefreshToken with the successor: %v", err)
+	}
+}
+
+func TestRotateRefreshTokenReuseRevokesFamily(t *testing.T) {
+	db, store := newRefreshTokenStore(t)
+	claims := testClaims()
+
+	first, err := IssueRefreshToken(db.DB, claims)
+	if err != nil {
+		t.Fatalf("IssueRefreshToken: %v", err)
+	}
+	second, _, err := RotateRefreshToken(db.DB, first)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+
+	// Another family of the same user must not be touched
+	other, err := IssueRefreshToken(db.DB, testClaims())
+	if err != nil {
+		t.Fatalf("IssueRefreshToken: %v", err)
+	}
+
+	if _, _, err := RotateRefreshToken(db.DB, first); !errors.Is(err, models.ErrRefreshTokenReused) {
+		t.Fatalf("replaying a rotated token: got %v, want %v", err, models.ErrRefreshTokenReused)
+	}
+
+	for _, token := range store.tokens {
+		if token.FamilyID == claims.SessionID && token.RevokedAt == nil {
+			t.Errorf("token %d in the replayed family is not revoked", token.ID)
+		}
+	}
+	if !store.revokedSessions[claims.SessionID] {
+		t.Error("the replayed family's session is not revoked")
+	}
+
+	// Whoever holds the current token is signed out too
+	if _, _, err := RotateRefreshToken(db.DB, second); !errors.Is(err, models.ErrRefreshTokenInvalid) {
+		t.Errorf("rotating the successor after reuse: got %v, want %v", err, models.ErrRefreshTokenInvalid)
+	}
+	if _, _, err := RotateRefreshToken(db.DB, other); err != nil {
+		t.Errorf("rotating another family after reuse: %v", err)
+	}
+}
+
+func TestRotateExpiredRefreshToken(t *testing.T) {
+	db, store := newRefreshTokenStore(t)
+
+	token, err := IssueRefreshToken(db.DB, testClaims())
+	if err != nil {
+		t.Fatalf("IssueRefreshToken: %v", err)
+	}
+	stored := store.byHash(HashRefreshToken(token))
+	stored.ExpiresAt = time.Now().Add(-time.Minute)
+
+	if _, _, err := RotateRefreshToken(db.DB, token); !errors.Is(err, models.ErrRefreshTokenInvalid) {
+		t.Fatalf("rotating an expired token: got %v, want %v", err, models.ErrRefreshTokenInvalid)
+	}
+	if stored.UsedAt != nil {
+		t.Error("expired token was marked used")
+	}
+	if len(store.tokens) != 1 {
+		t.Errorf("rotating an expired token stored %d tokens, want 1", len(store.tokens))
+	}
+
+	if _, _, err := RotateRefreshToken(db.DB, "unknown"); !errors.Is(err, models.ErrRefreshTokenInvalid) {
+		t.Errorf("rotating an unknown token: got %v, want %v", err, models.ErrRefreshTokenInvalid)
+	}
+}
@@ -0,0 +1,47 @@
+package tokens
+
+import (
+	"database/sql"
+	"time"
+
+	"go-server/models"
+)
+
+// IsRevoked checks whether the token described by the claims has been revoked.
+// Issue times and revocation cutoffs are compared to the microsecond, and a
+// cutoff revokes tokens issued at the same microsecond.
+func IsRevoked(db *sql.DB, claims *Claims) (bool, error) {
+	var issuedAt time.Time
+	if claims.IssuedAtMicros != 0 {
+		issuedAt = time.UnixMicro(claims.IssuedAtMicros)
+	} else if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time.Truncate(time.Microsecond)
+	}
+	return models.IsTokenRevoked(db, claims.ID, claims.SessionID, claims.UserID, issuedAt)
+}
+
+// RevokeSession revokes the access token described by the claims together
+// with its session and the session's refresh tokens
+func RevokeSession(db *sql.DB, claims *Claims) error {
+	expiresAt := time.Now().Add(accessTokenTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	if err := models.RevokeToken(db, claims.ID, claims.UserID, expiresAt); err != nil {
+		return err
+	}
+
+	if claims.SessionID == "" {
+		return nil
+	}
+	if err := models.EndUserSession(db, claims.SessionID); err != nil {
+		return err
+	}
+	return models.RevokeRefreshTokenFamily(db, claims.SessionID)
+}
+
+// RevokeAllForUser revokes every access and refresh token issued to the user
+func RevokeAllForUser(db *sql.DB, userID int) error {
+	return models.RevokeAllUserTokens(db, userID)
+}
@@ -0,0 +1,138 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"go-server/models"
+	"go-server/sqltest"
+)
+
+// useTestKeyring signs and verifies tokens with a fresh key for the test
+func useTestKeyring(t *testing.T) {
+	t.Helper()
+
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	key := &keyringKey{id: "test", algorithm: AlgorithmES256, signer: signer, createdAt: time.Now()}
+
+	previous := keyring
+	keyring = &Keyring{active: key, keys: map[string]*keyringKey{key.id: key}, lastReload: time.Now()}
+	t.Cleanup(func() { keyring = previous })
+}
+
+// newRevocationStore answers the statements that revoke all of a user's
+// tokens and check a token against the revocations
+func newRevocationStore(t *testing.T) *sqltest.DB {
+	db := sqltest.Open(t)
+	cutoffs := map[int64]time.Time{}
+
+	db.Handle(`^INSERT INTO user_token_revocations`, func(args []driver.Value) (*sqltest.Result, error) {
+		cutoffs[args[0].(int64)] = args[1].(time.Time)
+		return sqltest.Affected(1), nil
+	})
+	db.Ignore(`^UPDATE (refresh_tokens|user_sessions) SET revoked_at = \$1 WHERE user_id = \$2`)
+	db.Handle(`^SELECT EXISTS \(SELECT 1 FROM revoked_tokens`, func(args []driver.Value) (*sqltest.Result, error) {
+		cutoff, ok := cutoffs[args[2].(int64)]
+		revoked := ok && !cutoff.Before(args[3].(time.Time))
+		return sqltest.Row([]string{"revoked"}, revoked), nil
+	})
+
+	return db
+}
+
+func TestRevokeAllKeepsTokensIssuedAfterIt(t *testing.T) {
+	useTestKeyring(t)
+	db := newRevocationStore(t)
+	user := &models.User{ID: 7, Username: "alice", TenantID: 3}
+
+	// Tokens issued in the same second as the cutoff must fall on the
+	// right side of it
+	for i := 0; i < 20; i++ {
+		before, _, err := IssueForUser(user, nil)
+		if err != nil {
+			t.Fatalf("IssueForUser: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+
+		if err := RevokeAllForUser(db.DB, user.ID); err != nil {
+			t.Fatalf("RevokeAllForUser: %v", err)
+		}
+		after, _, err := IssueForUser(user, nil)
+		if err != nil {
+			t.Fatalf("IssueForUser: %v", err)
+		}
+
+		for _, test := range []struct {
+			name  string
+			token string
+			want  bool
+		}{
+			{"issued before", before, true},
+			{"issued after", after, false},
+		} {
+			claims, err := Parse(test.token)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			revoked, err := IsRevoked(db.DB, claims)
+			if err != nil {
+				t.Fatalf("IsRevoked: %v", err)
+			}
+			if revoked != test.want {
+				t.Fatalf("token %s revoking all tokens: revoked %v, want %v", test.name, revoked, test.want)
+			}
+		}
+	}
+}
+
+func TestRevokeAllComparesIssueTimesToTheMicrosecond(t *testing.T) {
+	useTestKeyring(t)
+	db := newRevocationStore(t)
+	user := &models.User{ID: 7, Username: "alice", TenantID: 3}
+
+	if err := RevokeAllForUser(db.DB, user.ID); err != nil {
+		t.Fatalf("RevokeAllForUser: %v", err)
+	}
+	cutoff := db.Statements(`^INSERT INTO user_token_revocations`)[0].Args[1].(time.Time)
+
+	for _, test := range []struct {
+		name     string
+		issuedAt time.Time
+		want     bool
+	}{
+		{"a microsecond before the cutoff", cutoff.Add(-time.Microsecond), true},
+		{"at the cutoff", cutoff, true},
+		{"a microsecond after the cutoff", cutoff.Add(time.Microsecond), false},
+	} {
+		claims := NewClaims(user, nil, NewID())
+		claims.ID = NewID()
+		claims.setIssuedAt(test.issuedAt)
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+		token, err := sign(claims)
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+
+		// The issue time has to survive the round trip through the token
+		parsed, err := Parse(token)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		revoked, err := IsRevoked(db.DB, parsed)
+		if err != nil {
+			t.Fatalf("IsRevoked: %v", err)
+		}
+		if revoked != test.want {
+			t.Errorf("token issued %s: revoked %v, want %v", test.name, revoked, test.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go-server/models"
+)
+
+// ClaimsVersion is the version of the claim set issued by this package.
+// Tokens carrying any other version are rejected by Parse.
+const ClaimsVersion = 1
+
+// DefaultAccessTokenTTL is how long an access token is valid when JWT_EXPIRY is not set.
+// Access tokens are short-lived; clients renew them with a refresh token.
+const DefaultAccessTokenTTL = 15 * time.Minute
+
+var accessTokenTTL = DefaultAccessTokenTTL
+
+func init() {
+	if expiry := os.Getenv("JWT_EXPIRY"); expiry != "" {
+		ttl, err := time.ParseDuration(expiry)
+		if err != nil || ttl <= 0 {
+			log.Printf("WARNING: Ignoring invalid JWT_EXPIRY %q, using %s", expiry, DefaultAccessTokenTTL)
+		} else {
+			accessTokenTTL = ttl
+		}
+	}
+}
+
+// Claims is the single claim set carried by every token the server issues
+type Claims struct {
+	Version      int      `json:"ver"`
+	UserID       int      `json:"userId"`
+	Username     string   `json:"username"`
+	Email        string   `json:"email"`
+	TenantID     int      `json:"tenantId"`
+	Roles        []string `json:"roles"`
+	IsSuperAdmin bool     `json:"isSuperAdmin"`
+	SessionID    string   `json:"sid"`
+	// Purpose is empty for access tokens. Tokens issued for anything else,
+	// such as PurposeMFAPending, are rejected by Parse.
+	Purpose string `json:"pur,omitempty"`
+	// ClientID is set on tokens issued to an API client through the
+	// client_credentials grant, and Scope to the scopes granted to it
+	ClientID string `json:"cid,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// Actor is set on impersonation tokens to the super admin acting as
+	// the user the token is for
+	Actor *Actor `json:"act,omitempty"`
+	// IssuedAtMicros is the issue time in microseconds since the epoch.
+	// Revoking all of a user's tokens revokes those issued up to the
+	// microsecond, which iat does not record.
+	IssuedAtMicros int64 `json:"iat_us,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// setIssuedAt records the issue time as iat and, more precisely, as iat_us
+func (c *Claims) setIssuedAt(now time.Time) {
+	c.IssuedAt = jwt.NewNumericDate(now)
+	c.IssuedAtMicros = now.UnixMicro()
+}
+
+// NewClaims builds the claims for a user acting in their current tenant.
+// The roles are the user's roles in that tenant.
+func NewClaims(user *models.User, roles []models.Role, sessionID string) *Claims {
+	roleNames := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Name)
+	}
+
+	return &Claims{
+		Version:      ClaimsVersion,
+		UserID:       user.ID,
+		Username:     user.Username,
+		Email:        user.Email,
+		TenantID:     user.TenantID,
+		Roles:        roleNames,
+		IsSuperAdmin: user.IsSuperAdmin,
+		SessionID:    sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: strconv.Itoa(user.ID),
+		},
+	}
+}
+
+// Issue signs the claims with the active keyring key, stamping a fresh token ID and validity window
+func Issue(claims *Claims) (string, error) {
+	now := time.Now()
+	claims.Version = ClaimsVersion
+	claims.ID = NewID()
+	claims.setIssuedAt(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(accessTokenTTL))
+	if claims.SessionID == "" {
+		claims.SessionID = NewID()
+	}
+
+	return sign(claims)
+}
+
+// IssueForUser starts a new session for the user and returns its signed token
+func IssueForUser(user *models.User, roles []models.Role) (string, *Claims, error) {
+	claims := NewClaims(user, roles, NewID())
+	tokenString, err := Issue(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return tokenString, claims, nil
+}
+
+// Parse validates an access token string and returns its claims
+func Parse(tokenString string) (*Claims, error) {
+	claims, err := parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("not an access token")
+	}
+
+	return claims, nil
+}
+
+// parse validates a token string of any purpose and returns its claims
+func parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKeyFunc,
+		jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmES256}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	if claims.Version != ClaimsVersion {
+		return nil, fmt.Errorf("unsupported token version %d", claims.Version)
+	}
+
+	return claims, nil
+}
+
+// ParseBearer extracts and validates the token from an Authorization header
+func ParseBearer(authHeader string) (*Claims, error) {
+	tokenString, err := BearerToken(authHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(tokenString)
+}
+
+// NewID returns a random identifier suitable for token and session IDs
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -116,54 +116,8 @@ const startProxy = async () => {
 
   // All /api/* requests are proxied to Go server by the middleware below
   // We don't need special handling for individual /api/auth/* routes
-
-  // EMERGENCY DIRECT HANDLER: Express handles the OAuth redirect directly
-  app.get('/api/auth/casdoor', (req, res) => {
-    console.log('⚠️ EXPRESS: EMERGENCY DIRECT HANDLING of /api/auth/casdoor');
-    console.log('⚠️ EXPRESS: Bypassing Go server completely for OAuth redirect');
-    
-    // Hard-coded Casdoor parameters
-    const casdoorEndpoint = "https://tracextech.casdoor.com";
-    const clientID = "d85be9c2468eae1dbf58";
-    
-    // Determine the callback URL for Replit environment
-    let callbackUrl: string;
-    const replitDomains = process.env.REPLIT_DOMAINS;
-    const protocol = req.headers['x-forwarded-proto'] || 'https';
-    const host = req.headers.host || '';
-    
-    console.log(`⚠️ EXPRESS OAUTH: Host information - protocol: ${protocol}, host: ${host}`);
-    console.log(`⚠️ EXPRESS OAUTH: REPLIT_DOMAINS env var: ${replitDomains}`);
-    
-    if (replitDomains) {
-      // First priority: Use REPLIT_DOMAINS environment variable
-      callbackUrl = `https://${replitDomains}/api/auth/callback`;
-      console.log(`⚠️ EXPRESS OAUTH: Using callback URL from REPLIT_DOMAINS: ${callbackUrl}`);
-    } else if (host && (host.includes('replit') || host.includes('.repl.co'))) {
-      // Second priority: Use hostname if it's a Replit domain
-      callbackUrl = `${protocol}://${host}/api/auth/callback`;
-      console.log(`⚠️ EXPRESS OAUTH: Using callback URL from hostname: ${callbackUrl}`);
-    } else {
-      // Fallback for local development
-      callbackUrl = "http://localhost:5000/api/auth/callback";
-      console.log(`⚠️ EXPRESS OAUTH: Using localhost callback URL: ${callbackUrl}`);
-    }
-    
-    // Construct the OAuth URL manually
-    const state = "eudr-complimate";
-    const scope = "read";
-    const authUrl = `${casdoorEndpoint}/login/oauth/authorize?` +
-      `client_id=${clientID}&` +
-      `response_type=code&` +
-      `redirect_uri=${encodeURIComponent(callbackUrl)}&` +
-      `scope=${scope}&` +
-      `state=${state}`;
-    
-    console.log(`⚠️ EXPRESS OAUTH: Redirecting to Casdoor with URL: ${authUrl}`);
-    
-    // Redirect directly to Casdoor
-    res.redirect(307, authUrl);
-  });
+  // The Casdoor login redirect in particular must reach the Go server, which
+  // binds each login to the browser with signed state and PKCE
 
   // Add an explicit route handler for the root auth endpoint for error debugging
   app.get('/auth', (req, res) => {